@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// checkAllIPs resolves the URL host once and measures every address, up to
+// --max-ips, within the deadline of ctx. The worst result wins and every
+// metric is reported per address, e.g. total_request_duration{192.0.2.10}.
+func checkAllIPs(ctx context.Context) (int, error) {
+	u, err := url.Parse(plugin.Url)
+	if err != nil {
+		printError(errorStatus(), err.Error(), reasonConfig, []metric{valueMetric("up", 0, "")})
+		return checkState(errorStatus()), nil
+	}
+	ips, err := lookupAll(ctx, u.Hostname())
+	if err != nil {
+		printError(dnsFailureStatus(), err.Error(), reasonDNS, []metric{valueMetric("up", 0, "")})
+		return checkState(dnsFailureStatus()), nil
+	}
+	if len(ips) > plugin.MaxIps {
+		ips = ips[:plugin.MaxIps]
+	}
+
+	// The summary has the reason token of the first of the worst results.
+	worst := measurement{status: "OK"}
+	var (
+		results []string
+		metrics []metric
+	)
+	start := time.Now()
+	for _, ip := range ips {
+		// Each address is forced the same way --resolve does it, so the Host
+		// header, SNI and certificate checks still use the URL host.
+		overrides := map[string]string{}
+		for k, v := range resolveOverrides {
+			overrides[k] = v
+		}
+		overrides[hostPort(u)] = ip
+
+		m := measureSamples(ctx, overrides)
+		if worseStatus(worst.status, m.status) != worst.status {
+			worst = m
+		}
+		for _, pm := range append(m.metrics, upMetric(m)) {
+			pm.label = fmt.Sprintf("%s{%s}", pm.label, ip)
+			metrics = append(metrics, pm)
+		}
+		if len(m.err) > 0 {
+			results = append(results, fmt.Sprintf("%s %s: %s", ip, m.status, m.err))
+			continue
+		}
+		result := fmt.Sprintf("%s %s: %s in %s", ip, m.status, m.statusLine, formatHeadline(m.elapsed, outputFormat()))
+		if m.status != "OK" {
+			result += m.details
+		}
+		results = append(results, result)
+	}
+
+	status := worst.status
+	message := fmt.Sprintf("%d addresses in %s: %s", len(ips), formatHeadline(time.Since(start), outputFormat()), strings.Join(results, ", "))
+	if plugin.OutputFormat == "json" {
+		result := CheckResult{Status: status, URL: plugin.Url, Message: message, Reason: reasonOf(worst)}
+		result.addMetrics(metrics)
+		printJSON(result)
+	} else {
+		fmt.Printf("%s %s: %s%s | %s\n", checkName(), status, message, reasonKey(worst), perfdata(metrics))
+	}
+	return checkState(status), nil
+}
+
+// lookupAll returns the addresses of host of the --ip-version family, through
+// the --dns-server resolver when one is set. An IP literal is returned as is.
+func lookupAll(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	resolver := newResolver(dnsServer, plugin.Resolver)
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	network := "ip"
+	if plugin.IpVersion == "4" || plugin.IpVersion == "6" {
+		network += plugin.IpVersion
+	}
+	ips, err := resolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, fmt.Errorf("DNS lookup of %s failed: %v", host, err)
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return addrs, nil
+}
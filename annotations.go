@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/spf13/cobra"
+)
+
+// configOutput receives the --print-config dump.
+var configOutput io.Writer = os.Stderr
+
+// stdinEvent tells the plugin SDK whether to read the Sensu event from stdin
+// and apply its annotations, which it does before checkArgs, so args are
+// looked at before the SDK parses them. Only --annotations-from-stdin reads
+// it, anything else on stdin, e.g. the input of a shell loop, is left alone.
+// A terminal has no event to read, and with --urls-from-stdin stdin holds
+// the URLs.
+func stdinEvent(args []string) bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice != 0 {
+		return false
+	}
+	cmd := &cobra.Command{}
+	for _, opt := range options {
+		if err := opt.SetupFlag(cmd); err != nil {
+			return false
+		}
+	}
+	cmd.Flags().ParseErrorsWhitelist.UnknownFlags = true
+	if err := cmd.ParseFlags(args); err != nil {
+		return false
+	}
+	return plugin.AnnotationsStdin && !plugin.UrlsFromStdin
+}
+
+// annotation is an option overridden by an annotation of the event.
+type annotation struct {
+	key   string
+	value string
+	// from is "check" or "entity", check annotations win over entity ones.
+	from string
+}
+
+// appliedAnnotations returns the annotations of event under the plugin
+// Keyspace, e.g. sensu.io/plugins/sensu-http-perf-go/config/warning, that the
+// SDK applied to the options, by option path. It looks them up the way the SDK
+// does, check annotations first.
+func appliedAnnotations(event *corev2.Event) map[string]annotation {
+	applied := map[string]annotation{}
+	if event == nil || len(plugin.Keyspace) == 0 {
+		return applied
+	}
+	for _, opt := range options {
+		key := path.Join(plugin.Keyspace, optionPath(opt))
+		for _, key := range []string{strings.ToLower(key), key} {
+			var value, from string
+			if event.Check != nil {
+				value, from = event.Check.Annotations[key], "check"
+			}
+			if value == "" && event.Entity != nil {
+				value, from = event.Entity.Annotations[key], "entity"
+			}
+			if len(value) > 0 {
+				applied[optionPath(opt)] = annotation{key: key, value: value, from: from}
+				break
+			}
+		}
+	}
+	return applied
+}
+
+// optionPath returns the Path of an option, the name of its annotation.
+func optionPath(opt interface{}) string {
+	return reflect.ValueOf(opt).Elem().FieldByName("Path").String()
+}
+
+// optionValue returns the current value of an option.
+func optionValue(opt interface{}) interface{} {
+	return reflect.ValueOf(opt).Elem().FieldByName("Value").Elem().Interface()
+}
+
+// redactedOption returns value with the secrets it holds replaced, for the
+// options that carry passwords, tokens or credentials.
+func redactedOption(path string, value interface{}) interface{} {
+	const hidden = "[redacted]"
+	switch path {
+	case "client-key-password", "bearer-token", "request-body", "auth-command":
+		if s, _ := value.(string); len(s) > 0 {
+			return hidden
+		}
+	case "url":
+		if s, _ := value.(string); len(s) > 0 {
+			if u, err := url.Parse(s); err == nil {
+				u.User = nil
+				return u.String()
+			}
+			return hidden
+		}
+	case "user":
+		if s, _ := value.(string); len(s) > 0 {
+			name, _, _ := strings.Cut(s, ":")
+			return name + ":" + hidden
+		}
+	case "proxy":
+		if s, _ := value.(string); len(s) > 0 {
+			if u, err := url.Parse(s); err == nil {
+				return u.Redacted()
+			}
+			return hidden
+		}
+	case "headers":
+		headers, _ := value.([]string)
+		redacted := make([]string, len(headers))
+		for i, h := range headers {
+			name, _, _ := strings.Cut(h, ":")
+			redacted[i] = h
+			if sensitiveHeaders[http.CanonicalHeaderKey(strings.TrimSpace(name))] {
+				redacted[i] = name + ": " + hidden
+			}
+		}
+		return redacted
+	case "pre-requests":
+		// A step can carry a body with credentials, e.g. a login form.
+		steps, _ := value.([]string)
+		redacted := make([]string, len(steps))
+		for i := range steps {
+			redacted[i] = hidden
+		}
+		return redacted
+	case "cookies":
+		cookies, _ := value.([]string)
+		redacted := make([]string, len(cookies))
+		for i, c := range cookies {
+			name, _, _ := strings.Cut(c, "=")
+			redacted[i] = name + "=" + hidden
+		}
+		return redacted
+	}
+	return value
+}
+
+// printConfig writes the effective configuration to w as JSON, every option
+// by its path with secrets redacted, and the annotations that overrode them.
+func printConfig(w io.Writer, applied map[string]annotation) {
+	type override struct {
+		Annotation string `json:"annotation"`
+		From       string `json:"from"`
+	}
+	config := map[string]interface{}{}
+	for _, opt := range options {
+		path := optionPath(opt)
+		config[path] = redactedOption(path, optionValue(opt))
+	}
+	overrides := map[string]override{}
+	for path, a := range applied {
+		overrides[path] = override{Annotation: a.key, From: a.from}
+	}
+	out, _ := json.MarshalIndent(struct {
+		Config      map[string]interface{} `json:"config"`
+		Annotations map[string]override    `json:"annotations"`
+	}{config, overrides}, "", "  ")
+	fmt.Fprintf(w, "%s\n", out)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// validateAuthCommand checks --auth-command against the other ways of
+// setting the Authorization header.
+func validateAuthCommand() error {
+	authCommandHeader = ""
+	if len(plugin.AuthCommand) == 0 {
+		return nil
+	}
+	if plugin.AuthCommandTimeout < 1 {
+		return fmt.Errorf("--auth-command-timeout must be at least 1 second")
+	}
+	if len(plugin.User) > 0 || len(plugin.BearerToken) > 0 || len(plugin.BearerTokenFile) > 0 || len(plugin.AwsSigv4) > 0 {
+		return fmt.Errorf("--auth-command cannot be combined with --user, a bearer token or --aws-sigv4")
+	}
+	return nil
+}
+
+// runAuthCommand runs --auth-command through the shell, bounded by
+// --auth-command-timeout, and keeps the one line it prints as the value of
+// the Authorization header. The stderr of a failed command ends up in the
+// error, truncated.
+func runAuthCommand(ctx context.Context) error {
+	if len(plugin.AuthCommand) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(plugin.AuthCommandTimeout)*time.Second)
+	defer cancel()
+
+	shell, flag := "/bin/sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, shell, flag, plugin.AuthCommand)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	// Children of the shell may hold on to its output after it was killed.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("--auth-command timed out after %ds", plugin.AuthCommandTimeout)
+	}
+	if err != nil {
+		message := fmt.Sprintf("--auth-command failed: %v", err)
+		if output := bytes.TrimSpace(stderr.Bytes()); len(output) > 0 {
+			message += fmt.Sprintf(": %s", truncate(output, 200))
+		}
+		return fmt.Errorf("%s", message)
+	}
+
+	value := strings.TrimSpace(stdout.String())
+	switch {
+	case len(value) == 0:
+		return fmt.Errorf("--auth-command printed nothing, expected the Authorization header value")
+	case strings.ContainsAny(value, "\r\n"):
+		return fmt.Errorf("--auth-command printed more than one line, expected the Authorization header value")
+	}
+	authCommandHeader = value
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestExecuteCheckAuthCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are written for sh")
+	}
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	var debug bytes.Buffer
+	debugOutput = &debug
+	defer func() { debugOutput = os.Stderr }()
+	setup(t, "--url", ts.URL, "--auth-command", "printf 'Bearer t0ken\\n'", "--verbose")
+	status, out := run(t)
+	if status != sensu.CheckStateOK || authorization != "Bearer t0ken" {
+		t.Errorf("expected the printed value as the Authorization header, got %d %q: %s", status, authorization, out)
+	}
+	if strings.Contains(out+debug.String(), "t0ken") {
+		t.Errorf("expected the token to be redacted, got %s %s", out, debug.String())
+	}
+
+	tests := []struct {
+		command string
+		args    []string
+		err     string
+	}{
+		{"echo oops >&2; exit 3", nil, "--auth-command failed: exit status 3: oops"},
+		{"echo " + strings.Repeat("x", 300) + " >&2; exit 1", nil, ": " + strings.Repeat("x", 200) + " reason=config_error"},
+		{"sleep 5", []string{"--auth-command-timeout", "1"}, "--auth-command timed out after 1s"},
+		{"true", nil, "printed nothing"},
+		{"printf 'a\\nb\\n'", nil, "printed more than one line"},
+	}
+	for _, tt := range tests {
+		authorization = ""
+		setup(t, append([]string{"--url", ts.URL, "--auth-command", tt.command}, tt.args...)...)
+		status, out := run(t)
+		if status != sensu.CheckStateUnknown || !strings.Contains(out, tt.err) || len(authorization) > 0 {
+			t.Errorf("%s: expected UNKNOWN with %q and no request, got %d: %s", tt.command, tt.err, status, out)
+		}
+	}
+
+	parseArgs(t, "--url", ts.URL, "--auth-command", "true", "--bearer-token", "x")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected --auth-command and --bearer-token to be rejected")
+	}
+}
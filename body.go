@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/DoctorOgg/sensu-http-perf-go/httpperf"
+)
+
+// responseBody is what was read of a response body.
+type responseBody struct {
+	// data is the body when it is inspected, decompressed when the check
+	// decodes it.
+	data []byte
+	// size is how many bytes were transferred and readDone when the transfer
+	// ended, zero when the body was not read.
+	size     int64
+	readDone time.Time
+	// encoding is the content coding of the response, decoded is set when
+	// the check decompressed the body itself.
+	encoding           string
+	decoded            bool
+	compressedSize     int64
+	decompressDuration time.Duration
+	// truncated is set when the body was cut short of its Content-Length.
+	truncated bool
+	// digest is the SHA-256 of the body with --expect-sha256.
+	digest hash.Hash
+}
+
+// inspectsBody reports whether the content of the body is checked, it is
+// then kept in memory.
+func inspectsBody() bool {
+	return len(plugin.ExpectBodyContains) > 0 || bodyRegex != nil || len(plugin.JsonPath) > 0
+}
+
+// readResponseBody reads the body of resp when it has to be inspected or
+// timed, bounded by --max-body-bytes. With --accept-encoding gzip or br the
+// check decompresses the body itself, after the transfer, to time both. A
+// body that cannot be read fails the request, the secrets are redacted from
+// the error.
+func readResponseBody(resp *http.Response, final *httpperf.Hop, secrets ...string) (responseBody, measurement, bool) {
+	body := responseBody{encoding: contentEncoding(resp)}
+	body.decoded = decodesBody() && canDecode(body.encoding)
+	inspect := inspectsBody()
+	if !inspect && !body.decoded && !plugin.ReadBody && requestRange == nil && !checksBodySize() && expectedSHA256 == nil && plugin.ThroughputWarning <= 0 && plugin.ThroughputCritical <= 0 {
+		return body, measurement{}, true
+	}
+
+	var buf bytes.Buffer
+	sink := io.Discard
+	if inspect || body.decoded {
+		sink = &buf
+	}
+	// The digest is computed while the body streams in, a large download is
+	// not kept in memory to verify it.
+	if expectedSHA256 != nil {
+		body.digest = sha256.New()
+		sink = io.MultiWriter(sink, body.digest)
+	}
+	var err error
+	body.size, err = io.Copy(sink, io.LimitReader(resp.Body, plugin.MaxBodyBytes+1))
+	body.readDone = time.Now()
+	// A body cut short of its Content-Length is reported along with the rest
+	// of the measurement rather than as a failed request.
+	if errors.Is(err, io.ErrUnexpectedEOF) && resp.ContentLength > body.size {
+		body.truncated, err = true, nil
+	}
+	if err != nil {
+		m := classifyError(err)
+		if m.reason == reasonConnection {
+			m = connectionFailure("Error reading response body: " + redact(err.Error(), secrets...))
+		}
+		return body, requestFailure(m, final, err), false
+	}
+	if body.size > plugin.MaxBodyBytes {
+		return body, requestFailure(failure("CRITICAL", fmt.Sprintf("Response body larger than --max-body-bytes %d", plugin.MaxBodyBytes)), final, nil), false
+	}
+	body.data = buf.Bytes()
+	if body.decoded {
+		decompressStart := time.Now()
+		decoded, err := decodeBody(body.encoding, body.data, plugin.MaxBodyBytes)
+		body.decompressDuration = time.Since(decompressStart)
+		if err != nil {
+			return body, requestFailure(failure("CRITICAL", fmt.Sprintf("Error decompressing %s response body: %v", body.encoding, err)), final, nil), false
+		}
+		body.compressedSize, body.data = body.size, decoded
+		if body.digest != nil {
+			body.digest.Reset()
+			body.digest.Write(body.data)
+		}
+	}
+	return body, measurement{}, true
+}
+
+// read reports whether the body was read rather than drained.
+func (b responseBody) read() bool {
+	return !b.readDone.IsZero()
+}
+
+// throughput returns the download rate in bytes per second. It covers the
+// transfer after the first byte, so slow links show up even when the time to
+// first byte is fine.
+func (b responseBody) throughput(t httpperf.Timings) float64 {
+	return bytesPerSecond(b.size, b.readDone.Sub(t.FirstResponseByte))
+}
+
+// checkBody evaluates the --range response, the body assertions, the
+// download throughput and the body size, raising verdict and adding their
+// details to it.
+func checkBody(verdict *measurement, resp *http.Response, body responseBody, t httpperf.Timings) {
+	// A --range response must be partial whatever the status code rules say,
+	// and hold exactly the bytes asked for.
+	if requestRange != nil {
+		rangeCode, rangeBody, rangeDetails := checkRange(resp, body.size)
+		verdict.raise(rangeCode, reasonStatusMismatch)
+		verdict.raise(rangeBody, reasonBodyMismatch)
+		verdict.details += rangeDetails
+	}
+
+	if len(plugin.ExpectBodyContains) > 0 && !bytes.Contains(body.data, []byte(plugin.ExpectBodyContains)) {
+		verdict.raise("CRITICAL", reasonBodyMismatch)
+		verdict.details += fmt.Sprintf(" body does not contain %q, got %q", plugin.ExpectBodyContains, truncate(body.data, 200))
+	}
+
+	if bodyRegex != nil {
+		matched := bodyRegex.Match(body.data)
+		verdict.details += fmt.Sprintf(" matched=%t", matched)
+		if matched == plugin.InvertRegex {
+			verdict.raise("CRITICAL", reasonBodyMismatch)
+		}
+	}
+
+	if body.digest != nil {
+		if actual := body.digest.Sum(nil); bytes.Equal(actual, expectedSHA256) {
+			verdict.details += " sha256 verified"
+		} else {
+			verdict.raise("CRITICAL", reasonBodyMismatch)
+			verdict.details += fmt.Sprintf(" sha256 mismatch, expected %x got %x", expectedSHA256, actual)
+		}
+	}
+
+	if !body.read() {
+		return
+	}
+	kbs := body.throughput(t) / 1024
+	switch {
+	case plugin.ThroughputCritical > 0 && kbs < float64(plugin.ThroughputCritical):
+		verdict.raise("CRITICAL", "")
+		verdict.details += fmt.Sprintf(" download_throughput %.1fKB/s < %gKB/s", kbs, plugin.ThroughputCritical)
+	case plugin.ThroughputWarning > 0 && kbs < float64(plugin.ThroughputWarning):
+		verdict.raise("WARNING", "")
+		verdict.details += fmt.Sprintf(" download_throughput %.1fKB/s < %gKB/s", kbs, plugin.ThroughputWarning)
+	}
+
+	sizeStatus, sizeDetails := checkBodySize(body.size)
+	verdict.raise(sizeStatus, "")
+	verdict.details += sizeDetails
+	if body.truncated || resp.Request.Method != http.MethodHead && resp.ContentLength >= 0 && body.size != resp.ContentLength {
+		verdict.raise("WARNING", reasonBodyMismatch)
+		verdict.details += fmt.Sprintf(" Content-Length %d but %d bytes read", resp.ContentLength, body.size)
+	}
+}
+
+// bodyMetrics returns the transfer, size and decompression metrics of a body
+// that was read.
+func bodyMetrics(body responseBody, t httpperf.Timings) []metric {
+	var metrics []metric
+	if body.read() {
+		metrics = append(metrics,
+			durationMetric("content_transfer_duration", body.readDone.Sub(t.FirstResponseByte), 0, 0),
+			bodySizeMetric(body.size),
+			metric{
+				label:    "download_throughput_bytes_per_sec",
+				value:    math.Round(body.throughput(t)),
+				warning:  kbThreshold(plugin.ThroughputWarning),
+				critical: kbThreshold(plugin.ThroughputCritical),
+				below:    true,
+			},
+		)
+	}
+	if requestRange != nil {
+		metrics = append(metrics, valueMetric("range_bytes", float64(body.size), "B"))
+	}
+	if body.decoded {
+		metrics = append(metrics,
+			valueMetric("compressed_bytes", float64(body.compressedSize), "B"),
+			valueMetric("uncompressed_bytes", float64(len(body.data)), "B"),
+			durationMetric("decompress_duration", body.decompressDuration, 0, 0),
+		)
+	}
+	return metrics
+}
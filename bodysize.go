@@ -0,0 +1,62 @@
+package main
+
+import "fmt"
+
+// checksBodySize reports whether a body size threshold is set, the body is
+// then read to count its bytes.
+func checksBodySize() bool {
+	return plugin.MinBodyBytes > 0 || plugin.MaxBodyBytesWarn > 0 || plugin.MaxBodyBytesCrit > 0
+}
+
+// validateBodySize checks --min-body-bytes, --max-body-bytes-warn and
+// --max-body-bytes-crit against each other and --max-body-bytes, above
+// which a body is critical anyway.
+func validateBodySize() error {
+	switch {
+	case plugin.MinBodyBytes < 0 || plugin.MaxBodyBytesWarn < 0 || plugin.MaxBodyBytesCrit < 0:
+		return fmt.Errorf("--min-body-bytes, --max-body-bytes-warn and --max-body-bytes-crit must not be negative")
+	case plugin.MaxBodyBytesWarn > 0 && plugin.MaxBodyBytesCrit > 0 && plugin.MaxBodyBytesWarn > plugin.MaxBodyBytesCrit:
+		return fmt.Errorf("--max-body-bytes-warn must be lower than --max-body-bytes-crit")
+	case plugin.MaxBodyBytesWarn > plugin.MaxBodyBytes || plugin.MaxBodyBytesCrit > plugin.MaxBodyBytes:
+		return fmt.Errorf("--max-body-bytes-warn and --max-body-bytes-crit must not exceed --max-body-bytes %d", plugin.MaxBodyBytes)
+	}
+	for _, max := range []int64{plugin.MaxBodyBytesWarn, plugin.MaxBodyBytesCrit} {
+		if max > 0 && plugin.MinBodyBytes >= max {
+			return fmt.Errorf("--min-body-bytes must be lower than --max-body-bytes-warn and --max-body-bytes-crit")
+		}
+	}
+	return nil
+}
+
+// checkBodySize compares the number of body bytes read against the size
+// thresholds, returning the status and the details explaining it.
+func checkBodySize(n int64) (string, string) {
+	switch {
+	case plugin.MinBodyBytes > 0 && n < plugin.MinBodyBytes:
+		return "CRITICAL", fmt.Sprintf(" response_body_bytes %d < %d", n, plugin.MinBodyBytes)
+	case plugin.MaxBodyBytesCrit > 0 && n > plugin.MaxBodyBytesCrit:
+		return "CRITICAL", fmt.Sprintf(" response_body_bytes %d > %d", n, plugin.MaxBodyBytesCrit)
+	case plugin.MaxBodyBytesWarn > 0 && n > plugin.MaxBodyBytesWarn:
+		return "WARNING", fmt.Sprintf(" response_body_bytes %d > %d", n, plugin.MaxBodyBytesWarn)
+	}
+	return "OK", ""
+}
+
+// bodySizeMetric returns the response_body_bytes metric, with the upper
+// size thresholds.
+func bodySizeMetric(n int64) metric {
+	m := valueMetric("response_body_bytes", float64(n), "B")
+	m.warning = intThreshold(plugin.MaxBodyBytesWarn)
+	m.critical = intThreshold(plugin.MaxBodyBytesCrit)
+	return m
+}
+
+// intThreshold converts a size flag value into a metric threshold, zero
+// disables it.
+func intThreshold(value int64) *float64 {
+	if value <= 0 {
+		return nil
+	}
+	f := float64(value)
+	return &f
+}
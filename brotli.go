@@ -0,0 +1,18 @@
+//go:build !nobrotli
+
+package main
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+// brotliSupported reports whether --accept-encoding br is available, builds
+// with the nobrotli tag leave the decoder out.
+const brotliSupported = true
+
+// newBrotliReader returns a reader decompressing the brotli stream r.
+func newBrotliReader(r io.Reader) io.Reader {
+	return brotli.NewReader(r)
+}
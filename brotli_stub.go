@@ -0,0 +1,15 @@
+//go:build nobrotli
+
+package main
+
+import "io"
+
+// brotliSupported reports whether --accept-encoding br is available, this
+// build was made with the nobrotli tag and leaves the decoder out.
+const brotliSupported = false
+
+// newBrotliReader is never called, checkArgs rejects --accept-encoding br
+// first.
+func newBrotliReader(_ io.Reader) io.Reader {
+	return nil
+}
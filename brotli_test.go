@@ -0,0 +1,23 @@
+//go:build !nobrotli
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestDecodeBodyBrotli(t *testing.T) {
+	body := strings.Repeat("hello ", 100)
+	var buf bytes.Buffer
+	bw := brotli.NewWriter(&buf)
+	bw.Write([]byte(body))
+	bw.Close()
+	got, err := decodeBody("br", buf.Bytes(), 1<<20)
+	if err != nil || string(got) != body {
+		t.Errorf("expected the body back, got %q %v", got, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cacheHeaders are the CDN and proxy headers shown in the output when the
+// response has them, to tell a cache HIT from a MISS.
+var cacheHeaders = []string{"X-Cache", "X-Cache-Status", "CF-Cache-Status", "Cache-Status", "Via"}
+
+// checksCacheAge reports whether an Age option is set, cache_age_seconds is
+// then reported even when the response has no Age header.
+func checksCacheAge() bool {
+	return plugin.MaxAgeWarning > 0 || plugin.MaxAgeCritical > 0 || plugin.RequireAge
+}
+
+// cacheAge returns the Age header of a response in seconds, ok is false when
+// it is missing or not a number.
+func cacheAge(header http.Header) (int64, bool) {
+	age, err := strconv.ParseInt(strings.TrimSpace(header.Get("Age")), 10, 64)
+	if err != nil || age < 0 {
+		return 0, false
+	}
+	return age, true
+}
+
+// checkCache compares the Age of a response against --max-age-warning and
+// --max-age-critical, a missing Age counts as 0 unless --require-age is set.
+// It returns the status, the details with the cache headers of the response
+// and the cache_age_seconds metric, nil when there is nothing to report.
+func checkCache(header http.Header) (string, string, *metric) {
+	status := "OK"
+	var details string
+	for _, name := range cacheHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			details += fmt.Sprintf(" %s=%q", name, strings.Join(values, ", "))
+		}
+	}
+
+	age, ok := cacheAge(header)
+	if !ok && !checksCacheAge() {
+		return status, details, nil
+	}
+	switch {
+	case !ok && plugin.RequireAge:
+		status = "WARNING"
+		details += " Age header missing"
+	case plugin.MaxAgeCritical > 0 && age > plugin.MaxAgeCritical:
+		status = "CRITICAL"
+		details += fmt.Sprintf(" cache_age %ds > %ds", age, plugin.MaxAgeCritical)
+	case plugin.MaxAgeWarning > 0 && age > plugin.MaxAgeWarning:
+		status = "WARNING"
+		details += fmt.Sprintf(" cache_age %ds > %ds", age, plugin.MaxAgeWarning)
+	}
+	m := valueMetric("cache_age_seconds", float64(age), "s")
+	m.warning = intThreshold(plugin.MaxAgeWarning)
+	m.critical = intThreshold(plugin.MaxAgeCritical)
+	return status, details, &m
+}
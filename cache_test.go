@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestExecuteCheckCacheAge(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if age := r.URL.Query().Get("age"); len(age) > 0 {
+			w.Header().Set("Age", age)
+			w.Header().Set("X-Cache", "HIT")
+			w.Header().Set("CF-Cache-Status", "HIT")
+			w.Header().Add("Via", "1.1 varnish")
+			w.Header().Add("Via", "1.1 edge")
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		query   string
+		args    []string
+		status  int
+		want    []string
+		notWant string
+	}{
+		{"", nil, sensu.CheckStateOK, nil, "cache_age_seconds"},
+		{"?age=120", nil, sensu.CheckStateOK, []string{
+			` X-Cache="HIT" `,
+			` CF-Cache-Status="HIT" `,
+			` Via="1.1 varnish, 1.1 edge" `,
+			" cache_age_seconds=120s ",
+		}, ""},
+		{"", []string{"--max-age-warning", "60"}, sensu.CheckStateOK, []string{" cache_age_seconds=0s;60 "}, ""},
+		{"?age=120", []string{"--max-age-warning", "60", "--max-age-critical", "600"}, sensu.CheckStateWarning, []string{" cache_age 120s > 60s", " cache_age_seconds=120s;60;600 "}, ""},
+		{"?age=3600", []string{"--max-age-warning", "60", "--max-age-critical", "600"}, sensu.CheckStateCritical, []string{" cache_age 3600s > 600s"}, ""},
+		{"", []string{"--require-age"}, sensu.CheckStateWarning, []string{" Age header missing"}, ""},
+		{"?age=5", []string{"--require-age"}, sensu.CheckStateOK, []string{" cache_age_seconds=5s "}, ""},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL + "/" + tt.query}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%s %q: expected %d, got %d: %s", tt.query, tt.args, tt.status, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s %q: expected %q in %s", tt.query, tt.args, want, out)
+			}
+		}
+		if len(tt.notWant) > 0 && strings.Contains(out, tt.notWant) {
+			t.Errorf("%s %q: expected no %q in %s", tt.query, tt.args, tt.notWant, out)
+		}
+	}
+
+	parseArgs(t, "--url", ts.URL, "--max-age-warning", "600", "--max-age-critical", "60")
+	if _, err := checkArgs(nil); err == nil {
+		t.Errorf("expected --max-age-warning above --max-age-critical to be rejected")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// matchesCertPattern reports whether value matches an --expect-cert-subject
+// or --expect-cert-san pattern: a substring, or the whole value when the
+// pattern ends with $.
+func matchesCertPattern(pattern, value string, fold bool) bool {
+	if fold {
+		pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+	}
+	if exact, ok := strings.CutSuffix(pattern, "$"); ok {
+		return value == exact
+	}
+	return strings.Contains(value, pattern)
+}
+
+// certSANs returns the subject alternative names of cert, DNS names first.
+func certSANs(cert *x509.Certificate) []string {
+	sans := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+// checkCertIdentity checks the leaf certificate the server presented against
+// --expect-cert-subject and --expect-cert-san, whether or not it was
+// verified. A mismatch is critical and the details show what was presented
+// instead. SANs are matched ignoring case, any one of them may match, and a
+// wildcard only as written.
+func checkCertIdentity(leaf *x509.Certificate) (string, string) {
+	status, details := "OK", ""
+	if pattern := plugin.ExpectCertSubject; len(pattern) > 0 && !matchesCertPattern(pattern, leaf.Subject.String(), false) {
+		status = "CRITICAL"
+		details += fmt.Sprintf(" (cert subject %q does not match --expect-cert-subject %q)", leaf.Subject.String(), pattern)
+	}
+	if pattern := plugin.ExpectCertSan; len(pattern) > 0 {
+		sans := certSANs(leaf)
+		matched := false
+		for _, san := range sans {
+			if matchesCertPattern(pattern, san, true) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			status = "CRITICAL"
+			details += fmt.Sprintf(" (cert SANs [%s] do not match --expect-cert-san %q)", strings.Join(sans, ", "), pattern)
+		}
+	}
+	return status, details
+}
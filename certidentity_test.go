@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestMatchesCertPattern(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		fold, want     bool
+	}{
+		{"example.com", "api.example.com", false, true},
+		{"example.com$", "api.example.com", false, false},
+		{"api.example.com$", "api.example.com", false, true},
+		{"API.example.com$", "api.example.com", false, false},
+		{"API.example.com$", "api.example.com", true, true},
+		{"O=Acme", "CN=api.example.com,O=Example", false, false},
+	}
+	for _, tt := range tests {
+		if got := matchesCertPattern(tt.pattern, tt.value, tt.fold); got != tt.want {
+			t.Errorf("%q against %q: expected %t, got %t", tt.pattern, tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestExecuteCheckCertIdentity(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// The certificate of httptest is issued to O=Acme Co for example.com,
+	// *.example.com, 127.0.0.1 and ::1, and is not trusted. A wildcard is
+	// matched as the name it is, not as the hosts it covers.
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--expect-cert-subject", "O=Acme Co$", "--expect-cert-san", "EXAMPLE.com$"}, sensu.CheckStateOK, " cipher="},
+		{[]string{"--expect-cert-san", "127.0.0.1$"}, sensu.CheckStateOK, " cipher="},
+		{[]string{"--expect-cert-subject", "O=Example"}, sensu.CheckStateCritical, `(cert subject "O=Acme Co" does not match --expect-cert-subject "O=Example")`},
+		{[]string{"--expect-cert-subject", "O=Example"}, sensu.CheckStateCritical, " reason=cert_mismatch | "},
+		{[]string{"--expect-cert-san", "api.example.com"}, sensu.CheckStateCritical, `(cert SANs [example.com, *.example.com, 127.0.0.1, ::1] do not match --expect-cert-san "api.example.com")`},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL, "--insecure-skip-verify"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected state %d with %q, got %d: %s", tt.args, tt.status, tt.want, status, out)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// classifyError turns the error of a request into a failed measurement with
+// a reason token: dns_error, timeout, conn_refused, tls_error, cancelled, or
+// conn_error for anything else. DNS failures get the --dns-failure-status,
+// secrets are redacted from the generic message.
+func classifyError(err error, secrets ...string) measurement {
+	var (
+		pinErr    *pinError
+		recordErr tls.RecordHeaderError
+		quicErr   *quicTimeoutError
+		connErr   *connectTimeoutError
+		noAddrErr *noAddressError
+		dnsErr    *net.DNSError
+		socketErr *unixSocketError
+		bindErr   *bindError
+		opErr     *net.OpError
+		netErr    net.Error
+		urlErr    *url.Error
+	)
+	// Messages quote the cause rather than the "Get <url>" wrapping.
+	cause := err
+	if errors.As(err, &urlErr) {
+		cause = urlErr.Err
+	}
+	if message, ok := describeVerifyError(err); ok {
+		return measurement{status: "CRITICAL", err: message, reason: reasonTLS}
+	}
+	switch {
+	case errors.As(err, &pinErr):
+		return measurement{status: "CRITICAL", err: pinErr.Error(), reason: reasonTLS}
+	case errors.As(err, &recordErr):
+		return measurement{status: "CRITICAL", err: "TLS handshake failed: " + recordErr.Msg, reason: reasonTLS}
+	case cause.Error() == "http: server gave HTTP response to HTTPS client":
+		// net/http replaces the RecordHeaderError of a plain http server.
+		return measurement{status: "CRITICAL", err: "TLS handshake failed: server answered with plain HTTP", reason: reasonTLS}
+	case errors.As(err, &connErr):
+		return measurement{status: "CRITICAL", err: connErr.Error(), reason: reasonTimeout, retryable: true}
+	case errors.As(err, &quicErr):
+		return measurement{status: "CRITICAL", err: quicErr.Error(), reason: reasonTimeout, retryable: true}
+	case errors.As(err, &noAddrErr):
+		return measurement{status: dnsFailureStatus(), err: noAddrErr.Error(), reason: reasonDNS, retryable: true}
+	case errors.As(err, &dnsErr):
+		// The resolvers word a missing name differently, "no such host"
+		// for the Go one.
+		cause := dnsErr.Err
+		if dnsErr.IsNotFound {
+			cause = "NXDOMAIN"
+		}
+		message := fmt.Sprintf("DNS lookup of %s failed: %s", dnsErr.Name, cause)
+		if len(dnsServer) > 0 {
+			message = fmt.Sprintf("DNS lookup of %s via %s failed: %s", dnsErr.Name, dnsServer, cause)
+		}
+		return measurement{status: dnsFailureStatus(), err: message, reason: reasonDNS, retryable: true}
+	case errors.As(err, &bindErr):
+		// The source is misconfigured on this host, the server was never
+		// reached.
+		return measurement{status: "UNKNOWN", err: bindErr.Error(), reason: reasonConfig}
+	case errors.As(err, &socketErr):
+		reason := reasonConnection
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			reason = reasonConnRefused
+		}
+		return measurement{status: "CRITICAL", err: socketErr.Error(), reason: reason, retryable: true}
+	case errors.Is(err, syscall.ECONNREFUSED) && errors.As(err, &opErr) && opErr.Addr != nil:
+		return measurement{status: "CRITICAL", err: "connection refused to " + opErr.Addr.String(), reason: reasonConnRefused, retryable: true}
+	case errors.Is(err, context.Canceled):
+		return measurement{status: "CRITICAL", err: "request cancelled", reason: reasonCancelled}
+	case errors.Is(err, context.DeadlineExceeded):
+		message := fmt.Sprintf("request timed out after %ds", plugin.Timeout)
+		if plugin.Critical > 0 {
+			message += fmt.Sprintf(" (threshold %gs)", plugin.Critical)
+		}
+		return measurement{status: "CRITICAL", err: message, reason: reasonTimeout, retryable: true}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return measurement{status: "CRITICAL", err: "timed out: " + strings.TrimPrefix(cause.Error(), "net/http: "), reason: reasonTimeout, retryable: true}
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		// The server rejected the handshake with a TLS alert.
+		return measurement{status: "CRITICAL", err: "TLS handshake failed: " + opErr.Err.Error(), reason: reasonTLS}
+	case strings.HasPrefix(cause.Error(), "tls: "):
+		// Local handshake errors, e.g. no TLS version left to offer.
+		return measurement{status: "CRITICAL", err: "TLS handshake failed: " + cause.Error(), reason: reasonTLS}
+	}
+	return measurement{status: "CRITICAL", err: "Error making request: " + redact(err.Error(), secrets...), reason: reasonConnection, retryable: true}
+}
+
+// dnsFailureStatus returns the status of a failed DNS lookup as set with
+// --dns-failure-status.
+func dnsFailureStatus() string {
+	return strings.ToUpper(plugin.DnsFailureStatus)
+}
+
+// errorStatus returns the status of a configuration or plugin-side error,
+// UNKNOWN so the person who set up the check is told rather than the owner
+// of the target, or CRITICAL with --critical-on-error.
+func errorStatus() string {
+	if plugin.CriticalOnError {
+		return "CRITICAL"
+	}
+	return "UNKNOWN"
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DoctorOgg/sensu-http-perf-go/httpperf"
+)
+
+// expectsContinue reports whether the request asks for 100 Continue before
+// sending its body, with --expect-continue and a body of at least
+// --expect-continue-min-bytes.
+func expectsContinue() bool {
+	return plugin.ExpectContinue && len(requestBody) > 0 && int64(len(requestBody)) >= plugin.ContinueMinBytes
+}
+
+// expectContinueTimeout returns how long the transport waits for 100
+// Continue, zero sends the body right away.
+func expectContinueTimeout() time.Duration {
+	if !plugin.ExpectContinue {
+		return 0
+	}
+	return time.Duration(plugin.ContinueTimeout) * time.Millisecond
+}
+
+// checkContinue describes how the server answered an Expect: 100-continue
+// request that did not get its 100 Continue: the body was sent anyway once
+// --expect-continue-timeout ran out, or the server sent its final response
+// without reading the body. continue_fallback is 1 in the first case, the
+// wait itself is the continue_wait_duration phase otherwise.
+func checkContinue(t httpperf.Timings) (string, []metric) {
+	if t.Wait100Continue.IsZero() {
+		return "", nil
+	}
+	var fallback float64
+	var details string
+	if t.Got100Continue.IsZero() {
+		if !t.WroteRequest.IsZero() && (t.FirstResponseByte.IsZero() || t.WroteRequest.Before(t.FirstResponseByte)) {
+			fallback = 1
+			details = fmt.Sprintf(" no 100 Continue within %dms, body sent anyway", plugin.ContinueTimeout)
+		} else {
+			details = " answered without 100 Continue"
+		}
+	}
+	return details, []metric{valueMetric("continue_fallback", fallback, "")}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestExecuteCheckExpectContinue(t *testing.T) {
+	var expect string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expect = r.Header.Get("Expect")
+		if r.URL.Path == "/reject" {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		// The server sends 100 Continue on the first read of the body.
+		time.Sleep(20 * time.Millisecond)
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer ts.Close()
+
+	// A server that ignores Expect, the client sends the body after the
+	// timeout.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				io.Copy(io.Discard, req.Body)
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			}()
+		}
+	}()
+
+	body := strings.Repeat("x", 4096)
+	tests := []struct {
+		url     string
+		args    []string
+		expect  string
+		want    []string
+		notWant string
+	}{
+		{ts.URL, nil, "100-continue", []string{" continue_wait_duration=", " continue_fallback=0 "}, "answered without"},
+		{ts.URL + "/reject", nil, "100-continue", []string{" answered without 100 Continue", " continue_fallback=0 "}, "continue_wait_duration"},
+		{"http://" + ln.Addr().String(), nil, "", []string{" no 100 Continue within 50ms, body sent anyway", " continue_fallback=1 "}, "continue_wait_duration"},
+		{ts.URL, []string{"--expect-continue-min-bytes", "5000"}, "", nil, "continue_"},
+	}
+	for _, tt := range tests {
+		expect = ""
+		setup(t, append([]string{"--url", tt.url, "--method", "POST", "--request-body", body, "--expect-continue", "--expect-continue-min-bytes", "1024", "--expect-continue-timeout", "50", "--status-ok-anything"}, tt.args...)...)
+		status, out := run(t)
+		if status != sensu.CheckStateOK {
+			t.Errorf("%s %q: expected OK, got %d: %s", tt.url, tt.args, status, out)
+		}
+		if expect != tt.expect {
+			t.Errorf("%s %q: expected Expect %q, got %q", tt.url, tt.args, tt.expect, expect)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s %q: expected %q in %s", tt.url, tt.args, want, out)
+			}
+		}
+		if len(tt.notWant) > 0 && strings.Contains(out, tt.notWant) {
+			t.Errorf("%s %q: expected no %q in %s", tt.url, tt.args, tt.notWant, out)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--expect-continue"},
+		{"--expect-continue", "--request-body", body, "--expect-continue-timeout", "0"},
+	} {
+		parseArgs(t, append([]string{"--url", ts.URL, "--method", "POST"}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected a validation error", args)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// validateCORSOrigin checks that --cors-origin is an origin as browsers send
+// it, scheme://host[:port] without a path, or null.
+func validateCORSOrigin(origin string) error {
+	if origin == "null" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 || len(u.Path) > 0 || len(u.RawQuery) > 0 {
+		return fmt.Errorf("invalid --cors-origin %q, expected scheme://host[:port]", origin)
+	}
+	return nil
+}
+
+// setPreflight turns req into the CORS preflight a browser at --cors-origin
+// sends before a --method request.
+func setPreflight(req *http.Request) {
+	req.Method = http.MethodOptions
+	req.Header.Set("Origin", plugin.CorsOrigin)
+	req.Header.Set("Access-Control-Request-Method", plugin.Method)
+}
+
+// corsSafelisted are the methods browsers allow without the preflight
+// listing them.
+var corsSafelisted = map[string]bool{http.MethodGet: true, http.MethodHead: true, http.MethodPost: true}
+
+// checkCORS validates the answer to a preflight: Access-Control-Allow-Origin
+// must be the origin or *, and Access-Control-Allow-Methods must allow the
+// method. It returns a description with the received headers when the
+// browser would block the request, empty otherwise.
+func checkCORS(header http.Header) string {
+	allowOrigin := strings.TrimSpace(header.Get("Access-Control-Allow-Origin"))
+	allowMethods := header.Values("Access-Control-Allow-Methods")
+
+	originAllowed := allowOrigin == "*" || allowOrigin == plugin.CorsOrigin
+	methodAllowed := corsSafelisted[plugin.Method]
+	for _, value := range allowMethods {
+		for _, method := range strings.Split(value, ",") {
+			method = strings.TrimSpace(method)
+			methodAllowed = methodAllowed || method == "*" || method == plugin.Method
+		}
+	}
+	if originAllowed && methodAllowed {
+		return ""
+	}
+	return fmt.Sprintf(" CORS preflight for %s from %s failed: Access-Control-Allow-Origin=%q Access-Control-Allow-Methods=%q",
+		plugin.Method, plugin.CorsOrigin, allowOrigin, strings.Join(allowMethods, ", "))
+}
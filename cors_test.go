@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestExecuteCheckCORS(t *testing.T) {
+	var method, requested string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, requested = r.Method, r.Header.Get("Access-Control-Request-Method")
+		switch r.URL.Path {
+		case "/any":
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case "/app":
+			w.Header().Set("Access-Control-Allow-Origin", "https://app.example.com")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, PUT")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		args   []string
+		status int
+		want   string
+	}{
+		{"/app", []string{"--method", "PUT"}, sensu.CheckStateOK, " CORS preflight allowed"},
+		{"/app", []string{"--method", "DELETE"}, sensu.CheckStateCritical, ` CORS preflight for DELETE from https://app.example.com failed: Access-Control-Allow-Origin="https://app.example.com" Access-Control-Allow-Methods="GET, PUT"`},
+		// Safelisted methods need no Access-Control-Allow-Methods.
+		{"/any", nil, sensu.CheckStateOK, " CORS preflight allowed"},
+		{"/any", []string{"--method", "POST"}, sensu.CheckStateOK, " CORS preflight allowed"},
+		{"/", nil, sensu.CheckStateCritical, `Access-Control-Allow-Origin="" Access-Control-Allow-Methods=""`},
+		{"/", nil, sensu.CheckStateCritical, " reason=cors_mismatch | "},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL + tt.path, "--cors-origin", "https://app.example.com"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) || !strings.Contains(out, "first_byte_duration=") {
+			t.Errorf("%s %q: expected %d with %q, got %d: %s", tt.path, tt.args, tt.status, tt.want, status, out)
+		}
+		if method != http.MethodOptions || len(requested) == 0 {
+			t.Errorf("%s %q: expected a preflight, got %s with Access-Control-Request-Method %q", tt.path, tt.args, method, requested)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--cors-origin", "https://app.example.com/path"},
+		{"--cors-origin", "https://app.example.com", "--method", "POST", "--request-body", "{}"},
+		{"--cors-origin", "https://app.example.com", "--method", "OPTIONS"},
+	} {
+		parseArgs(t, append([]string{"--url", ts.URL}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected a validation error", args)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DoctorOgg/sensu-http-perf-go/httpperf"
+)
+
+// debugOutput receives the --verbose dump, stderr keeps the check output
+// parseable.
+var debugOutput io.Writer = os.Stderr
+
+// sensitiveHeaders are the headers whose values the dump leaves out.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"X-Amz-Security-Token": true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+}
+
+// dumpExchange writes every hop of x the way curl -v shows it: the request
+// and response headers, the connection and the trace events with their
+// offset from the start of the hop. secrets are redacted from the URLs.
+func dumpExchange(w io.Writer, x *httpperf.Exchange, secrets ...string) {
+	var b strings.Builder
+	for i, h := range x.Hops {
+		t := h.Timings()
+		fmt.Fprintf(&b, "* hop %d: %s\n", i+1, redact(x.Chain[i], secrets...))
+		if req := h.Request(); req != nil {
+			host := req.Host
+			if len(host) == 0 {
+				host = req.URL.Host
+			}
+			fmt.Fprintf(&b, "> %s %s %s\n", req.Method, req.URL.RequestURI(), req.Proto)
+			fmt.Fprintf(&b, "> Host: %s\n", host)
+			dumpHeader(&b, "> ", req.Header, secrets)
+		}
+		if len(t.RemoteAddr) > 0 {
+			fmt.Fprintf(&b, "* remote_addr=%s local_addr=%s reused=%t\n", t.RemoteAddr, t.LocalAddr, t.Reused)
+		}
+		if resp := h.Response(); resp != nil {
+			if resp.TLS != nil {
+				fmt.Fprintf(&b, "* tls=%s cipher=%s\n", httpperf.TLSVersionName(resp.TLS.Version), tls.CipherSuiteName(resp.TLS.CipherSuite))
+			}
+			fmt.Fprintf(&b, "< %s %s\n", resp.Proto, resp.Status)
+			dumpHeader(&b, "< ", resp.Header, secrets)
+		}
+		for _, e := range t.Events() {
+			fmt.Fprintf(&b, "* %+.3fms %s\n", float64(e.Offset.Microseconds())/1000, e.Name)
+		}
+	}
+	fmt.Fprint(w, b.String())
+}
+
+// dumpHeader writes header sorted by name, each line prefixed.
+func dumpHeader(b *strings.Builder, prefix string, header http.Header, secrets []string) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+				value = "[redacted]"
+			}
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, name, redact(value, secrets...))
+		}
+	}
+}
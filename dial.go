@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// dialFunc is the signature of http.Transport.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// noAddressError is returned when a host has no address of the family
+// requested with --ip-version.
+type noAddressError struct {
+	host    string
+	version string
+}
+
+func (e *noAddressError) Error() string {
+	record := "A"
+	if e.version == "6" {
+		record = "AAAA"
+	}
+	return fmt.Sprintf("no %s record for %s", record, e.host)
+}
+
+// unixSocketError is returned when the --unix-socket cannot be dialed.
+type unixSocketError struct {
+	path string
+	err  error
+}
+
+func (e *unixSocketError) Error() string {
+	var errno syscall.Errno
+	if errors.As(e.err, &errno) {
+		return fmt.Sprintf("unable to connect to unix socket %s: %v (errno %d)", e.path, e.err, int(errno))
+	}
+	return fmt.Sprintf("unable to connect to unix socket %s: %v", e.path, e.err)
+}
+
+func (e *unixSocketError) Unwrap() error {
+	return e.err
+}
+
+// unixDialContext returns a DialContext connecting every request to the unix
+// domain socket at path, the URL then only supplies the Host header.
+func unixDialContext(dialer *net.Dialer, path string) dialFunc {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, "unix", path)
+		if err != nil {
+			return nil, &unixSocketError{path: path, err: err}
+		}
+		return conn, nil
+	}
+}
+
+// quicTimeoutError is returned when a QUIC handshake gets no answer, which
+// usually means UDP is filtered somewhere on the path.
+type quicTimeoutError struct {
+	err error
+}
+
+func (e *quicTimeoutError) Error() string {
+	return "QUIC handshake timed out, UDP may be blocked"
+}
+
+func (e *quicTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// connectTimeoutError is returned when a TCP connect gives up, after
+// --connect-timeout or when the --timeout ran out first.
+type connectTimeoutError struct {
+	addr   string
+	waited time.Duration
+	err    error
+}
+
+func (e *connectTimeoutError) Error() string {
+	return fmt.Sprintf("connect to %s timed out after %s", e.addr, e.waited)
+}
+
+func (e *connectTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// withConnectTimeout wraps dial so that a connect timing out is reported as
+// a connectTimeoutError naming the address and how long it waited.
+func withConnectTimeout(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := dial(ctx, network, addr)
+		var netErr net.Error
+		if err != nil && errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, &connectTimeoutError{addr: addr, waited: time.Since(start).Round(time.Millisecond), err: err}
+		}
+		return conn, err
+	}
+}
+
+// connectTimeout returns the TCP connect timeout, --connect-timeout or the
+// whole --timeout when it is not set.
+func connectTimeout() time.Duration {
+	if plugin.ConnectTimeout > 0 {
+		return time.Duration(plugin.ConnectTimeout) * time.Millisecond
+	}
+	return time.Duration(plugin.Timeout) * time.Second
+}
+
+// parseResolve parses --resolve entries in the "host:port:ip" form of curl
+// into a map from "host:port" to the IP to connect to.
+func parseResolve(entries []string) (map[string]string, error) {
+	overrides := map[string]string{}
+	for _, entry := range entries {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid --resolve %q, expected host:port:ip", entry)
+		}
+		host, port := strings.ToLower(parts[0]), parts[1]
+		ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]"))
+		if n, err := strconv.Atoi(port); len(host) == 0 || err != nil || n < 1 || n > 65535 || ip == nil {
+			return nil, fmt.Errorf("invalid --resolve %q, expected host:port:ip", entry)
+		}
+		overrides[net.JoinHostPort(host, port)] = ip.String()
+	}
+	return overrides, nil
+}
+
+// parseDNSServer parses --dns-server, an IP with an optional port defaulting
+// to 53, into an ip:port address.
+func parseDNSServer(value string) (string, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+	if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")); ip != nil {
+		return net.JoinHostPort(ip.String(), "53"), nil
+	}
+	host, port, err := net.SplitHostPort(value)
+	if n, perr := strconv.Atoi(port); err != nil || net.ParseIP(host) == nil || perr != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid --dns-server %q, expected ip or ip:port", value)
+	}
+	return value, nil
+}
+
+// validateResolver checks --resolver. The cgo resolver needs a build with
+// cgo, and cannot send its queries to a --dns-server.
+func validateResolver() error {
+	switch plugin.Resolver {
+	case "auto", "go":
+	case "cgo":
+		if !cgoResolver {
+			return fmt.Errorf("--resolver cgo is not available, this build has cgo disabled")
+		}
+		if len(dnsServer) > 0 {
+			return fmt.Errorf("--dns-server uses the Go resolver and cannot be combined with --resolver cgo")
+		}
+	default:
+		return fmt.Errorf("unsupported --resolver %q, must be one of auto, go or cgo", plugin.Resolver)
+	}
+	return nil
+}
+
+// newResolver returns the resolver of the --resolver mode, sending every
+// query to server when one is set, or nil for the system resolver with the
+// auto mode. The pure Go resolver retries truncated UDP answers over TCP, the
+// network it asks for is kept.
+func newResolver(server, mode string) *net.Resolver {
+	switch {
+	case len(server) > 0:
+		dialer := &net.Dialer{Timeout: 5 * time.Second}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	case mode == "go":
+		return &net.Resolver{PreferGo: true}
+	case mode == "cgo":
+		// validateArgs sets GODEBUG to force the C library resolver.
+		return &net.Resolver{PreferGo: false}
+	}
+	return nil
+}
+
+// forceCgoNetdns returns godebug with its netdns setting, if any, switched to
+// the C library resolver, keeping a debug level such as netdns=go+1.
+func forceCgoNetdns(godebug string) string {
+	var settings []string
+	netdns := "netdns=cgo"
+	for _, setting := range strings.Split(godebug, ",") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(setting), "netdns=")
+		switch {
+		case len(strings.TrimSpace(setting)) == 0:
+		case !ok:
+			settings = append(settings, setting)
+		default:
+			for _, part := range strings.Split(value, "+") {
+				if part != "go" && part != "cgo" && len(part) > 0 {
+					netdns += "+" + part
+				}
+			}
+		}
+	}
+	return strings.Join(append(settings, netdns), ",")
+}
+
+// resolverName returns the resolver lookups go through for the verbose
+// output: "go" for the pure Go resolver, "cgo" for the C library one, and
+// "system" when the platform picks at run time. --dns-server always uses the
+// Go resolver.
+func resolverName() string {
+	switch {
+	case len(dnsServer) > 0 || plugin.Resolver == "go" || net.DefaultResolver.PreferGo || !cgoResolver:
+		return "go"
+	case plugin.Resolver == "cgo":
+		return "cgo"
+	}
+	for _, setting := range strings.Split(os.Getenv("GODEBUG"), ",") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(setting), "netdns="); ok {
+			switch mode, _, _ := strings.Cut(value, "+"); mode {
+			case "go", "cgo":
+				return mode
+			}
+		}
+	}
+	return "system"
+}
+
+// hostPort returns the "host:port" a URL connects to, with the default port
+// of the scheme when none is given.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if len(port) == 0 {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// newDialContext returns a DialContext using dialer. Addresses listed in
+// overrides connect to the given IP instead of being resolved, and only IPv4
+// or IPv6 is used when ipVersion is "4" or "6". Lookups go through the
+// dialer's resolver with the request context, so DNS timings are still
+// traced.
+func newDialContext(dialer *net.Dialer, ipVersion string, overrides map[string]string) dialFunc {
+	if ipVersion != "4" && ipVersion != "6" && len(overrides) == 0 {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if ip, ok := overrides[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+			host, addr = ip, net.JoinHostPort(ip, port)
+		}
+		if ipVersion != "4" && ipVersion != "6" {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		network = "tcp" + ipVersion
+		if ip := net.ParseIP(host); ip != nil {
+			if (ip.To4() != nil) != (ipVersion == "4") {
+				return nil, &noAddressError{host: host, version: ipVersion}
+			}
+			return dialer.DialContext(ctx, network, addr)
+		}
+		ips, err := lookupFamily(ctx, dialer.Resolver, ipVersion, host)
+		if err != nil {
+			return nil, err
+		}
+		// Try the addresses in order like the standard dialer does.
+		for _, ip := range ips {
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// lookupFamily resolves host to its addresses, only IPv4 or IPv6 ones when
+// ipVersion is "4" or "6" in which case a host without any is reported as a
+// noAddressError. A nil resolver is the system one.
+func lookupFamily(ctx context.Context, resolver *net.Resolver, ipVersion, host string) ([]net.IP, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if ipVersion != "4" && ipVersion != "6" {
+		return resolver.LookupIP(ctx, "ip", host)
+	}
+	ips, err := resolver.LookupIP(ctx, "ip"+ipVersion, host)
+	// A name of /etc/hosts with addresses of the other family only fails
+	// with an AddrError rather than a not found DNSError.
+	var (
+		dnsErr  *net.DNSError
+		addrErr *net.AddrError
+	)
+	if len(ips) == 0 && (err == nil || errors.As(err, &addrErr) || errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return nil, &noAddressError{host: host, version: ipVersion}
+	}
+	return ips, err
+}
+
+// remoteIP returns the IP of a remote address and its family, IPv4 or IPv6.
+func remoteIP(addr string) (string, string) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host, ""
+	}
+	if ip.To4() != nil {
+		return host, "IPv4"
+	}
+	return host, "IPv6"
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestNewDialContextIPVersion(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	for _, version := range []string{"any", "4"} {
+		conn, err := newDialContext(&net.Dialer{}, version, nil)(context.Background(), "tcp", ln.Addr().String())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", version, err)
+			continue
+		}
+		conn.Close()
+	}
+
+	_, err = newDialContext(&net.Dialer{}, "6", nil)(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", port))
+	var noAddrErr *noAddressError
+	if !errors.As(err, &noAddrErr) || err.Error() != "no AAAA record for 127.0.0.1" {
+		t.Errorf("expected a missing AAAA record, got %v", err)
+	}
+	_, err = newDialContext(&net.Dialer{}, "4", nil)(context.Background(), "tcp", "[::1]:"+port)
+	if !errors.As(err, &noAddrErr) || err.Error() != "no A record for ::1" {
+		t.Errorf("expected a missing A record, got %v", err)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		addr, ip, family string
+	}{
+		{"127.0.0.1:443", "127.0.0.1", "IPv4"},
+		{"[2001:db8::1]:443", "2001:db8::1", "IPv6"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if ip, family := remoteIP(tt.addr); ip != tt.ip || family != tt.family {
+			t.Errorf("remoteIP(%q): expected %s %s, got %s %s", tt.addr, tt.ip, tt.family, ip, family)
+		}
+	}
+}
+
+func TestParseResolve(t *testing.T) {
+	overrides, err := parseResolve([]string{"Example.com:443:192.0.2.10", "example.com:8443:[2001:db8::1]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"example.com:443": "192.0.2.10", "example.com:8443": "2001:db8::1"}
+	if !reflect.DeepEqual(overrides, want) {
+		t.Errorf("expected %v, got %v", want, overrides)
+	}
+	for _, entry := range []string{"example.com:443", "example.com:https:192.0.2.10", "example.com:0:192.0.2.10", ":443:192.0.2.10", "example.com:443:not-an-ip"} {
+		if _, err := parseResolve([]string{entry}); err == nil {
+			t.Errorf("expected an error for %q", entry)
+		}
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	for raw, want := range map[string]string{
+		"http://Example.com/":      "example.com:80",
+		"https://example.com/":     "example.com:443",
+		"https://example.com:8443": "example.com:8443",
+		"http://[::1]/":            "[::1]:80",
+	} {
+		u, _ := url.Parse(raw)
+		if got := hostPort(u); got != want {
+			t.Errorf("hostPort(%s): expected %s, got %s", raw, want, got)
+		}
+	}
+}
+
+// startDNSServer answers A queries for check.test and big.test with
+// 127.0.0.1, for multi.test with 127.0.0.1 and 127.0.0.2 and every other name
+// with NXDOMAIN. Answers for big.test are
+// truncated over UDP so the resolver has to retry over TCP. It returns the
+// server address and a counter of TCP queries.
+func startDNSServer(t *testing.T) (string, *int32) {
+	t.Helper()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Skipf("unable to listen on the same TCP port: %v", err)
+	}
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+
+	answer := func(query []byte, overTCP bool) []byte {
+		var p dnsmessage.Parser
+		header, err := p.Start(query)
+		if err != nil {
+			return nil
+		}
+		q, err := p.Question()
+		if err != nil {
+			return nil
+		}
+		header.Response, header.Authoritative = true, true
+		name := q.Name.String()
+		known := name == "check.test." || name == "big.test." || name == "multi.test." || name == "dual.test."
+		if !known {
+			header.RCode = dnsmessage.RCodeNameError
+		}
+		if name == "big.test." && !overTCP {
+			header.Truncated = true
+		}
+		b := dnsmessage.NewBuilder(nil, header)
+		b.StartQuestions()
+		b.Question(q)
+		b.StartAnswers()
+		if known && !header.Truncated && q.Type == dnsmessage.TypeA {
+			b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})
+			if name == "multi.test." {
+				b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.AResource{A: [4]byte{127, 0, 0, 2}})
+			}
+		}
+		if name == "dual.test." && q.Type == dnsmessage.TypeAAAA {
+			b.AAAAResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.AAAAResource{AAAA: [16]byte{15: 1}})
+		}
+		msg, _ := b.Finish()
+		return msg
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(answer(buf[:n], false), addr)
+		}
+	}()
+	var tcpQueries int32
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var length [2]byte
+					if _, err := io.ReadFull(conn, length[:]); err != nil {
+						return
+					}
+					query := make([]byte, binary.BigEndian.Uint16(length[:]))
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					atomic.AddInt32(&tcpQueries, 1)
+					msg := answer(query, true)
+					binary.BigEndian.PutUint16(length[:], uint16(len(msg)))
+					conn.Write(append(length[:], msg...))
+				}
+			}()
+		}
+	}()
+	return udp.LocalAddr().String(), &tcpQueries
+}
+
+func TestNewResolver(t *testing.T) {
+	if newResolver("", "auto") != nil {
+		t.Error("expected the system resolver without --dns-server")
+	}
+	server, tcpQueries := startDNSServer(t)
+	resolver := newResolver(server, "auto")
+
+	ips, err := resolver.LookupIP(context.Background(), "ip4", "check.test")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("expected 127.0.0.1, got %v, %v", ips, err)
+	}
+	if _, err := resolver.LookupIP(context.Background(), "ip4", "big.test"); err != nil || atomic.LoadInt32(tcpQueries) == 0 {
+		t.Errorf("expected the truncated answer to be retried over TCP, got %v after %d TCP queries", err, atomic.LoadInt32(tcpQueries))
+	}
+	if _, err := resolver.LookupIP(context.Background(), "ip4", "missing.test"); err == nil {
+		t.Error("expected an error for an unknown name")
+	}
+
+	if resolver := newResolver("", "go"); resolver == nil || !resolver.PreferGo {
+		t.Error("expected the pure Go resolver with --resolver go")
+	}
+	if resolver := newResolver("", "cgo"); resolver == nil || resolver.PreferGo {
+		t.Error("expected the system resolver with --resolver cgo")
+	}
+}
+
+func TestValidateResolver(t *testing.T) {
+	args := [][]string{{"--resolver", "nss"}, {"--resolver", "cgo", "--dns-server", "127.0.0.1"}}
+	if !cgoResolver {
+		args = append(args, []string{"--resolver", "cgo"})
+	}
+	for _, a := range args {
+		parseArgs(t, append([]string{"--url", "http://example.com/"}, a...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("expected %q to be rejected", a)
+		}
+	}
+	if !cgoResolver {
+		return
+	}
+
+	for godebug, want := range map[string]string{"": "netdns=cgo", "http2client=0": "http2client=0,netdns=cgo", "netdns=go": "netdns=cgo", "netdns=go+1,http2client=0": "http2client=0,netdns=cgo+1"} {
+		t.Setenv("GODEBUG", godebug)
+		setup(t, "--url", "http://example.com/", "--resolver", "cgo")
+		if got := os.Getenv("GODEBUG"); got != want {
+			t.Errorf("GODEBUG=%s: expected %s with --resolver cgo, got %s", godebug, want, got)
+		}
+	}
+}
+
+func TestResolverName(t *testing.T) {
+	plugin.Resolver = "auto"
+	dnsServer = "127.0.0.1:53"
+	if got := resolverName(); got != "go" {
+		t.Errorf("expected the Go resolver with --dns-server, got %s", got)
+	}
+	dnsServer = ""
+	want := map[string]string{"netdns=go": "go", "netdns=cgo+1": "cgo", "": "system"}
+	for godebug, name := range want {
+		t.Setenv("GODEBUG", godebug)
+		if !cgoResolver {
+			name = "go"
+		}
+		if got := resolverName(); got != name {
+			t.Errorf("GODEBUG=%s: expected the %s resolver, got %s", godebug, name, got)
+		}
+	}
+}
+
+func TestParseDNSServer(t *testing.T) {
+	for value, want := range map[string]string{
+		"":                    "",
+		"192.0.2.53":          "192.0.2.53:53",
+		"192.0.2.53:5353":     "192.0.2.53:5353",
+		"2001:db8::53":        "[2001:db8::53]:53",
+		"[2001:db8::53]:5353": "[2001:db8::53]:5353",
+	} {
+		if got, err := parseDNSServer(value); err != nil || got != want {
+			t.Errorf("parseDNSServer(%q): expected %q, got %q, %v", value, want, got, err)
+		}
+	}
+	for _, value := range []string{"dns.example.com", "192.0.2.53:dns", "192.0.2.53:0"} {
+		if _, err := parseDNSServer(value); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestWithConnectTimeout(t *testing.T) {
+	timeout := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		time.Sleep(20 * time.Millisecond)
+		return nil, &net.OpError{Op: "dial", Net: network, Err: os.ErrDeadlineExceeded}
+	}
+	_, err := withConnectTimeout(timeout)(context.Background(), "tcp", "192.0.2.1:443")
+	var connErr *connectTimeoutError
+	if !errors.As(err, &connErr) || connErr.addr != "192.0.2.1:443" || connErr.waited < 20*time.Millisecond {
+		t.Fatalf("expected a connect timeout for 192.0.2.1:443, got %v", err)
+	}
+	m := classifyError(&url.Error{Op: "Get", URL: "https://192.0.2.1/", Err: err})
+	if !strings.HasPrefix(m.err, "connect to 192.0.2.1:443 timed out after ") || m.reason != "timeout" {
+		t.Errorf("unexpected classification %q %q", m.err, m.reason)
+	}
+
+	// Other errors pass through.
+	refused := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+	}
+	if _, err := withConnectTimeout(refused)(context.Background(), "tcp", "192.0.2.1:443"); errors.As(err, &connErr) {
+		t.Errorf("expected a refused connection to pass through, got %v", err)
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	parseArgs(t, "--timeout", "2")
+	if status, err := checkArgs(nil); err != nil || connectTimeout() != 2*time.Second {
+		t.Errorf("expected the --timeout as default, got %s (%d, %v)", connectTimeout(), status, err)
+	}
+	parseArgs(t, "--timeout", "2", "--connect-timeout", "500")
+	if status, err := checkArgs(nil); err != nil || connectTimeout() != 500*time.Millisecond {
+		t.Errorf("expected 500ms, got %s (%d, %v)", connectTimeout(), status, err)
+	}
+	for _, value := range []string{"2001", "-1"} {
+		parseArgs(t, "--timeout", "2", "--connect-timeout", value)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("expected --connect-timeout %s to be rejected with --timeout 2", value)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// parseSHA256 parses --expect-sha256, a hex encoded SHA-256 digest like
+// sha256sum prints, returning nil when it is not set.
+func parseSHA256(value string) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	digest, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("invalid --expect-sha256 %q, expected %d hex digits", value, 2*sha256.Size)
+	}
+	return digest, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DoctorOgg/sensu-http-perf-go/httpperf"
+)
+
+// digestChallenge holds the parameters of a WWW-Authenticate: Digest
+// challenge, RFC 7616.
+type digestChallenge struct {
+	realm, nonce, opaque string
+	// algorithm is MD5, MD5-sess, SHA-256 or SHA-256-sess.
+	algorithm string
+	// qop is auth, or empty for a server that predates qop (RFC 2069).
+	qop string
+}
+
+// digestError is a Digest challenge the check cannot answer, the 401 is then
+// reported as it is.
+type digestError struct {
+	msg string
+}
+
+func (e *digestError) Error() string {
+	return e.msg
+}
+
+// parseDigestChallenge picks the Digest challenge out of the WWW-Authenticate
+// headers, preferring SHA-256 when the server offers more than one.
+func parseDigestChallenge(headers []string) (digestChallenge, error) {
+	var (
+		found bool
+		best  digestChallenge
+		err   error = &digestError{"401 without a Digest challenge"}
+	)
+	for _, header := range headers {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		params := parseAuthParams(rest)
+		c := digestChallenge{realm: params["realm"], nonce: params["nonce"], opaque: params["opaque"], algorithm: "MD5"}
+		if algorithm, ok := params["algorithm"]; ok {
+			c.algorithm = strings.ToUpper(algorithm)
+		}
+		switch c.algorithm {
+		case "MD5", "MD5-SESS", "SHA-256", "SHA-256-SESS":
+		default:
+			err = &digestError{fmt.Sprintf("unsupported digest algorithm %s", params["algorithm"])}
+			continue
+		}
+		if qop, ok := params["qop"]; ok {
+			for _, option := range strings.Split(qop, ",") {
+				if strings.TrimSpace(option) == "auth" {
+					c.qop = "auth"
+				}
+			}
+			if len(c.qop) == 0 {
+				err = &digestError{fmt.Sprintf("unsupported digest qop %s, only auth is", qop)}
+				continue
+			}
+		}
+		if len(c.nonce) == 0 {
+			err = &digestError{"digest challenge without a nonce"}
+			continue
+		}
+		if !found || strings.HasPrefix(c.algorithm, "SHA-256") && !strings.HasPrefix(best.algorithm, "SHA-256") {
+			best, found = c, true
+		}
+	}
+	if !found {
+		return digestChallenge{}, err
+	}
+	return best, nil
+}
+
+// parseAuthParams parses the comma separated name=value parameters of a
+// challenge, values may be quoted strings.
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " \t,")
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		rest = strings.TrimLeft(rest, " \t")
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			if i < len(rest) {
+				i++
+			}
+			s = rest[i:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			s = rest[end:]
+		}
+		params[name] = value.String()
+	}
+	return params
+}
+
+// authorization returns the Authorization header answering c for a request
+// of method to uri, with cnonce as the client nonce.
+func (c digestChallenge) authorization(user, password, method, uri, cnonce string) string {
+	var newHash func() hash.Hash = md5.New
+	if strings.HasPrefix(c.algorithm, "SHA-256") {
+		newHash = sha256.New
+	}
+	h := func(parts ...string) string {
+		d := newHash()
+		io.WriteString(d, strings.Join(parts, ":"))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+	const nc = "00000001"
+	ha1 := h(user, c.realm, password)
+	if strings.HasSuffix(c.algorithm, "-SESS") {
+		ha1 = h(ha1, c.nonce, cnonce)
+	}
+	ha2 := h(method, uri)
+
+	response := h(ha1, c.nonce, ha2)
+	if len(c.qop) > 0 {
+		response = h(ha1, c.nonce, nc, cnonce, c.qop, ha2)
+	}
+	algorithm := strings.Replace(c.algorithm, "-SESS", "-sess", 1)
+	header := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s, response=%q`, user, c.realm, c.nonce, uri, algorithm, response)
+	if len(c.qop) > 0 {
+		header += fmt.Sprintf(`, qop=%s, nc=%s, cnonce=%q`, c.qop, nc, cnonce)
+	}
+	if len(c.opaque) > 0 {
+		header += fmt.Sprintf(`, opaque=%q`, c.opaque)
+	}
+	return header
+}
+
+// answerDigest answers the Digest challenge of a 401 response with --user,
+// sending the challenged request once more. It returns the exchange of the
+// authenticated request and how long the challenge took from the start of
+// x, or x itself when it was not challenged.
+func answerDigest(ctx context.Context, x *httpperf.Exchange, jar http.CookieJar, transport func(*http.Request) http.RoundTripper) (*httpperf.Exchange, time.Duration, error) {
+	resp := x.Response
+	if resp.StatusCode != http.StatusUnauthorized {
+		return x, 0, nil
+	}
+	challenge, err := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if err != nil {
+		return x, 0, err
+	}
+
+	// The 401 body is not part of the measurement, read it so the
+	// authenticated request can reuse the connection.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	x.Final().Finish()
+	roundtrip := x.Final().Timings().Done.Sub(x.Start)
+	if plugin.Verbose {
+		dumpExchange(debugOutput, x, basicAuthPassword, proxyPassword())
+	}
+
+	// The jar adds its cookies to the request again, only keep the ones
+	// of --header.
+	req := resp.Request.Clone(ctx)
+	req.Header.Del("Cookie")
+	for _, value := range requestHeaders.Values("Cookie") {
+		req.Header.Add("Cookie", value)
+	}
+	if req.GetBody != nil {
+		if req.Body, err = req.GetBody(); err != nil {
+			return x, 0, err
+		}
+	}
+	cnonce := make([]byte, 16)
+	if _, err := rand.Read(cnonce); err != nil {
+		return x, 0, err
+	}
+	req.Header.Set("Authorization", challenge.authorization(basicAuthUser, basicAuthPassword, req.Method, req.URL.RequestURI(), hex.EncodeToString(cnonce)))
+	authenticated, err := httpperf.Send(ctx, req, requestBody, plugin.MaxRedirects, jar, transport)
+	return authenticated, roundtrip, err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestDigestAuthorization(t *testing.T) {
+	// The examples of RFC 7616 section 3.9.1.
+	const (
+		challenge = `realm="http-auth@example.org", qop="auth, auth-int", nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+		cnonce    = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	)
+	tests := []struct {
+		headers  []string
+		response string
+	}{
+		{[]string{"Digest " + challenge + ", algorithm=MD5"}, `response="8ca523f5e9506fed4657c9700eebdbec"`},
+		{[]string{"Digest " + challenge + ", algorithm=MD5", "Digest " + challenge + ", algorithm=SHA-256", "Basic realm=x"}, `response="753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"`},
+	}
+	for _, tt := range tests {
+		c, err := parseDigestChallenge(tt.headers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := c.authorization("Mufasa", "Circle of Life", "GET", "/dir/index.html", cnonce)
+		for _, want := range []string{tt.response, `username="Mufasa"`, `uri="/dir/index.html"`, "qop=auth, nc=00000001", `cnonce="` + cnonce + `"`, `opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`} {
+			if !strings.Contains(got, want) {
+				t.Errorf("expected %q in %s", want, got)
+			}
+		}
+	}
+
+	for _, bad := range [][]string{nil, {"Basic realm=x"}, {`Digest realm="x", nonce="n", algorithm=SHA-512-256`}, {`Digest realm="x", nonce="n", qop="auth-int"`}, {`Digest realm="x"`}} {
+		if _, err := parseDigestChallenge(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	got := parseAuthParams(`realm="a \"b\", c", nonce=abc ,qop="auth",stale=FALSE`)
+	want := map[string]string{"realm": `a "b", c`, "nonce": "abc", "qop": "auth", "stale": "FALSE"}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s: expected %q, got %q", name, value, got[name])
+		}
+	}
+}
+
+func TestExecuteCheckDigestAuth(t *testing.T) {
+	const challenge = `Digest realm="appliance", qop="auth", algorithm=SHA-256, nonce="abc123"`
+	var authorizations []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		authorizations = append(authorizations, authorization)
+		params := parseAuthParams(strings.TrimPrefix(authorization, "Digest "))
+		c, _ := parseDigestChallenge([]string{challenge})
+		if !strings.HasPrefix(authorization, "Digest ") || c.authorization("admin", "s3cret", r.Method, r.URL.RequestURI(), params["cnonce"]) != authorization {
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL+"/status?x=1", "--user", "admin:s3cret", "--auth-type", "digest")
+	status, out := run(t)
+	if status != sensu.CheckStateOK || !strings.Contains(out, "-> 200 OK") || !strings.Contains(out, "auth_roundtrip_duration=") {
+		t.Errorf("expected the digest challenge to be answered, got %d: %s", status, out)
+	}
+	if len(authorizations) != 2 || len(authorizations[0]) > 0 {
+		t.Errorf("expected an unauthenticated request then one answering the challenge, got %q", authorizations)
+	}
+	if strings.Contains(out, "s3cret") {
+		t.Errorf("expected the password to stay hidden, got %s", out)
+	}
+
+	setup(t, "--url", ts.URL, "--user", "admin:wrong", "--auth-type", "digest")
+	status, out = run(t)
+	if status != sensu.CheckStateWarning || !strings.Contains(out, "-> 401 Unauthorized") || !strings.Contains(out, "(digest authentication rejected)") {
+		t.Errorf("expected a rejected password to be reported, got %d: %s", status, out)
+	}
+
+	setup(t, "--url", ts.URL, "--user", "admin:s3cret")
+	if status, out := run(t); status != sensu.CheckStateWarning || strings.Contains(out, "auth_roundtrip_duration") {
+		t.Errorf("expected basic auth not to answer the challenge, got %d: %s", status, out)
+	}
+
+	parseArgs(t, "--url", ts.URL, "--auth-type", "digest")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected --auth-type digest without --user to be rejected")
+	}
+}
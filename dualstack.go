@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// family is one address family checked by --dual-stack-compare.
+type family struct {
+	name    string // "IPv4" or "IPv6"
+	prefix  string // metric prefix, "v4" or "v6"
+	version string // --ip-version of the family, "4" or "6"
+	record  string // DNS record type, "A" or "AAAA"
+}
+
+var (
+	familyV4 = family{"IPv4", "v4", "4", "A"}
+	familyV6 = family{"IPv6", "v6", "6", "AAAA"}
+)
+
+// validateDualStack checks --dual-stack-compare, which picks the address
+// family itself and reports a line of its own.
+func validateDualStack() error {
+	switch {
+	case plugin.DualStackDelta < 0:
+		return fmt.Errorf("--dualstack-delta-warning must not be negative")
+	case plugin.DualStackDelta > 0 && !plugin.DualStackCompare:
+		return fmt.Errorf("--dualstack-delta-warning requires --dual-stack-compare")
+	case !plugin.DualStackCompare:
+		return nil
+	case plugin.AllIps || len(checkedURLs) > 0:
+		return fmt.Errorf("--dual-stack-compare cannot be combined with --all-ips or --urls-file")
+	case plugin.IpVersion != "any":
+		return fmt.Errorf("--dual-stack-compare checks both address families and cannot be combined with --ip-version")
+	case plugin.OutputFormat != "nagios" && plugin.OutputFormat != "json":
+		return fmt.Errorf("--dual-stack-compare only supports the nagios and json output formats")
+	case tracksRegression():
+		return fmt.Errorf("--state-file and the regression thresholds are not supported with --dual-stack-compare")
+	}
+	return nil
+}
+
+// checkDualStack resolves the A and AAAA records of the URL host and measures
+// the first address of each family, the way a client falling back from IPv6
+// to IPv4 would see them. It reports v4_total_duration, v6_total_duration
+// and v6_minus_v4_ms. A family failing while the other works is a warning, as
+// is IPv6 diverging from IPv4 by more than --dualstack-delta-warning. A host
+// with a single family is measured and reported without a comparison.
+func checkDualStack(ctx context.Context) (int, error) {
+	u, err := url.Parse(plugin.Url)
+	if err != nil {
+		printError(errorStatus(), err.Error(), reasonConfig, []metric{valueMetric("up", 0, "")})
+		return checkState(errorStatus()), nil
+	}
+	host := u.Hostname()
+	v4, err4 := lookupStack(ctx, host, familyV4)
+	v6, err6 := lookupStack(ctx, host, familyV6)
+	if len(v4) == 0 && len(v6) == 0 {
+		err := err4
+		if err == nil {
+			err = err6
+		}
+		if err == nil {
+			err = fmt.Errorf("DNS lookup of %s failed: no A or AAAA record", host)
+		}
+		printError(dnsFailureStatus(), err.Error(), reasonDNS, []metric{valueMetric("up", 0, "")})
+		return checkState(dnsFailureStatus()), nil
+	}
+
+	measure := func(ip string) measurement {
+		overrides := map[string]string{}
+		for k, v := range resolveOverrides {
+			overrides[k] = v
+		}
+		overrides[hostPort(u)] = ip
+		return measureSamples(ctx, overrides)
+	}
+
+	var (
+		results  []string
+		metrics  []metric
+		measured = map[family]measurement{}
+	)
+	start := time.Now()
+	for _, f := range []family{familyV4, familyV6} {
+		ips, err := v4, err4
+		if f == familyV6 {
+			ips, err = v6, err6
+		}
+		if len(ips) == 0 {
+			if err != nil {
+				// A lookup failing for another reason than a missing record
+				// is a failure of the family, not a single stack host.
+				results = append(results, fmt.Sprintf("%s CRITICAL: %v", f.name, err))
+				measured[f] = measurement{status: "CRITICAL", err: err.Error(), reason: reasonDNS}
+				continue
+			}
+			results = append(results, fmt.Sprintf("no %s record, comparison skipped", f.record))
+			continue
+		}
+		m := measure(ips[0])
+		measured[f] = m
+		if len(m.err) > 0 {
+			results = append(results, fmt.Sprintf("%s %s %s: %s", f.name, ips[0], m.status, m.err))
+			continue
+		}
+		metrics = append(metrics, durationMetric(f.prefix+"_total_duration", m.elapsed, 0, 0))
+		result := fmt.Sprintf("%s %s %s: %s in %s", f.name, ips[0], m.status, m.statusLine, formatHeadline(m.elapsed, outputFormat()))
+		if m.status != "OK" {
+			result += m.details
+		}
+		results = append(results, result)
+	}
+
+	// The reason token is the one of the first of the worst results.
+	worst := measurement{status: "OK"}
+	for _, f := range []family{familyV4, familyV6} {
+		if m, ok := measured[f]; ok && worseStatus(worst.status, m.status) != worst.status {
+			worst = m
+		}
+	}
+	status := worst.status
+	m4, ok4 := measured[familyV4]
+	m6, ok6 := measured[familyV6]
+	switch {
+	case !ok4 || !ok6:
+		// A single stack host, there is nothing to compare.
+	case len(m4.err) > 0 && len(m6.err) == 0, len(m4.err) == 0 && len(m6.err) > 0:
+		// Clients fall back to the working family, slower.
+		failed, working := m4, m6
+		if len(m6.err) > 0 {
+			failed, working = m6, m4
+		}
+		status = worseStatus(working.status, "WARNING")
+		worst = failed
+	case len(m4.err) == 0 && len(m6.err) == 0:
+		delta := m6.elapsed - m4.elapsed
+		metrics = append(metrics, valueMetric("v6_minus_v4_ms", math.Round(float64(delta)/float64(time.Millisecond)*100)/100, ""))
+		result := fmt.Sprintf("IPv6 %s than IPv4", describeDelta(delta))
+		if plugin.DualStackDelta > 0 && absDuration(delta) > time.Duration(plugin.DualStackDelta)*time.Millisecond {
+			result += fmt.Sprintf(" (over --dualstack-delta-warning %dms)", plugin.DualStackDelta)
+			if worseStatus(status, "WARNING") != status {
+				status = "WARNING"
+				worst = measurement{status: "WARNING"}
+			}
+		}
+		results = append(results, result)
+	}
+
+	message := fmt.Sprintf("dual-stack in %s: %s", formatHeadline(time.Since(start), outputFormat()), strings.Join(results, ", "))
+	if plugin.OutputFormat == "json" {
+		result := CheckResult{Status: status, URL: plugin.Url, Message: message, Reason: reasonOf(worst)}
+		result.addMetrics(metrics)
+		printJSON(result)
+	} else {
+		fmt.Printf("%s %s: %s%s | %s\n", checkName(), status, message, reasonKey(worst), perfdata(metrics))
+	}
+	return checkState(status), nil
+}
+
+// lookupStack returns the addresses of host in family f, through the
+// --dns-server resolver when one is set. A host without a record of the
+// family has no addresses and no error. An IP literal is its own family.
+func lookupStack(ctx context.Context, host string, f family) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if (ip.To4() != nil) == (f == familyV4) {
+			return []string{ip.String()}, nil
+		}
+		return nil, nil
+	}
+	ips, err := lookupFamily(ctx, newResolver(dnsServer, plugin.Resolver), f.version, host)
+	var noAddrErr *noAddressError
+	if errors.As(err, &noAddrErr) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s lookup of %s failed: %v", f.record, host, err)
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return addrs, nil
+}
+
+// describeDelta renders how much slower or faster IPv6 was, e.g. "12.30ms
+// slower".
+func describeDelta(delta time.Duration) string {
+	word := "slower"
+	if delta < 0 {
+		word = "faster"
+	}
+	return fmt.Sprintf("%.2fms %s", float64(absDuration(delta))/float64(time.Millisecond), word)
+}
+
+// absDuration returns the absolute value of d.
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
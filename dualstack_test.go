@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestExecuteCheckDualStack(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// IPv6 is the slow family.
+		if strings.HasPrefix(r.RemoteAddr, "[") {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	// Listen on both loopback addresses so either family answers.
+	ln, err := net.Listen("tcp", "[::]:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	ts.Listener.Close()
+	ts.Listener = ln
+	ts.Start()
+	defer ts.Close()
+	server, _ := startDNSServer(t)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	tests := []struct {
+		args   []string
+		status int
+		want   []string
+	}{
+		{
+			[]string{"--url", "http://dual.test:" + port + "/"},
+			sensu.CheckStateOK,
+			[]string{"OK: dual-stack in ", "IPv4 127.0.0.1 OK: 200 OK in ", "IPv6 ::1 OK: 200 OK in ", "ms slower than IPv4 reason=ok | ", "v4_total_duration=", "v6_total_duration=", "v6_minus_v4_ms="},
+		},
+		{
+			[]string{"--url", "http://dual.test:" + port + "/", "--dualstack-delta-warning", "10"},
+			sensu.CheckStateWarning,
+			[]string{"ms slower than IPv4 (over --dualstack-delta-warning 10ms) reason=threshold_warning | "},
+		},
+		{
+			[]string{"--url", "http://check.test:" + port + "/", "--dualstack-delta-warning", "10"},
+			sensu.CheckStateOK,
+			[]string{"IPv4 127.0.0.1 OK: 200 OK in ", "no AAAA record, comparison skipped reason=ok | "},
+		},
+		{
+			[]string{"--url", "http://missing.test:" + port + "/"},
+			sensu.CheckStateCritical,
+			[]string{"CRITICAL: DNS lookup of missing.test failed: no A or AAAA record reason=dns_error"},
+		},
+	}
+	for _, tt := range tests {
+		setup(t, append(tt.args, "--dns-server", server, "--dual-stack-compare")...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %q", tt.args, want, out)
+			}
+		}
+	}
+
+	// Only IPv4 answers, clients fall back to it.
+	v4 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer v4.Close()
+	_, port, _ = net.SplitHostPort(v4.Listener.Addr().String())
+	setup(t, "--url", "http://dual.test:"+port+"/", "--dns-server", server, "--dual-stack-compare")
+	status, out := run(t)
+	if status != sensu.CheckStateWarning || !strings.Contains(out, "IPv6 ::1 CRITICAL: connection refused to [::1]:") || !strings.Contains(out, " reason=conn_refused | v4_total_duration=") {
+		t.Errorf("expected the refused IPv6 address to be a warning, got %d: %s", status, out)
+	}
+}
+
+func TestValidateDualStack(t *testing.T) {
+	for _, args := range [][]string{
+		{"--dualstack-delta-warning", "10"},
+		{"--dual-stack-compare", "--dualstack-delta-warning", "-1"},
+		{"--dual-stack-compare", "--all-ips"},
+		{"--dual-stack-compare", "--ip-version", "6"},
+		{"--dual-stack-compare", "--output-format", "influxdb"},
+		{"--dual-stack-compare", "--regression-warning", "2"},
+	} {
+		parseArgs(t, append([]string{"--url", "http://example.com/"}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("expected %q to be rejected", args)
+		}
+	}
+}
+
+func TestLookupStackIPv4OnlyHost(t *testing.T) {
+	// Find a name of /etc/hosts with IPv4 addresses only.
+	data, err := os.ReadFile("/etc/hosts")
+	if err != nil {
+		t.Skip(err)
+	}
+	families := map[string]map[bool]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(strings.SplitN(line, "#", 2)[0])
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			if families[name] == nil {
+				families[name] = map[bool]bool{}
+			}
+			families[name][ip.To4() != nil] = true
+		}
+	}
+	host := ""
+	for name, f := range families {
+		if f[true] && !f[false] {
+			host = name
+			break
+		}
+	}
+	if host == "" {
+		t.Skip("no IPv4 only name in /etc/hosts")
+	}
+
+	setup(t, "--url", "http://"+host+"/", "--resolver", "go")
+	v4, err := lookupStack(context.Background(), host, familyV4)
+	if len(v4) == 0 || err != nil {
+		t.Errorf("%s: expected A records, got %v %v", host, v4, err)
+	}
+	v6, err := lookupStack(context.Background(), host, familyV6)
+	if len(v6) != 0 || err != nil {
+		t.Errorf("%s: expected no AAAA record and no error, got %v %v", host, v6, err)
+	}
+}
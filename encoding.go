@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decodesBody reports whether the body is decompressed by the check rather
+// than by the transport, which --accept-encoding gzip or br turns off.
+func decodesBody() bool {
+	return plugin.AcceptEncoding == "gzip" || plugin.AcceptEncoding == "br"
+}
+
+// contentEncoding returns the content coding of resp, gzip when the
+// transport already decompressed it and identity when there is none.
+func contentEncoding(resp *http.Response) string {
+	if resp.Uncompressed {
+		return "gzip"
+	}
+	if encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); len(encoding) > 0 {
+		return encoding
+	}
+	return "identity"
+}
+
+// canDecode reports whether a body with encoding can be decompressed.
+func canDecode(encoding string) bool {
+	return encoding == "gzip" || (encoding == "br" && brotliSupported)
+}
+
+// decodeBody decompresses body, which is encoded with gzip or br, refusing
+// to produce more than limit bytes.
+func decodeBody(encoding string, body []byte, limit int64) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "br":
+		r = newBrotliReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, fmt.Errorf("decompressed body larger than --max-body-bytes %d", limit)
+	}
+	return buf.Bytes(), nil
+}
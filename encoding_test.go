@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// gzipped returns s compressed with gzip.
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	body := strings.Repeat("hello ", 100)
+	got, err := decodeBody("gzip", gzipped(t, body), 1<<20)
+	if err != nil || string(got) != body {
+		t.Errorf("expected the body back, got %q %v", got, err)
+	}
+	if _, err := decodeBody("gzip", gzipped(t, body), 100); err == nil || !strings.Contains(err.Error(), "larger than --max-body-bytes") {
+		t.Errorf("expected the limit to apply to the decompressed body, got %v", err)
+	}
+	if _, err := decodeBody("gzip", []byte("plain"), 1<<20); err == nil {
+		t.Errorf("expected an error for a body that is not gzip")
+	}
+	if _, err := decodeBody("deflate", nil, 1<<20); err == nil {
+		t.Errorf("expected an error for an unsupported encoding")
+	}
+}
+
+func TestContentEncoding(t *testing.T) {
+	tests := []struct {
+		resp *http.Response
+		want string
+	}{
+		{&http.Response{Header: http.Header{}}, "identity"},
+		{&http.Response{Header: http.Header{"Content-Encoding": {"GZIP "}}}, "gzip"},
+		{&http.Response{Header: http.Header{}, Uncompressed: true}, "gzip"},
+	}
+	for _, tt := range tests {
+		if got := contentEncoding(tt.resp); got != tt.want {
+			t.Errorf("%v: expected %s, got %s", tt.resp.Header, tt.want, got)
+		}
+	}
+}
+
+func TestExecuteCheckAcceptEncoding(t *testing.T) {
+	body := strings.Repeat("hello ", 1000)
+	compressed := gzipped(t, body)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") == "gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed)
+			return
+		}
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		args    []string
+		want    []string
+		notWant string
+	}{
+		// Go asks for gzip by itself and decompresses transparently.
+		{nil, []string{" content_encoding=gzip "}, "decompress_duration"},
+		{[]string{"--accept-encoding", "identity"}, []string{" content_encoding=identity "}, "decompress_duration"},
+		{[]string{"--accept-encoding", "gzip", "--expect-body-contains", "hello hello"}, []string{
+			" content_encoding=gzip ",
+			" compressed_bytes=" + strconv.Itoa(len(compressed)) + "B",
+			" uncompressed_bytes=" + strconv.Itoa(len(body)) + "B",
+			" decompress_duration=",
+		}, ""},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		status, out := run(t)
+		if status != sensu.CheckStateOK {
+			t.Errorf("%q: expected OK, got %d: %s", tt.args, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %s", tt.args, want, out)
+			}
+		}
+		if len(tt.notWant) > 0 && strings.Contains(out, tt.notWant) {
+			t.Errorf("%q: expected no %q in %s", tt.args, tt.notWant, out)
+		}
+	}
+
+	parseArgs(t, "--url", ts.URL, "--accept-encoding", "gzip", "--header", "Accept-Encoding: br")
+	if _, err := checkArgs(nil); err == nil {
+		t.Errorf("expected --accept-encoding and an Accept-Encoding header to conflict")
+	}
+}
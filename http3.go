@@ -0,0 +1,185 @@
+//go:build !nohttp3
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Supported reports whether --http3 is available, builds with the
+// nohttp3 tag leave quic-go out to save binary size.
+const http3Supported = true
+
+// http3Transport wraps the quic-go round tripper, which does not report
+// httptrace events, and fires the ones the hop timings rely on.
+type http3Transport struct {
+	roundTripper *http3.RoundTripper
+	conns        map[string]quic.EarlyConnection
+}
+
+// newHTTP3Transport returns a round tripper speaking HTTP/3 over QUIC. The
+// address is resolved the same way as for TCP, with the --resolve overrides,
+// --ip-version and the --dns-server resolver.
+func newHTTP3Transport(tlsConfig *tls.Config, resolver *net.Resolver, ipVersion string, overrides map[string]string) http.RoundTripper {
+	t := &http3Transport{conns: map[string]quic.EarlyConnection{}}
+	t.roundTripper = &http3.RoundTripper{
+		TLSClientConfig:    tlsConfig,
+		DisableCompression: len(plugin.AcceptEncoding) > 0,
+		QuicConfig: &quic.Config{
+			HandshakeIdleTimeout: time.Duration(plugin.TlsTimeout) * time.Millisecond,
+		},
+		Dial: func(ctx context.Context, addr string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+			conn, err := dialQUIC(ctx, addr, tlsConfig, config, resolver, ipVersion, overrides)
+			if err != nil {
+				return nil, err
+			}
+			t.conns[strings.ToLower(addr)] = conn
+			return conn, nil
+		},
+	}
+	return t
+}
+
+func (t *http3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace == nil {
+		trace = &httptrace.ClientTrace{}
+	}
+	// A new connection reports itself from dialQUIC.
+	if conn, ok := t.conns[hostPort(req.URL)]; ok && conn.Context().Err() == nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: quicConn{conn: conn}, Reused: true})
+	}
+	resp, err := t.roundTripper.RoundTrip(req)
+	// The response headers are parsed by now, which is as close to the first
+	// byte as quic-go lets us get.
+	if err == nil && trace.GotFirstResponseByte != nil {
+		trace.GotFirstResponseByte()
+	}
+	return resp, err
+}
+
+func (t *http3Transport) Close() error {
+	return t.roundTripper.Close()
+}
+
+// dialQUIC resolves addr and establishes a QUIC connection to it. QUIC has
+// no separate TCP connect, so both the connect and the TLS handshake phases
+// of the trace span the whole QUIC handshake.
+func dialQUIC(ctx context.Context, addr string, tlsConfig *tls.Config, config *quic.Config, resolver *net.Resolver, ipVersion string, overrides map[string]string) (quic.EarlyConnection, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip, ok := overrides[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+		host = ip
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ips, err := lookupFamily(ctx, resolver, ipVersion, host)
+		if err != nil {
+			return nil, err
+		}
+		ip = ips[0]
+	} else if (ipVersion == "4" || ipVersion == "6") && (ip.To4() != nil) != (ipVersion == "4") {
+		return nil, &noAddressError{host: host, version: ipVersion}
+	}
+	remote := net.JoinHostPort(ip.String(), port)
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace == nil {
+		trace = &httptrace.ClientTrace{}
+	}
+	if trace.ConnectStart != nil {
+		trace.ConnectStart("udp", remote)
+	}
+	if trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	conn, err := dialQUICFrom(ctx, remote, tlsConfig, config)
+	if err == nil {
+		select {
+		case <-conn.HandshakeComplete():
+		case <-conn.Context().Done():
+			err = context.Cause(conn.Context())
+		case <-ctx.Done():
+			err = ctx.Err()
+			conn.CloseWithError(0, "")
+		}
+	}
+	var state tls.ConnectionState
+	if err == nil {
+		state = conn.ConnectionState().TLS
+	}
+	if trace.ConnectDone != nil {
+		trace.ConnectDone("udp", remote, err)
+	}
+	if trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(state, err)
+	}
+	if err != nil {
+		var (
+			handshakeTimeout *quic.HandshakeTimeoutError
+			idleTimeout      *quic.IdleTimeoutError
+		)
+		if errors.As(err, &handshakeTimeout) || errors.As(err, &idleTimeout) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, &quicTimeoutError{err: err}
+		}
+		return nil, err
+	}
+	if trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: quicConn{conn: conn}})
+	}
+	return conn, nil
+}
+
+// dialQUICFrom establishes a QUIC connection to remote, from a socket bound
+// to the --source-address and --interface when set.
+func dialQUICFrom(ctx context.Context, remote string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+	if !bound() {
+		return quic.DialAddrEarly(ctx, remote, tlsConfig, config)
+	}
+	addr, err := net.ResolveUDPAddr("udp", remote)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := listenPacket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := quic.DialEarly(ctx, pc, addr, tlsConfig, config)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	// quic-go leaves closing a socket it was handed to the caller.
+	go func() {
+		<-conn.Context().Done()
+		pc.Close()
+	}()
+	return conn, nil
+}
+
+// quicConn presents a QUIC connection as the net.Conn httptrace hands out,
+// only its addresses are used.
+type quicConn struct {
+	net.Conn
+	conn quic.Connection
+}
+
+func (c quicConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c quicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
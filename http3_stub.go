@@ -0,0 +1,18 @@
+//go:build nohttp3
+
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+// http3Supported reports whether --http3 is available, this build was made
+// with the nohttp3 tag and leaves quic-go out.
+const http3Supported = false
+
+// newHTTP3Transport is never called, checkArgs rejects --http3 first.
+func newHTTP3Transport(_ *tls.Config, _ *net.Resolver, _ string, _ map[string]string) http.RoundTripper {
+	return nil
+}
@@ -0,0 +1,70 @@
+//go:build !nohttp3
+
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestCheckArgsHTTP3(t *testing.T) {
+	for _, args := range [][]string{
+		{"--url", "http://localhost/", "--http3"},
+		{"--url", "https://localhost/", "--http3", "--http-version", "2"},
+		{"--url", "https://localhost/", "--http3", "--unix-socket", "/tmp/socket"},
+	} {
+		parseArgs(t, args...)
+		if status, err := checkArgs(nil); err == nil || status != sensu.CheckStateUnknown {
+			t.Errorf("%q: expected UNKNOWN, got %d, %v", args, status, err)
+		}
+	}
+}
+
+func TestExecuteCheckHTTP3(t *testing.T) {
+	// Borrow the test certificate of a TLS server for the QUIC listener.
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) }),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: ts.TLS.Certificates}),
+	}
+	go server.Serve(conn)
+	defer server.Close()
+
+	setup(t, "--url", "https://"+conn.LocalAddr().String()+"/", "--insecure-skip-verify", "--http3", "--read-body")
+	status, out := run(t)
+	if status != sensu.CheckStateOK {
+		t.Fatalf("expected OK, got %d: %s", status, out)
+	}
+	for _, want := range []string{" remote_addr=" + conn.LocalAddr().String() + " ", " proto=HTTP/3.0 ", " tls=TLS1.3 ", "http_version=3 ", "response_body_bytes=5B "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %q", want, out)
+		}
+	}
+}
+
+func TestExecuteCheckHTTP3Blocked(t *testing.T) {
+	// Nothing answers on this socket, as if a firewall dropped the packets.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	setup(t, "--url", "https://"+conn.LocalAddr().String()+"/", "--http3", "--tls-timeout", "200")
+	status, out := run(t)
+	if status != sensu.CheckStateCritical || !strings.Contains(out, "QUIC handshake timed out, UDP may be blocked") {
+		t.Errorf("expected a QUIC handshake timeout, got %d: %s", status, out)
+	}
+}
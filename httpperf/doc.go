@@ -0,0 +1,11 @@
+// Package httpperf times HTTP requests phase by phase: the DNS lookup, the
+// TCP connect, the TLS handshake, the time to first byte and the transfer of
+// the body, following redirects one hop at a time.
+//
+// Measure runs one request and returns its Result. Send and Hop are the
+// building blocks it uses, for callers that need a transport per hop or
+// want to inspect the response themselves, as sensu-http-perf-go does.
+//
+// The exported API follows semantic versioning along with the module,
+// breaking changes only come with a new major version.
+package httpperf
@@ -0,0 +1,179 @@
+package httpperf
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Options describes the request to measure.
+type Options struct {
+	// URL is the http or https URL to request.
+	URL string
+
+	// Method defaults to GET.
+	Method string
+
+	Header http.Header
+	Body   []byte
+
+	// Host overrides the Host header, the URL host is used when empty.
+	Host string
+
+	// MaxRedirects is the number of redirects to follow, the redirect
+	// response itself is measured when zero.
+	MaxRedirects int
+
+	// Jar holds the cookies sent and collects those set by the responses,
+	// e.g. to measure a request of a logged in session. A new jar is used
+	// when nil.
+	Jar http.CookieJar
+
+	// Transport sends every hop, http.DefaultTransport when nil. Timeouts,
+	// proxies and TLS settings are configured on it.
+	Transport http.RoundTripper
+}
+
+// Result is the outcome of a measured request. Durations are in nanoseconds
+// once encoded as JSON, phases that did not occur, like the lookup of a
+// reused connection, are zero.
+type Result struct {
+	// URL is the requested URL and FinalURL the one that produced the
+	// response, after redirects.
+	URL           string   `json:"url"`
+	FinalURL      string   `json:"final_url"`
+	StatusCode    int      `json:"status_code"`
+	Proto         string   `json:"proto"`
+	Redirects     int      `json:"redirects"`
+	RemoteAddr    string   `json:"remote_addr,omitempty"`
+	LocalAddr     string   `json:"local_addr,omitempty"`
+	ResolvedAddrs []string `json:"resolved_addrs,omitempty"`
+	Reused        bool     `json:"reused"`
+	BodyBytes     int64    `json:"body_bytes"`
+	TLS           *TLSInfo `json:"tls,omitempty"`
+
+	// The phases are those of the final hop. FirstByte runs from the start
+	// of the request and is split into NetworkSetup until a connection was
+	// obtained, RequestWrite and ServerProcessing when the transport reports
+	// when the request was written.
+	DNS              time.Duration `json:"dns"`
+	Connect          time.Duration `json:"connect"`
+	TLSHandshake     time.Duration `json:"tls_handshake"`
+	FirstByte        time.Duration `json:"first_byte"`
+	NetworkSetup     time.Duration `json:"network_setup"`
+	RequestWrite     time.Duration `json:"request_write"`
+	ServerProcessing time.Duration `json:"server_processing"`
+	ContentTransfer  time.Duration `json:"content_transfer"`
+
+	// Total covers every hop, from the first request until the body of the
+	// final response was read.
+	Total time.Duration `json:"total"`
+}
+
+// TLSInfo describes the negotiated TLS connection.
+type TLSInfo struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name,omitempty"`
+	ALPN        string `json:"alpn,omitempty"`
+}
+
+// NewTLSInfo summarizes state, returning nil for plain http.
+func NewTLSInfo(state *tls.ConnectionState) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+	return &TLSInfo{
+		Version:     TLSVersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+		ALPN:        state.NegotiatedProtocol,
+	}
+}
+
+// TLSVersionName returns the name of a TLS version, e.g. TLS1.3.
+func TLSVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS1.0"
+	case tls.VersionTLS11:
+		return "TLS1.1"
+	case tls.VersionTLS12:
+		return "TLS1.2"
+	case tls.VersionTLS13:
+		return "TLS1.3"
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
+
+// Measure sends the request described by opts, reads the whole response
+// body and returns the timings. A request that fails still returns the
+// phases it got through along with the error. Cancelling ctx aborts the
+// request, including the read of the body.
+func Measure(ctx context.Context, opts Options) (Result, error) {
+	method := opts.Method
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if len(opts.Body) > 0 {
+		body = bytes.NewReader(opts.Body)
+	}
+	req, err := http.NewRequest(method, opts.URL, body)
+	if err != nil {
+		return Result{}, err
+	}
+	if opts.Header != nil {
+		req.Header = opts.Header.Clone()
+	}
+	if len(opts.Host) > 0 {
+		req.Host = opts.Host
+	}
+	transport := opts.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	x, err := Send(ctx, req, opts.Body, opts.MaxRedirects, opts.Jar, func(*http.Request) http.RoundTripper { return transport })
+	if err != nil {
+		return newResult(x, 0), err
+	}
+	defer x.Response.Body.Close()
+	n, err := io.Copy(io.Discard, x.Response.Body)
+	x.Final().Finish()
+	return newResult(x, n), err
+}
+
+// newResult summarizes x, n is the size of the body that was read.
+func newResult(x *Exchange, n int64) Result {
+	t := x.Final().Timings()
+	r := Result{
+		URL:              x.Chain[0],
+		FinalURL:         x.Chain[len(x.Hops)-1],
+		StatusCode:       t.Status,
+		Redirects:        len(x.Hops) - 1,
+		RemoteAddr:       t.RemoteAddr,
+		LocalAddr:        t.LocalAddr,
+		ResolvedAddrs:    t.DNSAddrs,
+		Reused:           t.Reused,
+		BodyBytes:        n,
+		DNS:              span(t.DNSStart, t.DNSDone),
+		Connect:          span(t.ConnectStart, t.ConnectDone),
+		TLSHandshake:     span(t.TLSHandshakeStart, t.TLSHandshakeDone),
+		FirstByte:        span(t.Start, t.FirstResponseByte),
+		NetworkSetup:     span(t.Start, t.GotConn),
+		RequestWrite:     span(t.GotConn, t.WroteRequest),
+		ServerProcessing: span(t.WroteRequest, t.FirstResponseByte),
+		ContentTransfer:  span(t.FirstResponseByte, t.Done),
+		Total:            span(x.Start, t.Done),
+	}
+	if x.Response != nil {
+		r.Proto = x.Response.Proto
+		r.TLS = NewTLSInfo(x.Response.TLS)
+	}
+	return r
+}
@@ -0,0 +1,84 @@
+package httpperf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMeasure(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "www.example.com" || r.Header.Get("X-Test") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(w, "hello")
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+
+	opts := Options{
+		URL:          ts.URL + "/redirect",
+		Header:       http.Header{"X-Test": {"1"}},
+		Host:         "www.example.com",
+		MaxRedirects: 5,
+		Transport:    ts.Client().Transport,
+	}
+	r, err := Measure(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != http.StatusOK || r.Redirects != 1 || r.FinalURL != ts.URL+"/" || r.BodyBytes != 5 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r.TLS == nil || r.Proto != "HTTP/1.1" {
+		t.Errorf("expected TLS over HTTP/1.1, got %+v %s", r.TLS, r.Proto)
+	}
+	if r.ServerProcessing < 20*time.Millisecond || r.FirstByte < r.ServerProcessing || r.Total < r.FirstByte {
+		t.Errorf("unexpected phases %+v", r)
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Result
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded.Total != r.Total || decoded.TLS.Version != r.TLS.Version {
+		t.Errorf("expected %s to round trip, got %+v %v", b, decoded, err)
+	}
+}
+
+func TestMeasureCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	r, err := Measure(ctx, Options{URL: ts.URL})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the request to be canceled, got %v", err)
+	}
+	if r.Connect == 0 || r.StatusCode != 0 {
+		t.Errorf("expected the phases up to the cancellation, got %+v", r)
+	}
+}
+
+func TestTLSVersionName(t *testing.T) {
+	for version, want := range map[uint16]string{0x0301: "TLS1.0", 0x0303: "TLS1.2", 0x0304: "TLS1.3", 0x9999: "0x9999"} {
+		if got := TLSVersionName(version); got != want {
+			t.Errorf("%x: expected %s, got %s", version, want, got)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package httpperf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// redirectTarget returns the URL a redirect response points to, resolved
+// against the request URL.
+func redirectTarget(resp *http.Response) (*url.URL, bool) {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, false
+	}
+	location := resp.Header.Get("Location")
+	if len(location) == 0 {
+		return nil, false
+	}
+	target, err := resp.Request.URL.Parse(location)
+	if err != nil {
+		return nil, false
+	}
+	return target, true
+}
+
+// nextRequest builds the request for the next hop of a redirect the same way
+// http.Client does: 301, 302 and 303 switch to GET without a body while 307
+// and 308 repeat the method and body. Credentials are dropped when the
+// redirect leaves the original host, cookies come from the client jar.
+func nextRequest(prev *http.Request, target *url.URL, status int, body []byte) (*http.Request, error) {
+	method := prev.Method
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+		if method != http.MethodGet && method != http.MethodHead {
+			method = http.MethodGet
+		}
+		body = nil
+	}
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, target.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = prev.Header.Clone()
+	req.Close = prev.Close
+	if len(body) == 0 {
+		req.Header.Del("Content-Type")
+		req.Header.Del("Expect")
+	}
+	if !strings.EqualFold(target.Host, prev.URL.Host) {
+		req.Header.Del("Authorization")
+		req.Header.Del("Cookie")
+	} else if len(prev.Host) > 0 {
+		// Keep an overridden Host header while staying on the same server.
+		req.Host = prev.Host
+	}
+	return req, nil
+}
+
+// RedirectError is returned when a request is redirected more than the
+// maximum number of redirects.
+type RedirectError struct {
+	// Chain is the URL of every hop, the last one was not followed.
+	Chain []string
+	Limit int
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("stopped after %d redirects: %s", e.Limit, strings.Join(e.Chain, " -> "))
+}
+
+// FollowError is returned when the request for the next hop of a redirect
+// cannot be built.
+type FollowError struct {
+	Err error
+}
+
+func (e *FollowError) Error() string {
+	return "Error following redirect: " + e.Err.Error()
+}
+
+func (e *FollowError) Unwrap() error {
+	return e.Err
+}
+
+// Exchange is a request along with the redirects it followed.
+type Exchange struct {
+	// Hops has one entry per request sent, the last one is the hop that
+	// failed or produced Response.
+	Hops []*Hop
+
+	// Chain is the URL of every hop, including a redirect target that was
+	// not followed because of the redirect limit.
+	Chain []string
+
+	// Response is the final response, its body is left for the caller to
+	// read and close.
+	Response *http.Response
+	Start    time.Time
+}
+
+// Final returns the last hop of x.
+func (x *Exchange) Final() *Hop {
+	return x.Hops[len(x.Hops)-1]
+}
+
+// Send sends req, following up to maxRedirects redirects one hop at a time
+// so that every hop gets its own trace, with the transport returned for each
+// hop. body is sent again when a 307 or 308 redirect repeats the request.
+// jar carries cookies from one hop to the next, a new one is used when nil.
+// The deadline of ctx covers the whole redirect chain. The exchange is
+// returned along with the error when a hop fails, so the phases it got
+// through can be reported.
+func Send(ctx context.Context, req *http.Request, body []byte, maxRedirects int, jar http.CookieJar, transport func(*http.Request) http.RoundTripper) (*Exchange, error) {
+	if jar == nil {
+		jar, _ = cookiejar.New(nil)
+	}
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	x := &Exchange{Chain: []string{req.URL.String()}, Start: time.Now()}
+	for {
+		h := newHop()
+		x.Hops = append(x.Hops, h)
+		client.Transport = transport(req)
+		h.req = req
+		resp, err := client.Do(req.WithContext(httptrace.WithClientTrace(ctx, h.trace())))
+		if err != nil {
+			return x, err
+		}
+		h.mu.Lock()
+		h.status = resp.StatusCode
+		h.req = resp.Request
+		h.resp = resp
+		h.mu.Unlock()
+
+		target, ok := redirectTarget(resp)
+		if !ok || maxRedirects == 0 {
+			x.Response = resp
+			return x, nil
+		}
+		x.Chain = append(x.Chain, target.String())
+		if len(x.Hops) > maxRedirects {
+			resp.Body.Close()
+			return x, &RedirectError{Chain: x.Chain, Limit: maxRedirects}
+		}
+		// Drain a little of the redirect body so the connection can be reused.
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+		resp.Body.Close()
+		h.Finish()
+
+		req, err = nextRequest(req, target, resp.StatusCode, body)
+		if err != nil {
+			return x, &FollowError{Err: err}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package httpperf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextRequest(t *testing.T) {
+	prev, _ := http.NewRequest(http.MethodPost, "http://example.com/a", nil)
+	prev.Header.Set("Authorization", "Bearer secret")
+	prev.Header.Set("Content-Type", "application/json")
+	body := []byte(`{}`)
+
+	tests := []struct {
+		status     int
+		target     string
+		method     string
+		hasBody    bool
+		authorized bool
+	}{
+		{http.StatusFound, "http://example.com/b", http.MethodGet, false, true},
+		{http.StatusSeeOther, "http://example.com/b", http.MethodGet, false, true},
+		{http.StatusTemporaryRedirect, "http://example.com/b", http.MethodPost, true, true},
+		{http.StatusPermanentRedirect, "http://other.example.com/b", http.MethodPost, true, false},
+	}
+	for _, tt := range tests {
+		target, _ := url.Parse(tt.target)
+		req, err := nextRequest(prev, target, tt.status, body)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", tt.status, err)
+		}
+		if req.Method != tt.method {
+			t.Errorf("%d: expected method %s, got %s", tt.status, tt.method, req.Method)
+		}
+		if (req.Body != nil) != tt.hasBody || (req.Header.Get("Content-Type") != "") != tt.hasBody {
+			t.Errorf("%d: expected body %t, got %v %q", tt.status, tt.hasBody, req.Body, req.Header.Get("Content-Type"))
+		}
+		if (req.Header.Get("Authorization") != "") != tt.authorized {
+			t.Errorf("%d: expected authorization %t, got %q", tt.status, tt.authorized, req.Header.Get("Authorization"))
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+	transport := func(*http.Request) http.RoundTripper { return ts.Client().Transport }
+
+	send := func(t *testing.T, ctx context.Context, path string, maxRedirects int) (*Exchange, error) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Send(ctx, req, nil, maxRedirects, nil, transport)
+	}
+
+	t.Run("tls", func(t *testing.T) {
+		x, err := send(t, context.Background(), "/", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer x.Response.Body.Close()
+		timings := x.Final().Timings()
+		if x.Response.TLS == nil || len(x.Hops) != 1 || timings.Status != http.StatusOK {
+			t.Errorf("unexpected exchange: tls=%v hops=%d", x.Response.TLS != nil, len(x.Hops))
+		}
+		if timings.TLSHandshakeDone.IsZero() {
+			t.Errorf("expected a traced TLS handshake")
+		}
+		if timings.ALPN != x.Response.TLS.NegotiatedProtocol {
+			t.Errorf("expected the ALPN protocol %q, got %q", x.Response.TLS.NegotiatedProtocol, timings.ALPN)
+		}
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		x, err := send(t, context.Background(), "/redirect", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer x.Response.Body.Close()
+		if len(x.Hops) != 2 || x.Hops[0].Timings().Status != http.StatusFound || x.Response.StatusCode != http.StatusOK {
+			t.Errorf("unexpected exchange: %d hops, chain %v", len(x.Hops), x.Chain)
+		}
+		if x.Hops[0].Timings().Done.IsZero() {
+			t.Errorf("expected the redirect hop to be done")
+		}
+	})
+
+	t.Run("no redirects", func(t *testing.T) {
+		x, err := send(t, context.Background(), "/redirect", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer x.Response.Body.Close()
+		if len(x.Hops) != 1 || x.Response.StatusCode != http.StatusFound {
+			t.Errorf("expected the redirect itself, got %d hops and %d", len(x.Hops), x.Response.StatusCode)
+		}
+	})
+
+	t.Run("redirect limit", func(t *testing.T) {
+		x, err := send(t, context.Background(), "/loop", 2)
+		var redirectErr *RedirectError
+		if !errors.As(err, &redirectErr) {
+			t.Fatalf("expected a redirect error, got %v", err)
+		}
+		if len(x.Hops) != 3 || len(x.Chain) != 4 {
+			t.Errorf("expected 3 hops and 4 URLs, got %d and %v", len(x.Hops), x.Chain)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		x, err := send(t, ctx, "/slow", 10)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected a deadline error, got %v", err)
+		}
+		if len(x.Hops) != 1 || x.Final().Timings().GotConn.IsZero() {
+			t.Errorf("expected the failed hop to have connected")
+		}
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:1/", nil)
+		_, err := Send(context.Background(), req, nil, 10, nil, func(*http.Request) http.RoundTripper { return &http.Transport{} })
+		if err == nil || !strings.Contains(err.Error(), "refused") {
+			t.Errorf("expected connection refused, got %v", err)
+		}
+	})
+}
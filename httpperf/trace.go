@@ -0,0 +1,228 @@
+package httpperf
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Hop is a single request of a redirect chain along with the timings and
+// addresses recorded by its trace. The trace can still fire after a request
+// failed, mu guards what it records.
+type Hop struct {
+	mu                                  sync.Mutex
+	start, done                         time.Time
+	dnsStart, dnsDone                   time.Time
+	connectStart, connectDone           time.Time
+	tlsHandshakeStart, tlsHandshakeDone time.Time
+	gotConn, firstResponseByte          time.Time
+	wroteHeaders, wroteRequest          time.Time
+	wait100Continue, got100Continue     time.Time
+	remoteAddr, localAddr               string
+	alpn                                string
+	reused, dnsCoalesced                bool
+	dnsAddrs                            []string
+	dnsErr                              error
+	status                              int
+
+	// req is the request sent and resp its response, if any. The body of
+	// resp is closed unless it is the final response.
+	req  *http.Request
+	resp *http.Response
+}
+
+// Timings is a copy of what the trace of a hop recorded. Events that did not
+// happen, like the lookup of a reused connection, are zero. When the
+// transport retries the request on another connection the events fire
+// again, the last occurrence is kept.
+type Timings struct {
+	// Start is when the request was sent and Done when its response was
+	// complete, Done is zero while the body has not been read.
+	Start, Done                         time.Time
+	DNSStart, DNSDone                   time.Time
+	ConnectStart, ConnectDone           time.Time
+	TLSHandshakeStart, TLSHandshakeDone time.Time
+	GotConn, FirstResponseByte          time.Time
+	WroteHeaders, WroteRequest          time.Time
+
+	// Wait100Continue is when the headers of a request with Expect:
+	// 100-continue were written and Got100Continue when the server allowed
+	// the body to follow, zero when it never did.
+	Wait100Continue, Got100Continue time.Time
+
+	// RemoteAddr is the address of the connection the request went over
+	// and LocalAddr the source address it was opened from.
+	RemoteAddr string
+	LocalAddr  string
+
+	// ALPN is the protocol the TLS handshake negotiated, e.g. h2, empty
+	// without TLS, without ALPN or over a reused connection.
+	ALPN string
+
+	// Reused is set when the request went over a kept alive connection.
+	Reused bool
+
+	// DNSAddrs are the addresses the lookup returned, DNSErr why it failed.
+	// DNSCoalesced is set when the lookup was shared with a concurrent one
+	// of the same host.
+	DNSAddrs     []string
+	DNSErr       error
+	DNSCoalesced bool
+
+	// Status is the status code of the response, zero when it failed.
+	Status int
+}
+
+// newHop starts a hop.
+func newHop() *Hop {
+	return &Hop{start: time.Now()}
+}
+
+// trace returns a ClientTrace recording the phases of the hop. A new
+// connection starts a new attempt at writing the request, so GotConn clears
+// what an earlier attempt wrote.
+func (h *Hop) trace() *httptrace.ClientTrace {
+	now := func(t *time.Time) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		*t = time.Now()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(_ httptrace.DNSStartInfo) { now(&h.dnsStart) },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			now(&h.dnsDone)
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			for _, addr := range info.Addrs {
+				h.dnsAddrs = append(h.dnsAddrs, addr.String())
+			}
+			h.dnsErr, h.dnsCoalesced = info.Err, info.Coalesced
+		},
+		ConnectStart:      func(_, _ string) { now(&h.connectStart) },
+		ConnectDone:       func(_, _ string, _ error) { now(&h.connectDone) },
+		TLSHandshakeStart: func() { now(&h.tlsHandshakeStart) },
+		TLSHandshakeDone: func(state tls.ConnectionState, _ error) {
+			now(&h.tlsHandshakeDone)
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.alpn = state.NegotiatedProtocol
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			now(&h.gotConn)
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.remoteAddr = info.Conn.RemoteAddr().String()
+			h.localAddr = info.Conn.LocalAddr().String()
+			h.reused = info.Reused
+			h.wroteHeaders, h.wroteRequest = time.Time{}, time.Time{}
+			h.wait100Continue, h.got100Continue = time.Time{}, time.Time{}
+		},
+		WroteHeaders:         func() { now(&h.wroteHeaders) },
+		WroteRequest:         func(_ httptrace.WroteRequestInfo) { now(&h.wroteRequest) },
+		Wait100Continue:      func() { now(&h.wait100Continue) },
+		Got100Continue:       func() { now(&h.got100Continue) },
+		GotFirstResponseByte: func() { now(&h.firstResponseByte) },
+	}
+}
+
+// Finish records that the response of the hop is complete, once its body
+// was read.
+func (h *Hop) Finish() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.done = time.Now()
+}
+
+// Timings returns what the trace of the hop recorded so far.
+func (h *Hop) Timings() Timings {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Timings{
+		Start:             h.start,
+		Done:              h.done,
+		DNSStart:          h.dnsStart,
+		DNSDone:           h.dnsDone,
+		ConnectStart:      h.connectStart,
+		ConnectDone:       h.connectDone,
+		TLSHandshakeStart: h.tlsHandshakeStart,
+		TLSHandshakeDone:  h.tlsHandshakeDone,
+		GotConn:           h.gotConn,
+		FirstResponseByte: h.firstResponseByte,
+		WroteHeaders:      h.wroteHeaders,
+		WroteRequest:      h.wroteRequest,
+		Wait100Continue:   h.wait100Continue,
+		Got100Continue:    h.got100Continue,
+		RemoteAddr:        h.remoteAddr,
+		LocalAddr:         h.localAddr,
+		ALPN:              h.alpn,
+		Reused:            h.reused,
+		DNSAddrs:          append([]string(nil), h.dnsAddrs...),
+		DNSErr:            h.dnsErr,
+		DNSCoalesced:      h.dnsCoalesced,
+		Status:            h.status,
+	}
+}
+
+// Request returns the request the hop sent, with the cookies of the jar.
+func (h *Hop) Request() *http.Request {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.req
+}
+
+// Response returns the response of the hop, nil when the request failed.
+func (h *Hop) Response() *http.Response {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.resp
+}
+
+// Event is a trace event of a hop.
+type Event struct {
+	Name string
+
+	// Offset is the time since the start of the hop.
+	Offset time.Duration
+}
+
+// Events returns the events that happened in order, named like their
+// httptrace hooks, e.g. dns_start or got_first_response_byte. done is the
+// end of the response body.
+func (t Timings) Events() []Event {
+	var events []Event
+	for _, e := range []struct {
+		name string
+		at   time.Time
+	}{
+		{"dns_start", t.DNSStart},
+		{"dns_done", t.DNSDone},
+		{"connect_start", t.ConnectStart},
+		{"connect_done", t.ConnectDone},
+		{"tls_handshake_start", t.TLSHandshakeStart},
+		{"tls_handshake_done", t.TLSHandshakeDone},
+		{"got_conn", t.GotConn},
+		{"wrote_headers", t.WroteHeaders},
+		{"wait_100_continue", t.Wait100Continue},
+		{"got_100_continue", t.Got100Continue},
+		{"wrote_request", t.WroteRequest},
+		{"got_first_response_byte", t.FirstResponseByte},
+		{"done", t.Done},
+	} {
+		if !e.at.IsZero() {
+			events = append(events, Event{Name: e.name, Offset: e.at.Sub(t.Start)})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Offset < events[j].Offset })
+	return events
+}
+
+// span returns the time from start to end, zero unless both happened.
+func span(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
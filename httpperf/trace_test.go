@@ -0,0 +1,61 @@
+package httpperf
+
+import (
+	"io"
+	"net"
+	"net/http/httptrace"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimingsEvents(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	timings := Timings{
+		Start:             start,
+		GotConn:           at(1),
+		WroteHeaders:      at(2),
+		WroteRequest:      at(2),
+		FirstResponseByte: at(10),
+		Done:              at(12),
+	}
+	want := []Event{
+		{"got_conn", time.Millisecond},
+		{"wrote_headers", 2 * time.Millisecond},
+		{"wrote_request", 2 * time.Millisecond},
+		{"got_first_response_byte", 10 * time.Millisecond},
+		{"done", 12 * time.Millisecond},
+	}
+	if got := timings.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestHopTraceRetry(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	h := newHop()
+	trace := h.trace()
+	trace.GotConn(httptrace.GotConnInfo{Conn: client, Reused: true})
+	trace.WroteHeaders()
+	trace.WroteRequest(httptrace.WroteRequestInfo{Err: io.ErrUnexpectedEOF})
+	first := h.Timings()
+
+	// The kept alive connection was closed, the transport retries on a new
+	// one and has not written the request yet.
+	trace.GotConn(httptrace.GotConnInfo{Conn: client})
+	retried := h.Timings()
+	if retried.Reused || !retried.WroteHeaders.IsZero() || !retried.WroteRequest.IsZero() {
+		t.Errorf("expected the events of the first attempt to be cleared, got %+v", retried)
+	}
+
+	trace.WroteHeaders()
+	trace.WroteRequest(httptrace.WroteRequestInfo{})
+	last := h.Timings()
+	if !last.GotConn.After(first.GotConn) || !last.WroteRequest.After(first.WroteRequest) || last.WroteRequest.Before(last.GotConn) {
+		t.Errorf("expected the last occurrence of every event, got %+v after %+v", last, first)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lookupJSONPath extracts the value at a dotted path such as
+// "data.queue_depth" from a JSON document. Numeric segments index into
+// arrays, e.g. "items.0.name".
+func lookupJSONPath(body []byte, path string) (interface{}, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("response body is not valid JSON: %v", err)
+	}
+	value := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			v, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("JSON path %q not found", path)
+			}
+			value = v
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("JSON path %q not found", path)
+			}
+			value = node[i]
+		default:
+			return nil, fmt.Errorf("JSON path %q not found", path)
+		}
+	}
+	return value, nil
+}
+
+// formatJSONValue renders a decoded JSON scalar the way it appeared in the
+// document, so it can be compared against --json-expect.
+func formatJSONValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return "null"
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+var metricNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// jsonMetricName turns a JSON path into a perfdata label.
+func jsonMetricName(path string) string {
+	return metricNameInvalid.ReplaceAllString(path, "_")
+}
+
+// checkJSON evaluates --json-expect and the --json-warning and
+// --json-critical thresholds against the value at --json-path in body,
+// raising verdict. It returns the metric of a numeric value, and an error
+// when the body is not JSON or the value is missing or not numeric while a
+// threshold needs it.
+func checkJSON(verdict *measurement, body []byte) (*metric, error) {
+	value, err := lookupJSONPath(body, plugin.JsonPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(plugin.JsonExpect) > 0 && formatJSONValue(value) != plugin.JsonExpect {
+		verdict.raise("CRITICAL", reasonBodyMismatch)
+		verdict.details += fmt.Sprintf(" %s=%q (expected %q)", plugin.JsonPath, formatJSONValue(value), plugin.JsonExpect)
+	}
+	number, isNumber := value.(float64)
+	if !isNumber {
+		if jsonWarning != nil || jsonCritical != nil {
+			return nil, fmt.Errorf("JSON path %q is not numeric: %s", plugin.JsonPath, formatJSONValue(value))
+		}
+		return nil, nil
+	}
+	if jsonCritical != nil && number > *jsonCritical {
+		verdict.raise("CRITICAL", "")
+		verdict.details += fmt.Sprintf(" %s=%s > %s", plugin.JsonPath, formatJSONValue(number), plugin.JsonCritical)
+	} else if jsonWarning != nil && number > *jsonWarning {
+		verdict.raise("WARNING", "")
+		verdict.details += fmt.Sprintf(" %s=%s > %s", plugin.JsonPath, formatJSONValue(number), plugin.JsonWarning)
+	}
+	return &metric{
+		label:    jsonMetricName(plugin.JsonPath),
+		value:    number,
+		warning:  jsonWarning,
+		critical: jsonCritical,
+	}, nil
+}
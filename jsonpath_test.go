@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLookupJSONPath(t *testing.T) {
+	body := []byte(`{"status":"ok","data":{"queue_depth":42,"ratio":0.5,"ready":true},"items":[{"name":"a"},{"name":"b"}]}`)
+	tests := []struct {
+		path string
+		want string
+		err  bool
+	}{
+		{"status", "ok", false},
+		{"data.queue_depth", "42", false},
+		{"data.ratio", "0.5", false},
+		{"data.ready", "true", false},
+		{"items.1.name", "b", false},
+		{"items.2.name", "", true},
+		{"data.missing", "", true},
+		{"status.deeper", "", true},
+	}
+	for _, tt := range tests {
+		value, err := lookupJSONPath(body, tt.path)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: unexpected error %v", tt.path, err)
+			continue
+		}
+		if err == nil && formatJSONValue(value) != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.path, tt.want, formatJSONValue(value))
+		}
+	}
+	if _, err := lookupJSONPath([]byte("<html>"), "status"); err == nil {
+		t.Error("expected invalid JSON to be an error")
+	}
+}
+
+func TestJSONMetricName(t *testing.T) {
+	if got := jsonMetricName("data.queue-depth"); got != "data_queue_depth" {
+		t.Errorf("unexpected metric name %q", got)
+	}
+}
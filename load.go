@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	// maxRequests and maxConcurrency keep --requests a latency probe
+	// rather than a load test.
+	maxRequests    = 1000
+	maxConcurrency = 50
+)
+
+// validateLoad checks --requests and --concurrency.
+func validateLoad() error {
+	switch {
+	case plugin.Requests < 1 || plugin.Requests > maxRequests:
+		return fmt.Errorf("--requests must be between 1 and %d", maxRequests)
+	case plugin.Concurrency < 1 || plugin.Concurrency > maxConcurrency:
+		return fmt.Errorf("--concurrency must be between 1 and %d", maxConcurrency)
+	case plugin.Concurrency > plugin.Requests:
+		return fmt.Errorf("--concurrency %d exceeds --requests %d", plugin.Concurrency, plugin.Requests)
+	case plugin.Requests > 1 && (plugin.Samples > 1 || plugin.Retries > 0 || plugin.MeasureReuse || plugin.MeasureResumption || plugin.Conditional):
+		return fmt.Errorf("--requests cannot be combined with --samples, --retries, --measure-reuse, --measure-resumption or --conditional")
+	}
+	return nil
+}
+
+// measureLoad sends --requests requests, --concurrency of them at once over
+// the shared transports, and combines the successful ones: the status,
+// details and other metrics are those of the worst, the timings are replaced
+// by the p50, p95, p99 and max of the total and the --evaluate statistic.
+// Requests that failed, or were not sent before the deadline of ctx, are
+// counted in error_count and make the run a warning. Without a single
+// successful request the run fails with the first error.
+func measureLoad(ctx context.Context, transports *transportCache) measurement {
+	results := make([]measurement, plugin.Requests)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < plugin.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = measure(ctx, transports)
+			}
+		}()
+	}
+send:
+	for i := range results {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var (
+		succeeded []measurement
+		first     *measurement
+	)
+	for i, r := range results {
+		switch {
+		case len(r.status) == 0:
+			// Never sent.
+		case len(r.err) > 0:
+			if first == nil {
+				first = &results[i]
+			}
+		default:
+			succeeded = append(succeeded, r)
+		}
+	}
+	failed := len(results) - len(succeeded)
+	counts := []metric{
+		valueMetric("success_count", float64(len(succeeded)), ""),
+		valueMetric("error_count", float64(failed), ""),
+	}
+
+	reason := "not sent before the timeout"
+	if first != nil {
+		reason = first.err
+	}
+	if len(succeeded) == 0 {
+		m := failure("CRITICAL", "")
+		if first != nil {
+			m = *first
+		}
+		m.err = fmt.Sprintf("all %d requests failed: %s", len(results), reason)
+		m.metrics = append(m.metrics, counts...)
+		return m
+	}
+
+	details := fmt.Sprintf(" requests=%d concurrency=%d evaluate=%s", plugin.Requests, plugin.Concurrency, plugin.Evaluate)
+	m := combine(succeeded, plugin.Evaluate, []string{"p50", "p95", "p99", "max"}, details)
+	if failed > 0 {
+		m.raise("WARNING", reasonRequest)
+		m.details += fmt.Sprintf(" %d of %d requests failed: %s", failed, len(results), reason)
+	}
+	m.metrics = append(m.metrics, counts...)
+	return m
+}
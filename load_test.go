@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestExecuteCheckLoad(t *testing.T) {
+	var requests, inFlight, peak int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if current <= p || atomic.CompareAndSwapInt32(&peak, p, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		if r.URL.Path == "/flaky" && n%4 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--requests", "10", "--concurrency", "5", "--evaluate", "p95")
+	status, out := run(t)
+	if status != sensu.CheckStateOK {
+		t.Fatalf("expected OK, got %d: %s", status, out)
+	}
+	if requests != 10 || peak > 5 || peak < 2 {
+		t.Errorf("expected 10 requests at most 5 at once, got %d with a peak of %d", requests, peak)
+	}
+	for _, want := range []string{" requests=10 concurrency=5 evaluate=p95", " total_p50=", " total_p95=", ";1;2;0 ", " total_p99=", " total_max=", " success_count=10 error_count=0 "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %s", want, out)
+		}
+	}
+	if strings.Contains(out, " total_request_duration=") {
+		t.Errorf("expected the per request total to be replaced, got %s", out)
+	}
+
+	// A 503 is a response rather than an error, the worst status wins.
+	atomic.StoreInt32(&requests, 0)
+	setup(t, "--url", ts.URL+"/flaky", "--requests", "8", "--concurrency", "2")
+	if status, out = run(t); status != sensu.CheckStateCritical || !strings.Contains(out, " success_count=8 error_count=0 ") {
+		t.Errorf("expected the 503s to be CRITICAL, got %d: %s", status, out)
+	}
+}
+
+func TestMeasureLoadErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	// The burst stops at the deadline, the requests not sent count as
+	// errors.
+	setup(t, "--url", ts.URL, "--requests", "20", "--concurrency", "2")
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	m := measureLoad(ctx, newTransportCache(nil))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the burst to stop at the deadline, took %s", elapsed)
+	}
+	perfdata := formatPerfdata(m.metrics, outputFormat(), false)
+	if m.status != "WARNING" || !strings.Contains(m.details, " of 20 requests failed: ") || !strings.Contains(perfdata, " error_count=") || strings.Contains(perfdata, " error_count=0") {
+		t.Errorf("expected a WARNING with errors, got %s %q %s", m.status, m.details, perfdata)
+	}
+
+	ts.Close()
+	setup(t, "--url", ts.URL, "--requests", "3", "--concurrency", "3")
+	m = measureLoad(context.Background(), newTransportCache(nil))
+	if m.status != "CRITICAL" || !strings.HasPrefix(m.err, "all 3 requests failed: ") || !strings.Contains(formatPerfdata(m.metrics, outputFormat(), false), "success_count=0 error_count=3") {
+		t.Errorf("expected every request to fail, got %s %q %v", m.status, m.err, m.metrics)
+	}
+}
+
+func TestCheckArgsLoad(t *testing.T) {
+	for _, args := range [][]string{
+		{"--requests", "0"},
+		{"--requests", "1001"},
+		{"--requests", "10", "--concurrency", "0"},
+		{"--requests", "100", "--concurrency", "51"},
+		{"--requests", "2", "--concurrency", "5"},
+		{"--requests", "5", "--samples", "2"},
+		{"--requests", "5", "--retries", "1"},
+		{"--requests", "5", "--measure-reuse"},
+		{"--requests", "5", "--conditional"},
+	} {
+		parseArgs(t, append([]string{"--url", "http://example.com"}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected a validation error", args)
+		}
+	}
+}
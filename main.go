@@ -1,28 +1,178 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
-	"net/http/httptrace"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/DoctorOgg/sensu-http-perf-go/httpperf"
 	corev2 "github.com/sensu/sensu-go/api/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-plugin-sdk/version"
 )
 
 // Config represents the check plugin config.
 type Config struct {
 	sensu.PluginConfig
-	Url                string
-	Timeout            int
-	Warning            float32
-	Critical           float32
-	OutputInMs         bool
-	InsecureSkipVerify bool
-	TlsTimeout         int
-	UserAgent          string
+	Url                 string
+	Port                int
+	UrlsFile            string
+	UrlsFromStdin       bool
+	PerUrlTimeout       int
+	Timeout             int
+	Warning             float32
+	Critical            float32
+	ThresholdUnit       string
+	OutputInMs          bool
+	OutputUnit          string
+	Precision           int
+	InsecureSkipVerify  bool
+	TlsTimeout          int
+	ConnectTimeout      int
+	CaFile              string
+	CaPath              string
+	ClientCert          string
+	ClientKey           string
+	ClientKeyPassword   string
+	TlsMinVersion       string
+	TlsMaxVersion       string
+	FailOnTlsBelow      string
+	WeakCipherStatus    string
+	ExpectAlpn          string
+	ExpectAlpnCritical  bool
+	PinSha256           []string
+	CheckChain          bool
+	RequireOcspStaple   bool
+	ExpectCertSubject   string
+	ExpectCertSan       string
+	UserAgent           string
+	Method              string
+	RequestBody         string
+	ExpectContinue      bool
+	ContinueMinBytes    int64
+	ContinueTimeout     int
+	CorsOrigin          string
+	TraceHeader         string
+	BodyFile            string
+	ContentType         string
+	Headers             []string
+	PreRequests         []string
+	Cookies             []string
+	User                string
+	AuthType            string
+	BearerToken         string
+	BearerTokenFile     string
+	AwsSigv4            string
+	AuthCommand         string
+	AuthCommandTimeout  int
+	HostHeader          string
+	Sni                 string
+	IpVersion           string
+	Resolve             []string
+	DnsServer           string
+	Resolver            string
+	DnsFailureStatus    string
+	CriticalOnError     bool
+	UnixSocket          string
+	SourceAddress       string
+	Interface           string
+	Proxy               string
+	NoProxyEnv          bool
+	HttpVersion         string
+	Http3               bool
+	ExpectStatus        string
+	StatusOkAnything    bool
+	StatusMap           string
+	ExpectBodyContains  string
+	MaxBodyBytes        int64
+	MinBodyBytes        int64
+	MaxBodyBytesWarn    int64
+	MaxBodyBytesCrit    int64
+	ReadBody            bool
+	Range               string
+	AcceptEncoding      string
+	ExpectBodyRegex     string
+	ExpectSha256        string
+	InvertRegex         bool
+	JsonPath            string
+	JsonExpect          string
+	JsonWarning         string
+	JsonCritical        string
+	ExpectHeaders       []string
+	ExpectHeaderRegex   []string
+	SecurityHeaders     bool
+	SecurityHeadersCrit bool
+	IgnoreSecHeaders    []string
+	HstsMinAge          int64
+	MaxAgeWarning       int64
+	MaxAgeCritical      int64
+	RequireAge          bool
+	RatelimitHeader     string
+	RatelimitWarning    int64
+	RatelimitCritical   int64
+	DnsWarning          float32
+	DnsCritical         float32
+	ConnectWarning      float32
+	ConnectCritical     float32
+	TlsWarning          float32
+	TlsCritical         float32
+	TtfbWarning         float32
+	TtfbCritical        float32
+	ServerWarning       float32
+	ServerCritical      float32
+	ThroughputWarning   float32
+	ThroughputCritical  float32
+	StateFile           string
+	RegressionWarning   float32
+	RegressionCritical  float32
+	CertExpiryWarning   int
+	CertExpiryCritical  int
+	LegacyOutput        bool
+	Verbose             bool
+	AnnotationsStdin    bool
+	PrintConfig         bool
+	OutputFormat        string
+	MetricName          string
+	MetricTags          []string
+	MetricPrefix        string
+	CheckLabel          string
+	NoUrlInOutput       bool
+	MaxRedirects        int
+	AllIps              bool
+	MaxIps              int
+	DualStackCompare    bool
+	DualStackDelta      int
+	Retries             int
+	RetryDelay          int
+	Samples             int
+	SampleInterval      int
+	Evaluate            string
+	Requests            int
+	Concurrency         int
+	NoKeepalive         bool
+	Warmup              bool
+	WarmupNewConnection bool
+	FailOnWarmupError   bool
+	MeasureReuse        bool
+	WarnOnNoReuse       bool
+	MeasureResumption   bool
+	WarnOnNoResumption  bool
+	Conditional         bool
 }
 
 var (
@@ -41,9 +191,17 @@ var (
 			Argument:  "url",
 			Shorthand: "u",
 			Default:   "http://localhost:80/",
-			Usage:     "URL to test (default http://localhost:80/)",
+			Usage:     "URL to test, also accepted as the only positional argument (default http://localhost:80/), may be a template of the Sensu event such as https://{{ .Entity.Name }}.internal/ and reference $ENVIRONMENT variables",
 			Value:     &plugin.Url,
 		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "port",
+			Env:      "CHECK_PORT",
+			Argument: "port",
+			Default:  0,
+			Usage:    "Port to connect to instead of the one of --url, e.g. for an alternate deployment on another port",
+			Value:    &plugin.Port,
+		},
 		&sensu.PluginConfigOption[int]{
 			Path:      "timeout",
 			Env:       "CHECK_TIMEOUT",
@@ -53,13 +211,37 @@ var (
 			Usage:     "Request timeout in seconds",
 			Value:     &plugin.Timeout,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "urls-file",
+			Env:      "CHECK_URLS_FILE",
+			Argument: "urls-file",
+			Default:  "",
+			Usage:    "File with one URL per line to check instead of --url, the worst result wins (nagios and json output only)",
+			Value:    &plugin.UrlsFile,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "urls-from-stdin",
+			Env:      "CHECK_URLS_FROM_STDIN",
+			Argument: "urls-from-stdin",
+			Default:  false,
+			Usage:    "Read the URLs to check like --urls-file from stdin, one per line until EOF",
+			Value:    &plugin.UrlsFromStdin,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "per-url-timeout",
+			Env:      "CHECK_PER_URL_TIMEOUT",
+			Argument: "per-url-timeout",
+			Default:  0,
+			Usage:    "Timeout in seconds of each URL of --urls-file, --timeout still bounds them all (default an equal share of --timeout)",
+			Value:    &plugin.PerUrlTimeout,
+		},
 		&sensu.PluginConfigOption[float32]{
 			Path:      "warning",
 			Env:       "CHECK_WARNING",
 			Argument:  "warning",
 			Shorthand: "w",
 			Default:   1,
-			Usage:     "Warning threshold, in seconds",
+			Usage:     "Warning threshold, in seconds or the --threshold-unit, 0 disables it and a value equal to --critical is skipped",
 			Value:     &plugin.Warning,
 		},
 		&sensu.PluginConfigOption[float32]{
@@ -68,18 +250,44 @@ var (
 			Argument:  "critical",
 			Shorthand: "c",
 			Default:   2,
-			Usage:     "Critical threshold, in seconds",
+			Usage:     "Critical threshold, in seconds or the --threshold-unit, 0 disables it",
 			Value:     &plugin.Critical,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "threshold-unit",
+			Env:      "CHECK_THRESHOLD_UNIT",
+			Argument: "threshold-unit",
+			Default:  "s",
+			Allow:    []string{"s", "ms"},
+			Usage:    "Unit of --warning and --critical, s or ms, independent of the --output-unit",
+			Value:    &plugin.ThresholdUnit,
+		},
 		&sensu.PluginConfigOption[bool]{
 			Path:      "output-in-ms",
 			Env:       "CHECK_OUTPUT_IN_MS",
 			Argument:  "output-in-ms",
 			Shorthand: "m",
 			Default:   false,
-			Usage:     "Provide output in milliseconds (default false, display in seconds)",
+			Usage:     "Deprecated, same as --output-unit ms",
 			Value:     &plugin.OutputInMs,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "output-unit",
+			Env:      "CHECK_OUTPUT_UNIT",
+			Argument: "output-unit",
+			Default:  "s",
+			Allow:    []string{"s", "ms", "us"},
+			Usage:    "Unit of the durations in the output and perfdata, one of s, ms or us",
+			Value:    &plugin.OutputUnit,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "precision",
+			Env:      "CHECK_PRECISION",
+			Argument: "precision",
+			Default:  -1,
+			Usage:    "Decimal places of the durations in the output and perfdata, -1 for the default of the unit (6 for s, 2 for ms in the perfdata, 0 for us)",
+			Value:    &plugin.Precision,
+		},
 		&sensu.PluginConfigOption[bool]{
 			Path:      "insecure-skip-verify",
 			Env:       "CHECK_INSECURE_SKIP_VERIFY",
@@ -98,127 +306,2651 @@ var (
 			Usage:     "TLS handshake timeout in milliseconds",
 			Value:     &plugin.TlsTimeout,
 		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "connect-timeout",
+			Env:      "CHECK_CONNECT_TIMEOUT",
+			Argument: "connect-timeout",
+			Default:  0,
+			Usage:    "TCP connect timeout in milliseconds, at most --timeout which is also the default (0)",
+			Value:    &plugin.ConnectTimeout,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ca-file",
+			Env:      "CHECK_CA_FILE",
+			Argument: "ca-file",
+			Default:  "",
+			Usage:    "PEM bundle of CA certificates to verify the server with instead of the system roots",
+			Value:    &plugin.CaFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ca-path",
+			Env:      "CHECK_CA_PATH",
+			Argument: "ca-path",
+			Default:  "",
+			Usage:    "Directory of PEM CA certificates to verify the server with instead of the system roots",
+			Value:    &plugin.CaPath,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "client-cert",
+			Env:      "CHECK_CLIENT_CERT",
+			Argument: "client-cert",
+			Default:  "",
+			Usage:    "PEM client certificate for mutual TLS, requires --client-key",
+			Value:    &plugin.ClientCert,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "client-key",
+			Env:      "CHECK_CLIENT_KEY",
+			Argument: "client-key",
+			Default:  "",
+			Usage:    "PEM private key of the client certificate",
+			Value:    &plugin.ClientKey,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "client-key-password",
+			Env:      "CHECK_CLIENT_KEY_PASSWORD",
+			Argument: "client-key-password",
+			Default:  "",
+			Secret:   true,
+			Usage:    "Password of an encrypted --client-key, preferably given as CHECK_CLIENT_KEY_PASSWORD",
+			Value:    &plugin.ClientKeyPassword,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "tls-min-version",
+			Env:      "CHECK_TLS_MIN_VERSION",
+			Argument: "tls-min-version",
+			Default:  "",
+			Usage:    "Minimum TLS version to offer, one of 1.0, 1.1, 1.2 or 1.3",
+			Value:    &plugin.TlsMinVersion,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "tls-max-version",
+			Env:      "CHECK_TLS_MAX_VERSION",
+			Argument: "tls-max-version",
+			Default:  "",
+			Usage:    "Maximum TLS version to offer, one of 1.0, 1.1, 1.2 or 1.3",
+			Value:    &plugin.TlsMaxVersion,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "fail-on-tls-below",
+			Env:      "CHECK_FAIL_ON_TLS_BELOW",
+			Argument: "fail-on-tls-below",
+			Default:  "",
+			Usage:    "Return critical when the negotiated TLS version is older than this, one of 1.0, 1.1, 1.2 or 1.3",
+			Value:    &plugin.FailOnTlsBelow,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "weak-cipher-status",
+			Env:      "CHECK_WEAK_CIPHER_STATUS",
+			Argument: "weak-cipher-status",
+			Default:  "ok",
+			Allow:    []string{"ok", "warning", "critical"},
+			Usage:    "Status when the connection negotiates a CBC, RC4, 3DES or RSA key exchange cipher suite, one of ok, warning or critical",
+			Value:    &plugin.WeakCipherStatus,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "expect-alpn",
+			Env:      "CHECK_EXPECT_ALPN",
+			Argument: "expect-alpn",
+			Default:  "",
+			Usage:    "Return warning unless the TLS handshake negotiates this ALPN protocol, e.g. h2 or http/1.1 (cleartext URLs are not checked)",
+			Value:    &plugin.ExpectAlpn,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "expect-alpn-critical",
+			Env:      "CHECK_EXPECT_ALPN_CRITICAL",
+			Argument: "expect-alpn-critical",
+			Default:  false,
+			Usage:    "Return critical instead of warning when the --expect-alpn protocol was not negotiated",
+			Value:    &plugin.ExpectAlpnCritical,
+		},
+		&stringArrayOption{
+			Path:      "pin-sha256",
+			Env:       "CHECK_PIN_SHA256",
+			Argument:  "pin-sha256",
+			Separator: ",",
+			Usage:     "Base64 SHA-256 hash of the expected server public key (SPKI), may be repeated",
+			Value:     &plugin.PinSha256,
+		},
 		&sensu.PluginConfigOption[string]{
 			Path:      "user-agent",
 			Env:       "CHECK_USER_AGENT",
 			Argument:  "user-agent",
 			Shorthand: "a",
-			Default:   "Mozilla/5.0 (Commodore 64; AIX 11; HP/UX 12) AppleWebKit/42.20 (KHTML, like Gecko) EvilGoogle/96.0.4664.45 SafariRocks/537.36",
+			Default:   defaultUserAgent(),
 			Usage:     "Custom user agent for the HTTP request",
 			Value:     &plugin.UserAgent,
 		},
-	}
-)
-
-func main() {
-	check := sensu.NewGoCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, false)
-	check.Execute()
-}
-
-func checkArgs(event *corev2.Event) (int, error) {
-	if len(plugin.Url) == 0 {
-		return sensu.CheckStateWarning, fmt.Errorf("--url or CHECK_URL environment variable is required")
-	}
-
-	// ensure the warning and critical thresholds are valid, warnings must be lower than criticals
-	if plugin.Warning > plugin.Critical {
-		return sensu.CheckStateWarning, fmt.Errorf("warning threshold must be lower than critical threshold")
-	}
-
-	return sensu.CheckStateOK, nil
-}
-
-func executeCheck(event *corev2.Event) (int, error) {
-	req, _ := http.NewRequest("GET", plugin.Url, nil)
-
-	if plugin.UserAgent != "" {
-		req.Header.Set("User-Agent", plugin.UserAgent)
-	}
-
-	var (
-		startTime, connectStart, connectDone, dnsStart, dnsDone, tlsHandshakeStart, tlsHandshakeDone, gotConn, firstResponseByte time.Time
-	)
-
-	// Define the HTTP trace.
-	trace := &httptrace.ClientTrace{
-		DNSStart:          func(_ httptrace.DNSStartInfo) { dnsStart = time.Now() },
-		DNSDone:           func(_ httptrace.DNSDoneInfo) { dnsDone = time.Now() },
-		ConnectStart:      func(_, _ string) { connectStart = time.Now() },
-		ConnectDone:       func(_, _ string, _ error) { connectDone = time.Now() },
-		TLSHandshakeStart: func() { tlsHandshakeStart = time.Now() },
-		TLSHandshakeDone:  func(_ tls.ConnectionState, _ error) { tlsHandshakeDone = time.Now() },
-		GotConn:           func(_ httptrace.GotConnInfo) { gotConn = time.Now() },
-		GotFirstResponseByte: func() {
-			firstResponseByte = time.Now()
+		&sensu.PluginConfigOption[string]{
+			Path:      "method",
+			Env:       "CHECK_METHOD",
+			Argument:  "method",
+			Shorthand: "X",
+			Default:   "GET",
+			Usage:     "HTTP method to use for the request (GET, HEAD, POST, PUT, DELETE, OPTIONS)",
+			Value:     &plugin.Method,
 		},
-	}
-
-	// Associate the trace with the request context.
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
-
-	transport := &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout: 30 * time.Second, // This is the TCP connection timeout
-		}).DialContext,
-		TLSHandshakeTimeout: time.Duration(plugin.TlsTimeout) * time.Millisecond,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: plugin.InsecureSkipVerify,
+		&sensu.PluginConfigOption[string]{
+			Path:      "request-body",
+			Env:       "CHECK_REQUEST_BODY",
+			Argument:  "request-body",
+			Shorthand: "d",
+			Default:   "",
+			Usage:     "Request body to send (mutually exclusive with --body-file)",
+			Value:     &plugin.RequestBody,
 		},
-		ResponseHeaderTimeout: time.Duration(plugin.Timeout) * time.Second,
-	}
-
-	client := &http.Client{
-		Timeout:   time.Duration(plugin.Timeout) * time.Second, // This is the client timeout
-		Transport: transport,
-	}
-
-	// Send the request and record the total time.
-	startTime = time.Now()
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error making request:", err)
-		return sensu.CheckStateCritical, nil
-	}
-
-	defer resp.Body.Close()
-
-	// Lets see if we completed the request with in the allowed time
-	// Critical if we exceeded plugin.Critical and Warning if we exceeded plugin.Warning
-	status := "OK"
-	if time.Since(startTime) > time.Duration(plugin.Critical)*time.Second {
-		status = "CRITICAL"
-	} else if time.Since(startTime) > time.Duration(plugin.Warning)*time.Second {
-		status = "WARNING"
-	}
-
-	// Output the results
-	if !plugin.OutputInMs {
-		fmt.Printf("%s %s: %.6fs | dns_duration=%.6f, tls_handshake_duration=%.6f, connect_duration=%.6f, first_byte_duration=%.6f, total_request_duration=%.6f\n",
-			plugin.Name,
-			status,
-			time.Since(startTime).Seconds(),
-			dnsDone.Sub(dnsStart).Seconds(),
-			tlsHandshakeDone.Sub(tlsHandshakeStart).Seconds(),
-			connectDone.Sub(connectStart).Seconds(),
-			firstResponseByte.Sub(gotConn).Seconds(),
-			time.Since(startTime).Seconds(),
-		)
-	} else {
-		fmt.Printf("%s %s: %.6fms | dns_duration=%.2f, tls_handshake_duration=%.2f, connect_duration=%.2f, first_byte_duration=%.2f, total_request_duration=%.2f\n",
-			plugin.Name,
-			status,
-			float64(time.Since(startTime))/float64(time.Millisecond),
-			float64(dnsDone.Sub(dnsStart))/float64(time.Millisecond),
-			float64(tlsHandshakeDone.Sub(tlsHandshakeStart))/float64(time.Millisecond),
-			float64(connectDone.Sub(connectStart))/float64(time.Millisecond),
-			float64(firstResponseByte.Sub(gotConn))/float64(time.Millisecond),
-			float64(time.Since(startTime))/float64(time.Millisecond),
-		)
-	}
-	if status == "CRITICAL" {
-		return sensu.CheckStateCritical, nil
-	} else if status == "WARNING" {
-		return sensu.CheckStateWarning, nil
-	}
-	return sensu.CheckStateOK, nil
+		&sensu.PluginConfigOption[string]{
+			Path:     "body-file",
+			Env:      "CHECK_BODY_FILE",
+			Argument: "body-file",
+			Default:  "",
+			Usage:    "Path to a file whose contents are sent as the request body (mutually exclusive with --request-body)",
+			Value:    &plugin.BodyFile,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "expect-continue",
+			Env:      "CHECK_EXPECT_CONTINUE",
+			Argument: "expect-continue",
+			Default:  false,
+			Usage:    "Send Expect: 100-continue with request bodies of --expect-continue-min-bytes or more and time the wait for the server to accept the body",
+			Value:    &plugin.ExpectContinue,
+		},
+		&sensu.PluginConfigOption[int64]{
+			Path:     "expect-continue-min-bytes",
+			Env:      "CHECK_EXPECT_CONTINUE_MIN_BYTES",
+			Argument: "expect-continue-min-bytes",
+			Default:  1 << 20,
+			Usage:    "Smallest request body that --expect-continue asks 100 Continue for, in bytes",
+			Value:    &plugin.ContinueMinBytes,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "expect-continue-timeout",
+			Env:      "CHECK_EXPECT_CONTINUE_TIMEOUT",
+			Argument: "expect-continue-timeout",
+			Default:  1000,
+			Usage:    "How long --expect-continue waits for 100 Continue before sending the body anyway, in milliseconds",
+			Value:    &plugin.ContinueTimeout,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "cors-origin",
+			Env:      "CHECK_CORS_ORIGIN",
+			Argument: "cors-origin",
+			Default:  "",
+			Usage:    "Send the CORS preflight of a --method request from this origin instead, critical unless the response allows the origin and method",
+			Value:    &plugin.CorsOrigin,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "trace-header",
+			Env:      "CHECK_TRACE_HEADER",
+			Argument: "trace-header",
+			Default:  "",
+			Allow:    []string{"traceparent", "request-id"},
+			Usage:    "Send a fresh W3C traceparent or X-Request-ID with every request and print its ID, to find the request in server traces",
+			Value:    &plugin.TraceHeader,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "content-type",
+			Env:      "CHECK_CONTENT_TYPE",
+			Argument: "content-type",
+			Default:  "",
+			Usage:    "Content-Type of the request body (default application/json when a body is present)",
+			Value:    &plugin.ContentType,
+		},
+		&stringArrayOption{
+			Path:      "headers",
+			Env:       "CHECK_HEADERS",
+			Argument:  "header",
+			Shorthand: "H",
+			Separator: "|",
+			Usage:     "Additional request header as \"Name: Value\", may be repeated (CHECK_HEADERS separates headers with |)",
+			Value:     &plugin.Headers,
+		},
+		&stringArrayOption{
+			Path:      "pre-requests",
+			Env:       "CHECK_PRE_REQUESTS",
+			Argument:  "pre-request",
+			Separator: "|",
+			Usage:     "Request sent before the measured one as \"METHOD URL[ BODY]\", e.g. to log in, may be repeated (CHECK_PRE_REQUESTS separates steps with |)",
+			Value:     &plugin.PreRequests,
+		},
+		&stringArrayOption{
+			Path:      "cookies",
+			Env:       "CHECK_COOKIES",
+			Argument:  "cookie",
+			Separator: "|",
+			Usage:     "Cookie sent to the URL host as \"name=value\", may be repeated (CHECK_COOKIES separates cookies with |)",
+			Value:     &plugin.Cookies,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "user",
+			Env:      "CHECK_USER",
+			Argument: "user",
+			Default:  "",
+			Secret:   true,
+			Usage:    "Credentials as user:password, or just user with the password in CHECK_PASSWORD, sent as set by --auth-type",
+			Value:    &plugin.User,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "auth-type",
+			Env:      "CHECK_AUTH_TYPE",
+			Argument: "auth-type",
+			Default:  "basic",
+			Allow:    []string{"basic", "digest"},
+			Usage:    "How --user authenticates, basic sends it with the request, digest answers the 401 Digest challenge of the server",
+			Value:    &plugin.AuthType,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "bearer-token",
+			Env:      "CHECK_BEARER_TOKEN",
+			Argument: "bearer-token",
+			Default:  "",
+			Secret:   true,
+			Usage:    "Bearer token sent in the Authorization header",
+			Value:    &plugin.BearerToken,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "bearer-token-file",
+			Env:      "CHECK_BEARER_TOKEN_FILE",
+			Argument: "bearer-token-file",
+			Default:  "",
+			Usage:    "File containing the bearer token, read on every run so rotated tokens are picked up",
+			Value:    &plugin.BearerTokenFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "aws-sigv4",
+			Env:      "CHECK_AWS_SIGV4",
+			Argument: "aws-sigv4",
+			Default:  "",
+			Usage:    "Sign the request with AWS Signature Version 4 for region/service, e.g. us-east-1/execute-api, using the static keys of the AWS_ACCESS_KEY_ID environment variables or the shared credentials and config files, or else the EC2 instance role",
+			Value:    &plugin.AwsSigv4,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "auth-command",
+			Env:      "CHECK_AUTH_COMMAND",
+			Argument: "auth-command",
+			Default:  "",
+			Usage:    "Shell command printing the Authorization header value, e.g. \"Bearer <token>\", run before the request",
+			Value:    &plugin.AuthCommand,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "auth-command-timeout",
+			Env:      "CHECK_AUTH_COMMAND_TIMEOUT",
+			Argument: "auth-command-timeout",
+			Default:  10,
+			Usage:    "Timeout of --auth-command in seconds, it is not part of --timeout",
+			Value:    &plugin.AuthCommandTimeout,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "host-header",
+			Env:      "CHECK_HOST_HEADER",
+			Argument: "host-header",
+			Default:  "",
+			Usage:    "Host header to send instead of the URL host, also used as the TLS server name",
+			Value:    &plugin.HostHeader,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "sni",
+			Env:      "CHECK_SNI",
+			Argument: "sni",
+			Default:  "",
+			Usage:    "TLS server name to send and verify the certificate against, regardless of the URL host and Host header",
+			Value:    &plugin.Sni,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ip-version",
+			Env:      "CHECK_IP_VERSION",
+			Argument: "ip-version",
+			Default:  "any",
+			Allow:    []string{"any", "4", "6"},
+			Usage:    "Address family to connect with, one of any, 4 or 6",
+			Value:    &plugin.IpVersion,
+		},
+		&stringArrayOption{
+			Path:      "resolve",
+			Env:       "CHECK_RESOLVE",
+			Argument:  "resolve",
+			Separator: ",",
+			Usage:     "Connect to IP instead of resolving host and port, as \"host:port:ip\" like curl --resolve, may be repeated",
+			Value:     &plugin.Resolve,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "dns-server",
+			Env:      "CHECK_DNS_SERVER",
+			Argument: "dns-server",
+			Default:  "",
+			Usage:    "DNS server to resolve the host with instead of the system resolver, as ip or ip:port",
+			Value:    &plugin.DnsServer,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "resolver",
+			Env:      "CHECK_RESOLVER",
+			Argument: "resolver",
+			Default:  "auto",
+			Allow:    []string{"auto", "go", "cgo"},
+			Usage:    "Resolver to look the host up with: auto lets Go pick, go forces the pure Go resolver, cgo the C library one",
+			Value:    &plugin.Resolver,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "dns-failure-status",
+			Env:      "CHECK_DNS_FAILURE_STATUS",
+			Argument: "dns-failure-status",
+			Default:  "critical",
+			Allow:    []string{"critical", "warning", "unknown"},
+			Usage:    "Status of a failed DNS lookup, critical, warning for when DNS is watched by a separate check, or unknown",
+			Value:    &plugin.DnsFailureStatus,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "critical-on-error",
+			Env:      "CHECK_CRITICAL_ON_ERROR",
+			Argument: "critical-on-error",
+			Default:  false,
+			Usage:    "Report configuration and plugin errors, such as an invalid option or unreadable file, as critical instead of unknown",
+			Value:    &plugin.CriticalOnError,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "unix-socket",
+			Env:      "CHECK_UNIX_SOCKET",
+			Argument: "unix-socket",
+			Default:  "",
+			Usage:    "Connect to this unix domain socket instead of the URL host, which still sets the Host header",
+			Value:    &plugin.UnixSocket,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "source-address",
+			Env:      "CHECK_SOURCE_ADDRESS",
+			Argument: "source-address",
+			Default:  "",
+			Usage:    "Local IP to connect from, to pin the egress path of a multihomed host",
+			Value:    &plugin.SourceAddress,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "interface",
+			Env:      "CHECK_INTERFACE",
+			Argument: "interface",
+			Default:  "",
+			Usage:    "Network interface to connect through with SO_BINDTODEVICE, Linux only",
+			Value:    &plugin.Interface,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "proxy",
+			Env:      "CHECK_PROXY",
+			Argument: "proxy",
+			Default:  "",
+			Usage:    "Proxy to send the request through, as http, https or socks5 URL with optional user:password credentials",
+			Value:    &plugin.Proxy,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "no-proxy-env",
+			Env:      "CHECK_NO_PROXY_ENV",
+			Argument: "no-proxy-env",
+			Default:  false,
+			Usage:    "Ignore HTTP_PROXY, HTTPS_PROXY and NO_PROXY, which are otherwise used when --proxy is not set",
+			Value:    &plugin.NoProxyEnv,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "http-version",
+			Env:      "CHECK_HTTP_VERSION",
+			Argument: "http-version",
+			Default:  "auto",
+			Allow:    []string{"auto", "1.1", "2"},
+			Usage:    "HTTP version to use, one of auto, 1.1 or 2 (h2c prior knowledge for http URLs)",
+			Value:    &plugin.HttpVersion,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "http3",
+			Env:      "CHECK_HTTP3",
+			Argument: "http3",
+			Default:  false,
+			Usage:    "Use HTTP/3 over QUIC for https URLs, connect_duration and tls_handshake_duration then both cover the QUIC handshake",
+			Value:    &plugin.Http3,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "expect-status",
+			Env:      "CHECK_EXPECT_STATUS",
+			Argument: "expect-status",
+			Default:  "",
+			Usage:    "Comma separated list of expected status codes and ranges, e.g. 200,201,301-302 (default 4xx is warning, 5xx is critical)",
+			Value:    &plugin.ExpectStatus,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "status-ok-anything",
+			Env:      "CHECK_STATUS_OK_ANYTHING",
+			Argument: "status-ok-anything",
+			Default:  false,
+			Usage:    "Ignore the response status code and only evaluate latency",
+			Value:    &plugin.StatusOkAnything,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "status-map",
+			Env:      "CHECK_STATUS_MAP",
+			Argument: "status-map",
+			Default:  "",
+			Usage:    "Comma separated status codes or classes mapped to ok, warning or critical, e.g. 503=warning,429=ok,4xx=critical, a code wins over its class and over --expect-status",
+			Value:    &plugin.StatusMap,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "expect-body-contains",
+			Env:      "CHECK_EXPECT_BODY_CONTAINS",
+			Argument: "expect-body-contains",
+			Default:  "",
+			Usage:    "Return critical unless the response body contains this string",
+			Value:    &plugin.ExpectBodyContains,
+		},
+		&sensu.PluginConfigOption[int64]{
+			Path:     "max-body-bytes",
+			Env:      "CHECK_MAX_BODY_BYTES",
+			Argument: "max-body-bytes",
+			Default:  1 << 20,
+			Usage:    "Maximum size of a response body that is read, larger bodies are critical",
+			Value:    &plugin.MaxBodyBytes,
+		},
+		&sensu.PluginConfigOption[int64]{
+			Path:     "min-body-bytes",
+			Env:      "CHECK_MIN_BODY_BYTES",
+			Argument: "min-body-bytes",
+			Default:  0,
+			Usage:    "Critical when the response body is smaller than this, e.g. a truncated page, in bytes (0 disables, implies --read-body)",
+			Value:    &plugin.MinBodyBytes,
+		},
+		&sensu.PluginConfigOption[int64]{
+			Path:     "max-body-bytes-warn",
+			Env:      "CHECK_MAX_BODY_BYTES_WARN",
+			Argument: "max-body-bytes-warn",
+			Default:  0,
+			Usage:    "Warning when the response body is larger than this, in bytes (0 disables, implies --read-body)",
+			Value:    &plugin.MaxBodyBytesWarn,
+		},
+		&sensu.PluginConfigOption[int64]{
+			Path:     "max-body-bytes-crit",
+			Env:      "CHECK_MAX_BODY_BYTES_CRIT",
+			Argument: "max-body-bytes-crit",
+			Default:  0,
+			Usage:    "Critical when the response body is larger than this, in bytes (0 disables, implies --read-body)",
+			Value:    &plugin.MaxBodyBytesCrit,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "accept-encoding",
+			Env:      "CHECK_ACCEPT_ENCODING",
+			Argument: "accept-encoding",
+			Default:  "",
+			Allow:    []string{"identity", "gzip", "br"},
+			Usage:    "Accept-Encoding to send, identity, gzip or br, the body is then decompressed by the check and timed (default gzip, decompressed by Go)",
+			Value:    &plugin.AcceptEncoding,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "read-body",
+			Env:      "CHECK_READ_BODY",
+			Argument: "read-body",
+			Default:  false,
+			Usage:    "Read the whole response body and report the content transfer time and body size",
+			Value:    &plugin.ReadBody,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "range",
+			Env:      "CHECK_RANGE",
+			Argument: "range",
+			Default:  "",
+			Usage:    "Request the byte range first-last, e.g. 0-1023, and return critical unless the response is a 206 with that range",
+			Value:    &plugin.Range,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "expect-body-regex",
+			Env:      "CHECK_EXPECT_BODY_REGEX",
+			Argument: "expect-body-regex",
+			Default:  "",
+			Usage:    "Return critical unless the response body matches this regular expression",
+			Value:    &plugin.ExpectBodyRegex,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "invert-regex",
+			Env:      "CHECK_INVERT_REGEX",
+			Argument: "invert-regex",
+			Default:  false,
+			Usage:    "Return critical when --expect-body-regex matches instead of when it does not",
+			Value:    &plugin.InvertRegex,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "expect-sha256",
+			Env:      "CHECK_EXPECT_SHA256",
+			Argument: "expect-sha256",
+			Default:  "",
+			Usage:    "Return critical unless the SHA-256 digest of the response body is this hex value, implies --read-body",
+			Value:    &plugin.ExpectSha256,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "json-path",
+			Env:      "CHECK_JSON_PATH",
+			Argument: "json-path",
+			Default:  "",
+			Usage:    "Dotted path of a value in a JSON response body, e.g. data.queue_depth",
+			Value:    &plugin.JsonPath,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "json-expect",
+			Env:      "CHECK_JSON_EXPECT",
+			Argument: "json-expect",
+			Default:  "",
+			Usage:    "Return critical unless the value at --json-path equals this string",
+			Value:    &plugin.JsonExpect,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "json-warning",
+			Env:      "CHECK_JSON_WARNING",
+			Argument: "json-warning",
+			Default:  "",
+			Usage:    "Return warning when the numeric value at --json-path exceeds this threshold",
+			Value:    &plugin.JsonWarning,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "json-critical",
+			Env:      "CHECK_JSON_CRITICAL",
+			Argument: "json-critical",
+			Default:  "",
+			Usage:    "Return critical when the numeric value at --json-path exceeds this threshold",
+			Value:    &plugin.JsonCritical,
+		},
+		&stringArrayOption{
+			Path:      "expect-headers",
+			Env:       "CHECK_EXPECT_HEADERS",
+			Argument:  "expect-header",
+			Separator: "|",
+			Usage:     "Expected response header as \"Name: substring\", may be repeated",
+			Value:     &plugin.ExpectHeaders,
+		},
+		&stringArrayOption{
+			Path:      "expect-header-regex",
+			Env:       "CHECK_EXPECT_HEADER_REGEX",
+			Argument:  "expect-header-regex",
+			Separator: "|",
+			Usage:     "Expected response header as \"Name: regex\", may be repeated",
+			Value:     &plugin.ExpectHeaderRegex,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "check-security-headers",
+			Env:      "CHECK_CHECK_SECURITY_HEADERS",
+			Argument: "check-security-headers",
+			Default:  false,
+			Usage:    "Warn when Strict-Transport-Security, X-Content-Type-Options, X-Frame-Options or Content-Security-Policy is missing from the response",
+			Value:    &plugin.SecurityHeaders,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "security-headers-critical",
+			Env:      "CHECK_SECURITY_HEADERS_CRITICAL",
+			Argument: "security-headers-critical",
+			Default:  false,
+			Usage:    "Report missing or weak security headers as critical instead of warning",
+			Value:    &plugin.SecurityHeadersCrit,
+		},
+		&stringArrayOption{
+			Path:      "ignore-security-headers",
+			Env:       "CHECK_IGNORE_SECURITY_HEADERS",
+			Argument:  "ignore-security-header",
+			Separator: ",",
+			Usage:     "Security header that --check-security-headers does not expect, e.g. Content-Security-Policy on an API, may be repeated",
+			Value:     &plugin.IgnoreSecHeaders,
+		},
+		&sensu.PluginConfigOption[int64]{
+			Path:     "hsts-min-age",
+			Env:      "CHECK_HSTS_MIN_AGE",
+			Argument: "hsts-min-age",
+			Default:  15768000,
+			Usage:    "Minimum Strict-Transport-Security max-age expected by --check-security-headers, in seconds",
+			Value:    &plugin.HstsMinAge,
+		},
+		&sensu.PluginConfigOption[int64]{
+			Path:     "max-age-warning",
+			Env:      "CHECK_MAX_AGE_WARNING",
+			Argument: "max-age-warning",
+			Default:  0,
+			Usage:    "Warning when the Age header of a cached response exceeds this, in seconds (0 disables)",
+			Value:    &plugin.MaxAgeWarning,
+		},
+		&sensu.PluginConfigOption[int64]{
+			Path:     "max-age-critical",
+			Env:      "CHECK_MAX_AGE_CRITICAL",
+			Argument: "max-age-critical",
+			Default:  0,
+			Usage:    "Critical when the Age header of a cached response exceeds this, in seconds (0 disables)",
+			Value:    &plugin.MaxAgeCritical,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "require-age",
+			Env:      "CHECK_REQUIRE_AGE",
+			Argument: "require-age",
+			Default:  false,
+			Usage:    "Warn when the response has no Age header, which otherwise counts as 0",
+			Value:    &plugin.RequireAge,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ratelimit-header",
+			Env:      "CHECK_RATELIMIT_HEADER",
+			Argument: "ratelimit-header",
+			Default:  "",
+			Usage:    "Header with the remaining request count of a rate limited API (default X-RateLimit-Remaining, RateLimit-Remaining, X-Rate-Limit-Remaining or RateLimit)",
+			Value:    &plugin.RatelimitHeader,
+		},
+		&sensu.PluginConfigOption[int64]{
+			Path:     "ratelimit-warning",
+			Env:      "CHECK_RATELIMIT_WARNING",
+			Argument: "ratelimit-warning",
+			Default:  0,
+			Usage:    "Warning when fewer requests than this remain before the API throttles (0 disables)",
+			Value:    &plugin.RatelimitWarning,
+		},
+		&sensu.PluginConfigOption[int64]{
+			Path:     "ratelimit-critical",
+			Env:      "CHECK_RATELIMIT_CRITICAL",
+			Argument: "ratelimit-critical",
+			Default:  0,
+			Usage:    "Critical when fewer requests than this remain before the API throttles (0 disables)",
+			Value:    &plugin.RatelimitCritical,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "dns-warning",
+			Env:      "CHECK_DNS_WARNING",
+			Argument: "dns-warning",
+			Default:  0,
+			Usage:    "Warning threshold for the DNS lookup phase, in seconds (0 disables)",
+			Value:    &plugin.DnsWarning,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "dns-critical",
+			Env:      "CHECK_DNS_CRITICAL",
+			Argument: "dns-critical",
+			Default:  0,
+			Usage:    "Critical threshold for the DNS lookup phase, in seconds (0 disables)",
+			Value:    &plugin.DnsCritical,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "connect-warning",
+			Env:      "CHECK_CONNECT_WARNING",
+			Argument: "connect-warning",
+			Default:  0,
+			Usage:    "Warning threshold for the TCP connect phase, in seconds (0 disables)",
+			Value:    &plugin.ConnectWarning,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "connect-critical",
+			Env:      "CHECK_CONNECT_CRITICAL",
+			Argument: "connect-critical",
+			Default:  0,
+			Usage:    "Critical threshold for the TCP connect phase, in seconds (0 disables)",
+			Value:    &plugin.ConnectCritical,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "tls-warning",
+			Env:      "CHECK_TLS_WARNING",
+			Argument: "tls-warning",
+			Default:  0,
+			Usage:    "Warning threshold for the TLS handshake phase, in seconds (0 disables)",
+			Value:    &plugin.TlsWarning,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "tls-critical",
+			Env:      "CHECK_TLS_CRITICAL",
+			Argument: "tls-critical",
+			Default:  0,
+			Usage:    "Critical threshold for the TLS handshake phase, in seconds (0 disables)",
+			Value:    &plugin.TlsCritical,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "ttfb-warning",
+			Env:      "CHECK_TTFB_WARNING",
+			Argument: "ttfb-warning",
+			Default:  0,
+			Usage:    "Warning threshold for the time to first byte from the start of the request, in seconds (0 disables)",
+			Value:    &plugin.TtfbWarning,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "ttfb-critical",
+			Env:      "CHECK_TTFB_CRITICAL",
+			Argument: "ttfb-critical",
+			Default:  0,
+			Usage:    "Critical threshold for the time to first byte from the start of the request, in seconds (0 disables)",
+			Value:    &plugin.TtfbCritical,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "server-warning",
+			Env:      "CHECK_SERVER_WARNING",
+			Argument: "server-warning",
+			Default:  0,
+			Usage:    "Warning threshold for the server processing phase, from the request written to the first byte, in seconds (0 disables)",
+			Value:    &plugin.ServerWarning,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "server-critical",
+			Env:      "CHECK_SERVER_CRITICAL",
+			Argument: "server-critical",
+			Default:  0,
+			Usage:    "Critical threshold for the server processing phase, from the request written to the first byte, in seconds (0 disables)",
+			Value:    &plugin.ServerCritical,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "throughput-warning",
+			Env:      "CHECK_THROUGHPUT_WARNING",
+			Argument: "throughput-warning",
+			Default:  0,
+			Usage:    "Warning when the body download is slower than this, in KB/s (0 disables, implies --read-body)",
+			Value:    &plugin.ThroughputWarning,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "throughput-critical",
+			Env:      "CHECK_THROUGHPUT_CRITICAL",
+			Argument: "throughput-critical",
+			Default:  0,
+			Usage:    "Critical when the body download is slower than this, in KB/s (0 disables, implies --read-body)",
+			Value:    &plugin.ThroughputCritical,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "state-file",
+			Env:      "CHECK_STATE_FILE",
+			Argument: "state-file",
+			Default:  "",
+			Usage:    "File keeping the recent total request durations the regression thresholds compare against (default a file named after the URL in the cache directory of the user, e.g. ~/.cache/sensu-http-perf-go)",
+			Value:    &plugin.StateFile,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "regression-warning",
+			Env:      "CHECK_REGRESSION_WARNING",
+			Argument: "regression-warning",
+			Default:  0,
+			Usage:    "Warning when the total request duration is this many times the median of the recent runs in --state-file (0 disables)",
+			Value:    &plugin.RegressionWarning,
+		},
+		&sensu.PluginConfigOption[float32]{
+			Path:     "regression-critical",
+			Env:      "CHECK_REGRESSION_CRITICAL",
+			Argument: "regression-critical",
+			Default:  0,
+			Usage:    "Critical when the total request duration is this many times the median of the recent runs in --state-file (0 disables)",
+			Value:    &plugin.RegressionCritical,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "cert-expiry-warning",
+			Env:      "CHECK_CERT_EXPIRY_WARNING",
+			Argument: "cert-expiry-warning",
+			Default:  0,
+			Usage:    "Warning when the server certificate expires within this many days (0 disables)",
+			Value:    &plugin.CertExpiryWarning,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "cert-expiry-critical",
+			Env:      "CHECK_CERT_EXPIRY_CRITICAL",
+			Argument: "cert-expiry-critical",
+			Default:  0,
+			Usage:    "Critical when the server certificate expires within this many days (0 disables)",
+			Value:    &plugin.CertExpiryCritical,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "check-chain",
+			Env:      "CHECK_CHECK_CHAIN",
+			Argument: "check-chain",
+			Default:  false,
+			Usage:    "Apply the certificate expiry thresholds to every certificate of the chain and report chain_min_expiry_days",
+			Value:    &plugin.CheckChain,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "require-ocsp-staple",
+			Env:      "CHECK_REQUIRE_OCSP_STAPLE",
+			Argument: "require-ocsp-staple",
+			Default:  false,
+			Usage:    "Return warning when the server staples no OCSP response and critical when it is invalid, expired or revoked, reporting ocsp_stapled and ocsp_next_update_hours",
+			Value:    &plugin.RequireOcspStaple,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "expect-cert-subject",
+			Env:      "CHECK_EXPECT_CERT_SUBJECT",
+			Argument: "expect-cert-subject",
+			Default:  "",
+			Usage:    "Return critical unless the subject of the presented certificate, e.g. CN=example.com,O=Example, contains this, or equals it with a trailing $",
+			Value:    &plugin.ExpectCertSubject,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "expect-cert-san",
+			Env:      "CHECK_EXPECT_CERT_SAN",
+			Argument: "expect-cert-san",
+			Default:  "",
+			Usage:    "Return critical unless a subject alternative name of the presented certificate contains this, or equals it with a trailing $, ignoring case",
+			Value:    &plugin.ExpectCertSan,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "legacy-output",
+			Env:      "CHECK_LEGACY_OUTPUT",
+			Argument: "legacy-output",
+			Default:  false,
+			Usage:    "Emit the comma separated perfdata of earlier releases instead of Nagios perfdata",
+			Value:    &plugin.LegacyOutput,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "verbose",
+			Env:      "CHECK_VERBOSE",
+			Argument: "verbose",
+			Default:  false,
+			Usage:    "Include every resolved address of the host and the proxy used in the output, and dump the headers, connection and trace events of every request to stderr",
+			Value:    &plugin.Verbose,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "annotations-from-stdin",
+			Env:      "CHECK_ANNOTATIONS_FROM_STDIN",
+			Argument: "annotations-from-stdin",
+			Default:  false,
+			Usage:    "Read the Sensu event from stdin, which needs stdin: true on the check, to apply its annotations and expand the --url template",
+			Value:    &plugin.AnnotationsStdin,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "print-config",
+			Env:      "CHECK_PRINT_CONFIG",
+			Argument: "print-config",
+			Default:  false,
+			Usage:    "Print the effective configuration, after the annotations of the Sensu event are applied, to stderr with secrets redacted",
+			Value:    &plugin.PrintConfig,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "output-format",
+			Env:      "CHECK_OUTPUT_FORMAT",
+			Argument: "output-format",
+			Default:  "nagios",
+			Allow:    []string{"nagios", "json", "influxdb", "graphite", "prometheus"},
+			Usage:    "Output format, one of nagios, json, influxdb, graphite or prometheus",
+			Value:    &plugin.OutputFormat,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "metric-name",
+			Env:      "CHECK_METRIC_NAME",
+			Argument: "metric-name",
+			Default:  "http_perf",
+			Usage:    "Measurement name used by the influxdb output format and metric name prefix of the prometheus format",
+			Value:    &plugin.MetricName,
+		},
+		&stringArrayOption{
+			Path:      "metric-tags",
+			Env:       "CHECK_METRIC_TAGS",
+			Argument:  "metric-tag",
+			Separator: ",",
+			Usage:     "Additional metric tag as key=value, may be repeated",
+			Value:     &plugin.MetricTags,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "metric-prefix",
+			Env:      "CHECK_METRIC_PREFIX",
+			Argument: "metric-prefix",
+			Default:  "",
+			Usage:    "Metric prefix used by the graphite output format (default derived from the URL host)",
+			Value:    &plugin.MetricPrefix,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "check-label",
+			Env:      "CHECK_CHECK_LABEL",
+			Argument: "check-label",
+			Default:  "",
+			Usage:    "Name leading the output instead of the plugin name, also prefixing every perfdata metric with its letters, digits and underscores",
+			Value:    &plugin.CheckLabel,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "no-url-in-output",
+			Env:      "CHECK_NO_URL_IN_OUTPUT",
+			Argument: "no-url-in-output",
+			Default:  false,
+			Usage:    "Show only the host of the URL in the output line and leave out the final URL of redirects, for URLs with secrets in their path or query string",
+			Value:    &plugin.NoUrlInOutput,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "max-redirects",
+			Env:      "CHECK_MAX_REDIRECTS",
+			Argument: "max-redirects",
+			Default:  10,
+			Usage:    "Maximum number of redirects to follow, 0 reports the redirect response itself",
+			Value:    &plugin.MaxRedirects,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "all-ips",
+			Env:      "CHECK_ALL_IPS",
+			Argument: "all-ips",
+			Default:  false,
+			Usage:    "Resolve the host once and check every address, the worst result wins (nagios and json output only)",
+			Value:    &plugin.AllIps,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "max-ips",
+			Env:      "CHECK_MAX_IPS",
+			Argument: "max-ips",
+			Default:  10,
+			Usage:    "Maximum number of addresses checked by --all-ips",
+			Value:    &plugin.MaxIps,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "dual-stack-compare",
+			Env:      "CHECK_DUAL_STACK_COMPARE",
+			Argument: "dual-stack-compare",
+			Default:  false,
+			Usage:    "Measure one request over IPv4 and one over IPv6 and compare them (nagios and json output only)",
+			Value:    &plugin.DualStackCompare,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "dualstack-delta-warning",
+			Env:      "CHECK_DUALSTACK_DELTA_WARNING",
+			Argument: "dualstack-delta-warning",
+			Default:  0,
+			Usage:    "Warn when the IPv6 and IPv4 durations of --dual-stack-compare differ by more than this many milliseconds, 0 disables",
+			Value:    &plugin.DualStackDelta,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "retries",
+			Env:      "CHECK_RETRIES",
+			Argument: "retries",
+			Default:  0,
+			Usage:    "Number of times to retry a request that failed to connect or got a 5xx response, all attempts share --timeout",
+			Value:    &plugin.Retries,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "retry-delay",
+			Env:      "CHECK_RETRY_DELAY",
+			Argument: "retry-delay",
+			Default:  1000,
+			Usage:    "Delay between retries in milliseconds",
+			Value:    &plugin.RetryDelay,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "samples",
+			Env:      "CHECK_SAMPLES",
+			Argument: "samples",
+			Default:  1,
+			Usage:    "Number of measurements to take, all of them within --timeout",
+			Value:    &plugin.Samples,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "sample-interval",
+			Env:      "CHECK_SAMPLE_INTERVAL",
+			Argument: "sample-interval",
+			Default:  0,
+			Usage:    "Delay between samples in milliseconds",
+			Value:    &plugin.SampleInterval,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "evaluate",
+			Env:      "CHECK_EVALUATE",
+			Argument: "evaluate",
+			Default:  "avg",
+			Allow:    []string{"avg", "max", "p50", "p95", "p99"},
+			Usage:    "Statistic of the samples or --requests the latency and phase thresholds apply to, one of avg, max, p50, p95 or p99",
+			Value:    &plugin.Evaluate,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "requests",
+			Env:      "CHECK_REQUESTS",
+			Argument: "requests",
+			Default:  1,
+			Usage:    "Number of requests to send as a burst within --timeout, reporting their latency distribution (at most 1000)",
+			Value:    &plugin.Requests,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "concurrency",
+			Env:      "CHECK_CONCURRENCY",
+			Argument: "concurrency",
+			Default:  1,
+			Usage:    "Number of --requests in flight at once (at most 50)",
+			Value:    &plugin.Concurrency,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "no-keepalive",
+			Env:      "CHECK_NO_KEEPALIVE",
+			Argument: "no-keepalive",
+			Default:  false,
+			Usage:    "Send Connection: close and open a new connection for every request and sample, to measure what a new client sees",
+			Value:    &plugin.NoKeepalive,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "warmup",
+			Env:      "CHECK_WARMUP",
+			Argument: "warmup",
+			Default:  false,
+			Usage:    "Send one untimed request before measuring, its result is ignored and warmup=1 is added to the perfdata",
+			Value:    &plugin.Warmup,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "warmup-new-connection",
+			Env:      "CHECK_WARMUP_NEW_CONNECTION",
+			Argument: "warmup-new-connection",
+			Default:  false,
+			Usage:    "Measure over a new connection instead of the one the --warmup request opened",
+			Value:    &plugin.WarmupNewConnection,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "fail-on-warmup-error",
+			Env:      "CHECK_FAIL_ON_WARMUP_ERROR",
+			Argument: "fail-on-warmup-error",
+			Default:  false,
+			Usage:    "Return critical when the --warmup request fails instead of measuring anyway",
+			Value:    &plugin.FailOnWarmupError,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "measure-reuse",
+			Env:      "CHECK_MEASURE_REUSE",
+			Argument: "measure-reuse",
+			Default:  false,
+			Usage:    "Send a second request right after the first over the same connection, reporting cold_total_duration, warm_total_duration and reuse_worked",
+			Value:    &plugin.MeasureReuse,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "warn-on-no-reuse",
+			Env:      "CHECK_WARN_ON_NO_REUSE",
+			Argument: "warn-on-no-reuse",
+			Default:  false,
+			Usage:    "Return warning when the second --measure-reuse request needed a new connection",
+			Value:    &plugin.WarnOnNoReuse,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "measure-resumption",
+			Env:      "CHECK_MEASURE_RESUMPTION",
+			Argument: "measure-resumption",
+			Default:  false,
+			Usage:    "Send a second request right after the first over a new connection resuming its TLS session, reporting tls_resumed and tls_resumed_handshake_duration",
+			Value:    &plugin.MeasureResumption,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "warn-on-no-resumption",
+			Env:      "CHECK_WARN_ON_NO_RESUMPTION",
+			Argument: "warn-on-no-resumption",
+			Default:  false,
+			Usage:    "Return warning when the second --measure-resumption request did not resume the TLS session",
+			Value:    &plugin.WarnOnNoResumption,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "conditional",
+			Env:      "CHECK_CONDITIONAL",
+			Argument: "conditional",
+			Default:  false,
+			Usage:    "Repeat the request with the ETag and Last-Modified validators of the first response, reporting revalidation_duration and revalidated (1 for a 304)",
+			Value:    &plugin.Conditional,
+		},
+	}
+
+	// requestBody holds the payload resolved from --request-body or --body-file.
+	requestBody []byte
+
+	// requestHeaders holds the headers parsed from --header.
+	requestHeaders http.Header
+
+	// expectedStatus holds the status code ranges parsed from --expect-status.
+	expectedStatus []statusRange
+
+	// statusMap holds the rules parsed from --status-map by code or class.
+	statusMap map[string]statusRule
+
+	// requestRange is the byte range parsed from --range, nil when unset.
+	requestRange *byteRange
+
+	// bodyRegex is the compiled --expect-body-regex.
+	bodyRegex *regexp.Regexp
+
+	// expectedSHA256 is the digest parsed from --expect-sha256, nil when
+	// unset.
+	expectedSHA256 []byte
+
+	// jsonWarning and jsonCritical are the parsed --json-warning and
+	// --json-critical thresholds, nil when unset.
+	jsonWarning, jsonCritical *float64
+
+	// expectedHeaders and expectedHeaderRegex hold the parsed
+	// --expect-header and --expect-header-regex assertions.
+	expectedHeaders     http.Header
+	expectedHeaderRegex []headerRegex
+
+	// ignoredSecurityHeaders holds the canonical names parsed from
+	// --ignore-security-header.
+	ignoredSecurityHeaders map[string]bool
+
+	// metricTags holds the tags parsed from --metric-tag.
+	metricTags map[string]string
+
+	// rootCAs holds the certificates loaded from --ca-file and --ca-path, nil
+	// to use the system roots.
+	rootCAs *x509.CertPool
+
+	// clientCertificates holds the pair loaded from --client-cert and
+	// --client-key.
+	clientCertificates []tls.Certificate
+
+	// tlsMinVersion, tlsMaxVersion and tlsFailBelow hold the versions parsed
+	// from --tls-min-version, --tls-max-version and --fail-on-tls-below, zero
+	// when unset.
+	tlsMinVersion, tlsMaxVersion, tlsFailBelow uint16
+
+	// resolveOverrides maps the "host:port" of --resolve entries to the IP to
+	// connect to.
+	resolveOverrides map[string]string
+
+	// dnsServer is the ip:port parsed from --dns-server, empty for the system
+	// resolver.
+	dnsServer string
+
+	// sourceIP is the IP parsed from --source-address, nil to let the system
+	// pick the source.
+	sourceIP net.IP
+
+	// pins holds the SPKI hashes parsed from --pin-sha256.
+	pins []string
+
+	// proxyURL holds the proxy parsed from --proxy.
+	proxyURL *url.URL
+
+	// checkedURLs holds the URLs read from --urls-file or --urls-from-stdin.
+	checkedURLs []string
+
+	// preRequests holds the steps parsed from --pre-request.
+	preRequests []preRequest
+
+	// staticCookies holds the cookies parsed from --cookie.
+	staticCookies []*http.Cookie
+
+	// basicAuthUser and basicAuthPassword hold the credentials resolved from
+	// --user and CHECK_PASSWORD. The password must never be printed.
+	basicAuthUser, basicAuthPassword string
+
+	// awsRegion and awsService are parsed from --aws-sigv4, the credentials
+	// are fetched by the first request that signs. awsCredentialsMu guards
+	// the cache, the workers of --concurrency sign at the same time.
+	awsRegion, awsService string
+	awsCredentialsMu      sync.Mutex
+	awsCredentialsCache   *awsCredentials
+
+	// authCommandHeader is the Authorization header value printed by
+	// --auth-command. It must never be printed.
+	authCommandHeader string
+
+	// allowedMethods lists the HTTP methods accepted by --method.
+	allowedMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodDelete,
+		http.MethodOptions,
+	}
+)
+
+func main() {
+	os.Args = append(os.Args[:1], takeURLArgument(os.Args[1:])...)
+	check := sensu.NewGoCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, stdinEvent(os.Args[1:]))
+	check.Execute()
+}
+
+// defaultUserAgent identifies the plugin and the release it was built from.
+func defaultUserAgent() string {
+	v, _, _ := strings.Cut(version.Version(), ",")
+	return fmt.Sprintf("%s/%s", plugin.Name, v)
+}
+
+// checkArgs expands the --url template with the Sensu event, whose
+// annotations the SDK has already applied, and validates the configuration.
+// Invalid options and unreadable files are configuration problems rather than
+// failures of the target, they are UNKNOWN unless --critical-on-error is set,
+// and their message ends with the config_error reason token.
+func checkArgs(event *corev2.Event) (status int, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%v reason=%s", err, reasonConfig)
+		}
+	}()
+	if len(urlArgument) > 0 {
+		plugin.Url = urlArgument
+	}
+	if plugin.Url, err = expandURL(plugin.Url, event); err != nil {
+		return checkState(errorStatus()), err
+	}
+	if plugin.PrintConfig {
+		printConfig(configOutput, appliedAnnotations(event))
+	}
+	if err := validateArgs(); err != nil {
+		return checkState(errorStatus()), err
+	}
+	return sensu.CheckStateOK, nil
+}
+
+// validateArgs checks the options and loads everything they point to.
+func validateArgs() error {
+	if len(plugin.Url) == 0 {
+		return fmt.Errorf("--url or CHECK_URL environment variable is required")
+	}
+	if err := validateURL(plugin.Url); err != nil {
+		return err
+	}
+	if err := applyPort(); err != nil {
+		return err
+	}
+	checkedURLs = nil
+	switch {
+	case len(plugin.UrlsFile) > 0 && plugin.UrlsFromStdin:
+		return fmt.Errorf("--urls-file and --urls-from-stdin are mutually exclusive")
+	case plugin.AnnotationsStdin && plugin.UrlsFromStdin:
+		return fmt.Errorf("--annotations-from-stdin and --urls-from-stdin both read stdin and are mutually exclusive")
+	case len(plugin.UrlsFile) > 0:
+		urls, err := loadURLs(plugin.UrlsFile)
+		if err != nil {
+			return err
+		}
+		checkedURLs = urls
+	case plugin.UrlsFromStdin:
+		urls, err := readURLs(urlsInput, "--urls-from-stdin")
+		if err != nil {
+			return err
+		}
+		checkedURLs = urls
+	}
+	if plugin.PerUrlTimeout < 0 {
+		return fmt.Errorf("--per-url-timeout must not be negative")
+	}
+
+	// ensure the warning and critical thresholds are valid, warnings must be lower than criticals
+	// unless either is 0, which disables it
+	if plugin.Warning < 0 || plugin.Critical < 0 {
+		return fmt.Errorf("--warning and --critical must not be negative, 0 disables a threshold")
+	}
+	if plugin.Warning > 0 && plugin.Critical > 0 && plugin.Warning > plugin.Critical {
+		return fmt.Errorf("warning threshold must be lower than critical threshold")
+	}
+	// The thresholds are kept in seconds from here on.
+	switch plugin.ThresholdUnit {
+	case "s":
+	case "ms":
+		plugin.Warning /= 1000
+		plugin.Critical /= 1000
+	default:
+		return fmt.Errorf("unsupported --threshold-unit %q, must be s or ms", plugin.ThresholdUnit)
+	}
+
+	plugin.Method = strings.ToUpper(strings.TrimSpace(plugin.Method))
+	if !isAllowedMethod(plugin.Method) {
+		return fmt.Errorf("unsupported --method %q, must be one of %s", plugin.Method, strings.Join(allowedMethods, ", "))
+	}
+
+	if len(plugin.RequestBody) > 0 && len(plugin.BodyFile) > 0 {
+		return fmt.Errorf("--request-body and --body-file are mutually exclusive")
+	}
+	requestBody = []byte(plugin.RequestBody)
+	if len(plugin.BodyFile) > 0 {
+		body, err := os.ReadFile(plugin.BodyFile)
+		if err != nil {
+			return fmt.Errorf("unable to read --body-file: %v", err)
+		}
+		if len(body) == 0 {
+			return fmt.Errorf("--body-file %s is empty", plugin.BodyFile)
+		}
+		requestBody = body
+	}
+	if len(plugin.CorsOrigin) > 0 {
+		if err := validateCORSOrigin(plugin.CorsOrigin); err != nil {
+			return err
+		}
+		switch {
+		case len(requestBody) > 0:
+			return fmt.Errorf("--cors-origin sends a preflight without body, --request-body and --body-file cannot be used")
+		case plugin.Method == http.MethodOptions:
+			return fmt.Errorf("--cors-origin needs the --method of the actual request, not OPTIONS")
+		}
+	}
+	if plugin.ExpectContinue {
+		switch {
+		case len(requestBody) == 0:
+			return fmt.Errorf("--expect-continue requires --request-body or --body-file")
+		case plugin.Http3:
+			return fmt.Errorf("--expect-continue is not supported with --http3")
+		case plugin.ContinueMinBytes < 0:
+			return fmt.Errorf("--expect-continue-min-bytes must not be negative")
+		case plugin.ContinueTimeout <= 0:
+			return fmt.Errorf("--expect-continue-timeout must be greater than 0")
+		}
+	}
+
+	headers, err := parseHeaders("--header", plugin.Headers)
+	if err != nil {
+		return err
+	}
+	requestHeaders = headers
+	switch plugin.TraceHeader {
+	case "", "traceparent", "request-id":
+	default:
+		return fmt.Errorf("unsupported --trace-header %q, must be traceparent or request-id", plugin.TraceHeader)
+	}
+	if _, ok := requestHeaders[traceHeaderName()]; ok && len(plugin.TraceHeader) > 0 {
+		return fmt.Errorf("--trace-header cannot be combined with a %s --header", traceHeaderName())
+	}
+
+	switch plugin.AcceptEncoding {
+	case "", "identity", "gzip":
+	case "br":
+		if !brotliSupported {
+			return fmt.Errorf("--accept-encoding br is not supported by this build")
+		}
+	default:
+		return fmt.Errorf("unsupported --accept-encoding %q, must be one of identity, gzip or br", plugin.AcceptEncoding)
+	}
+	if _, ok := requestHeaders["Accept-Encoding"]; ok && len(plugin.AcceptEncoding) > 0 {
+		return fmt.Errorf("--accept-encoding and an Accept-Encoding --header are mutually exclusive")
+	}
+
+	if preRequests, err = parsePreRequests(plugin.PreRequests); err != nil {
+		return err
+	}
+	if staticCookies, err = parseCookies(plugin.Cookies); err != nil {
+		return err
+	}
+
+	basicAuthUser, basicAuthPassword = "", ""
+	if len(plugin.User) > 0 {
+		user, password, found := strings.Cut(plugin.User, ":")
+		if !found {
+			password = os.Getenv("CHECK_PASSWORD")
+		}
+		if len(user) == 0 {
+			return fmt.Errorf("--user requires a user name")
+		}
+		if len(password) == 0 {
+			return fmt.Errorf("--user %s has no password, use --user user:password or set CHECK_PASSWORD", user)
+		}
+		basicAuthUser, basicAuthPassword = user, password
+	}
+
+	ranges, err := parseStatusRanges(plugin.ExpectStatus)
+	if err != nil {
+		return err
+	}
+	expectedStatus = ranges
+	if statusMap, err = parseStatusMap(plugin.StatusMap); err != nil {
+		return err
+	}
+
+	if plugin.MaxRedirects < 0 {
+		return fmt.Errorf("--max-redirects must not be negative")
+	}
+	if plugin.Retries < 0 || plugin.RetryDelay < 0 {
+		return fmt.Errorf("--retries and --retry-delay must not be negative")
+	}
+	if plugin.OutputInMs {
+		if plugin.OutputUnit != "s" && plugin.OutputUnit != "ms" {
+			return fmt.Errorf("--output-in-ms cannot be combined with --output-unit %s", plugin.OutputUnit)
+		}
+		plugin.OutputUnit = "ms"
+	}
+	if _, ok := durationUnits[plugin.OutputUnit]; !ok {
+		return fmt.Errorf("unsupported --output-unit %q, must be one of s, ms or us", plugin.OutputUnit)
+	}
+	if plugin.Precision < -1 || plugin.Precision > 9 {
+		return fmt.Errorf("--precision must be between 0 and 9, or -1 for the default")
+	}
+
+	if plugin.ConnectTimeout < 0 || time.Duration(plugin.ConnectTimeout)*time.Millisecond > time.Duration(plugin.Timeout)*time.Second {
+		return fmt.Errorf("--connect-timeout %d must be between 0 and --timeout %ds", plugin.ConnectTimeout, plugin.Timeout)
+	}
+	if plugin.Samples < 1 {
+		return fmt.Errorf("--samples must be at least 1")
+	}
+	if plugin.SampleInterval < 0 {
+		return fmt.Errorf("--sample-interval must not be negative")
+	}
+	if time.Duration(plugin.Samples-1)*time.Duration(plugin.SampleInterval)*time.Millisecond >= time.Duration(plugin.Timeout)*time.Second {
+		return fmt.Errorf("--samples %d with --sample-interval %d cannot complete within --timeout %d", plugin.Samples, plugin.SampleInterval, plugin.Timeout)
+	}
+	if plugin.MeasureReuse && (plugin.Samples > 1 || plugin.Warmup || plugin.NoKeepalive) {
+		return fmt.Errorf("--measure-reuse cannot be combined with --samples, --warmup or --no-keepalive")
+	}
+	if plugin.MeasureResumption {
+		switch {
+		case plugin.MeasureReuse:
+			return fmt.Errorf("--measure-resumption and --measure-reuse are mutually exclusive")
+		case plugin.Samples > 1 || plugin.Warmup || plugin.NoKeepalive:
+			return fmt.Errorf("--measure-resumption cannot be combined with --samples, --warmup or --no-keepalive")
+		case plugin.Http3:
+			return fmt.Errorf("--measure-resumption cannot be combined with --http3")
+		case !strings.HasPrefix(strings.ToLower(plugin.Url), "https://"):
+			return fmt.Errorf("--measure-resumption requires an https URL")
+		}
+	}
+	if plugin.WarnOnNoResumption && !plugin.MeasureResumption {
+		return fmt.Errorf("--warn-on-no-resumption requires --measure-resumption")
+	}
+	if plugin.Conditional {
+		switch {
+		case plugin.MeasureReuse || plugin.MeasureResumption:
+			return fmt.Errorf("--conditional cannot be combined with --measure-reuse or --measure-resumption")
+		case plugin.Method != http.MethodGet && plugin.Method != http.MethodHead:
+			return fmt.Errorf("--conditional requires --method GET or HEAD")
+		case len(plugin.CorsOrigin) > 0:
+			return fmt.Errorf("--conditional and --cors-origin are mutually exclusive")
+		}
+	}
+	switch plugin.Evaluate {
+	case "avg", "max", "p50", "p95", "p99":
+	default:
+		return fmt.Errorf("unsupported --evaluate %q, must be one of avg, max, p50, p95 or p99", plugin.Evaluate)
+	}
+	if err := validateLoad(); err != nil {
+		return err
+	}
+	if plugin.MaxIps < 1 {
+		return fmt.Errorf("--max-ips must be at least 1")
+	}
+	if plugin.AllIps && plugin.OutputFormat != "nagios" && plugin.OutputFormat != "json" {
+		return fmt.Errorf("--all-ips only supports the nagios and json output formats")
+	}
+	if err := validateDualStack(); err != nil {
+		return err
+	}
+	if len(checkedURLs) > 0 {
+		switch {
+		case plugin.AllIps:
+			return fmt.Errorf("--urls-file and --all-ips are mutually exclusive")
+		case plugin.Port > 0:
+			return fmt.Errorf("--port only applies to --url, list the ports in --urls-file instead")
+		case plugin.OutputFormat != "nagios" && plugin.OutputFormat != "json":
+			return fmt.Errorf("--urls-file only supports the nagios and json output formats")
+		}
+	}
+
+	if plugin.MaxBodyBytes <= 0 {
+		return fmt.Errorf("--max-body-bytes must be greater than 0")
+	}
+	if err := validateBodySize(); err != nil {
+		return err
+	}
+	if err := validateRange(); err != nil {
+		return err
+	}
+	if err := validateRegression(); err != nil {
+		return err
+	}
+
+	bodyRegex = nil
+	if len(plugin.ExpectBodyRegex) > 0 {
+		re, err := regexp.Compile(plugin.ExpectBodyRegex)
+		if err != nil {
+			return fmt.Errorf("invalid --expect-body-regex: %v", err)
+		}
+		bodyRegex = re
+	} else if plugin.InvertRegex {
+		return fmt.Errorf("--invert-regex requires --expect-body-regex")
+	}
+	if expectedSHA256, err = parseSHA256(plugin.ExpectSha256); err != nil {
+		return err
+	}
+
+	if len(plugin.JsonPath) == 0 && (len(plugin.JsonExpect) > 0 || len(plugin.JsonWarning) > 0 || len(plugin.JsonCritical) > 0) {
+		return fmt.Errorf("--json-expect, --json-warning and --json-critical require --json-path")
+	}
+	if jsonWarning, err = parseOptionalFloat("--json-warning", plugin.JsonWarning); err != nil {
+		return err
+	}
+	if jsonCritical, err = parseOptionalFloat("--json-critical", plugin.JsonCritical); err != nil {
+		return err
+	}
+	if jsonWarning != nil && jsonCritical != nil && *jsonWarning > *jsonCritical {
+		return fmt.Errorf("--json-warning must be lower than --json-critical")
+	}
+
+	if rootCAs, err = loadCertPool(plugin.CaFile, plugin.CaPath); err != nil {
+		return err
+	}
+
+	clientCertificates = nil
+	if len(plugin.ClientCert) > 0 || len(plugin.ClientKey) > 0 {
+		if len(plugin.ClientCert) == 0 || len(plugin.ClientKey) == 0 {
+			return fmt.Errorf("--client-cert and --client-key must be given together")
+		}
+		cert, err := loadClientCertificate(plugin.ClientCert, plugin.ClientKey, plugin.ClientKeyPassword)
+		if err != nil {
+			return err
+		}
+		clientCertificates = []tls.Certificate{cert}
+	}
+
+	for _, v := range []struct {
+		name    string
+		value   string
+		version *uint16
+	}{
+		{"--tls-min-version", plugin.TlsMinVersion, &tlsMinVersion},
+		{"--tls-max-version", plugin.TlsMaxVersion, &tlsMaxVersion},
+		{"--fail-on-tls-below", plugin.FailOnTlsBelow, &tlsFailBelow},
+	} {
+		if *v.version, err = parseTLSVersion(v.value); err != nil {
+			return fmt.Errorf("invalid %s: %v", v.name, err)
+		}
+	}
+	if tlsMinVersion > 0 && tlsMaxVersion > 0 && tlsMinVersion > tlsMaxVersion {
+		return fmt.Errorf("--tls-min-version must not be higher than --tls-max-version")
+	}
+	switch plugin.WeakCipherStatus {
+	case "ok", "warning", "critical":
+	default:
+		return fmt.Errorf("unsupported --weak-cipher-status %q, must be ok, warning or critical", plugin.WeakCipherStatus)
+	}
+	if plugin.ExpectAlpnCritical && len(plugin.ExpectAlpn) == 0 {
+		return fmt.Errorf("--expect-alpn-critical requires --expect-alpn")
+	}
+
+	switch plugin.IpVersion {
+	case "any", "4", "6":
+	default:
+		return fmt.Errorf("unsupported --ip-version %q, must be one of any, 4 or 6", plugin.IpVersion)
+	}
+
+	if resolveOverrides, err = parseResolve(plugin.Resolve); err != nil {
+		return err
+	}
+
+	if dnsServer, err = parseDNSServer(plugin.DnsServer); err != nil {
+		return err
+	}
+	if err := validateResolver(); err != nil {
+		return err
+	}
+	// A resolver that does not prefer Go still lets Go pick its own when it
+	// understands the system configuration, only GODEBUG forces the C
+	// library one. The net package reads it on the first lookup, so it is set
+	// once here rather than with every transport. The option wins over a
+	// netdns setting of the user, so the verbose resolver= is the one used.
+	if plugin.Resolver == "cgo" {
+		os.Setenv("GODEBUG", forceCgoNetdns(os.Getenv("GODEBUG")))
+	}
+	switch plugin.DnsFailureStatus {
+	case "critical", "warning", "unknown":
+	default:
+		return fmt.Errorf("unsupported --dns-failure-status %q, must be critical, warning or unknown", plugin.DnsFailureStatus)
+	}
+
+	if sourceIP, err = parseSourceAddress(plugin.SourceAddress); err != nil {
+		return err
+	}
+	if len(plugin.Interface) > 0 {
+		if !interfaceSupported {
+			return fmt.Errorf("--interface is only supported on Linux")
+		}
+		if _, err := net.InterfaceByName(plugin.Interface); err != nil {
+			return fmt.Errorf("unknown --interface %q", plugin.Interface)
+		}
+	}
+	if bound() && len(plugin.UnixSocket) > 0 {
+		return fmt.Errorf("--source-address and --interface cannot be combined with --unix-socket")
+	}
+
+	switch plugin.HttpVersion {
+	case "auto", "1.1", "2":
+	default:
+		return fmt.Errorf("unsupported --http-version %q, must be one of auto, 1.1 or 2", plugin.HttpVersion)
+	}
+
+	if plugin.Http3 {
+		switch {
+		case !http3Supported:
+			return fmt.Errorf("--http3 is not supported by this build")
+		case plugin.HttpVersion != "auto":
+			return fmt.Errorf("--http3 and --http-version are mutually exclusive")
+		case len(plugin.UnixSocket) > 0:
+			return fmt.Errorf("--http3 and --unix-socket are mutually exclusive")
+		case !strings.HasPrefix(strings.ToLower(plugin.Url), "https://"):
+			return fmt.Errorf("--http3 requires an https URL")
+		}
+		for _, u := range checkedURLs {
+			if !strings.HasPrefix(strings.ToLower(u), "https://") {
+				return fmt.Errorf("--http3 requires https URLs, got %s", u)
+			}
+		}
+	}
+
+	if pins, err = parsePins(plugin.PinSha256); err != nil {
+		return err
+	}
+
+	if proxyURL, err = parseProxy(plugin.Proxy); err != nil {
+		return err
+	}
+	if proxyURL != nil && (plugin.Http3 || len(plugin.UnixSocket) > 0) {
+		return fmt.Errorf("--proxy cannot be combined with --http3 or --unix-socket")
+	}
+
+	if expectedHeaders, err = parseHeaders("--expect-header", plugin.ExpectHeaders); err != nil {
+		return err
+	}
+	if expectedHeaderRegex, err = parseHeaderRegex(plugin.ExpectHeaderRegex); err != nil {
+		return err
+	}
+	if ignoredSecurityHeaders, err = parseIgnoredSecurityHeaders(plugin.IgnoreSecHeaders); err != nil {
+		return err
+	}
+	if !plugin.SecurityHeaders && (plugin.SecurityHeadersCrit || len(plugin.IgnoreSecHeaders) > 0) {
+		return fmt.Errorf("--security-headers-critical and --ignore-security-header require --check-security-headers")
+	}
+	if plugin.HstsMinAge < 0 {
+		return fmt.Errorf("--hsts-min-age must not be negative")
+	}
+	if plugin.MaxAgeWarning < 0 || plugin.MaxAgeCritical < 0 {
+		return fmt.Errorf("--max-age-warning and --max-age-critical must not be negative")
+	}
+	if plugin.MaxAgeWarning > 0 && plugin.MaxAgeCritical > 0 && plugin.MaxAgeWarning > plugin.MaxAgeCritical {
+		return fmt.Errorf("--max-age-warning must be lower than --max-age-critical")
+	}
+	if plugin.RatelimitWarning < 0 || plugin.RatelimitCritical < 0 {
+		return fmt.Errorf("--ratelimit-warning and --ratelimit-critical must not be negative")
+	}
+	// The remaining count alerts when it drops below the threshold, so
+	// warning is the higher value.
+	if plugin.RatelimitWarning > 0 && plugin.RatelimitCritical > 0 && plugin.RatelimitWarning < plugin.RatelimitCritical {
+		return fmt.Errorf("--ratelimit-warning must be higher than --ratelimit-critical")
+	}
+
+	for _, t := range []struct {
+		name              string
+		warning, critical float32
+	}{
+		{"dns", plugin.DnsWarning, plugin.DnsCritical},
+		{"connect", plugin.ConnectWarning, plugin.ConnectCritical},
+		{"tls", plugin.TlsWarning, plugin.TlsCritical},
+		{"ttfb", plugin.TtfbWarning, plugin.TtfbCritical},
+		{"server", plugin.ServerWarning, plugin.ServerCritical},
+	} {
+		if t.warning < 0 || t.critical < 0 {
+			return fmt.Errorf("--%s-warning and --%s-critical must not be negative", t.name, t.name)
+		}
+		if t.warning > 0 && t.critical > 0 && t.warning > t.critical {
+			return fmt.Errorf("--%s-warning must be lower than --%s-critical", t.name, t.name)
+		}
+	}
+	if plugin.ThroughputWarning < 0 || plugin.ThroughputCritical < 0 {
+		return fmt.Errorf("--throughput-warning and --throughput-critical must not be negative")
+	}
+	// Throughput alerts when it drops below the threshold, so warning is the
+	// higher value.
+	if plugin.ThroughputWarning > 0 && plugin.ThroughputCritical > 0 && plugin.ThroughputWarning < plugin.ThroughputCritical {
+		return fmt.Errorf("--throughput-warning must be higher than --throughput-critical")
+	}
+	if plugin.CertExpiryWarning < 0 || plugin.CertExpiryCritical < 0 {
+		return fmt.Errorf("--cert-expiry-warning and --cert-expiry-critical must not be negative")
+	}
+	if plugin.CertExpiryWarning > 0 && plugin.CertExpiryCritical > 0 && plugin.CertExpiryWarning < plugin.CertExpiryCritical {
+		return fmt.Errorf("--cert-expiry-warning must be higher than --cert-expiry-critical")
+	}
+
+	metricTags = map[string]string{}
+	for _, tag := range plugin.MetricTags {
+		key, value, found := strings.Cut(tag, "=")
+		if !found || len(strings.TrimSpace(key)) == 0 || len(strings.TrimSpace(value)) == 0 {
+			return fmt.Errorf("invalid --metric-tag %q, expected key=value", tag)
+		}
+		metricTags[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	if len(plugin.BearerToken) > 0 && len(plugin.BearerTokenFile) > 0 {
+		return fmt.Errorf("--bearer-token and --bearer-token-file are mutually exclusive")
+	}
+	if len(plugin.User) > 0 && (len(plugin.BearerToken) > 0 || len(plugin.BearerTokenFile) > 0) {
+		return fmt.Errorf("--user cannot be combined with a bearer token")
+	}
+	if plugin.AuthType == "digest" && len(plugin.User) == 0 {
+		return fmt.Errorf("--auth-type digest requires --user")
+	}
+	if err := validateSigV4(); err != nil {
+		return err
+	}
+	if err := validateAuthCommand(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseHeaders parses the "Name: Value" entries of the option flag, e.g.
+// --header, into an http.Header. Repeated names are appended rather than
+// replaced, as curl does.
+func parseHeaders(flag string, entries []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, entry := range entries {
+		name, value, found := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !found || len(name) == 0 || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid %s %q, expected \"Name: Value\"", flag, entry)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// readBearerToken returns the token from --bearer-token, or reads and trims
+// it from --bearer-token-file.
+func readBearerToken() (string, error) {
+	if len(plugin.BearerTokenFile) == 0 {
+		return plugin.BearerToken, nil
+	}
+	b, err := os.ReadFile(plugin.BearerTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read --bearer-token-file: %v", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if len(token) == 0 {
+		return "", fmt.Errorf("--bearer-token-file %s is empty", plugin.BearerTokenFile)
+	}
+	return token, nil
+}
+
+// redact replaces every occurrence of the given secrets in s.
+func redact(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if len(secret) > 0 {
+			s = strings.ReplaceAll(s, secret, "REDACTED")
+		}
+	}
+	return s
+}
+
+// serverName returns the TLS server name for req, which follows an overridden
+// Host header so the certificate is verified against the virtual host.
+func serverName(req *http.Request) string {
+	if len(req.Host) == 0 {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		return req.Host
+	}
+	return host
+}
+
+// statusRange is an inclusive range of HTTP status codes.
+type statusRange struct {
+	min, max int
+}
+
+// parseStatusRanges parses a comma separated list of status codes and ranges
+// such as "200,201,301-302".
+func parseStatusRanges(list string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(entry, "-")
+		if !isRange {
+			hi = lo
+		}
+		min, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --expect-status entry %q", entry)
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --expect-status entry %q", entry)
+		}
+		if min < 100 || max > 599 || min > max {
+			return nil, fmt.Errorf("invalid --expect-status entry %q, codes must be between 100 and 599", entry)
+		}
+		ranges = append(ranges, statusRange{min, max})
+	}
+	return ranges, nil
+}
+
+// statusExpected reports whether code falls in one of ranges. An empty list
+// accepts any code.
+func statusExpected(code int, ranges []statusRange) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if code >= r.min && code <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// phase is a timed portion of the request with optional thresholds, in
+// seconds. A zero threshold is disabled.
+type phase struct {
+	name              string
+	start, end        time.Time
+	warning, critical float32
+}
+
+// occurred reports whether both ends of the phase were recorded. DNS does not
+// happen for IP literals, TLS for plain http and the connect for a reused
+// connection, such phases are left out instead of reported as zero.
+func (p phase) occurred() bool {
+	return !p.start.IsZero() && !p.end.IsZero()
+}
+
+// phaseMetrics returns the metrics of the phases that occurred.
+func phaseMetrics(phases []phase) []metric {
+	var metrics []metric
+	for _, p := range phases {
+		if p.occurred() {
+			metrics = append(metrics, durationMetric(p.name, p.end.Sub(p.start), p.warning, p.critical))
+		}
+	}
+	return metrics
+}
+
+// hopPhases returns the timed phases of a hop with their thresholds. The
+// time to first byte runs from the start of the request, like curl and
+// http-perf report it, and is split into the network setup until a
+// connection was obtained, about zero for a reused one, the request write and
+// the server processing once the request was written.
+func hopPhases(t httpperf.Timings) []phase {
+	return []phase{
+		{"dns_duration", t.DNSStart, t.DNSDone, plugin.DnsWarning, plugin.DnsCritical},
+		{"tls_handshake_duration", t.TLSHandshakeStart, t.TLSHandshakeDone, plugin.TlsWarning, plugin.TlsCritical},
+		{"connect_duration", t.ConnectStart, t.ConnectDone, plugin.ConnectWarning, plugin.ConnectCritical},
+		{"first_byte_duration", t.Start, t.FirstResponseByte, plugin.TtfbWarning, plugin.TtfbCritical},
+		{"network_setup_duration", t.Start, t.GotConn, 0, 0},
+		{"request_write_duration", t.GotConn, t.WroteRequest, 0, 0},
+		{"server_processing_duration", t.WroteRequest, t.FirstResponseByte, plugin.ServerWarning, plugin.ServerCritical},
+		{"continue_wait_duration", t.Wait100Continue, t.Got100Continue, 0, 0},
+	}
+}
+
+// checkPhases compares each phase that occurred against its thresholds and
+// returns the worst status along with a description of every breach.
+func checkPhases(phases []phase) (string, []string) {
+	status := "OK"
+	var breaches []string
+	for _, p := range phases {
+		if !p.occurred() {
+			continue
+		}
+		d := p.end.Sub(p.start)
+		switch {
+		case p.critical > 0 && d > secondsToDuration(p.critical):
+			status = "CRITICAL"
+			breaches = append(breaches, fmt.Sprintf("%s %.3fs > %gs", p.name, d.Seconds(), p.critical))
+		case p.warning > 0 && d > secondsToDuration(p.warning):
+			if status == "OK" {
+				status = "WARNING"
+			}
+			breaches = append(breaches, fmt.Sprintf("%s %.3fs > %gs", p.name, d.Seconds(), p.warning))
+		}
+	}
+	return status, breaches
+}
+
+// expiryMetric returns a certificate expiry metric with the
+// --cert-expiry-warning and --cert-expiry-critical thresholds.
+func expiryMetric(label string, days float64) metric {
+	return metric{
+		label:    label,
+		value:    days,
+		warning:  threshold(float32(plugin.CertExpiryWarning)),
+		critical: threshold(float32(plugin.CertExpiryCritical)),
+		below:    true,
+	}
+}
+
+// expiryStatus compares the days left on a certificate against the
+// --cert-expiry-warning and --cert-expiry-critical thresholds.
+func expiryStatus(days float64) string {
+	switch {
+	case plugin.CertExpiryCritical > 0 && days < float64(plugin.CertExpiryCritical):
+		return "CRITICAL"
+	case plugin.CertExpiryWarning > 0 && days < float64(plugin.CertExpiryWarning):
+		return "WARNING"
+	}
+	return "OK"
+}
+
+// bytesPerSecond returns the transfer rate of n bytes over d, an empty body or
+// an instant transfer is reported as 0.
+func bytesPerSecond(n int64, d time.Duration) float64 {
+	if n <= 0 || d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+// kbThreshold converts a threshold in KB/s into a bytes per second metric
+// threshold.
+func kbThreshold(value float32) *float64 {
+	t := threshold(value)
+	if t != nil {
+		*t *= 1024
+	}
+	return t
+}
+
+// secondsToDuration converts a threshold in seconds to a time.Duration.
+func secondsToDuration(seconds float32) time.Duration {
+	return time.Duration(float64(seconds) * float64(time.Second))
+}
+
+// headerRegex is a response header assertion from --expect-header-regex.
+type headerRegex struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// parseHeaderRegex parses "Name: regex" entries.
+func parseHeaderRegex(entries []string) ([]headerRegex, error) {
+	var assertions []headerRegex
+	for _, entry := range entries {
+		name, expr, found := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !found || len(name) == 0 || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid --expect-header-regex %q, expected \"Name: regex\"", entry)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(expr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --expect-header-regex %q: %v", entry, err)
+		}
+		assertions = append(assertions, headerRegex{http.CanonicalHeaderKey(name), re})
+	}
+	return assertions, nil
+}
+
+// checkHeaders evaluates the --cors-origin preflight, the header assertions,
+// the cache and rate limit headers and, with --check-security-headers, the
+// security headers of resp, raising verdict and returning their metrics.
+func checkHeaders(verdict *measurement, resp *http.Response) []metric {
+	if len(plugin.CorsOrigin) > 0 {
+		if failure := checkCORS(resp.Header); len(failure) > 0 {
+			verdict.raise("CRITICAL", reasonCORS)
+			verdict.details += failure
+		} else {
+			verdict.details += " CORS preflight allowed"
+		}
+	}
+
+	if failures := checkResponseHeaders(resp.Header); len(failures) > 0 {
+		verdict.raise("CRITICAL", reasonHeaderMismatch)
+		verdict.details += " " + strings.Join(failures, ", ")
+	}
+
+	var metrics []metric
+	cacheStatus, cacheDetails, cacheMetric := checkCache(resp.Header)
+	verdict.raise(cacheStatus, "")
+	verdict.details += cacheDetails
+	if cacheMetric != nil {
+		metrics = append(metrics, *cacheMetric)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		verdict.details += rateLimited(resp.Header)
+	}
+	ratelimitStatus, ratelimitDetails, ratelimitMetric := checkRatelimit(resp.Header)
+	verdict.raise(ratelimitStatus, "")
+	verdict.details += ratelimitDetails
+	if ratelimitMetric != nil {
+		metrics = append(metrics, *ratelimitMetric)
+	}
+
+	if plugin.SecurityHeaders {
+		missing, weak := checkSecurityHeaders(resp)
+		if len(missing) > 0 || len(weak) > 0 {
+			failed := "WARNING"
+			if plugin.SecurityHeadersCrit {
+				failed = "CRITICAL"
+			}
+			verdict.raise(failed, reasonHeaderMismatch)
+		}
+		if len(missing) > 0 {
+			verdict.details += " missing_security_headers=" + strings.Join(missing, ",")
+		}
+		if len(weak) > 0 {
+			verdict.details += " " + weak
+		}
+		metrics = append(metrics, valueMetric("missing_security_headers", float64(len(missing)), ""))
+	}
+	return metrics
+}
+
+// checkResponseHeaders evaluates the --expect-header and
+// --expect-header-regex assertions, returning a description of each failure.
+// Header names are matched case-insensitively.
+func checkResponseHeaders(header http.Header) []string {
+	var failures []string
+	for name, values := range expectedHeaders {
+		got, ok := header[name]
+		for _, want := range values {
+			if !ok {
+				failures = append(failures, fmt.Sprintf("header %s missing", name))
+			} else if !strings.Contains(strings.Join(got, ", "), want) {
+				failures = append(failures, fmt.Sprintf("header %s=%q does not contain %q", name, strings.Join(got, ", "), want))
+			}
+		}
+	}
+	for _, a := range expectedHeaderRegex {
+		got, ok := header[a.name]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("header %s missing", a.name))
+		} else if !a.re.MatchString(strings.Join(got, ", ")) {
+			failures = append(failures, fmt.Sprintf("header %s=%q does not match %q", a.name, strings.Join(got, ", "), a.re))
+		}
+	}
+	sort.Strings(failures)
+	return failures
+}
+
+// parseOptionalFloat parses an optional numeric flag, returning nil when it
+// is empty.
+func parseOptionalFloat(name, value string) (*float64, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: must be a number", name, value)
+	}
+	return &f, nil
+}
+
+// truncate returns at most n bytes of b.
+func truncate(b []byte, n int) []byte {
+	if len(b) > n {
+		return b[:n]
+	}
+	return b
+}
+
+// validateURL checks that rawURL is an absolute http or https URL with a
+// host.
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid --url: %v", err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	default:
+		return fmt.Errorf("unsupported --url scheme %q, must be http or https", u.Scheme)
+	}
+	if len(u.Hostname()) == 0 {
+		return fmt.Errorf("invalid --url %q, the host is missing", rawURL)
+	}
+	return nil
+}
+
+// applyPort replaces the port of --url with --port. The default port of the
+// scheme is left out, as browsers do, so the Host header does not carry it.
+func applyPort() error {
+	if plugin.Port == 0 {
+		return nil
+	}
+	if plugin.Port < 1 || plugin.Port > 65535 {
+		return fmt.Errorf("--port must be between 1 and 65535")
+	}
+	u, err := url.Parse(plugin.Url)
+	if err != nil {
+		return fmt.Errorf("invalid --url: %v", err)
+	}
+	u.Host = u.Hostname()
+	if strings.Contains(u.Host, ":") {
+		u.Host = "[" + u.Host + "]"
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if !(scheme == "http" && plugin.Port == 80 || scheme == "https" && plugin.Port == 443) {
+		u.Host += ":" + strconv.Itoa(plugin.Port)
+	}
+	plugin.Url = u.String()
+	return nil
+}
+
+// isAllowedMethod reports whether method is one of allowedMethods.
+func isAllowedMethod(method string) bool {
+	for _, m := range allowedMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// measure runs one timed request against the URL, following redirects, and
+// evaluates every assertion. The deadline of ctx covers the whole redirect
+// chain.
+func measure(ctx context.Context, transports *transportCache) measurement {
+	return measureWith(ctx, transports, nil)
+}
+
+// drainLimit is how much of a body that is not read is drained so that its
+// connection is kept alive, a larger one closes the connection.
+const drainLimit = 64 << 10
+
+// measureWith is measure with header added to the request, e.g. the
+// validators of a conditional request.
+func measureWith(ctx context.Context, transports *transportCache, header http.Header) measurement {
+	req, bearerToken, err := buildRequest()
+	if err != nil {
+		return configFailure(err.Error())
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	traceID, err := setTraceHeader(req)
+	if err != nil {
+		return configFailure(err.Error())
+	}
+	// The credentials are fetched before the request starts, they are not
+	// part of its timing.
+	if len(awsRegion) > 0 {
+		creds, err := loadAWSCredentials(ctx)
+		if err != nil {
+			return configFailure(err.Error())
+		}
+		signV4(req, requestBody, creds, awsRegion, awsService, time.Now())
+	}
+
+	// Mention the virtual host when it differs from the URL so operators know
+	// what was measured.
+	var details string
+	if len(req.Host) > 0 && req.Host != req.URL.Host {
+		details = " host=" + req.Host
+	}
+	if plugin.Port > 0 {
+		details += " target=" + hostPort(req.URL)
+	}
+	if len(plugin.Sni) > 0 && plugin.Sni != req.URL.Hostname() {
+		details += " sni=" + plugin.Sni
+	}
+	if len(traceID) > 0 {
+		details += " " + traceKey() + "=" + traceID
+	}
+	originalHost := req.URL.Host
+
+	transport := func(r *http.Request) http.RoundTripper {
+		name := serverName(r)
+		primary := r.URL.Host == originalHost
+		if len(plugin.Sni) > 0 && primary {
+			name = plugin.Sni
+		}
+		return transports.get(transportKey{
+			name:   name,
+			pinned: primary,
+			h2c:    plugin.HttpVersion == "2" && r.URL.Scheme == "http",
+			h3:     plugin.Http3 && r.URL.Scheme == "https",
+		})
+	}
+
+	// The --pre-request steps share the cookies of the measured request, the
+	// deadline of ctx covers them too.
+	jar := newSessionJar()
+	if m, ok := runPreRequests(ctx, jar, transport, basicAuthPassword, bearerToken, proxyPassword()); !ok {
+		return m
+	}
+
+	// Send the requests and record the total time.
+	x, err := httpperf.Send(ctx, req, requestBody, plugin.MaxRedirects, jar, transport)
+	var authRoundtrip time.Duration
+	if err == nil && plugin.AuthType == "digest" {
+		var digestErr *digestError
+		x, authRoundtrip, err = answerDigest(ctx, x, jar, transport)
+		if errors.As(err, &digestErr) {
+			details += " (" + err.Error() + ")"
+			err = nil
+		} else if err == nil && authRoundtrip > 0 && x.Response.StatusCode == http.StatusUnauthorized {
+			details += " (digest authentication rejected)"
+		}
+	}
+	if plugin.Verbose {
+		defer dumpExchange(debugOutput, x, basicAuthPassword, bearerToken, proxyPassword())
+	}
+	if err != nil {
+		return sendFailure(x, err, bearerToken)
+	}
+	resp, hops := x.Response, x.Hops
+
+	defer resp.Body.Close()
+
+	// The phases come from the hop that produced the final response.
+	final := x.Final()
+
+	body, m, ok := readResponseBody(resp, final, basicAuthPassword, bearerToken)
+	if !ok {
+		return m
+	}
+
+	// The request is complete once the body was read, this one value is
+	// compared against the thresholds and printed.
+	final.Finish()
+	t := final.Timings()
+	elapsed := t.Done.Sub(x.Start)
+
+	// A body that is not measured is still drained, after the timings, so
+	// the next sample reuses the connection instead of paying for a new
+	// one. This is the only drain, at most drainLimit bytes, a larger body
+	// closes the connection.
+	var drained int64
+	if !body.read() {
+		drained, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, drainLimit))
+	}
+
+	details += connectionDetails(resp.Request, t)
+	if plugin.Verbose && !body.read() {
+		details += fmt.Sprintf(" drained_bytes=%d", drained)
+	}
+
+	if transports.clientCertPresented.Load() {
+		details += " client cert presented"
+	}
+
+	if len(x.Chain) > 1 && !plugin.NoUrlInOutput {
+		details += " final_url=" + resp.Request.URL.String()
+	}
+
+	// The latency and phase thresholds are applied by evaluate, once the
+	// timings of every sample are in. verdict holds the worst status of the
+	// checks, along with the reason token of the first check that set it.
+	verdict := measurement{status: "OK", details: details}
+	codeStatus, codeDetails := checkStatusCode(resp.StatusCode)
+	verdict.raise(codeStatus, reasonStatusMismatch)
+	verdict.details += codeDetails
+
+	checkBody(&verdict, resp, body, t)
+
+	if len(plugin.AcceptEncoding) > 0 || body.encoding != "identity" {
+		verdict.details += " content_encoding=" + body.encoding
+	}
+	verdict.details += " proto=" + resp.Proto
+	if plugin.HttpVersion == "2" && resp.ProtoMajor != 2 {
+		verdict.raise("CRITICAL", reasonProtocol)
+		verdict.details += " (expected HTTP/2)"
+	}
+
+	// Plain http responses skip the TLS checks and have no certificate to
+	// check.
+	var certMetrics []metric
+	if resp.TLS != nil {
+		checkConnectionTLS(&verdict, resp.TLS, t)
+	}
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		certMetrics = checkCertificates(&verdict, resp.TLS)
+	}
+
+	headerMetrics := checkHeaders(&verdict, resp)
+
+	// Evaluate the JSON field, the value is also reported as perfdata when it
+	// is numeric.
+	var jsonMetric *metric
+	if len(plugin.JsonPath) > 0 {
+		if jsonMetric, err = checkJSON(&verdict, body.data); err != nil {
+			m := failure("UNKNOWN", err.Error())
+			m.reason = reasonJSON
+			return m
+		}
+	}
+
+	continueDetails, continueMetrics := checkContinue(t)
+	verdict.details += continueDetails
+
+	// Output the results
+	phases := hopPhases(t)
+	metrics := exchangeMetrics(x, phases, elapsed, authRoundtrip)
+	metrics = append(metrics, bodyMetrics(body, t)...)
+	metrics = append(metrics, continueMetrics...)
+	metrics = append(metrics, certMetrics...)
+	metrics = append(metrics, headerMetrics...)
+	if jsonMetric != nil {
+		metrics = append(metrics, *jsonMetric)
+	}
+	// Show the status of every hop, e.g. "301 -> 302 -> 200 OK".
+	var statuses []string
+	for _, h := range hops[:len(hops)-1] {
+		statuses = append(statuses, strconv.Itoa(h.Timings().Status))
+	}
+	statuses = append(statuses, resp.Status)
+
+	return measurement{
+		status:     verdict.status,
+		reason:     verdict.reason,
+		statusLine: strings.Join(statuses, " -> "),
+		started:    x.Start,
+		elapsed:    elapsed,
+		phases:     phases,
+		details:    verdict.details,
+		metrics:    metrics,
+		httpStatus: resp.StatusCode,
+		remoteAddr: t.RemoteAddr,
+		reused:     hops[0].Timings().Reused,
+		dnsAddrs:   t.DNSAddrs,
+		tls:        resp.TLS,
+		validators: conditionalHeader(resp.Header),
+		traceID:    traceID,
+	}
+}
+
+// exchangeMetrics returns the metrics of the exchange x that took elapsed:
+// the phases of its final hop, the request and response, and with redirects
+// the duration of every hop.
+func exchangeMetrics(x *httpperf.Exchange, phases []phase, elapsed, authRoundtrip time.Duration) []metric {
+	resp, t := x.Response, x.Final().Timings()
+	redirects := len(x.Chain) - 1
+	// Record whether the measurement is of a cold or a warm connection.
+	var reused float64
+	if x.Hops[0].Timings().Reused {
+		reused = 1
+	}
+
+	metrics := phaseMetrics(phases)
+	if d, ok := proxyConnectDuration(resp.Request, t); ok {
+		metrics = append(metrics, durationMetric("proxy_connect_duration", d, 0, 0))
+	}
+	metrics = append(metrics,
+		durationMetric("total_request_duration", elapsed, plugin.Warning, plugin.Critical),
+		valueMetric("request_body_bytes", float64(len(requestBody)), "B"),
+		valueMetric("http_status", float64(resp.StatusCode), ""),
+		valueMetric("http_version", float64(resp.ProtoMajor)+float64(resp.ProtoMinor)/10, ""),
+		valueMetric("redirect_count", float64(redirects), ""),
+		valueMetric("connection_reused", reused, ""),
+	)
+	if authRoundtrip > 0 {
+		metrics = append(metrics, durationMetric("auth_roundtrip_duration", authRoundtrip, 0, 0))
+	}
+	if redirects > 0 {
+		for i, h := range x.Hops {
+			ht := h.Timings()
+			metrics = append(metrics, durationMetric(fmt.Sprintf("hop%d_total", i+1), ht.Done.Sub(ht.Start), 0, 0))
+		}
+	}
+	return metrics
+}
+
+// sendFailure returns the measurement of the exchange x that failed with
+// err, classified and with the phases its final hop got through. The
+// credentials are redacted from the message.
+func sendFailure(x *httpperf.Exchange, err error, bearerToken string) measurement {
+	var (
+		redirectErr *httpperf.RedirectError
+		followErr   *httpperf.FollowError
+	)
+	switch {
+	case errors.As(err, &redirectErr):
+		m := failure("CRITICAL", redact(err.Error(), basicAuthPassword, bearerToken))
+		m.reason = reasonRedirect
+		return requestFailure(m, x.Final(), nil)
+	case errors.As(err, &followErr):
+		m := failure("CRITICAL", redact(err.Error(), basicAuthPassword, bearerToken))
+		m.reason = reasonRedirect
+		return m
+	}
+	// A failed lookup is reported as such, not as whatever the dial wrapped
+	// it in, unless the host merely lacks the --ip-version family.
+	var noAddrErr *noAddressError
+	if dnsErr := x.Final().Timings().DNSErr; dnsErr != nil && !errors.As(err, &noAddrErr) {
+		return requestFailure(classifyError(dnsErr), x.Final(), err)
+	}
+	return requestFailure(classifyError(err, basicAuthPassword, bearerToken, proxyPassword()), x.Final(), err)
+}
+
+// connectionDetails shows which backend req was sent to, along with the
+// address family when it was forced, and with --verbose the local address,
+// the lookup and the proxy.
+func connectionDetails(req *http.Request, t httpperf.Timings) string {
+	var details string
+	if ip, ok := resolveOverrides[hostPort(req.URL)]; ok {
+		details += " resolved-override=" + ip
+	}
+	if len(t.RemoteAddr) > 0 {
+		details += " remote_addr=" + t.RemoteAddr
+		if _, family := remoteIP(t.RemoteAddr); plugin.IpVersion != "any" && len(family) > 0 {
+			details += " family=" + family
+		}
+	}
+	if !plugin.Verbose {
+		return details
+	}
+	if bound() && len(t.LocalAddr) > 0 {
+		details += " local_addr=" + t.LocalAddr
+	}
+	// IP literals and reused connections have no lookup to show.
+	if len(t.DNSAddrs) > 0 {
+		details += " resolved=" + strings.Join(t.DNSAddrs, ",")
+	}
+	if !t.DNSDone.IsZero() {
+		details += fmt.Sprintf(" resolver=%s dns_coalesced=%t", resolverName(), t.DNSCoalesced)
+	}
+	proxy := "none"
+	if u := requestProxy(req); u != nil {
+		proxy = u.Redacted()
+	}
+	return details + " proxy=" + proxy
+}
+
+// measurement is the outcome of a check run against the URL.
+type measurement struct {
+	// status is OK, WARNING, CRITICAL or UNKNOWN.
+	status string
+
+	// err describes why no complete measurement was possible, the other
+	// fields are then unset.
+	err string
+
+	// reason is the reason token of what failed the request or set the
+	// status, e.g. dns_error or body_mismatch. It is empty for a breached
+	// threshold.
+	reason string
+
+	// retryable is set when the request failed to connect or the connection
+	// broke, a new attempt may succeed.
+	retryable bool
+
+	// statusLine is the status of every hop, e.g. "301 -> 200 OK".
+	statusLine string
+	elapsed    time.Duration
+	details    string
+	metrics    []metric
+
+	// started is when the request was sent, shown in the summary.
+	started time.Time
+
+	// phases are the timings of the final hop, which the phase thresholds
+	// apply to.
+	phases []phase
+
+	httpStatus int
+	remoteAddr string
+	// reused is set when the first request went over a kept alive
+	// connection.
+	reused   bool
+	dnsAddrs []string
+	tls      *tls.ConnectionState
+
+	// validators are the If-None-Match and If-Modified-Since headers that
+	// revalidate the response, empty when it has no ETag or Last-Modified.
+	validators http.Header
+
+	// traceID is the ID of the --trace-header the request was sent with.
+	traceID string
+}
+
+// worseStatus returns the more severe of two statuses.
+func worseStatus(a, b string) string {
+	rank := map[string]int{"OK": 0, "WARNING": 1, "UNKNOWN": 2, "CRITICAL": 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// failure returns the measurement of a run that could not be completed.
+func failure(status, message string) measurement {
+	return measurement{status: status, err: message}
+}
+
+// configFailure returns the measurement of a run that could not send the
+// request because of the configuration, not the target.
+func configFailure(message string) measurement {
+	return measurement{status: errorStatus(), err: message, reason: reasonConfig}
+}
+
+// requestFailure adds the phases h got through before the request failed
+// with err to m. A request that ran into --timeout also reports the timeout
+// as total_request_duration and timed_out=1, so the series has no hole when
+// the target is slow. The message gets the --trace-header ID of the request.
+func requestFailure(m measurement, h *httpperf.Hop, err error) measurement {
+	m.metrics = phaseMetrics(hopPhases(h.Timings()))
+	if id := requestTraceID(h.Request()); len(id) > 0 {
+		m.err += " " + traceKey() + "=" + id
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		m.metrics = append(m.metrics,
+			durationMetric("total_request_duration", time.Duration(plugin.Timeout)*time.Second, plugin.Warning, plugin.Critical),
+			valueMetric("timed_out", 1, ""),
+		)
+	}
+	return m
+}
+
+// upMetric returns the up gauge of m, 1 when the request completed whatever
+// its status and 0 when it failed.
+func upMetric(m measurement) metric {
+	if len(m.err) > 0 {
+		return valueMetric("up", 0, "")
+	}
+	return valueMetric("up", 1, "")
+}
+
+// connectionFailure returns the measurement of a run that failed to connect
+// or lost its connection, which --retries tries again.
+func connectionFailure(message string) measurement {
+	return measurement{status: "CRITICAL", err: message, reason: reasonConnection, retryable: true}
+}
+
+// measureWithRetries measures again, up to --retries times, while the
+// request fails to connect or gets a 5xx response, sleeping --retry-delay in
+// between. The deadline of ctx bounds all attempts together. The timings are
+// those of the last attempt, attempts and total_with_retries_duration are
+// added when retries are enabled.
+func measureWithRetries(ctx context.Context, transports *transportCache) measurement {
+	start := time.Now()
+	m := measure(ctx, transports)
+	attempts := 1
+	for ; attempts <= plugin.Retries && (m.retryable || m.httpStatus >= 500); attempts++ {
+		timer := time.NewTimer(time.Duration(plugin.RetryDelay) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return withAttempts(m, attempts, time.Since(start))
+		case <-timer.C:
+		}
+		m = measure(ctx, transports)
+	}
+	return withAttempts(m, attempts, time.Since(start))
+}
+
+// withAttempts notes the number of attempts in a measurement when retries
+// are enabled.
+func withAttempts(m measurement, attempts int, elapsed time.Duration) measurement {
+	if plugin.Retries == 0 {
+		return m
+	}
+	if len(m.err) > 0 {
+		if attempts > 1 {
+			m.err += fmt.Sprintf(" (after %d attempts)", attempts)
+		}
+		return m
+	}
+	m.metrics = append(m.metrics,
+		valueMetric("attempts", float64(attempts), ""),
+		durationMetric("total_with_retries_duration", elapsed, 0, 0),
+	)
+	return m
+}
+
+// evaluate applies the latency and phase thresholds to the timings of a
+// measurement, breaches are added to its details.
+func evaluate(m measurement) measurement {
+	if len(m.err) > 0 {
+		return m
+	}
+	status, _ := evaluateStatus(m.elapsed, plugin)
+	m.raise(status, "")
+	if plugin.Verbose {
+		m.details += fmt.Sprintf(" warning=%gs critical=%gs", plugin.Warning, plugin.Critical)
+	}
+	// The request gives up at --timeout, a threshold at or above it can
+	// never fire.
+	if timeout := time.Duration(plugin.Timeout) * time.Second; plugin.Critical > 0 && secondsToDuration(plugin.Critical) >= timeout {
+		m.details += fmt.Sprintf(" critical threshold %gs is not below --timeout %ds", plugin.Critical, plugin.Timeout)
+	} else if plugin.Critical == 0 && plugin.Warning > 0 && secondsToDuration(plugin.Warning) >= timeout {
+		m.details += fmt.Sprintf(" warning threshold %gs is not below --timeout %ds", plugin.Warning, plugin.Timeout)
+	}
+	phaseStatus, breaches := checkPhases(m.phases)
+	m.raise(phaseStatus, "")
+	if len(breaches) > 0 {
+		m.details += " " + strings.Join(breaches, ", ")
+	}
+	return m
+}
+
+// evaluateStatus compares the elapsed time of a request against the
+// --warning and --critical thresholds of cfg, returning the status and the
+// exit code it maps to. A threshold of 0 is disabled, and a warning equal to
+// the critical threshold is skipped. Reaching --timeout is critical whatever
+// the thresholds, which may be set at or above it.
+func evaluateStatus(elapsed time.Duration, cfg Config) (string, int) {
+	status := "OK"
+	switch {
+	case cfg.Timeout > 0 && elapsed >= time.Duration(cfg.Timeout)*time.Second:
+		status = "CRITICAL"
+	case cfg.Critical > 0 && elapsed > secondsToDuration(cfg.Critical):
+		status = "CRITICAL"
+	case cfg.Warning > 0 && cfg.Warning != cfg.Critical && elapsed > secondsToDuration(cfg.Warning):
+		status = "WARNING"
+	}
+	return status, checkState(status)
+}
+
+// outputFormat returns how durations are rendered, as set with --output-unit
+// and --precision.
+func outputFormat() durationFormat {
+	return durationFormat{unit: plugin.OutputUnit, precision: plugin.Precision}
+}
+
+// checkState maps a status to the exit code of the check.
+func checkState(status string) int {
+	switch status {
+	case "CRITICAL":
+		return sensu.CheckStateCritical
+	case "WARNING":
+		return sensu.CheckStateWarning
+	case "UNKNOWN":
+		return sensu.CheckStateUnknown
+	}
+	return sensu.CheckStateOK
+}
+
+func executeCheck(event *corev2.Event) (int, error) {
+	// The agent sends SIGTERM when the check exceeds its own timeout, which
+	// cancels the run so that the timings collected so far are printed.
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	start := time.Now()
+
+	// The command minting the credentials has a timeout of its own.
+	if err := runAuthCommand(signalCtx); err != nil {
+		m := configFailure(err.Error())
+		m.metrics = append(m.metrics, upMetric(m))
+		printOutput(render(m))
+		return checkState(m.status), nil
+	}
+
+	// The timeout covers the whole redirect chain, every retry and sample.
+	ctx, cancel := context.WithTimeout(signalCtx, time.Duration(plugin.Timeout)*time.Second)
+	defer cancel()
+
+	if plugin.AllIps {
+		return checkAllIPs(ctx)
+	}
+	if plugin.DualStackCompare {
+		return checkDualStack(ctx)
+	}
+	if len(checkedURLs) > 0 {
+		return checkURLs(ctx)
+	}
+
+	m := measureSamples(ctx, resolveOverrides)
+	if len(m.err) > 0 && signalCtx.Err() != nil {
+		m.status, m.reason = "CRITICAL", reasonCancelled
+		m.err = "check cancelled after " + formatHeadline(time.Since(start), outputFormat())
+	}
+	if len(m.err) == 0 && tracksRegression() {
+		m = checkRegression(m, statePath())
+	}
+	m.metrics = append(m.metrics, upMetric(m))
+	printOutput(render(m))
+	return checkState(m.status), nil
 }
@@ -1,8 +1,2517 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func TestMain(t *testing.T) {
 }
+
+// parseArgs resets the plugin configuration to its defaults and applies args
+// as command line flags, without validating them. checkArgs reads no event
+// from stdin.
+func parseArgs(t *testing.T, args ...string) {
+	t.Helper()
+	urlArgument = ""
+	cmd := &cobra.Command{}
+	for _, opt := range options {
+		if err := opt.SetupFlag(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setup parses args and validates them with checkArgs.
+func setup(t *testing.T, args ...string) {
+	t.Helper()
+	parseArgs(t, args...)
+	if status, err := checkArgs(nil); err != nil {
+		t.Fatalf("checkArgs: %d %v", status, err)
+	}
+}
+
+// captureStdout runs f and returns whatever it printed to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	return string(<-done)
+}
+
+// run executes the check and returns its state and output.
+func run(t *testing.T) (int, string) {
+	t.Helper()
+	var status int
+	out := captureStdout(t, func() {
+		var err error
+		if status, err = executeCheck(nil); err != nil {
+			t.Errorf("executeCheck: %v", err)
+		}
+	})
+	return status, out
+}
+
+func TestCheckArgsURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://exa mple.com/", "invalid --url: "},
+		{"http://example.com/\x7f", "invalid --url: "},
+		{"http://[::1/", "invalid --url: "},
+		{"ftp://example.com/", `unsupported --url scheme "ftp"`},
+		{"example.com/health", `unsupported --url scheme ""`},
+		{"http://", "the host is missing"},
+		{"https:///health", "the host is missing"},
+	}
+	for _, tt := range tests {
+		parseArgs(t, "--url", tt.url)
+		status, err := checkArgs(nil)
+		if status != sensu.CheckStateUnknown || err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: expected UNKNOWN with %q, got %d, %v", tt.url, tt.want, status, err)
+		}
+	}
+
+	// A URL that slips past checkArgs is reported instead of panicking.
+	setup(t, "--url", "http://example.com/")
+	plugin.Url = "http://exa mple.com/"
+	if status, out := run(t); status != sensu.CheckStateUnknown || !strings.Contains(out, "UNKNOWN: invalid --url: ") {
+		t.Errorf("expected UNKNOWN for an invalid URL, got %d: %s", status, out)
+	}
+}
+
+func TestCheckArgsMethod(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+		status int
+	}{
+		{"GET", "GET", sensu.CheckStateOK},
+		{"head", "HEAD", sensu.CheckStateOK},
+		{" post ", "POST", sensu.CheckStateOK},
+		{"OPTIONS", "OPTIONS", sensu.CheckStateOK},
+		{"GETT", "GETT", sensu.CheckStateUnknown},
+		{"", "", sensu.CheckStateUnknown},
+	}
+	for _, tt := range tests {
+		parseArgs(t, "--method", tt.method)
+		status, err := checkArgs(nil)
+		if status != tt.status {
+			t.Errorf("method %q: expected status %d, got %d (%v)", tt.method, tt.status, status, err)
+		}
+		if (err != nil) != (tt.status != sensu.CheckStateOK) {
+			t.Errorf("method %q: unexpected error %v", tt.method, err)
+		}
+		if plugin.Method != tt.want {
+			t.Errorf("method %q: expected normalized %q, got %q", tt.method, tt.want, plugin.Method)
+		}
+	}
+}
+
+func TestExecuteCheckMethod(t *testing.T) {
+	for _, method := range allowedMethods {
+		var got string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Method
+			// Advertise a body a HEAD response must never carry.
+			w.Header().Set("Content-Length", "1024")
+			w.WriteHeader(http.StatusOK)
+		}))
+		setup(t, "--url", ts.URL, "--method", method)
+		status, _ := run(t)
+		ts.Close()
+		if got != method {
+			t.Errorf("expected server to see %s, got %s", method, got)
+		}
+		if method == http.MethodHead && status != sensu.CheckStateOK {
+			t.Errorf("HEAD: expected OK, got %d", status)
+		}
+	}
+}
+
+func TestCheckArgsRequestBody(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.json")
+	payload := filepath.Join(dir, "payload.json")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(payload, []byte(`{"ping":true}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		file   string
+		status int
+		want   string
+	}{
+		{"none", "", "", sensu.CheckStateOK, ""},
+		{"inline", `{"a":1}`, "", sensu.CheckStateOK, `{"a":1}`},
+		{"file", "", payload, sensu.CheckStateOK, `{"ping":true}`},
+		{"both", `{"a":1}`, payload, sensu.CheckStateUnknown, ""},
+		{"empty file", "", empty, sensu.CheckStateUnknown, ""},
+		{"missing file", "", filepath.Join(dir, "nope.json"), sensu.CheckStateUnknown, ""},
+	}
+	for _, tt := range tests {
+		parseArgs(t, "--method", "POST", "--request-body", tt.body, "--body-file", tt.file)
+		status, err := checkArgs(nil)
+		if status != tt.status {
+			t.Errorf("%s: expected status %d, got %d (%v)", tt.name, tt.status, status, err)
+			continue
+		}
+		if status == sensu.CheckStateOK && string(requestBody) != tt.want {
+			t.Errorf("%s: expected body %q, got %q", tt.name, tt.want, requestBody)
+		}
+	}
+}
+
+func TestExecuteCheckRequestBody(t *testing.T) {
+	var gotBody, gotType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		gotType = r.Header.Get("Content-Type")
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--method", "POST", "--request-body", `{"ping":true}`)
+	_, out := run(t)
+	if gotBody != `{"ping":true}` {
+		t.Errorf("unexpected body %q", gotBody)
+	}
+	if gotType != "application/json" {
+		t.Errorf("expected default content type application/json, got %q", gotType)
+	}
+	if !strings.Contains(out, "request_body_bytes=13") {
+		t.Errorf("expected request_body_bytes in %q", out)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := parseHeaders("--header", []string{"X-Api-Key: secret", "x-tenant:acme", "X-Tenant: other", "Accept: a, b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := headers.Values("X-Tenant"); !reflect.DeepEqual(got, []string{"acme", "other"}) {
+		t.Errorf("expected duplicate headers to append, got %q", got)
+	}
+	if got := headers.Get("Accept"); got != "a, b" {
+		t.Errorf("unexpected Accept %q", got)
+	}
+	for _, bad := range []string{"NoColon", ": value", "Bad Name: value"} {
+		if _, err := parseHeaders("--header", []string{bad}); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if _, err := parseHeaders("--expect-header", []string{"NoColon"}); err == nil || !strings.Contains(err.Error(), "invalid --expect-header") {
+		t.Errorf("expected the error to name --expect-header, got %v", err)
+	}
+}
+
+func TestExecuteCheckHeaders(t *testing.T) {
+	var got http.Header
+	var host string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		host = r.Host
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL,
+		"-H", "X-Tenant: a", "-H", "X-Tenant: b",
+		"-H", "Host: vhost.example", "-H", "User-Agent: custom")
+	run(t)
+	if v := got.Values("X-Tenant"); !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Errorf("unexpected X-Tenant %q", v)
+	}
+	if v := got.Values("User-Agent"); !reflect.DeepEqual(v, []string{"custom"}) {
+		t.Errorf("unexpected User-Agent %q", v)
+	}
+	if host != "vhost.example" {
+		t.Errorf("unexpected Host %q", host)
+	}
+}
+
+func TestCheckArgsBasicAuth(t *testing.T) {
+	tests := []struct {
+		user, env        string
+		status           int
+		wantUser, wantPw string
+	}{
+		{"", "", sensu.CheckStateOK, "", ""},
+		{"alice:s3cret", "", sensu.CheckStateOK, "alice", "s3cret"},
+		{"alice:pa:ss", "", sensu.CheckStateOK, "alice", "pa:ss"},
+		{"alice", "fromenv", sensu.CheckStateOK, "alice", "fromenv"},
+		{"alice", "", sensu.CheckStateUnknown, "", ""},
+		{":s3cret", "", sensu.CheckStateUnknown, "", ""},
+	}
+	for _, tt := range tests {
+		t.Setenv("CHECK_PASSWORD", tt.env)
+		parseArgs(t, "--user", tt.user)
+		status, err := checkArgs(nil)
+		if status != tt.status {
+			t.Errorf("user %q: expected status %d, got %d (%v)", tt.user, tt.status, status, err)
+			continue
+		}
+		if err != nil && strings.Contains(err.Error(), "s3cret") {
+			t.Errorf("user %q: password leaked in %q", tt.user, err)
+		}
+		if status == sensu.CheckStateOK && (basicAuthUser != tt.wantUser || basicAuthPassword != tt.wantPw) {
+			t.Errorf("user %q: got %q/%q", tt.user, basicAuthUser, basicAuthPassword)
+		}
+	}
+}
+
+func TestExecuteCheckBasicAuth(t *testing.T) {
+	var authed bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pw, ok := r.BasicAuth()
+		authed = ok && user == "alice" && pw == "s3cret"
+	}))
+	setup(t, "--url", ts.URL, "--user", "alice:s3cret")
+	_, out := run(t)
+	ts.Close()
+	if !authed {
+		t.Error("expected the server to receive basic auth credentials")
+	}
+	if strings.Contains(out, "s3cret") {
+		t.Errorf("password leaked in output %q", out)
+	}
+
+	// The server is gone, so the request fails and prints an error.
+	_, out = run(t)
+	if !strings.Contains(out, "CRITICAL: ") || strings.Contains(out, "s3cret") {
+		t.Errorf("unexpected failure output %q", out)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	parseArgs(t, "--bearer-token", "tok", "--bearer-token-file", "/tmp/tok")
+	if status, err := checkArgs(nil); status != sensu.CheckStateUnknown || err == nil {
+		t.Errorf("expected both bearer options to be rejected, got %d %v", status, err)
+	}
+
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	file := filepath.Join(t.TempDir(), "token")
+	setup(t, "--url", ts.URL, "--bearer-token-file", file)
+	for _, token := range []string{"first", "rotated"} {
+		if err := os.WriteFile(file, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		run(t)
+		if got != "Bearer "+token {
+			t.Errorf("expected %q, got %q", "Bearer "+token, got)
+		}
+	}
+
+	os.Remove(file)
+	status, out := run(t)
+	if status != sensu.CheckStateUnknown || !strings.Contains(out, "bearer-token-file") {
+		t.Errorf("expected UNKNOWN for a missing token file, got %d %q", status, out)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	got := redact(`Get "http://x/?token=abc": abc failed`, "abc", "")
+	if want := `Get "http://x/?token=REDACTED": REDACTED failed`; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestExecuteCheckUserAgent(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.UserAgent()
+	}))
+	defer ts.Close()
+
+	for _, ua := range []string{"", "custom-agent/1.0"} {
+		args := []string{"--url", ts.URL}
+		if len(ua) > 0 {
+			args = append(args, "--user-agent", ua)
+		} else {
+			ua = defaultUserAgent()
+		}
+		setup(t, args...)
+		run(t)
+		if got != ua {
+			t.Errorf("expected User-Agent %q, got %q", ua, got)
+		}
+	}
+	if want := "sensu-http-perf-go/dev"; defaultUserAgent() != want {
+		t.Errorf("expected default User-Agent %q, got %q", want, defaultUserAgent())
+	}
+}
+
+func TestExecuteCheckHostHeader(t *testing.T) {
+	var host, sni string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		sni = r.TLS.ServerName
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--insecure-skip-verify", "--host-header", "example.com:8443")
+	_, out := run(t)
+	if host != "example.com:8443" {
+		t.Errorf("expected Host example.com:8443, got %q", host)
+	}
+	if sni != "example.com" {
+		t.Errorf("expected SNI example.com, got %q", sni)
+	}
+	if !strings.Contains(out, "Z) host=example.com:8443 ") {
+		t.Errorf("expected output to mention the effective host, got %q", out)
+	}
+}
+
+func TestExecuteCheckSNI(t *testing.T) {
+	var host, sni string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		sni = r.TLS.ServerName
+	}))
+	defer ts.Close()
+	// The httptest certificate is valid for example.com.
+	ca := writeCertPEM(t, t.TempDir(), ts.Certificate())
+
+	setup(t, "--url", ts.URL, "--ca-file", ca, "--sni", "example.com", "--host-header", "www.example.org")
+	status, out := run(t)
+	if status != sensu.CheckStateOK || !strings.Contains(out, " sni=example.com ") {
+		t.Errorf("expected the SNI to be verified and shown, got %d: %s", status, out)
+	}
+	if sni != "example.com" || host != "www.example.org" {
+		t.Errorf("expected SNI example.com and Host www.example.org, got %q and %q", sni, host)
+	}
+
+	setup(t, "--url", ts.URL, "--ca-file", ca, "--sni", "other.example.net")
+	if status, out := run(t); status != sensu.CheckStateCritical {
+		t.Errorf("expected verification against the SNI name to fail, got %d: %s", status, out)
+	}
+
+	setup(t, "--url", ts.URL, "--insecure-skip-verify", "--sni", "other.example.net")
+	if status, out := run(t); status != sensu.CheckStateOK || sni != "other.example.net" {
+		t.Errorf("expected --insecure-skip-verify to accept any SNI, got %d (%q): %s", status, sni, out)
+	}
+}
+
+func TestParseStatusRanges(t *testing.T) {
+	ranges, err := parseStatusRanges("200, 201,301-302")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code, want := range map[int]bool{200: true, 201: true, 202: false, 301: true, 302: true, 303: false, 503: false} {
+		if got := statusExpected(code, ranges); got != want {
+			t.Errorf("code %d: expected %v, got %v", code, want, got)
+		}
+	}
+	for _, bad := range []string{"abc", "200-", "302-301", "99", "600", "200-x"} {
+		if _, err := parseStatusRanges(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestExecuteCheckExpectStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--expect-status", "200-399")
+	status, out := run(t)
+	if status != sensu.CheckStateCritical {
+		t.Errorf("expected CRITICAL, got %d", status)
+	}
+	if !strings.Contains(out, "CRITICAL: GET "+ts.URL+" -> 503 Service Unavailable in ") || !strings.Contains(out, "(expected 200-399)") {
+		t.Errorf("expected the received code in the output, got %q", out)
+	}
+	if !strings.Contains(out, "http_status=503") || !strings.Contains(out, "total_request_duration=") {
+		t.Errorf("expected perfdata on status mismatch, got %q", out)
+	}
+}
+
+func TestExecuteCheckDefaultStatus(t *testing.T) {
+	tests := []struct {
+		code     int
+		args     []string
+		status   int
+		headline string
+	}{
+		{200, nil, sensu.CheckStateOK, "OK: GET %s -> 200 OK in "},
+		{302, nil, sensu.CheckStateOK, "OK: GET %s -> 302 Found in "},
+		{404, nil, sensu.CheckStateWarning, "WARNING: GET %s -> 404 Not Found in "},
+		{502, nil, sensu.CheckStateCritical, "CRITICAL: GET %s -> 502 Bad Gateway in "},
+		{503, []string{"--status-ok-anything"}, sensu.CheckStateOK, "OK: GET %s -> 503 Service Unavailable in "},
+	}
+	for _, tt := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.code)
+		}))
+		setup(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		status, out := run(t)
+		ts.Close()
+		if status != tt.status {
+			t.Errorf("code %d: expected state %d, got %d", tt.code, tt.status, status)
+		}
+		if headline := fmt.Sprintf(tt.headline, ts.URL); !strings.Contains(out, headline) {
+			t.Errorf("code %d: expected %q in %q", tt.code, headline, out)
+		}
+	}
+}
+
+func TestExecuteCheckExpectBodyContains(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>\x00<title>Welcome</title>"))
+		w.Write([]byte(strings.Repeat("x", 500)))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--expect-body-contains", "Welcome"}, sensu.CheckStateOK, "content_transfer_duration="},
+		{[]string{"--expect-body-contains", "\x00<title>"}, sensu.CheckStateOK, "content_transfer_duration="},
+		{[]string{"--expect-body-contains", "Goodbye"}, sensu.CheckStateCritical, `body does not contain "Goodbye", got "<html>\x00<title>Welcome</title>xxx`},
+		{[]string{"--expect-body-contains", "Welcome", "--max-body-bytes", "20"}, sensu.CheckStateCritical, "CRITICAL: Response body larger than --max-body-bytes 20"},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected %q in %q", tt.args, tt.want, out)
+		}
+	}
+	setup(t, "--url", ts.URL, "--expect-body-contains", "Goodbye")
+	if _, out := run(t); strings.Count(out, "x") > 200 {
+		t.Errorf("expected at most 200 bytes of body in the output, got %q", out)
+	}
+}
+
+func TestExecuteCheckExpectBodyRegex(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "yellow"}`))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--expect-body-regex", `"status":\s*"(green|yellow)"`}, sensu.CheckStateOK, "matched=true"},
+		{[]string{"--expect-body-regex", `"status":\s*"green"`}, sensu.CheckStateCritical, "matched=false"},
+		{[]string{"--expect-body-regex", `maintenance mode`, "--invert-regex"}, sensu.CheckStateOK, "matched=false"},
+		{[]string{"--expect-body-regex", `yellow`, "--invert-regex"}, sensu.CheckStateCritical, "matched=true"},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected %q in %q", tt.args, tt.want, out)
+		}
+	}
+
+	parseArgs(t, "--expect-body-regex", "(unclosed")
+	if status, err := checkArgs(nil); status != sensu.CheckStateUnknown || err == nil {
+		t.Errorf("expected an invalid regex to be UNKNOWN, got %d %v", status, err)
+	}
+}
+
+func TestExecuteCheckJSON(t *testing.T) {
+	body := `{"status":"ok","data":{"queue_depth":42}}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--json-path", "status", "--json-expect", "ok"}, sensu.CheckStateOK, "OK: GET " + ts.URL + " -> 200 OK"},
+		{[]string{"--json-path", "status", "--json-expect", "degraded"}, sensu.CheckStateCritical, `status="ok" (expected "degraded")`},
+		{[]string{"--json-path", "data.queue_depth"}, sensu.CheckStateOK, "data_queue_depth=42"},
+		{[]string{"--json-path", "data.queue_depth", "--json-warning", "40", "--json-critical", "50"}, sensu.CheckStateWarning, "data.queue_depth=42 > 40"},
+		{[]string{"--json-path", "data.queue_depth", "--json-critical", "10"}, sensu.CheckStateCritical, "data.queue_depth=42 > 10"},
+		{[]string{"--json-path", "data.missing"}, sensu.CheckStateUnknown, `UNKNOWN: JSON path "data.missing" not found reason=json_error`},
+		{[]string{"--json-path", "status", "--json-warning", "1"}, sensu.CheckStateUnknown, "is not numeric"},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected %q in %q", tt.args, tt.want, out)
+		}
+	}
+
+	body = "not json"
+	setup(t, "--url", ts.URL, "--json-path", "status")
+	if status, out := run(t); status != sensu.CheckStateUnknown || !strings.Contains(out, "not valid JSON") || !strings.Contains(out, " reason=json_error") {
+		t.Errorf("expected UNKNOWN for an invalid body, got %d %q", status, out)
+	}
+
+	for _, args := range [][]string{
+		{"--json-expect", "ok"},
+		{"--json-path", "a", "--json-warning", "x"},
+		{"--json-path", "a", "--json-warning", "5", "--json-critical", "1"},
+	} {
+		parseArgs(t, args...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected a validation error", args)
+		}
+	}
+}
+
+func TestExecuteCheckExpectHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-Cache", "HIT from edge-1")
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--expect-header", "x-cache: HIT", "--expect-header", "CONTENT-TYPE: application/json"}, sensu.CheckStateOK, "OK: GET " + ts.URL + " -> 200 OK"},
+		{[]string{"--expect-header", "X-Cache:"}, sensu.CheckStateOK, "OK: GET " + ts.URL + " -> 200 OK"},
+		{[]string{"--expect-header", "X-Cache: MISS"}, sensu.CheckStateCritical, `header X-Cache="HIT from edge-1" does not contain "MISS"`},
+		{[]string{"--expect-header", "Strict-Transport-Security: max-age"}, sensu.CheckStateCritical, "header Strict-Transport-Security missing"},
+		{[]string{"--expect-header-regex", `x-cache: ^HIT from edge-\d+$`}, sensu.CheckStateOK, "OK: GET " + ts.URL + " -> 200 OK"},
+		{[]string{"--expect-header-regex", `X-Cache: ^MISS`}, sensu.CheckStateCritical, `header X-Cache="HIT from edge-1" does not match "^MISS"`},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected %q in %q", tt.args, tt.want, out)
+		}
+	}
+
+	parseArgs(t, "--expect-header-regex", "X-Cache: (")
+	if status, err := checkArgs(nil); status != sensu.CheckStateUnknown || err == nil {
+		t.Errorf("expected an invalid regex to be UNKNOWN, got %d %v", status, err)
+	}
+}
+
+func TestCheckPhases(t *testing.T) {
+	base := time.Now()
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+	tests := []struct {
+		name     string
+		phases   []phase
+		status   string
+		breaches []string
+	}{
+		{"no thresholds", []phase{{"dns_duration", at(0), at(900), 0, 0}}, "OK", nil},
+		{"below", []phase{{"dns_duration", at(0), at(100), 0.5, 1}}, "OK", nil},
+		{"warning", []phase{{"tls_handshake_duration", at(0), at(900), 0.5, 1}}, "WARNING", []string{"tls_handshake_duration 0.900s > 0.5s"}},
+		{"critical", []phase{{"connect_duration", at(0), at(1500), 0.5, 1}}, "CRITICAL", []string{"connect_duration 1.500s > 1s"}},
+		{"skipped", []phase{{"tls_handshake_duration", time.Time{}, time.Time{}, 0.5, 1}}, "OK", nil},
+		{"worst wins", []phase{
+			{"dns_duration", at(0), at(1500), 0.5, 1},
+			{"first_byte_duration", at(0), at(600), 0.5, 1},
+		}, "CRITICAL", []string{"dns_duration 1.500s > 1s", "first_byte_duration 0.600s > 0.5s"}},
+	}
+	for _, tt := range tests {
+		status, breaches := checkPhases(tt.phases)
+		if status != tt.status || !reflect.DeepEqual(breaches, tt.breaches) {
+			t.Errorf("%s: expected %s %q, got %s %q", tt.name, tt.status, tt.breaches, status, breaches)
+		}
+	}
+}
+
+func TestEvaluateStatus(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		elapsed           time.Duration
+		warning, critical float32
+		status            string
+		state             int
+	}{
+		{900 * ms, 1, 2, "OK", sensu.CheckStateOK},
+		{1000 * ms, 1, 2, "OK", sensu.CheckStateOK},
+		{1001 * ms, 1, 2, "WARNING", sensu.CheckStateWarning},
+		{2000 * ms, 1, 2, "WARNING", sensu.CheckStateWarning},
+		{2001 * ms, 1, 2, "CRITICAL", sensu.CheckStateCritical},
+		// Fractional thresholds are not truncated to whole seconds.
+		{600 * ms, 0.5, 1.5, "WARNING", sensu.CheckStateWarning},
+		{1400 * ms, 0.5, 1.5, "WARNING", sensu.CheckStateWarning},
+		{1600 * ms, 0.5, 1.5, "CRITICAL", sensu.CheckStateCritical},
+		// 0 disables a threshold.
+		{5000 * ms, 0, 0, "OK", sensu.CheckStateOK},
+		{0, 0, 0, "OK", sensu.CheckStateOK},
+		{1000 * ms, 0, 1, "OK", sensu.CheckStateOK},
+		{1001 * ms, 0, 1, "CRITICAL", sensu.CheckStateCritical},
+		{1000 * ms, 1, 0, "OK", sensu.CheckStateOK},
+		{1001 * ms, 1, 0, "WARNING", sensu.CheckStateWarning},
+		{9000 * ms, 1, 0, "WARNING", sensu.CheckStateWarning},
+		// An equal warning is skipped.
+		{1000 * ms, 1, 1, "OK", sensu.CheckStateOK},
+		{1001 * ms, 1, 1, "CRITICAL", sensu.CheckStateCritical},
+		// Reaching the 10s --timeout is critical, thresholds above it
+		// never fire.
+		{9999 * ms, 15, 20, "OK", sensu.CheckStateOK},
+		{10000 * ms, 15, 20, "CRITICAL", sensu.CheckStateCritical},
+		{10000 * ms, 0, 0, "CRITICAL", sensu.CheckStateCritical},
+		{10000 * ms, 5, 0, "CRITICAL", sensu.CheckStateCritical},
+	}
+	for _, tt := range tests {
+		status, state := evaluateStatus(tt.elapsed, Config{Warning: tt.warning, Critical: tt.critical, Timeout: 10})
+		if status != tt.status || state != tt.state {
+			t.Errorf("%s with %g/%g: expected %s (%d), got %s (%d)", tt.elapsed, tt.warning, tt.critical, tt.status, tt.state, status, state)
+		}
+	}
+}
+
+func TestExecuteCheckThresholdUnit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   []string
+	}{
+		// 100 would be seconds by default.
+		{[]string{"-w", "100", "-c", "1000"}, sensu.CheckStateOK, []string{";100;1000;0 "}},
+		{[]string{"-w", "100", "-c", "1000", "--threshold-unit", "ms", "--verbose"}, sensu.CheckStateWarning, []string{" warning=0.1s critical=1s ", ";0.1;1;0 "}},
+		{[]string{"-w", "100", "-c", "120", "--threshold-unit", "ms", "--output-unit", "ms"}, sensu.CheckStateCritical, []string{";100;120;0 "}},
+		{[]string{"-w", "1", "-c", "20", "--timeout", "15"}, sensu.CheckStateOK, []string{" critical threshold 20s is not below --timeout 15s "}},
+		{[]string{"-w", "20", "-c", "0", "--timeout", "15"}, sensu.CheckStateOK, []string{" warning threshold 20s is not below --timeout 15s "}},
+		// 0 disables a threshold, the perfdata leaves it out.
+		{[]string{"-w", "0", "-c", "0"}, sensu.CheckStateOK, []string{" total_request_duration=", "s;;;0 "}},
+		{[]string{"-w", "0", "-c", "100", "--threshold-unit", "ms"}, sensu.CheckStateCritical, []string{"s;;0.1;0 "}},
+		{[]string{"-w", "100", "-c", "0", "--threshold-unit", "ms"}, sensu.CheckStateWarning, []string{"s;0.1;;0 "}},
+		// An equal warning is skipped.
+		{[]string{"-w", "100", "-c", "100", "--threshold-unit", "ms"}, sensu.CheckStateCritical, []string{"s;0.1;0.1;0 "}},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %q", tt.args, want, out)
+			}
+		}
+	}
+
+	parseArgs(t, "--threshold-unit", "us")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected --threshold-unit us to be rejected")
+	}
+	for _, args := range [][]string{{"-w", "-1"}, {"-c", "-0.5"}, {"-w", "3", "-c", "2"}} {
+		parseArgs(t, append([]string{"--url", ts.URL}, args...)...)
+		if state, err := checkArgs(nil); err == nil || state != sensu.CheckStateUnknown {
+			t.Errorf("%q: expected UNKNOWN with an error, got %d: %v", args, state, err)
+		}
+	}
+}
+
+func TestExecuteCheckPhaseThresholds(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	// An IP literal URL has no DNS phase, so a tiny DNS threshold never fires.
+	setup(t, "--url", ts.URL, "--ttfb-warning", "0.1", "--dns-critical", "0.000001")
+	status, out := run(t)
+	if status != sensu.CheckStateWarning || !strings.Contains(out, "first_byte_duration 0.1") || !strings.Contains(out, "s > 0.1s") {
+		t.Errorf("expected a TTFB warning, got %d %q", status, out)
+	}
+
+	parseArgs(t, "--tls-warning", "2", "--tls-critical", "1")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected --tls-warning above --tls-critical to be rejected")
+	}
+}
+
+// perfValue returns the value in seconds of the label perfdata in out.
+func perfValue(t *testing.T, out, label string) float64 {
+	t.Helper()
+	m := regexp.MustCompile(` ` + regexp.QuoteMeta(label) + `=([0-9.]+)s`).FindStringSubmatch(out)
+	if m == nil {
+		t.Fatalf("expected %s in %q", label, out)
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestExecuteCheckServerProcessing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL)
+	status, out := run(t)
+	if status != sensu.CheckStateOK {
+		t.Fatalf("expected OK, got %d: %s", status, out)
+	}
+	processing := perfValue(t, out, "server_processing_duration")
+	write := perfValue(t, out, "request_write_duration")
+	firstByte := perfValue(t, out, "first_byte_duration")
+	connect := perfValue(t, out, "connect_duration")
+	if processing < 0.2 || processing > 1 {
+		t.Errorf("expected the 200ms sleep as server processing, got %gs", processing)
+	}
+	if write > 0.1 {
+		t.Errorf("expected a quick request write, got %gs", write)
+	}
+	// The time to first byte includes the connect, write and processing.
+	if firstByte < processing+write || firstByte < connect+processing {
+		t.Errorf("expected first_byte_duration %gs to cover the whole request", firstByte)
+	}
+	if network := perfValue(t, out, "network_setup_duration"); network < connect || network+write+processing > firstByte+0.001 {
+		t.Errorf("expected network_setup_duration %gs to cover the connect and add up to first_byte_duration", network)
+	}
+
+	setup(t, "--url", ts.URL, "--server-warning", "0.05", "--server-critical", "0.1")
+	status, out = run(t)
+	if status != sensu.CheckStateCritical || !strings.Contains(out, "server_processing_duration=") || !strings.Contains(out, ";0.05;0.1;0") {
+		t.Errorf("expected the server processing thresholds to apply, got %d: %s", status, out)
+	}
+}
+
+// perfLabels returns the labels of the perfdata in out, in order.
+func perfLabels(out string) []string {
+	var labels []string
+	if i := strings.Index(out, " | "); i >= 0 {
+		for _, field := range strings.Fields(out[i+3:]) {
+			labels = append(labels, strings.SplitN(field, "=", 2)[0])
+		}
+	}
+	return labels
+}
+
+func TestExecuteCheckOccurredPhases(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secure.Close()
+	_, port, _ := net.SplitHostPort(secure.Listener.Addr().String())
+	dns, _ := startDNSServer(t)
+
+	timings := func(names ...string) []string {
+		return append(names, "first_byte_duration", "network_setup_duration", "request_write_duration", "server_processing_duration", "total_request_duration")
+	}
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"http IP literal", []string{"--url", plain.URL}, timings("connect_duration")},
+		{"https IP literal", []string{"--url", secure.URL, "--insecure-skip-verify"}, timings("tls_handshake_duration", "connect_duration")},
+		{"https name", []string{"--url", "https://check.test:" + port + "/", "--dns-server", dns, "--insecure-skip-verify"}, timings("dns_duration", "tls_handshake_duration", "connect_duration")},
+	}
+	for _, tt := range tests {
+		setup(t, tt.args...)
+		status, out := run(t)
+		if status != sensu.CheckStateOK {
+			t.Errorf("%s: expected OK, got %d: %s", tt.name, status, out)
+			continue
+		}
+		var got []string
+		for _, label := range perfLabels(out) {
+			if strings.HasSuffix(label, "_duration") {
+				got = append(got, label)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	// A reused connection has no lookup, connect or handshake.
+	setup(t, "--url", plain.URL)
+	transports := newTransportCache(nil)
+	defer transports.close()
+	measure(context.Background(), transports)
+	m := measure(context.Background(), transports)
+	if !m.reused {
+		t.Fatal("expected the second request to reuse the connection")
+	}
+	for _, p := range m.metrics {
+		if p.label == "dns_duration" || p.label == "connect_duration" || p.label == "tls_handshake_duration" {
+			t.Errorf("unexpected %s for a reused connection", p.label)
+		}
+		if p.label == "network_setup_duration" && (p.value < 0 || p.value > 0.05) {
+			t.Errorf("expected about no network setup for a reused connection, got %gs", p.value)
+		}
+	}
+}
+
+func TestExecuteCheckJSONOutput(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--insecure-skip-verify", "--output-format", "json")
+	status, out := run(t)
+	if status != sensu.CheckStateWarning {
+		t.Errorf("expected WARNING, got %d", status)
+	}
+	var result CheckResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out, err)
+	}
+	if result.Status != "WARNING" || result.URL != ts.URL || result.HTTPStatus != 404 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result.RemoteAddr != ts.Listener.Addr().String() {
+		t.Errorf("expected remote address %s, got %s", ts.Listener.Addr(), result.RemoteAddr)
+	}
+	if result.TLS == nil || result.TLS.Version == "" || result.TLS.CipherSuite == "" {
+		t.Errorf("expected TLS details, got %+v", result.TLS)
+	}
+	total, ok := result.Timings["total_request_duration"]
+	if !ok || total.Seconds <= 0 || math.Abs(total.Milliseconds-total.Seconds*1000) > 1e-9 {
+		t.Errorf("unexpected total timing %+v", total)
+	}
+	for _, name := range []string{"connect_duration", "tls_handshake_duration", "first_byte_duration"} {
+		if _, ok := result.Timings[name]; !ok {
+			t.Errorf("missing timing %s", name)
+		}
+	}
+	// An IP literal has no DNS lookup to time.
+	if _, ok := result.Timings["dns_duration"]; ok {
+		t.Errorf("unexpected dns_duration timing for an IP literal")
+	}
+	if result.Metrics["http_status"] != 404 {
+		t.Errorf("unexpected metrics %v", result.Metrics)
+	}
+
+	ts.Close()
+	status, out = run(t)
+	if err := json.Unmarshal([]byte(out), &result); err != nil || status != sensu.CheckStateCritical || result.Error == "" {
+		t.Errorf("expected a JSON error result, got %d %q", status, out)
+	}
+}
+
+func TestExecuteCheckOutputUnit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	tests := []struct {
+		args []string
+		want *regexp.Regexp
+	}{
+		{nil, regexp.MustCompile(` in \d+\.\d{6}s .* total_request_duration=\d+\.\d{6}s;1;2;0 `)},
+		{[]string{"--output-unit", "us"}, regexp.MustCompile(` in \d+us .* total_request_duration=\d+us;1000000;2000000;0 `)},
+		{[]string{"--output-in-ms"}, regexp.MustCompile(` in \d+\.\d{6}ms .* total_request_duration=\d+\.\d{2}ms;1000;2000;0 `)},
+		{[]string{"--output-unit", "ms", "--precision", "1"}, regexp.MustCompile(` in \d+\.\dms .* total_request_duration=\d+\.\dms;1000;2000;0 `)},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		if _, out := run(t); !tt.want.MatchString(out) {
+			t.Errorf("%q: expected %s to match %q", tt.args, tt.want, out)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--output-in-ms", "--output-unit", "us"},
+		{"--output-unit", "ns"},
+		{"--precision", "10"},
+		{"--precision", "-2"},
+	} {
+		parseArgs(t, append([]string{"--url", ts.URL}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
+
+func TestExecuteCheckInfluxDBOutput(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--output-format", "influxdb", "--metric-name", "web", "--metric-tag", "env=prod")
+	_, out := run(t)
+	prefix := "web,env=prod,url=" + ts.URL + " connect_duration="
+	if !strings.HasPrefix(out, prefix) || strings.Count(out, "\n") != 1 {
+		t.Errorf("expected a single line starting with %q, got %q", prefix, out)
+	}
+	fields := strings.Fields(out)
+	if ns, err := strconv.ParseInt(fields[len(fields)-1], 10, 64); err != nil || time.Since(time.Unix(0, ns)) > time.Minute {
+		t.Errorf("expected a nanosecond timestamp, got %q", fields[len(fields)-1])
+	}
+
+	parseArgs(t, "--metric-tag", "novalue")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected an invalid --metric-tag to be rejected")
+	}
+}
+
+func TestExecuteCheckPrometheusOutput(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	setup(t, "--url", ts.URL, "--output-format", "prometheus")
+	_, out := run(t)
+	up := `http_perf_up{url="` + ts.URL + `"} `
+	if !strings.Contains(out, up+"1\n") || !strings.Contains(out, "# TYPE http_perf_total_request_duration_seconds gauge\n") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	ts.Close()
+	status, out := run(t)
+	if status != sensu.CheckStateCritical || !strings.Contains(out, up+"0\n") || strings.Count(out, "http_perf_up{") != 1 {
+		t.Errorf("expected a single http_perf_up 0 on failure, got %d %q", status, out)
+	}
+}
+
+func TestExecuteCheckRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusMovedPermanently))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusFound))
+	mux.HandleFunc("/c", func(w http.ResponseWriter, r *http.Request) {})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   []string
+	}{
+		{nil, sensu.CheckStateOK, []string{"OK: GET " + ts.URL + "/a -> 301 -> 302 -> 200 OK in ", "final_url=" + ts.URL + "/c ", "redirect_count=2", "hop1_total=", "hop2_total=", "hop3_total="}},
+		{[]string{"--max-redirects", "2"}, sensu.CheckStateOK, []string{"redirect_count=2"}},
+		{[]string{"--max-redirects", "0"}, sensu.CheckStateOK, []string{"OK: GET " + ts.URL + "/a -> 301 Moved Permanently", "redirect_count=0"}},
+		{[]string{"--max-redirects", "1"}, sensu.CheckStateCritical, []string{"CRITICAL: stopped after 1 redirects: " + ts.URL + "/a -> " + ts.URL + "/b -> " + ts.URL + "/c"}},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL + "/a"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %q", tt.args, want, out)
+			}
+		}
+	}
+}
+
+func TestExecuteCheckRedirectCookies(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		http.Redirect(w, r, "/home", http.StatusFound)
+	})
+	mux.HandleFunc("/home", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL+"/login")
+	status, out := run(t)
+	if status != sensu.CheckStateOK || !strings.Contains(out, "OK: GET "+ts.URL+"/login -> 302 -> 200 OK") {
+		t.Errorf("expected the session cookie to be sent to the second hop, got %d: %s", status, out)
+	}
+}
+
+func TestExecuteCheckReadBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 5000)))
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL)
+	if _, out := run(t); strings.Contains(out, "content_transfer_duration") {
+		t.Errorf("expected no body metrics without --read-body, got %q", out)
+	}
+
+	setup(t, "--url", ts.URL, "--read-body")
+	status, out := run(t)
+	if status != sensu.CheckStateOK {
+		t.Errorf("expected state %d, got %d: %s", sensu.CheckStateOK, status, out)
+	}
+	for _, want := range []string{"content_transfer_duration=", "response_body_bytes=5000B"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %q", want, out)
+		}
+	}
+
+	setup(t, "--url", ts.URL, "--read-body", "--max-body-bytes", "4999")
+	status, out = run(t)
+	if status != sensu.CheckStateCritical || !strings.Contains(out, "Response body larger than --max-body-bytes 4999") {
+		t.Errorf("expected the oversized body to be critical, got %d: %s", status, out)
+	}
+}
+
+func TestExecuteCheckBodySize(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Repeat("x", 5000)
+		switch r.URL.Path {
+		case "/chunked":
+			w.Write([]byte(body[:2500]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body[2500:]))
+		case "/truncated":
+			// Promise more than is sent and hang up.
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8000\r\n\r\n" + body)
+			buf.Flush()
+			conn.Close()
+		default:
+			w.Write([]byte(body))
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		args   []string
+		status int
+		want   string
+	}{
+		{"/", []string{"--min-body-bytes", "100"}, sensu.CheckStateOK, " response_body_bytes=5000B "},
+		{"/chunked", []string{"--min-body-bytes", "100"}, sensu.CheckStateOK, " response_body_bytes=5000B "},
+		{"/", []string{"--min-body-bytes", "6000"}, sensu.CheckStateCritical, " response_body_bytes 5000 < 6000"},
+		{"/chunked", []string{"--max-body-bytes-warn", "4000"}, sensu.CheckStateWarning, " response_body_bytes 5000 > 4000"},
+		{"/", []string{"--max-body-bytes-warn", "3000", "--max-body-bytes-crit", "4000"}, sensu.CheckStateCritical, " response_body_bytes=5000B;3000;4000 "},
+		{"/truncated", []string{"--read-body"}, sensu.CheckStateWarning, " Content-Length 8000 but 5000 bytes read"},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL + tt.path}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%s %q: expected %d with %q, got %d: %s", tt.path, tt.args, tt.status, tt.want, status, out)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--min-body-bytes", "-1"},
+		{"--max-body-bytes-warn", "5000", "--max-body-bytes-crit", "4000"},
+		{"--max-body-bytes-crit", "5000", "--max-body-bytes", "4000"},
+		{"--min-body-bytes", "5000", "--max-body-bytes-warn", "5000"},
+	} {
+		parseArgs(t, append([]string{"--url", ts.URL}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected a validation error", args)
+		}
+	}
+}
+
+func TestExecuteCheckExpectSHA256(t *testing.T) {
+	body := strings.Repeat("firmware", 1000)
+	sum := sha256.Sum256([]byte(body))
+	digest := hex.EncodeToString(sum[:])
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   []string
+	}{
+		{[]string{"--expect-sha256", digest}, sensu.CheckStateOK, []string{" sha256 verified", "content_transfer_duration=", "download_throughput_bytes_per_sec="}},
+		{[]string{"--expect-sha256", strings.ToUpper(digest)}, sensu.CheckStateOK, []string{" sha256 verified"}},
+		{[]string{"--expect-sha256", digest, "--accept-encoding", "gzip"}, sensu.CheckStateOK, []string{" sha256 verified"}},
+		{[]string{"--expect-sha256", strings.Repeat("0", 64)}, sensu.CheckStateCritical, []string{" sha256 mismatch, expected " + strings.Repeat("0", 64) + " got " + digest}},
+		{[]string{"--expect-sha256", digest, "--max-body-bytes", "100"}, sensu.CheckStateCritical, []string{"Response body larger than --max-body-bytes 100"}},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %s", tt.args, want, out)
+			}
+		}
+	}
+
+	parseArgs(t, "--url", ts.URL, "--expect-sha256", "abc")
+	if _, err := checkArgs(nil); err == nil {
+		t.Errorf("expected a short digest to be rejected")
+	}
+}
+
+func TestBytesPerSecond(t *testing.T) {
+	tests := []struct {
+		n    int64
+		d    time.Duration
+		want float64
+	}{
+		{2048, time.Second, 2048},
+		{2048, 500 * time.Millisecond, 4096},
+		{0, time.Second, 0},
+		{2048, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := bytesPerSecond(tt.n, tt.d); got != tt.want {
+			t.Errorf("bytesPerSecond(%d, %s): expected %g, got %g", tt.n, tt.d, tt.want, got)
+		}
+	}
+}
+
+func TestExecuteCheckThroughput(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(" world"))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--throughput-warning", "0.001"}, sensu.CheckStateOK, "download_throughput_bytes_per_sec="},
+		{[]string{"--throughput-warning", "100"}, sensu.CheckStateWarning, "KB/s < 100KB/s"},
+		{[]string{"--throughput-warning", "200", "--throughput-critical", "100"}, sensu.CheckStateCritical, ";204800:;102400:"},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected %q in %q", tt.args, tt.want, out)
+		}
+	}
+
+	parseArgs(t, "--url", ts.URL, "--throughput-warning", "1", "--throughput-critical", "2")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected an error when --throughput-warning is lower than --throughput-critical")
+	}
+}
+
+func TestExecuteCheckCertExpiry(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// The httptest certificate is valid for decades.
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{nil, sensu.CheckStateOK, "cert_expiry_days="},
+		{[]string{"--cert-expiry-warning", "30", "--cert-expiry-critical", "7"}, sensu.CheckStateOK, ";30:;7:"},
+		{[]string{"--cert-expiry-warning", "100000"}, sensu.CheckStateWarning, " expires "},
+		{[]string{"--cert-expiry-critical", "100000"}, sensu.CheckStateCritical, " expires "},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL, "--insecure-skip-verify"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected %q in %q", tt.args, tt.want, out)
+		}
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	setup(t, "--url", plain.URL, "--cert-expiry-critical", "100000")
+	if status, out := run(t); status != sensu.CheckStateOK || strings.Contains(out, "cert_expiry_days") {
+		t.Errorf("expected plain http to skip the certificate check, got %d: %s", status, out)
+	}
+}
+
+func TestExecuteCheckCaFile(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	dir := t.TempDir()
+	ca := writeCertPEM(t, dir, ts.Certificate())
+
+	setup(t, "--url", ts.URL)
+	if status, out := run(t); status != sensu.CheckStateCritical || !strings.Contains(out, "CRITICAL: certificate verification failed: x509: certificate signed by unknown authority") {
+		t.Errorf("expected the unknown CA to fail verification, got %d: %s", status, out)
+	}
+	for _, args := range [][]string{{"--ca-file", ca}, {"--ca-path", dir}} {
+		setup(t, append([]string{"--url", ts.URL}, args...)...)
+		if status, out := run(t); status != sensu.CheckStateOK {
+			t.Errorf("%q: expected state %d, got %d: %s", args, sensu.CheckStateOK, status, out)
+		}
+	}
+
+	parseArgs(t, "--url", ts.URL, "--ca-file", filepath.Join(dir, "missing.pem"))
+	if status, err := checkArgs(nil); status != sensu.CheckStateUnknown || err == nil {
+		t.Errorf("expected UNKNOWN for a missing --ca-file, got %d, %v", status, err)
+	}
+}
+
+func TestExecuteCheckClientCert(t *testing.T) {
+	cert, certFile, keyFile := writeClientCert(t, t.TempDir(), "")
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	ts.TLS.ClientCAs.AddCert(cert)
+	ts.StartTLS()
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--insecure-skip-verify")
+	if status, out := run(t); status != sensu.CheckStateCritical {
+		t.Errorf("expected the handshake to fail without a client certificate, got %d: %s", status, out)
+	}
+
+	setup(t, "--url", ts.URL, "--insecure-skip-verify", "--client-cert", certFile, "--client-key", keyFile)
+	status, out := run(t)
+	if status != sensu.CheckStateOK || !strings.Contains(out, " client cert presented ") || !strings.Contains(out, "tls_handshake_duration=") {
+		t.Errorf("expected the client certificate to be presented, got %d: %s", status, out)
+	}
+
+	parseArgs(t, "--url", ts.URL, "--client-cert", certFile)
+	if status, err := checkArgs(nil); status != sensu.CheckStateUnknown || err == nil {
+		t.Errorf("expected UNKNOWN without --client-key, got %d, %v", status, err)
+	}
+}
+
+func TestExecuteCheckTLSVersion(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{nil, sensu.CheckStateOK, " tls=TLS1.2 cipher=TLS_"},
+		{[]string{"--fail-on-tls-below", "1.2"}, sensu.CheckStateOK, " tls=TLS1.2 "},
+		{[]string{"--fail-on-tls-below", "1.3"}, sensu.CheckStateCritical, " tls=TLS1.2 cipher=TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (expected TLS1.3 or newer)"},
+		{[]string{"--tls-min-version", "1.3"}, sensu.CheckStateCritical, "CRITICAL: TLS handshake failed: "},
+		{[]string{"--tls-max-version", "1.1"}, sensu.CheckStateCritical, "CRITICAL: TLS handshake failed: "},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL, "--insecure-skip-verify"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected %q in %q", tt.args, tt.want, out)
+		}
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	setup(t, "--url", plain.URL, "--fail-on-tls-below", "1.3")
+	if status, out := run(t); status != sensu.CheckStateOK || strings.Contains(out, "tls=") {
+		t.Errorf("expected plain http to skip the TLS checks, got %d: %s", status, out)
+	}
+
+	parseArgs(t, "--url", ts.URL, "--tls-min-version", "1.3", "--tls-max-version", "1.2")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected an error when --tls-min-version is higher than --tls-max-version")
+	}
+}
+
+func TestExecuteCheckPinSha256(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	pin := spkiHash(ts.Certificate())
+	other := "sha256//" + strings.Repeat("A", 43) + "="
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--pin-sha256", pin}, sensu.CheckStateOK, "OK: GET " + ts.URL + " -> 200 OK"},
+		{[]string{"--pin-sha256", other, "--pin-sha256", "sha256//" + pin}, sensu.CheckStateOK, "OK: GET " + ts.URL + " -> 200 OK"},
+		{[]string{"--pin-sha256", other}, sensu.CheckStateCritical, "CRITICAL: certificate pin mismatch: expected " + strings.Repeat("A", 43) + "=, got " + pin},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL, "--insecure-skip-verify"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected %q in %q", tt.args, tt.want, out)
+		}
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	setup(t, "--url", plain.URL, "--pin-sha256", other)
+	if status, out := run(t); status != sensu.CheckStateOK {
+		t.Errorf("expected pins to be ignored for plain http, got %d: %s", status, out)
+	}
+}
+
+func TestExecuteCheckChain(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	ca := writeCertPEM(t, t.TempDir(), ts.Certificate())
+
+	setup(t, "--url", ts.URL, "--ca-file", ca)
+	if _, out := run(t); strings.Contains(out, "chain_min_expiry_days") {
+		t.Errorf("expected no chain metrics without --check-chain, got %q", out)
+	}
+
+	setup(t, "--url", ts.URL, "--ca-file", ca, "--check-chain", "--cert-expiry-critical", "100000")
+	status, out := run(t)
+	if status != sensu.CheckStateCritical {
+		t.Errorf("expected state %d, got %d: %s", sensu.CheckStateCritical, status, out)
+	}
+	for _, want := range []string{" chain_min_expiry leaf CN= (", "chain_min_expiry_days=", ";;100000:"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %q", want, out)
+		}
+	}
+}
+
+func TestExecuteCheckIPVersion(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--ip-version", "4")
+	if status, out := run(t); status != sensu.CheckStateOK || !strings.Contains(out, " remote_addr=127.0.0.1:") || !strings.Contains(out, " family=IPv4 ") {
+		t.Errorf("expected the IPv4 address in the output, got %d: %s", status, out)
+	}
+
+	setup(t, "--url", ts.URL, "--ip-version", "6")
+	if status, out := run(t); status != sensu.CheckStateCritical || !strings.Contains(out, "CRITICAL: no AAAA record for 127.0.0.1") {
+		t.Errorf("expected a missing AAAA record to be critical, got %d: %s", status, out)
+	}
+
+	// The lookup fails for the IPv6 family only, the name exists.
+	server, _ := startDNSServer(t)
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	setup(t, "--url", "http://check.test:"+port+"/", "--dns-server", server, "--ip-version", "6")
+	if status, out := run(t); status != sensu.CheckStateCritical || !strings.Contains(out, "CRITICAL: no AAAA record for check.test ") {
+		t.Errorf("expected a missing AAAA record rather than NXDOMAIN, got %d: %s", status, out)
+	}
+
+	parseArgs(t, "--url", ts.URL, "--ip-version", "5")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected an error for --ip-version 5")
+	}
+}
+
+func TestExecuteCheckResolve(t *testing.T) {
+	var host string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer ts.Close()
+	ca := writeCertPEM(t, t.TempDir(), ts.Certificate())
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	// The certificate is verified against example.com, not the override.
+	setup(t, "--url", "https://example.com:"+port+"/", "--ca-file", ca, "--resolve", "example.com:"+port+":127.0.0.1")
+	status, out := run(t)
+	if status != sensu.CheckStateOK {
+		t.Errorf("expected state %d, got %d: %s", sensu.CheckStateOK, status, out)
+	}
+	if !strings.Contains(out, " resolved-override=127.0.0.1 ") || strings.Contains(out, "dns_duration=") {
+		t.Errorf("expected the override without a DNS lookup, got %q", out)
+	}
+	if host != "example.com:"+port {
+		t.Errorf("expected Host example.com:%s, got %q", port, host)
+	}
+
+	parseArgs(t, "--url", ts.URL, "--resolve", "example.com:443")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected an error for an invalid --resolve entry")
+	}
+}
+
+func TestApplyPort(t *testing.T) {
+	tests := []struct {
+		url  string
+		port string
+		want string
+	}{
+		{"http://example.com/health", "8080", "http://example.com:8080/health"},
+		{"https://example.com:8443/?q=1", "9443", "https://example.com:9443/?q=1"},
+		{"https://example.com:8443/", "443", "https://example.com/"},
+		{"http://[::1]:8080/", "80", "http://[::1]/"},
+		{"http://[::1]/", "8081", "http://[::1]:8081/"},
+	}
+	for _, tt := range tests {
+		setup(t, "--url", tt.url, "--port", tt.port)
+		if plugin.Url != tt.want {
+			t.Errorf("%s with --port %s: expected %s, got %s", tt.url, tt.port, tt.want, plugin.Url)
+		}
+	}
+	for _, bad := range []string{"-1", "65536"} {
+		parseArgs(t, "--url", "http://example.com/", "--port", bad)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("expected --port %s to be rejected", bad)
+		}
+	}
+}
+
+func TestExecuteCheckPort(t *testing.T) {
+	var host string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer ts.Close()
+	ca := writeCertPEM(t, t.TempDir(), ts.Certificate())
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	// --resolve matches the effective port, the Host header is kept.
+	setup(t, "--url", "https://example.com/", "--port", port, "--ca-file", ca, "--resolve", "example.com:"+port+":127.0.0.1", "--host-header", "blue.example.com")
+	status, out := run(t)
+	if status != sensu.CheckStateOK || !strings.Contains(out, " host=blue.example.com target=example.com:"+port+" ") {
+		t.Errorf("expected the effective host and port in the output, got %d: %s", status, out)
+	}
+	if host != "blue.example.com" {
+		t.Errorf("expected Host blue.example.com, got %q", host)
+	}
+
+	setup(t, "--url", "https://example.com/", "--port", port, "--ca-file", ca, "--resolve", "example.com:"+port+":127.0.0.1")
+	if status, out := run(t); status != sensu.CheckStateOK || host != "example.com:"+port {
+		t.Errorf("expected Host example.com:%s, got %q with %d: %s", port, host, status, out)
+	}
+}
+
+func TestExecuteCheckDNSServer(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	server, _ := startDNSServer(t)
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	setup(t, "--url", "http://check.test:"+port+"/", "--dns-server", server)
+	if status, out := run(t); status != sensu.CheckStateOK {
+		t.Errorf("expected check.test to resolve via %s, got %d: %s", server, status, out)
+	}
+
+	setup(t, "--url", "http://missing.test:"+port+"/", "--dns-server", server)
+	if status, out := run(t); status != sensu.CheckStateCritical || !strings.Contains(out, "CRITICAL: DNS lookup of missing.test via "+server+" failed: ") {
+		t.Errorf("expected the failure to name the DNS server, got %d: %s", status, out)
+	}
+}
+
+func TestExecuteCheckRemoteAddr(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	server, _ := startDNSServer(t)
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	tests := []struct {
+		args     []string
+		want     []string
+		unwanted string
+	}{
+		{[]string{"--url", ts.URL}, []string{" remote_addr=127.0.0.1:" + port + " "}, "resolved="},
+		{[]string{"--url", ts.URL, "--verbose"}, []string{" remote_addr=127.0.0.1:" + port + " "}, "resolver="},
+		{[]string{"--url", "http://check.test:" + port + "/", "--dns-server", server}, []string{" remote_addr=127.0.0.1:" + port + " "}, "resolved="},
+		{[]string{"--url", "http://check.test:" + port + "/", "--dns-server", server, "--verbose"}, []string{" remote_addr=127.0.0.1:" + port + " ", " resolved=127.0.0.1 resolver=go dns_coalesced=false "}, ""},
+		{[]string{"--url", "http://localhost:" + port + "/", "--resolver", "go", "--verbose"}, []string{" resolver=go dns_coalesced=false "}, ""},
+	}
+	for _, tt := range tests {
+		setup(t, tt.args...)
+		_, out := run(t)
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %q", tt.args, want, out)
+			}
+		}
+		if len(tt.unwanted) > 0 && strings.Contains(out, tt.unwanted) {
+			t.Errorf("%q: expected no %q in %q", tt.args, tt.unwanted, out)
+		}
+	}
+}
+
+func TestExecuteCheckAllIPs(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// Listen on every loopback address so 127.0.0.2 answers as well.
+	ln, err := net.Listen("tcp4", "0.0.0.0:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	ts.Listener.Close()
+	ts.Listener = ln
+	ts.Start()
+	defer ts.Close()
+	server, _ := startDNSServer(t)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	url := "http://multi.test:" + port + "/"
+
+	setup(t, "--url", url, "--dns-server", server, "--all-ips")
+	status, out := run(t)
+	if status != sensu.CheckStateOK {
+		t.Errorf("expected state %d, got %d: %s", sensu.CheckStateOK, status, out)
+	}
+	for _, want := range []string{"OK: 2 addresses in ", "127.0.0.1 OK: 200 OK in ", "127.0.0.2 OK: 200 OK in ", "total_request_duration{127.0.0.1}=", "total_request_duration{127.0.0.2}="} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %q", want, out)
+		}
+	}
+
+	setup(t, "--url", url, "--dns-server", server, "--all-ips", "--max-ips", "1")
+	if _, out := run(t); strings.Count(out, "total_request_duration{") != 1 {
+		t.Errorf("expected a single address with --max-ips 1, got %q", out)
+	}
+
+	refused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	refusedURL := refused.URL
+	refused.Close()
+	setup(t, "--url", refusedURL, "--all-ips")
+	if status, out := run(t); status != sensu.CheckStateCritical || !strings.Contains(out, "127.0.0.1 CRITICAL: connection refused to 127.0.0.1:") {
+		t.Errorf("expected the refused address to be critical, got %d: %s", status, out)
+	}
+
+	parseArgs(t, "--url", url, "--all-ips", "--output-format", "influxdb")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected an error for --all-ips with the influxdb output format")
+	}
+}
+
+func TestExecuteCheckUnixSocket(t *testing.T) {
+	var host string
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip(err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	ts.Listener.Close()
+	ts.Listener = ln
+	ts.Start()
+	defer ts.Close()
+
+	setup(t, "--url", "http://localhost/metrics", "--unix-socket", socket)
+	status, out := run(t)
+	if status != sensu.CheckStateOK || strings.Contains(out, "dns_duration=") {
+		t.Errorf("expected the request to go over the socket, got %d: %s", status, out)
+	}
+	if host != "localhost" {
+		t.Errorf("expected Host localhost, got %q", host)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+	setup(t, "--url", "http://localhost/metrics", "--unix-socket", missing)
+	status, out = run(t)
+	if status != sensu.CheckStateCritical || !strings.Contains(out, "CRITICAL: unable to connect to unix socket "+missing+": ") || !strings.Contains(out, "(errno ") {
+		t.Errorf("expected a missing socket to be critical with the errno, got %d: %s", status, out)
+	}
+}
+
+func TestExecuteCheckSourceAddress(t *testing.T) {
+	var client string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--source-address", "127.0.0.2", "--verbose")
+	debugOutput = io.Discard
+	defer func() { debugOutput = os.Stderr }()
+	status, out := run(t)
+	if status != sensu.CheckStateOK || !strings.Contains(out, " local_addr=127.0.0.2:") {
+		t.Errorf("expected the request to come from 127.0.0.2, got %d: %s", status, out)
+	}
+	if client != "127.0.0.2" {
+		t.Errorf("expected the server to see 127.0.0.2, got %q", client)
+	}
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		// Documentation range, not configured on any interface.
+		{"192.0.2.1", "UNKNOWN: unable to bind to source address 192.0.2.1: "},
+		{"::1", "UNKNOWN: unable to bind to source address ::1: "},
+	}
+	for _, tt := range tests {
+		setup(t, "--url", ts.URL, "--source-address", tt.source)
+		status, out := run(t)
+		if status != sensu.CheckStateUnknown || !strings.Contains(out, tt.want) || !strings.Contains(out, "reason=config_error") {
+			t.Errorf("%s: expected %q, got %d: %s", tt.source, tt.want, status, out)
+		}
+	}
+
+	if interfaceSupported {
+		setup(t, "--url", ts.URL, "--interface", "lo")
+		status, out := run(t)
+		if strings.Contains(out, "operation not permitted") {
+			t.Skip("SO_BINDTODEVICE needs CAP_NET_RAW")
+		}
+		if status != sensu.CheckStateOK {
+			t.Errorf("expected the request to go through lo, got %d: %s", status, out)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--source-address", "not-an-ip"},
+		{"--interface", "no-such-interface0"},
+		{"--source-address", "127.0.0.2", "--unix-socket", "/tmp/app.sock"},
+	} {
+		parseArgs(t, append([]string{"--url", ts.URL}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected a validation error", args)
+		}
+	}
+}
+
+func TestExecuteCheckHTTPVersion(t *testing.T) {
+	h2 := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+	h1 := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer h1.Close()
+	cleartext := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), &http2.Server{}))
+	defer cleartext.Close()
+
+	tests := []struct {
+		url    string
+		args   []string
+		status int
+		want   []string
+	}{
+		{h2.URL, nil, sensu.CheckStateOK, []string{" proto=HTTP/2.0 ", " alpn=h2 ", "http_version=2 "}},
+		{h2.URL, []string{"--expect-alpn", "h2", "--expect-alpn-critical"}, sensu.CheckStateOK, []string{" alpn=h2 "}},
+		{h2.URL, []string{"--http-version", "1.1", "--expect-alpn", "h2"}, sensu.CheckStateWarning, []string{" (expected alpn=h2) "}},
+		{h1.URL, []string{"--expect-alpn", "h2"}, sensu.CheckStateWarning, []string{" alpn=http/1.1 (expected alpn=h2) "}},
+		{h1.URL, []string{"--expect-alpn", "h2", "--expect-alpn-critical"}, sensu.CheckStateCritical, []string{" alpn=http/1.1 (expected alpn=h2) "}},
+		{cleartext.URL, []string{"--http-version", "2", "--expect-alpn", "h2"}, sensu.CheckStateOK, []string{" proto=HTTP/2.0 "}},
+		{h2.URL, []string{"--http-version", "1.1"}, sensu.CheckStateOK, []string{" proto=HTTP/1.1 ", "http_version=1.1 "}},
+		{h2.URL, []string{"--http-version", "2"}, sensu.CheckStateOK, []string{" proto=HTTP/2.0 "}},
+		{h1.URL, []string{"--http-version", "2"}, sensu.CheckStateCritical, []string{" proto=HTTP/1.1 (expected HTTP/2) "}},
+		{cleartext.URL, []string{"--http-version", "2"}, sensu.CheckStateOK, []string{" proto=HTTP/2.0 ", "connect_duration="}},
+		{cleartext.URL, nil, sensu.CheckStateOK, []string{" proto=HTTP/1.1 "}},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", tt.url, "--insecure-skip-verify"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%s %q: expected state %d, got %d: %s", tt.url, tt.args, tt.status, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s %q: expected %q in %q", tt.url, tt.args, want, out)
+			}
+		}
+	}
+}
+
+func TestExecuteCheckRetries(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--retries", "2", "--retry-delay", "10")
+	status, out := run(t)
+	if status != sensu.CheckStateOK {
+		t.Fatalf("expected OK after retries, got %d: %s", status, out)
+	}
+	for _, want := range []string{": GET " + ts.URL + " -> 200 OK in ", " attempts=3 ", " total_with_retries_duration="} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %q", want, out)
+		}
+	}
+
+	// Without retries the first 503 is reported and nothing is added.
+	atomic.StoreInt32(&requests, 0)
+	setup(t, "--url", ts.URL)
+	if status, out := run(t); status != sensu.CheckStateCritical || strings.Contains(out, "attempts=") {
+		t.Errorf("expected CRITICAL without attempts, got %d: %s", status, out)
+	}
+
+	// Connection errors are retried too.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + l.Addr().String() + "/"
+	l.Close()
+	setup(t, "--url", closed, "--retries", "1", "--retry-delay", "10")
+	if status, out := run(t); status != sensu.CheckStateCritical || !strings.Contains(out, "(after 2 attempts)") {
+		t.Errorf("expected CRITICAL after 2 attempts, got %d: %s", status, out)
+	}
+
+	// --timeout bounds the whole retry loop.
+	atomic.StoreInt32(&requests, -1000)
+	setup(t, "--url", ts.URL, "--timeout", "1", "--retries", "100", "--retry-delay", "300")
+	start := time.Now()
+	status, out = run(t)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the retries to stop at --timeout, took %s", elapsed)
+	}
+	if status != sensu.CheckStateCritical || !strings.Contains(out, " attempts=") {
+		t.Errorf("expected CRITICAL with attempts, got %d: %s", status, out)
+	}
+
+	parseArgs(t, "--url", ts.URL, "--retries", "-1")
+	if status, err := checkArgs(nil); err == nil || status != sensu.CheckStateUnknown {
+		t.Errorf("expected UNKNOWN for negative --retries, got %d, %v", status, err)
+	}
+}
+
+func TestExecuteCheckNoKeepalive(t *testing.T) {
+	var (
+		conns  int32
+		closed atomic.Value
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		closed.Store(r.Close)
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+		}
+	})
+	countConns := func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	h1 := httptest.NewUnstartedServer(handler)
+	h1.Config.ConnState = countConns
+	h1.Start()
+	defer h1.Close()
+	h2 := httptest.NewUnstartedServer(handler)
+	h2.Config.ConnState = countConns
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	tests := []struct {
+		url    string
+		args   []string
+		conns  int32
+		closed bool
+	}{
+		{h1.URL, nil, 1, false},
+		{h1.URL, []string{"--no-keepalive"}, 2, true},
+		{h2.URL, []string{"--insecure-skip-verify"}, 1, false},
+		{h2.URL, []string{"--insecure-skip-verify", "--no-keepalive"}, 2, false},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&conns, 0)
+		setup(t, append([]string{"--url", tt.url, "--max-redirects", "1"}, tt.args...)...)
+		status, out := run(t)
+		if status != sensu.CheckStateOK || !strings.Contains(out, " connection_reused=0 ") {
+			t.Errorf("%s %q: expected OK with connection_reused=0, got %d: %s", tt.url, tt.args, status, out)
+		}
+		if n := atomic.LoadInt32(&conns); n != tt.conns {
+			t.Errorf("%s %q: expected %d connections for the two hops, got %d", tt.url, tt.args, tt.conns, n)
+		}
+		// HTTP/2 has no Connection header, the client closes instead.
+		if got := closed.Load().(bool); got != tt.closed {
+			t.Errorf("%s %q: expected Connection: close %t, got %t", tt.url, tt.args, tt.closed, got)
+		}
+	}
+}
+
+func TestExecuteCheckSamples(t *testing.T) {
+	var requests, conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// One slow sample out of three.
+		if atomic.AddInt32(&requests, 1)%3 == 2 {
+			time.Sleep(150 * time.Millisecond)
+		}
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		conns  int32
+		want   []string
+	}{
+		{nil, sensu.CheckStateOK, 1, []string{" samples=3 evaluate=avg ", "total_min=", "total_avg=", "total_max=", "total_p95=", "first_byte_duration_avg="}},
+		{[]string{"--no-keepalive"}, sensu.CheckStateOK, 3, []string{"connect_duration_avg="}},
+		{[]string{"--evaluate", "max"}, sensu.CheckStateWarning, 1, []string{" samples=3 evaluate=max ", "first_byte_duration max "}},
+		{[]string{"--evaluate", "p99"}, sensu.CheckStateWarning, 1, []string{"total_p99="}},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&conns, 0)
+		setup(t, append([]string{"--url", ts.URL, "--samples", "3", "--sample-interval", "10", "--ttfb-warning", "0.1"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		if n := atomic.LoadInt32(&conns); n != tt.conns {
+			t.Errorf("%q: expected %d connections, got %d", tt.args, tt.conns, n)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %q", tt.args, want, out)
+			}
+		}
+		if strings.Contains(out, " total_request_duration=") {
+			t.Errorf("%q: expected the per request total to be replaced, got %q", tt.args, out)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--samples", "0"},
+		{"--samples", "4", "--sample-interval", "5000", "--timeout", "15"},
+		{"--evaluate", "p90"},
+	} {
+		parseArgs(t, append([]string{"--url", ts.URL}, args...)...)
+		if status, err := checkArgs(nil); err == nil || status != sensu.CheckStateUnknown {
+			t.Errorf("%q: expected UNKNOWN, got %d, %v", args, status, err)
+		}
+	}
+}
+
+func TestExecuteCheckWarmup(t *testing.T) {
+	var requests, conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request of each test breaks the connection.
+		if atomic.AddInt32(&requests, 1) == 1 && r.URL.Path == "/broken" {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		args   []string
+		status int
+		conns  int32
+		want   string
+	}{
+		{"/", []string{"--warmup"}, sensu.CheckStateOK, 1, " warmup=1"},
+		{"/", []string{"--warmup", "--warmup-new-connection"}, sensu.CheckStateOK, 2, " warmup=1"},
+		{"/broken", []string{"--warmup"}, sensu.CheckStateOK, 2, " warmup=1"},
+		{"/broken", []string{"--warmup", "--fail-on-warmup-error"}, sensu.CheckStateCritical, 1, "warm-up request failed: Error making request: "},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&conns, 0)
+		setup(t, append([]string{"--url", ts.URL + tt.path}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%s %q: expected state %d with %q, got %d: %s", tt.path, tt.args, tt.status, tt.want, status, out)
+		}
+		if n := atomic.LoadInt32(&conns); n != tt.conns {
+			t.Errorf("%s %q: expected %d connections, got %d", tt.path, tt.args, tt.conns, n)
+		}
+	}
+}
+
+func TestExecuteCheckMeasureReuse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/close" {
+			w.Header().Set("Connection", "close")
+		}
+		// An unread body must not keep the connection from being reused.
+		w.Write([]byte(strings.Repeat("x", 10000)))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		args   []string
+		status int
+		want   []string
+	}{
+		{"/", nil, sensu.CheckStateOK, []string{" cold_total_duration=", " warm_total_duration=", " reuse_worked=1"}},
+		{"/close", nil, sensu.CheckStateOK, []string{" connection not reused ", " reuse_worked=0"}},
+		{"/close", []string{"--warn-on-no-reuse"}, sensu.CheckStateWarning, []string{" connection not reused ", " reuse_worked=0"}},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL + tt.path, "--measure-reuse"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%s %q: expected state %d, got %d: %s", tt.path, tt.args, tt.status, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s %q: expected %q in %q", tt.path, tt.args, want, out)
+			}
+		}
+	}
+
+	parseArgs(t, "--url", ts.URL, "--measure-reuse", "--samples", "2")
+	if status, err := checkArgs(nil); err == nil || status != sensu.CheckStateUnknown {
+		t.Errorf("expected UNKNOWN for --measure-reuse with --samples, got %d, %v", status, err)
+	}
+}
+
+func TestExecuteCheckMeasureResumption(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	ts := httptest.NewTLSServer(handler)
+	defer ts.Close()
+	noTickets := httptest.NewUnstartedServer(handler)
+	noTickets.TLS = &tls.Config{SessionTicketsDisabled: true}
+	noTickets.StartTLS()
+	defer noTickets.Close()
+
+	tests := []struct {
+		url    string
+		args   []string
+		status int
+		want   []string
+	}{
+		{ts.URL, nil, sensu.CheckStateOK, []string{" tls_resumed=1 ", " tls_resumed_handshake_duration="}},
+		{noTickets.URL, nil, sensu.CheckStateOK, []string{" tls session not resumed ", " tls_resumed=0 "}},
+		{noTickets.URL, []string{"--warn-on-no-resumption"}, sensu.CheckStateWarning, []string{" tls session not resumed ", " tls_resumed=0 "}},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", tt.url, "--insecure-skip-verify", "--measure-resumption"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%q: expected state %d, got %d: %s", tt.args, tt.status, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: expected %q in %q", tt.args, want, out)
+			}
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--url", ts.URL, "--measure-resumption", "--measure-reuse"},
+		{"--url", ts.URL, "--measure-resumption", "--samples", "2"},
+		{"--url", "http://example.com/", "--measure-resumption"},
+		{"--url", ts.URL, "--warn-on-no-resumption"},
+	} {
+		parseArgs(t, args...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("expected %q to be rejected", args)
+		}
+	}
+}
+
+func TestExecuteCheckConditional(t *testing.T) {
+	const etag = `"v1"`
+	var conditional int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cached":
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				atomic.AddInt32(&conditional, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/ignored":
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		}
+		io.WriteString(w, "body")
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		want   []string
+	}{
+		{"/cached", sensu.CheckStateOK, []string{" revalidation_duration=", " revalidated=1", " revalidation_first_byte_duration="}},
+		{"/ignored", sensu.CheckStateWarning, []string{" not revalidated, got 200", " revalidated=0"}},
+		{"/none", sensu.CheckStateWarning, []string{" no ETag or Last-Modified to revalidate with"}},
+	}
+	for _, tt := range tests {
+		setup(t, "--url", ts.URL+tt.path, "--conditional")
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%s: expected state %d, got %d: %s", tt.path, tt.status, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: expected %q in %s", tt.path, want, out)
+			}
+		}
+	}
+	if n := atomic.LoadInt32(&conditional); n != 1 {
+		t.Errorf("expected 1 conditional request, got %d", n)
+	}
+
+	for _, args := range [][]string{{"--conditional", "--measure-reuse"}, {"--conditional", "--method", "POST"}} {
+		parseArgs(t, append([]string{"--url", ts.URL}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
+
+func TestExecuteCheckFailureReason(t *testing.T) {
+	dns, _ := startDNSServer(t)
+	refused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	refusedURL := refused.URL
+	refused.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	untrusted := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer untrusted.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+	}))
+	defer slow.Close()
+	// Accepts connections but never answers the TLS handshake.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	// Answers every connection with bytes that are not TLS.
+	garbage, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer garbage.Close()
+	go func() {
+		for {
+			conn, err := garbage.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--url", "http://missing.test/", "--dns-server", dns}, sensu.CheckStateCritical, "CRITICAL: DNS lookup of missing.test via " + dns + " failed: NXDOMAIN reason=dns_error"},
+		{[]string{"--url", "http://missing.test/", "--dns-server", dns, "--dns-failure-status", "warning"}, sensu.CheckStateWarning, "WARNING: DNS lookup of missing.test "},
+		{[]string{"--url", refusedURL}, sensu.CheckStateCritical, "CRITICAL: connection refused to " + strings.TrimPrefix(refusedURL, "http://") + " reason=conn_refused"},
+		{[]string{"--url", strings.Replace(plain.URL, "http:", "https:", 1)}, sensu.CheckStateCritical, "CRITICAL: TLS handshake failed: server answered with plain HTTP reason=tls_error"},
+		{[]string{"--url", "https://" + garbage.Addr().String() + "/"}, sensu.CheckStateCritical, "CRITICAL: TLS handshake failed: first record does not look like a TLS handshake reason=tls_error"},
+		{[]string{"--url", untrusted.URL}, sensu.CheckStateCritical, "CRITICAL: certificate verification failed: "},
+		{[]string{"--url", "https://" + silent.Addr().String() + "/", "--tls-timeout", "100"}, sensu.CheckStateCritical, "CRITICAL: timed out: TLS handshake timeout reason=timeout"},
+		{[]string{"--url", slow.URL, "--timeout", "1"}, sensu.CheckStateCritical, "CRITICAL: request timed out after 1s (threshold 2s) reason=timeout | "},
+		{[]string{"--url", slow.URL, "--timeout", "1", "-c", "0"}, sensu.CheckStateCritical, "CRITICAL: request timed out after 1s reason=timeout | "},
+	}
+	for _, tt := range tests {
+		setup(t, tt.args...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected state %d with %q, got %d: %s", tt.args, tt.status, tt.want, status, out)
+		}
+	}
+
+	setup(t, "--url", refusedURL, "--output-format", "json")
+	_, out := run(t)
+	var result CheckResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil || result.Reason != reasonConnRefused {
+		t.Errorf("expected reason conn_refused, got %v: %s", err, out)
+	}
+
+	parseArgs(t, "--url", refusedURL, "--dns-failure-status", "ok")
+	if status, err := checkArgs(nil); err == nil || status != sensu.CheckStateUnknown {
+		t.Errorf("expected UNKNOWN for --dns-failure-status ok, got %d, %v", status, err)
+	}
+}
+
+func TestExecuteCheckProxy(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	proxy, connects := startProxy(t, "user", "s3cret")
+
+	// https goes through a CONNECT tunnel, which is timed.
+	setup(t, "--url", target.URL, "--insecure-skip-verify", "--proxy", proxy)
+	status, out := run(t)
+	if status != sensu.CheckStateOK || !strings.Contains(out, " proxy_connect_duration=") {
+		t.Errorf("expected OK with proxy_connect_duration, got %d: %s", status, out)
+	}
+	if atomic.LoadInt32(connects) != 1 {
+		t.Errorf("expected 1 CONNECT, got %d", atomic.LoadInt32(connects))
+	}
+
+	// --verbose names the proxy without its password.
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--proxy", proxy}, " proxy=" + strings.Replace(proxy, "s3cret", "xxxxx", 1) + " "},
+		{nil, " proxy=none "},
+		{[]string{"--no-proxy-env"}, " proxy=none "},
+	} {
+		setup(t, append([]string{"--url", plain.URL, "--verbose"}, tt.args...)...)
+		if _, out := run(t); !strings.Contains(out, tt.want) || strings.Contains(out, "s3cret") {
+			t.Errorf("%q: expected %q in %q", tt.args, tt.want, out)
+		}
+	}
+
+	// Plain http is forwarded without a tunnel.
+	setup(t, "--url", plain.URL, "--proxy", proxy)
+	status, out = run(t)
+	if status != sensu.CheckStateOK || strings.Contains(out, "proxy_connect_duration=") {
+		t.Errorf("expected OK without proxy_connect_duration, got %d: %s", status, out)
+	}
+	if atomic.LoadInt32(connects) != 1 {
+		t.Errorf("expected no CONNECT for plain http, got %d", atomic.LoadInt32(connects))
+	}
+
+	// Wrong credentials fail the tunnel without leaking the password.
+	wrong := strings.Replace(proxy, "s3cret", "wr0ng", 1)
+	setup(t, "--url", target.URL, "--insecure-skip-verify", "--proxy", wrong)
+	status, out = run(t)
+	if status != sensu.CheckStateCritical || !strings.Contains(out, "Proxy Authentication Required") || strings.Contains(out, "wr0ng") {
+		t.Errorf("expected CRITICAL with the password redacted, got %d: %s", status, out)
+	}
+
+	setup(t, "--url", target.URL, "--insecure-skip-verify", "--proxy", startSOCKS5(t))
+	status, out = run(t)
+	if status != sensu.CheckStateOK || !strings.Contains(out, " proxy_connect_duration=") {
+		t.Errorf("expected OK through socks5, got %d: %s", status, out)
+	}
+
+	for _, args := range [][]string{
+		{"--proxy", "ftp://proxy:21"},
+		{"--proxy", "proxy:3128"},
+		{"--proxy", proxy, "--unix-socket", "/tmp/sock"},
+	} {
+		parseArgs(t, append([]string{"--url", target.URL}, args...)...)
+		if status, err := checkArgs(nil); err == nil || status != sensu.CheckStateUnknown {
+			t.Errorf("%q: expected UNKNOWN, got %d, %v", args, status, err)
+		}
+	}
+}
+
+func TestErrorPolicy(t *testing.T) {
+	dns, _ := startDNSServer(t)
+	refused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	refusedURL := refused.URL
+	refused.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	missing := filepath.Join(t.TempDir(), "token")
+
+	// Configuration problems are UNKNOWN, or CRITICAL when asked for.
+	for _, tt := range []struct {
+		args   []string
+		status int
+	}{
+		{[]string{"--method", "GETT"}, sensu.CheckStateUnknown},
+		{[]string{"--method", "GETT", "--critical-on-error"}, sensu.CheckStateCritical},
+		{[]string{"--ca-file", missing}, sensu.CheckStateUnknown},
+		{[]string{"--ca-file", missing, "--critical-on-error"}, sensu.CheckStateCritical},
+	} {
+		parseArgs(t, append([]string{"--url", ts.URL}, tt.args...)...)
+		if status, err := checkArgs(nil); err == nil || status != tt.status {
+			t.Errorf("%q: expected state %d, got %d, %v", tt.args, tt.status, status, err)
+		}
+	}
+
+	// So are plugin-side errors at run time, while failures of the target
+	// stay CRITICAL either way.
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--url", ts.URL, "--bearer-token-file", missing}, sensu.CheckStateUnknown, "UNKNOWN: unable to read --bearer-token-file: "},
+		{[]string{"--url", ts.URL, "--bearer-token-file", missing, "--critical-on-error"}, sensu.CheckStateCritical, " reason=config_error"},
+		{[]string{"--url", refusedURL}, sensu.CheckStateCritical, " reason=conn_refused"},
+		{[]string{"--url", refusedURL, "--critical-on-error"}, sensu.CheckStateCritical, " reason=conn_refused"},
+		{[]string{"--url", "http://missing.test/", "--dns-server", dns, "--dns-failure-status", "unknown"}, sensu.CheckStateUnknown, "UNKNOWN: DNS lookup of missing.test "},
+	}
+	for _, tt := range tests {
+		setup(t, tt.args...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected state %d with %q, got %d: %s", tt.args, tt.status, tt.want, status, out)
+		}
+	}
+}
+
+func TestExecuteCheckTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+	}))
+	defer slow.Close()
+	// Accepts connections but never answers the TLS handshake.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	setup(t, "--url", slow.URL, "--timeout", "1", "-w", "0.5", "-c", "0.8")
+	status, out := run(t)
+	if status != sensu.CheckStateCritical || !strings.Contains(out, "CRITICAL: request timed out after 1s (threshold 0.8s) reason=timeout | ") {
+		t.Fatalf("expected a timeout, got %d: %s", status, out)
+	}
+	for _, want := range []string{" connect_duration=", " total_request_duration=1.000000s;0.5;0.8;0 ", " timed_out=1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %q", want, out)
+		}
+	}
+
+	// The phases show where the request stalled, here the TLS handshake.
+	setup(t, "--url", "https://"+silent.Addr().String()+"/", "--timeout", "1", "--tls-timeout", "5000")
+	status, out = run(t)
+	if status != sensu.CheckStateCritical || !strings.Contains(out, " connect_duration=") || strings.Contains(out, "tls_handshake_duration=") {
+		t.Errorf("expected the connect without a TLS handshake, got %d: %s", status, out)
+	}
+
+	setup(t, "--url", slow.URL, "--timeout", "1", "--output-format", "json")
+	_, out = run(t)
+	var result CheckResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out, err)
+	}
+	if result.Reason != reasonTimeout || result.Metrics["timed_out"] != 1 || result.Timings["total_request_duration"].Seconds != 1 {
+		t.Errorf("expected the timeout metrics in %q", out)
+	}
+}
+
+func TestExecuteCheckSIGTERM(t *testing.T) {
+	// The subprocess runs the check the way the agent does.
+	if url := os.Getenv("TEST_SIGTERM_URL"); len(url) > 0 {
+		os.Args = []string{plugin.Name, "--url", url, "--timeout", "30"}
+		main()
+		return
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGTERM cannot be sent on windows")
+	}
+
+	received := make(chan struct{})
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	cmd := exec.Command(os.Args[0], "-test.run", "^TestExecuteCheckSIGTERM$")
+	cmd.Env = append(os.Environ(), "TEST_SIGTERM_URL="+ts.URL)
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("the subprocess did not send the request")
+	}
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != sensu.CheckStateCritical {
+		t.Errorf("expected to exit CRITICAL, got %v", err)
+	}
+	out := stdout.String()
+	if !strings.Contains(out, " CRITICAL: check cancelled after ") || !strings.Contains(out, " reason=cancelled | ") {
+		t.Errorf("expected the cancellation, got %q", out)
+	}
+	// The connection was made before the signal, its timing is reported.
+	if !strings.Contains(out, " connect_duration=") || !strings.HasSuffix(out, " up=0\n") {
+		t.Errorf("expected the partial timings, got %q", out)
+	}
+}
+
+func TestExecuteCheckFailurePerfdata(t *testing.T) {
+	dns, _ := startDNSServer(t)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	refused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	refusedURL := refused.URL
+	refused.Close()
+	untrusted := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer untrusted.Close()
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"ok", []string{"--url", healthy.URL}, []string{"connect_duration", "first_byte_duration", "network_setup_duration", "request_write_duration", "server_processing_duration", "total_request_duration", "request_body_bytes", "http_status", "http_version", "redirect_count", "connection_reused", "up"}},
+		{"dns", []string{"--url", "http://missing.test/", "--dns-server", dns}, []string{"dns_duration", "up"}},
+		{"refused", []string{"--url", refusedURL}, []string{"connect_duration", "up"}},
+		{"tls", []string{"--url", untrusted.URL}, []string{"tls_handshake_duration", "connect_duration", "up"}},
+		{"config", []string{"--url", healthy.URL, "--bearer-token-file", filepath.Join(t.TempDir(), "missing")}, []string{"up"}},
+	}
+	for _, tt := range tests {
+		setup(t, tt.args...)
+		_, out := run(t)
+		if got := perfLabels(out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %q, got %q in %q", tt.name, tt.want, got, out)
+		}
+		up := " up=0\n"
+		if tt.name == "ok" {
+			up = " up=1\n"
+		}
+		if !strings.HasSuffix(out, up) {
+			t.Errorf("%s: expected %q at the end of %q", tt.name, up, out)
+		}
+	}
+
+	setup(t, "--url", refusedURL, "--output-format", "json")
+	_, out := run(t)
+	var result CheckResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out, err)
+	}
+	if up, ok := result.Metrics["up"]; !ok || up != 0 {
+		t.Errorf("expected up 0 in %q", out)
+	}
+	if _, ok := result.Timings["connect_duration"]; !ok {
+		t.Errorf("expected the connect timing in %q", out)
+	}
+}
+
+func TestExecuteCheckVerboseDump(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc123"})
+		w.Header().Set("X-Backend", "b1")
+	}))
+	defer ts.Close()
+
+	var dump strings.Builder
+	debugOutput = &dump
+	defer func() { debugOutput = os.Stderr }()
+
+	setup(t, "--url", ts.URL, "--verbose", "--bearer-token", "t0ken", "--header", "X-Request: 1")
+	status, out := run(t)
+	if status != sensu.CheckStateOK || strings.Contains(out, "> GET") {
+		t.Errorf("expected OK without the dump on stdout, got %d: %s", status, out)
+	}
+	got := dump.String()
+	for _, want := range []string{
+		"* hop 1: " + ts.URL + "\n",
+		"> GET / HTTP/1.1\n",
+		"> Authorization: [redacted]\n",
+		"> X-Request: 1\n",
+		"< HTTP/1.1 302 Found\n",
+		"* hop 2: " + ts.URL + "/final\n",
+		"< X-Backend: b1\n",
+		"< Set-Cookie: [redacted]\n",
+		" got_first_response_byte\n",
+		" done\n",
+		"* remote_addr=" + ts.Listener.Addr().String() + " local_addr=127.0.0.1:",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in the dump:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "t0ken") || strings.Contains(got, "abc123") {
+		t.Errorf("expected secrets to be redacted:\n%s", got)
+	}
+
+	// Without --verbose nothing is dumped.
+	dump.Reset()
+	setup(t, "--url", ts.URL)
+	run(t)
+	if dump.Len() > 0 {
+		t.Errorf("expected no dump, got:\n%s", dump.String())
+	}
+}
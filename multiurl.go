@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// urlsInput is where --urls-from-stdin reads the URLs from.
+var urlsInput io.Reader = os.Stdin
+
+// loadURLs reads the URLs of a --urls-file.
+func loadURLs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --urls-file: %v", err)
+	}
+	defer f.Close()
+	return readURLs(f, "--urls-file "+path)
+}
+
+// readURLs reads URLs from r, one per line, until EOF. Blank lines and lines
+// starting with # are skipped, and a URL listed twice is checked once. source
+// names r in errors.
+func readURLs(r io.Reader, source string) ([]string, error) {
+	var urls []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		if err := validateURL(line); err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		seen[line] = true
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", source, err)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%s has no URLs", source)
+	}
+	return urls, nil
+}
+
+var urlPrefixInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// urlPrefixes returns the metric prefix of every URL, its host and path with
+// anything but letters and digits replaced, e.g. api_example_com_health.
+// URLs that end up with the same prefix get a _2, _3, ... suffix.
+func urlPrefixes(urls []string) []string {
+	prefixes := make([]string, len(urls))
+	used := map[string]int{}
+	for i, rawURL := range urls {
+		name := rawURL
+		if u, err := url.Parse(rawURL); err == nil {
+			name = u.Host + u.Path
+		}
+		prefix := strings.Trim(urlPrefixInvalid.ReplaceAllString(strings.ToLower(name), "_"), "_")
+		used[prefix]++
+		if n := used[prefix]; n > 1 {
+			prefix = fmt.Sprintf("%s_%d", prefix, n)
+		}
+		prefixes[i] = prefix
+	}
+	return prefixes
+}
+
+// urlTimeout returns how long each URL of --urls-file may take, the
+// --per-url-timeout or an equal share of --timeout.
+func urlTimeout(n int) time.Duration {
+	if plugin.PerUrlTimeout > 0 {
+		return time.Duration(plugin.PerUrlTimeout) * time.Second
+	}
+	return time.Duration(plugin.Timeout) * time.Second / time.Duration(n)
+}
+
+// checkURLs measures every URL of --urls-file in turn, each within its own
+// timeout while the deadline of ctx bounds them all. The worst result wins,
+// every metric is reported with the prefix of its URL, e.g.
+// api_example_com_total_request_duration, and each URL gets a line of its
+// own after the summary.
+func checkURLs(ctx context.Context) (int, error) {
+	defer func(u string) { plugin.Url = u }(plugin.Url)
+
+	// The summary has the reason token of the first of the worst results.
+	worst := measurement{status: "OK"}
+	var (
+		lines   []string
+		metrics []metric
+	)
+	prefixes := urlPrefixes(checkedURLs)
+	timeout := urlTimeout(len(checkedURLs))
+	start := time.Now()
+	for i, u := range checkedURLs {
+		// Everything measure reads comes from the plugin configuration.
+		plugin.Url = u
+		urlCtx, cancel := context.WithTimeout(ctx, timeout)
+		m := measureSamples(urlCtx, resolveOverrides)
+		cancel()
+
+		if worseStatus(worst.status, m.status) != worst.status {
+			worst = m
+		}
+		for _, pm := range append(m.metrics, upMetric(m)) {
+			pm.label = prefixes[i] + "_" + pm.label
+			metrics = append(metrics, pm)
+		}
+		if len(m.err) > 0 {
+			lines = append(lines, fmt.Sprintf("%s %s: %s%s", u, m.status, m.err, reasonKey(m)))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s: %s in %s%s", u, m.status, m.statusLine, formatHeadline(m.elapsed, outputFormat()), m.details+reasonKey(m)))
+	}
+
+	status := worst.status
+	message := fmt.Sprintf("%d URLs in %s", len(checkedURLs), formatHeadline(time.Since(start), outputFormat()))
+	if plugin.OutputFormat == "json" {
+		source := plugin.UrlsFile
+		if plugin.UrlsFromStdin {
+			source = "stdin"
+		}
+		result := CheckResult{Status: status, URL: source, Message: message + "\n" + strings.Join(lines, "\n"), Reason: reasonOf(worst)}
+		result.addMetrics(metrics)
+		printJSON(result)
+	} else {
+		fmt.Printf("%s %s: %s%s | %s\n%s\n", checkName(), status, message, reasonKey(worst), perfdata(metrics), strings.Join(lines, "\n"))
+	}
+	return checkState(status), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// writeURLsFile writes lines to a --urls-file and returns its path.
+func writeURLsFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "urls")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadURLs(t *testing.T) {
+	path := writeURLsFile(t, "# endpoints", "https://a.example.com/", "", "  https://b.example.com/health  ", "https://a.example.com/")
+	urls, err := loadURLs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://a.example.com/", "https://b.example.com/health"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("expected %q, got %q", want, urls)
+	}
+
+	for _, lines := range [][]string{{}, {"# nothing", ""}, {"ftp://example.com/"}} {
+		if _, err := loadURLs(writeURLsFile(t, lines...)); err == nil {
+			t.Errorf("%q: expected an error", lines)
+		}
+	}
+	if _, err := loadURLs(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestURLPrefixes(t *testing.T) {
+	got := urlPrefixes([]string{"https://api.example.com/", "https://api.example.com/v1/health", "http://API.example.com", "http://127.0.0.1:8080/"})
+	want := []string{"api_example_com", "api_example_com_v1_health", "api_example_com_2", "127_0_0_1_8080"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestExecuteCheckURLsFile(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(3 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	setup(t, "--urls-file", writeURLsFile(t, ok.URL, ok.URL+"/other"))
+	status, out := run(t)
+	prefix := urlPrefixes([]string{ok.URL})[0]
+	if status != sensu.CheckStateOK || !strings.Contains(out, " OK: 2 URLs in ") || !strings.Contains(out, " "+prefix+"_total_request_duration=") ||
+		!strings.Contains(out, " "+prefix+"_other_up=1") || !strings.Contains(out, "\n"+ok.URL+"/other OK: 200 OK in ") {
+		t.Errorf("expected OK for both URLs, got %d: %s", status, out)
+	}
+
+	setup(t, "--urls-file", writeURLsFile(t, ok.URL, failing.URL))
+	if status, out := run(t); status != sensu.CheckStateCritical || !strings.Contains(out, "\n"+failing.URL+" CRITICAL: 500 Internal Server Error") {
+		t.Errorf("expected the worst status, got %d: %s", status, out)
+	}
+
+	// The slow URL runs out of its time, the next one is still checked.
+	setup(t, "--urls-file", writeURLsFile(t, slow.URL, ok.URL), "--per-url-timeout", "1")
+	status, out = run(t)
+	if status != sensu.CheckStateCritical || !strings.Contains(out, "\n"+slow.URL+" CRITICAL: ") || !strings.Contains(out, "reason=timeout") ||
+		!strings.Contains(out, "\n"+ok.URL+" OK: ") {
+		t.Errorf("expected the slow URL to time out alone, got %d: %s", status, out)
+	}
+
+	for _, args := range [][]string{
+		{"--urls-file", writeURLsFile(t, ok.URL), "--all-ips"},
+		{"--urls-file", writeURLsFile(t, ok.URL), "--output-format", "prometheus"},
+		{"--urls-file", writeURLsFile(t, ok.URL), "--per-url-timeout", "-1"},
+		{"--urls-file", writeURLsFile(t)},
+		{"--urls-file", writeURLsFile(t, ok.URL), "--urls-from-stdin"},
+	} {
+		parseArgs(t, args...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
+
+func TestExecuteCheckURLsFromStdin(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	defer func() { urlsInput = os.Stdin }()
+
+	urlsInput = strings.NewReader("# generated\n" + ok.URL + "\n\n" + ok.URL + "/other\n")
+	setup(t, "--urls-from-stdin")
+	status, out := run(t)
+	if status != sensu.CheckStateOK || !strings.Contains(out, "OK: 2 URLs in ") || !strings.Contains(out, "\n"+ok.URL+"/other OK: ") {
+		t.Errorf("expected both URLs from stdin to be checked, got %d: %s", status, out)
+	}
+
+	for _, input := range []string{"", "# nothing\n\n", "ftp://example.com/\n"} {
+		urlsInput = strings.NewReader(input)
+		parseArgs(t, "--urls-from-stdin")
+		if status, err := checkArgs(nil); err == nil || status != sensu.CheckStateUnknown {
+			t.Errorf("%q: expected UNKNOWN, got %d %v", input, status, err)
+		}
+	}
+}
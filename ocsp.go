@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// checkOCSPStaple checks the OCSP response the server stapled to the
+// handshake for --require-ocsp-staple: a missing staple is a warning, one
+// that is invalid, expired or revokes the certificate is critical. It returns
+// the status, the details explaining it and the ocsp_stapled and
+// ocsp_next_update_hours metrics. With --insecure-skip-verify there is no
+// verified chain, the signature of the staple is not checked and the output
+// says so.
+func checkOCSPStaple(state *tls.ConnectionState, now time.Time) (string, string, []metric) {
+	if len(state.OCSPResponse) == 0 {
+		return "WARNING", " no OCSP staple", []metric{valueMetric("ocsp_stapled", 0, "")}
+	}
+	metrics := []metric{valueMetric("ocsp_stapled", 1, "")}
+
+	var (
+		chain   = certificateChain(state)
+		issuer  *x509.Certificate
+		details string
+	)
+	if plugin.InsecureSkipVerify {
+		details = " OCSP staple signature not verified (--insecure-skip-verify)"
+	} else if len(chain) > 1 {
+		issuer = chain[1]
+	}
+	staple, err := ocsp.ParseResponseForCert(state.OCSPResponse, chain[0], issuer)
+	if err != nil {
+		return "CRITICAL", details + fmt.Sprintf(" invalid OCSP staple: %v", err), metrics
+	}
+	if !staple.NextUpdate.IsZero() {
+		metrics = append(metrics, valueMetric("ocsp_next_update_hours", math.Round(staple.NextUpdate.Sub(now).Hours()*100)/100, ""))
+	}
+
+	switch {
+	case staple.Status == ocsp.Revoked:
+		return "CRITICAL", details + fmt.Sprintf(" OCSP staple: certificate revoked at %s", staple.RevokedAt.UTC().Format(time.RFC3339)), metrics
+	case !staple.NextUpdate.IsZero() && staple.NextUpdate.Before(now):
+		return "CRITICAL", details + fmt.Sprintf(" OCSP staple expired at %s", staple.NextUpdate.UTC().Format(time.RFC3339)), metrics
+	case staple.Status != ocsp.Good:
+		return "WARNING", details + " OCSP staple: certificate status unknown", metrics
+	}
+	return "OK", details + " ocsp=good", metrics
+}
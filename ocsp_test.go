@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestExecuteCheckOCSPStaple(t *testing.T) {
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	newCert := func(template, parent *x509.Certificate, key *ecdsa.PrivateKey, signer crypto.Signer) *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		return cert
+	}
+	caKey, leafKey := newKey(), newKey()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	ca := newCert(caTemplate, caTemplate, caKey, caKey)
+	leaf := newCert(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, leafKey, caKey)
+
+	staple := func(status int, nextUpdate time.Duration, signer crypto.Signer) []byte {
+		now := time.Now()
+		der, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       status,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   now.Add(-time.Hour),
+			NextUpdate:   now.Add(nextUpdate),
+			RevokedAt:    now.Add(-time.Minute),
+		}, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw, ca.Raw}, PrivateKey: leafKey}}}
+	ts.StartTLS()
+	defer ts.Close()
+	caFile := writeCertPEM(t, t.TempDir(), ca)
+
+	tests := []struct {
+		staple []byte
+		args   []string
+		status int
+		want   []string
+	}{
+		{nil, nil, sensu.CheckStateWarning, []string{" no OCSP staple", " ocsp_stapled=0 "}},
+		{staple(ocsp.Good, 24*time.Hour, caKey), nil, sensu.CheckStateOK, []string{" ocsp=good", " ocsp_stapled=1 ", " ocsp_next_update_hours=2"}},
+		{staple(ocsp.Revoked, 24*time.Hour, caKey), nil, sensu.CheckStateCritical, []string{" OCSP staple: certificate revoked at "}},
+		{staple(ocsp.Unknown, 24*time.Hour, caKey), nil, sensu.CheckStateWarning, []string{" OCSP staple: certificate status unknown"}},
+		{staple(ocsp.Good, -time.Minute, caKey), nil, sensu.CheckStateCritical, []string{" OCSP staple expired at ", " ocsp_next_update_hours=-0.02 "}},
+		{staple(ocsp.Good, 24*time.Hour, newKey()), nil, sensu.CheckStateCritical, []string{" invalid OCSP staple: "}},
+		{staple(ocsp.Good, 24*time.Hour, newKey()), []string{"--insecure-skip-verify"}, sensu.CheckStateOK, []string{" OCSP staple signature not verified (--insecure-skip-verify) ocsp=good"}},
+	}
+	for i, tt := range tests {
+		// Every run does a new handshake, which staples the response.
+		ts.TLS.Certificates[0].OCSPStaple = tt.staple
+		args := []string{"--url", ts.URL, "--require-ocsp-staple"}
+		if len(tt.args) == 0 {
+			args = append(args, "--ca-file", caFile)
+		}
+		setup(t, append(args, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status {
+			t.Errorf("%d: expected state %d, got %d: %s", i, tt.status, status, out)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%d: expected %q in %q", i, want, out)
+			}
+		}
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	setup(t, "--url", plain.URL, "--require-ocsp-staple")
+	if status, out := run(t); status != sensu.CheckStateOK || strings.Contains(out, "ocsp") {
+		t.Errorf("expected a cleartext URL to skip the OCSP check, got %d: %s", status, out)
+	}
+}
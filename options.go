@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"github.com/spf13/cobra"
+)
+
+// stringArrayOption is a repeatable string option. Unlike
+// sensu.SlicePluginConfigOption it never splits flag values on commas, so
+// values such as HTTP headers may contain them. When set from the environment
+// or an annotation, values are separated by Separator.
+type stringArrayOption struct {
+	// Value is the slice the configured values are read into.
+	Value *[]string
+
+	// Path is the path to the Sensu annotation to consult when parsing config.
+	Path string
+
+	// Env is the environment variable to consult when parsing config.
+	Env string
+
+	// Argument is the command line argument to consult when parsing config.
+	Argument string
+
+	// Shorthand is the shorthand command line argument to consult when parsing config.
+	Shorthand string
+
+	// Separator splits multiple values given through Env or an annotation.
+	Separator string
+
+	// Usage adds help context to the command-line flag.
+	Usage string
+}
+
+var _ sensu.ConfigOption = &stringArrayOption{}
+
+// SetupFlag sets up the option's command line flag, using the environment
+// variable as its default.
+func (p *stringArrayOption) SetupFlag(cmd *cobra.Command) error {
+	cmd.Flags().StringArrayVarP(p.Value, p.Argument, p.Shorthand, p.split(os.Getenv(p.Env)), p.Usage)
+	return nil
+}
+
+// SetValue sets the option from either a JSON array or a Separator delimited
+// string.
+func (p *stringArrayOption) SetValue(valueStr string) error {
+	var values []string
+	if err := json.Unmarshal([]byte(valueStr), &values); err == nil {
+		*p.Value = values
+		return nil
+	}
+	*p.Value = p.split(valueStr)
+	return nil
+}
+
+// SetAnnotationValue sets the option value from a check annotation, falling
+// back to an entity annotation, in the same way the sensu options do.
+func (p *stringArrayOption) SetAnnotationValue(keySpace string, event *corev2.Event) (sensu.SetAnnotationResult, error) {
+	key := path.Join(keySpace, p.Path)
+	var result sensu.SetAnnotationResult
+	for _, key := range []string{strings.ToLower(key), key} {
+		var value string
+		if event.Check != nil {
+			value = event.Check.Annotations[key]
+			result.CheckAnnotation = len(value) > 0
+		}
+		if value == "" && event.Entity != nil {
+			value = event.Entity.Annotations[key]
+			result.EntityAnnotation = len(value) > 0
+		}
+		if len(value) > 0 {
+			result.AnnotationKey = key
+			result.AnnotationValue = value
+			return result, p.SetValue(value)
+		}
+	}
+	return result, nil
+}
+
+func (p *stringArrayOption) split(value string) []string {
+	var values []string
+	if len(value) == 0 {
+		return values
+	}
+	for _, v := range strings.Split(value, p.Separator) {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
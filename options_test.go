@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/spf13/cobra"
+)
+
+func TestStringArrayOptionFlag(t *testing.T) {
+	var values []string
+	opt := &stringArrayOption{
+		Env:       "TEST_STRING_ARRAY",
+		Argument:  "header",
+		Separator: "|",
+		Value:     &values,
+	}
+	t.Setenv("TEST_STRING_ARRAY", "A: 1| B: 2, 3 |")
+	cmd := &cobra.Command{}
+	if err := opt.SetupFlag(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"A: 1", "B: 2, 3"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("expected env default %q, got %q", want, values)
+	}
+	if err := cmd.ParseFlags([]string{"--header", "Accept: a, b", "--header", "X: y"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Accept: a, b", "X: y"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("expected flags %q, got %q", want, values)
+	}
+}
+
+func TestStringArrayOptionAnnotation(t *testing.T) {
+	var values []string
+	opt := &stringArrayOption{Path: "headers", Separator: "|", Value: &values}
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Check.Annotations = map[string]string{"sensu.io/plugins/test/config/headers": "A: 1|B: 2"}
+	result, err := opt.SetAnnotationValue("sensu.io/plugins/test/config", event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.CheckAnnotation {
+		t.Error("expected the check annotation to be used")
+	}
+	if want := []string{"A: 1", "B: 2"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("expected %q, got %q", want, values)
+	}
+	if err := opt.SetValue(`["C: 3|4"]`); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"C: 3|4"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("expected JSON value %q, got %q", want, values)
+	}
+}
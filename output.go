@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DoctorOgg/sensu-http-perf-go/httpperf"
+)
+
+// CheckResult is the result of a check run as printed by --output-format=json.
+// Field names are part of the output contract and must not change.
+type CheckResult struct {
+	Status        string             `json:"status"`
+	URL           string             `json:"url"`
+	HTTPStatus    int                `json:"http_status,omitempty"`
+	Message       string             `json:"message,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	Reason        string             `json:"reason"`
+	RemoteAddr    string             `json:"remote_addr,omitempty"`
+	ResolvedAddrs []string           `json:"resolved_addrs,omitempty"`
+	TLS           *httpperf.TLSInfo  `json:"tls,omitempty"`
+	TLSCipher     string             `json:"tls_cipher,omitempty"`
+	Timings       map[string]Timing  `json:"timings,omitempty"`
+	Metrics       map[string]float64 `json:"metrics,omitempty"`
+}
+
+// Timing is a phase duration in both seconds and milliseconds.
+type Timing struct {
+	Seconds      float64 `json:"seconds"`
+	Milliseconds float64 `json:"milliseconds"`
+}
+
+// addMetrics fills the timings and metrics of r from the perfdata metrics.
+func (r *CheckResult) addMetrics(metrics []metric) {
+	r.Timings = map[string]Timing{}
+	r.Metrics = map[string]float64{}
+	for _, m := range metrics {
+		if m.isDuration {
+			d := time.Duration(m.value * float64(time.Second))
+			r.Timings[m.label] = Timing{
+				Seconds:      d.Seconds(),
+				Milliseconds: float64(d) / float64(time.Millisecond),
+			}
+		} else {
+			r.Metrics[m.label] = m.value
+		}
+	}
+}
+
+// formatJSON renders r as a single line of JSON.
+func formatJSON(r CheckResult) string {
+	b, err := json.Marshal(r)
+	if err != nil {
+		// CheckResult only holds plain values, this cannot happen.
+		panic(err)
+	}
+	return string(b)
+}
+
+// printJSON writes r as a single line of JSON.
+func printJSON(r CheckResult) {
+	fmt.Println(formatJSON(r))
+}
+
+// printError reports a failure that prevented a complete measurement, along
+// with its reason token when known and the metrics measured before it
+// failed, if any.
+func printError(status, message, reason string, metrics []metric) {
+	printOutput(render(measurement{status: status, err: message, reason: reason, metrics: metrics}))
+}
+
+// printOutput writes the output of render to stdout and stderr.
+func printOutput(out, log string) {
+	fmt.Print(out)
+	fmt.Fprint(os.Stderr, log)
+}
+
+// checkName returns the --check-label that leads the human output, the
+// plugin name by default.
+func checkName() string {
+	if len(plugin.CheckLabel) > 0 {
+		return plugin.CheckLabel
+	}
+	return plugin.Name
+}
+
+// target returns the method and URL of the request as shown in the summary,
+// e.g. GET https://api.example.com/health, with the password of the URL
+// redacted and only its host with --no-url-in-output.
+func target() string {
+	u, err := url.Parse(plugin.Url)
+	switch {
+	case err != nil:
+		return plugin.Method + " " + plugin.Url
+	case plugin.NoUrlInOutput:
+		return plugin.Method + " " + u.Host
+	}
+	return plugin.Method + " " + u.Redacted()
+}
+
+// perfdata formats metrics as perfdata, every label prefixed with the
+// --check-label reduced to letters, digits and underscores, e.g.
+// api_gateway_total_request_duration.
+func perfdata(metrics []metric) string {
+	if len(plugin.CheckLabel) > 0 {
+		prefix := strings.Trim(prometheusInvalid.ReplaceAllString(plugin.CheckLabel, "_"), "_") + "_"
+		labeled := make([]metric, len(metrics))
+		for i, m := range metrics {
+			m.label = prefix + m.label
+			labeled[i] = m
+		}
+		metrics = labeled
+	}
+	return formatPerfdata(metrics, outputFormat(), plugin.LegacyOutput)
+}
+
+// render formats m in the --output-format, returning what goes to stdout and
+// what goes to stderr. Metric output formats keep stdout parseable by writing
+// the summary or the error message to stderr.
+func render(m measurement) (out, log string) {
+	if len(m.err) > 0 {
+		return renderError(m)
+	}
+	summary := fmt.Sprintf("%s %s: %s -> %s in %s (%s)%s%s", checkName(), m.status, target(), m.statusLine, formatHeadline(m.elapsed, outputFormat()), m.started.UTC().Format(time.RFC3339), m.details, reasonKey(m))
+	switch plugin.OutputFormat {
+	case "influxdb":
+		return formatInfluxDB(plugin.MetricName, metricLabels(), m.metrics, outputFormat(), time.Now()) + "\n", summary + "\n"
+	case "graphite":
+		return formatGraphite(graphiteMetricPrefix(), m.metrics, outputFormat(), time.Now()) + "\n", summary + "\n"
+	case "prometheus":
+		return formatPrometheus(plugin.MetricName, metricLabels(), m.metrics, true) + "\n", summary + "\n"
+	case "json":
+		result := CheckResult{
+			Status:        m.status,
+			URL:           plugin.Url,
+			HTTPStatus:    m.httpStatus,
+			Message:       strings.TrimSpace(m.details),
+			Reason:        reasonOf(m),
+			RemoteAddr:    m.remoteAddr,
+			ResolvedAddrs: m.dnsAddrs,
+			TLS:           httpperf.NewTLSInfo(m.tls),
+		}
+		if m.tls != nil {
+			result.TLSCipher = tls.CipherSuiteName(m.tls.CipherSuite)
+		}
+		result.addMetrics(m.metrics)
+		return formatJSON(result) + "\n", ""
+	}
+	return fmt.Sprintf("%s | %s\n", summary, perfdata(m.metrics)), ""
+}
+
+// renderError formats a failed measurement, see render.
+func renderError(m measurement) (out, log string) {
+	if plugin.OutputFormat == "json" {
+		result := CheckResult{Status: m.status, URL: plugin.Url, Error: m.err, Reason: reasonOf(m)}
+		if len(m.metrics) > 0 {
+			result.addMetrics(m.metrics)
+		}
+		return formatJSON(result) + "\n", ""
+	}
+	message := m.err + reasonKey(m)
+	log = fmt.Sprintf("%s %s: %s\n", checkName(), m.status, message)
+	switch plugin.OutputFormat {
+	case "prometheus":
+		return formatPrometheus(plugin.MetricName, metricLabels(), m.metrics, false) + "\n", log
+	case "influxdb":
+		if len(m.metrics) > 0 {
+			out = formatInfluxDB(plugin.MetricName, metricLabels(), m.metrics, outputFormat(), time.Now()) + "\n"
+		}
+		return out, log
+	case "graphite":
+		if len(m.metrics) > 0 {
+			out = formatGraphite(graphiteMetricPrefix(), m.metrics, outputFormat(), time.Now()) + "\n"
+		}
+		return out, log
+	}
+	if len(m.metrics) > 0 {
+		message += " | " + perfdata(m.metrics)
+	}
+	return fmt.Sprintf("%s %s: %s\n", checkName(), m.status, message), ""
+}
+
+// influxEscaper escapes measurement names, tag keys and tag values in the
+// InfluxDB line protocol.
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// formatInfluxDB renders metrics as a single InfluxDB line protocol point
+// with a nanosecond timestamp.
+func formatInfluxDB(measurement string, tags map[string]string, metrics []metric, f durationFormat, ts time.Time) string {
+	var b strings.Builder
+	b.WriteString(influxEscaper.Replace(measurement))
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, ",%s=%s", influxEscaper.Replace(k), influxEscaper.Replace(tags[k]))
+	}
+	for i, m := range metrics {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		fmt.Fprintf(&b, "%s%s=%s", sep, influxEscaper.Replace(m.label), strconv.FormatFloat(m.scaled(f), 'f', -1, 64))
+	}
+	fmt.Fprintf(&b, " %d", ts.UnixNano())
+	return b.String()
+}
+
+var graphiteInvalid = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// graphitePrefix derives the default metric prefix from the URL host, keeping
+// dots but replacing slashes, colons and other separators.
+func graphitePrefix(rawURL string) string {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && len(u.Host) > 0 {
+		host = u.Host
+	}
+	return strings.Trim(graphiteInvalid.ReplaceAllString(host, "_"), ".")
+}
+
+// graphiteMetricPrefix returns the --metric-prefix, derived from the URL
+// host when not set.
+func graphiteMetricPrefix() string {
+	if len(plugin.MetricPrefix) > 0 {
+		return plugin.MetricPrefix
+	}
+	return graphitePrefix(plugin.Url)
+}
+
+// formatGraphite renders metrics as Graphite plaintext lines with a timestamp
+// in seconds.
+func formatGraphite(prefix string, metrics []metric, f durationFormat, ts time.Time) string {
+	lines := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		lines = append(lines, fmt.Sprintf("%s.%s %s %d", prefix, m.label, strconv.FormatFloat(m.scaled(f), 'f', -1, 64), ts.Unix()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	prometheusInvalid = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+	prometheusEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// prometheusName turns s into a valid Prometheus metric or label name.
+func prometheusName(s string) string {
+	s = prometheusInvalid.ReplaceAllString(s, "_")
+	if len(s) == 0 || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	return s
+}
+
+// prometheusLabels renders labels as {name="value",...} sorted by name.
+func prometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", prometheusName(k), prometheusEscaper.Replace(labels[k])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatPrometheus renders metrics in the Prometheus text exposition format.
+// Durations are always exposed in seconds, the Prometheus base unit, and
+// <prefix>_up reports whether the request succeeded.
+func formatPrometheus(prefix string, labels map[string]string, metrics []metric, up bool) string {
+	var b strings.Builder
+	l := prometheusLabels(labels)
+	gauge := func(name, help, value string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s%s %s\n", name, help, name, name, l, value)
+	}
+	upValue := "0"
+	if up {
+		upValue = "1"
+	}
+	gauge(prometheusName(prefix+"_up"), "Whether the HTTP request succeeded.", upValue)
+	for _, m := range metrics {
+		if m.label == "up" {
+			continue
+		}
+		name := prometheusName(prefix + "_" + m.label)
+		if m.isDuration {
+			name += "_seconds"
+		}
+		gauge(name, "HTTP check "+strings.ReplaceAll(m.label, "_", " ")+".", strconv.FormatFloat(m.value, 'f', -1, 64))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// metricLabels returns the url tag along with the --metric-tag tags.
+func metricLabels() map[string]string {
+	labels := map[string]string{"url": plugin.Url}
+	for k, v := range metricTags {
+		labels[k] = v
+	}
+	return labels
+}
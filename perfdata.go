@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metric is a single perfdata value.
+type metric struct {
+	label string
+
+	// isDuration marks timing metrics, which are rendered in the configured
+	// output unit. value then holds seconds.
+	isDuration bool
+	value      float64
+
+	// uom is the unit of measurement of non-duration metrics.
+	uom string
+
+	// warning and critical are the thresholds in the same unit as value, or
+	// nil when the metric has none.
+	warning, critical *float64
+
+	// below marks thresholds that alert when the value drops under them,
+	// rendered as the Nagios "N:" range.
+	below bool
+}
+
+// durationMetric returns a timing metric with optional thresholds in seconds,
+// where zero disables a threshold.
+func durationMetric(label string, d time.Duration, warning, critical float32) metric {
+	return metric{
+		label:      label,
+		isDuration: true,
+		value:      d.Seconds(),
+		warning:    threshold(warning),
+		critical:   threshold(critical),
+	}
+}
+
+// valueMetric returns a metric with a plain value.
+func valueMetric(label string, value float64, uom string) metric {
+	return metric{label: label, value: value, uom: uom}
+}
+
+// threshold converts a float32 flag value into a metric threshold.
+func threshold(value float32) *float64 {
+	if value <= 0 {
+		return nil
+	}
+	// Round trip through the float32 representation so that 0.1 is 0.1.
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(value), 'f', -1, 32), 64)
+	return &f
+}
+
+// durationFormat is how durations are rendered, in the --output-unit with
+// --precision decimals. A negative precision uses the default of the unit.
+type durationFormat struct {
+	unit      string
+	precision int
+}
+
+// durationUnits maps every output unit to its factor from seconds and its
+// default number of decimals in the perfdata and in the headline.
+var durationUnits = map[string]struct {
+	scale              float64
+	perfdata, headline int
+}{
+	"s":  {1, 6, 6},
+	"ms": {1e3, 2, 6},
+	"us": {1e6, 0, 0},
+}
+
+// scale returns the factor converting seconds to the unit of f.
+func (f durationFormat) scale() float64 {
+	return durationUnits[f.unit].scale
+}
+
+// perfdataPrecision returns the decimals of durations in the perfdata.
+func (f durationFormat) perfdataPrecision() int {
+	if f.precision >= 0 {
+		return f.precision
+	}
+	return durationUnits[f.unit].perfdata
+}
+
+// headlinePrecision returns the decimals of the headline duration.
+func (f durationFormat) headlinePrecision() int {
+	if f.precision >= 0 {
+		return f.precision
+	}
+	return durationUnits[f.unit].headline
+}
+
+// scaled returns the metric value with durations in the unit of f.
+func (m metric) scaled(f durationFormat) float64 {
+	if m.isDuration {
+		return m.value * f.scale()
+	}
+	return m.value
+}
+
+// formatPerfdata renders metrics either as Nagios perfdata tokens
+// (label=value[UOM];warn;crit;min;max separated by spaces) or, in legacy
+// mode, as the comma separated label=value list of earlier releases.
+func formatPerfdata(metrics []metric, f durationFormat, legacy bool) string {
+	tokens := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		value, uom := m.value, m.uom
+		scale := 1.0
+		precision := -1
+		if m.isDuration {
+			uom, scale, precision = f.unit, f.scale(), f.perfdataPrecision()
+		}
+		formatted := strconv.FormatFloat(value*scale, 'f', precision, 64)
+		if legacy {
+			tokens = append(tokens, m.label+"="+formatted)
+			continue
+		}
+		fields := []string{
+			m.label + "=" + formatted + uom,
+			formatThreshold(m.warning, scale, m.below),
+			formatThreshold(m.critical, scale, m.below),
+		}
+		if m.isDuration {
+			fields = append(fields, "0")
+		}
+		tokens = append(tokens, strings.TrimRight(strings.Join(fields, ";"), ";"))
+	}
+	if legacy {
+		return strings.Join(tokens, ", ")
+	}
+	return strings.Join(tokens, " ")
+}
+
+func formatThreshold(t *float64, scale float64, below bool) string {
+	if t == nil {
+		return ""
+	}
+	if below {
+		return strconv.FormatFloat(*t*scale, 'f', -1, 32) + ":"
+	}
+	return strconv.FormatFloat(*t*scale, 'f', -1, 32)
+}
+
+// formatHeadline renders the total duration shown before the perfdata.
+func formatHeadline(d time.Duration, f durationFormat) string {
+	return strconv.FormatFloat(d.Seconds()*f.scale(), 'f', f.headlinePrecision(), 64) + f.unit
+}
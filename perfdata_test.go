@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// The duration formats of the units with their default precision.
+var (
+	seconds      = durationFormat{unit: "s", precision: -1}
+	milliseconds = durationFormat{unit: "ms", precision: -1}
+	microseconds = durationFormat{unit: "us", precision: -1}
+)
+
+func TestFormatPerfdata(t *testing.T) {
+	fortyTwo, fifty := 40.0, 50.0
+	metrics := []metric{
+		durationMetric("dns_duration", 47340*time.Microsecond, 0, 0),
+		durationMetric("tls_handshake_duration", 89218*time.Microsecond, 0.1, 0),
+		durationMetric("connect_duration", 49823*time.Microsecond, 0, 0),
+		durationMetric("first_byte_duration", 601708*time.Microsecond, 0, 0),
+		durationMetric("total_request_duration", 790421*time.Microsecond, 1, 2),
+		valueMetric("request_body_bytes", 13, "B"),
+		valueMetric("http_status", 200, ""),
+		{label: "data_queue_depth", value: 42, warning: &fortyTwo, critical: &fifty},
+		{label: "download_throughput_bytes_per_sec", value: 51200, warning: &fifty, critical: &fortyTwo, below: true},
+	}
+	tests := []struct {
+		name   string
+		format durationFormat
+		legacy bool
+		want   string
+	}{
+		{"nagios seconds", seconds, false, "dns_duration=0.047340s;;;0 tls_handshake_duration=0.089218s;0.1;;0 connect_duration=0.049823s;;;0 first_byte_duration=0.601708s;;;0 total_request_duration=0.790421s;1;2;0 request_body_bytes=13B http_status=200 data_queue_depth=42;40;50 download_throughput_bytes_per_sec=51200;50:;40:"},
+		{"nagios milliseconds", milliseconds, false, "dns_duration=47.34ms;;;0 tls_handshake_duration=89.22ms;100;;0 connect_duration=49.82ms;;;0 first_byte_duration=601.71ms;;;0 total_request_duration=790.42ms;1000;2000;0 request_body_bytes=13B http_status=200 data_queue_depth=42;40;50 download_throughput_bytes_per_sec=51200;50:;40:"},
+		{"legacy seconds", seconds, true, "dns_duration=0.047340, tls_handshake_duration=0.089218, connect_duration=0.049823, first_byte_duration=0.601708, total_request_duration=0.790421, request_body_bytes=13, http_status=200, data_queue_depth=42, download_throughput_bytes_per_sec=51200"},
+		{"legacy milliseconds", milliseconds, true, "dns_duration=47.34, tls_handshake_duration=89.22, connect_duration=49.82, first_byte_duration=601.71, total_request_duration=790.42, request_body_bytes=13, http_status=200, data_queue_depth=42, download_throughput_bytes_per_sec=51200"},
+	}
+	for _, tt := range tests {
+		if got := formatPerfdata(metrics, tt.format, tt.legacy); got != tt.want {
+			t.Errorf("%s:\nexpected %s\n     got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestFormatHeadline(t *testing.T) {
+	tests := []struct {
+		d      time.Duration
+		format durationFormat
+		want   string
+	}{
+		{790421 * time.Microsecond, seconds, "0.790421s"},
+		{790421 * time.Microsecond, milliseconds, "790.421000ms"},
+		{790421 * time.Microsecond, microseconds, "790421us"},
+		{time.Second, seconds, "1.000000s"},
+		{time.Second, milliseconds, "1000.000000ms"},
+		{time.Second, microseconds, "1000000us"},
+		{time.Second, durationFormat{unit: "s", precision: 0}, "1s"},
+		{time.Second - time.Microsecond, durationFormat{unit: "s", precision: 3}, "1.000s"},
+		{1500 * time.Nanosecond, microseconds, "2us"},
+		{1500 * time.Nanosecond, durationFormat{unit: "us", precision: 1}, "1.5us"},
+	}
+	for _, tt := range tests {
+		if got := formatHeadline(tt.d, tt.format); got != tt.want {
+			t.Errorf("%s in %+v: expected %q, got %q", tt.d, tt.format, tt.want, got)
+		}
+	}
+}
+
+func TestFormatPerfdataUnits(t *testing.T) {
+	metrics := []metric{durationMetric("total_request_duration", time.Second, 0.5, 1)}
+	tests := []struct {
+		format durationFormat
+		want   string
+	}{
+		{seconds, "total_request_duration=1.000000s;0.5;1;0"},
+		{milliseconds, "total_request_duration=1000.00ms;500;1000;0"},
+		{microseconds, "total_request_duration=1000000us;500000;1000000;0"},
+		{durationFormat{unit: "ms", precision: 0}, "total_request_duration=1000ms;500;1000;0"},
+		{durationFormat{unit: "s", precision: 3}, "total_request_duration=1.000s;0.5;1;0"},
+	}
+	for _, tt := range tests {
+		if got := formatPerfdata(metrics, tt.format, false); got != tt.want {
+			t.Errorf("%+v: expected %q, got %q", tt.format, tt.want, got)
+		}
+	}
+}
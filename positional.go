@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// urlArgument is the URL given as the positional argument, used when --url
+// is not on the command line.
+var urlArgument string
+
+// takeURLArgument removes a single positional argument from args, e.g. the
+// URL of "sensu-http-perf-go https://example.com", and keeps it in
+// urlArgument unless --url is given too. The plugin SDK rejects positional
+// arguments, so they have to go before it parses the command line. The
+// version and help commands are left alone.
+func takeURLArgument(args []string) []string {
+	urlArgument = ""
+	cmd := &cobra.Command{}
+	for _, opt := range options {
+		if err := opt.SetupFlag(cmd); err != nil {
+			return args
+		}
+	}
+	flags := cmd.Flags()
+
+	var positional []int
+	urlFlag := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			for j := i + 1; j < len(args); j++ {
+				positional = append(positional, j)
+			}
+			i = len(args)
+		case strings.HasPrefix(arg, "--"):
+			name, _, hasValue := strings.Cut(arg[2:], "=")
+			urlFlag = urlFlag || name == "url"
+			if f := flags.Lookup(name); f != nil && !hasValue && len(f.NoOptDefVal) == 0 {
+				i++
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			// Shorthands may be combined, the last one takes the rest of
+			// the argument or the next one as its value.
+			for j := 1; j < len(arg); j++ {
+				f := flags.ShorthandLookup(arg[j : j+1])
+				if f == nil || len(f.NoOptDefVal) > 0 {
+					continue
+				}
+				urlFlag = urlFlag || f.Name == "url"
+				if j == len(arg)-1 {
+					i++
+				}
+				break
+			}
+		default:
+			positional = append(positional, i)
+		}
+	}
+
+	if len(positional) != 1 {
+		return args
+	}
+	switch value := args[positional[0]]; value {
+	case "version", "help", "completion":
+		return args
+	default:
+		if !urlFlag {
+			urlArgument = value
+		}
+	}
+	rest := append([]string{}, args[:positional[0]]...)
+	return append(rest, args[positional[0]+1:]...)
+}
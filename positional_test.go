@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTakeURLArgument(t *testing.T) {
+	tests := []struct {
+		args []string
+		url  string
+		rest []string
+	}{
+		{[]string{"https://example.com"}, "https://example.com", []string{}},
+		{[]string{"--timeout", "5", "https://example.com", "-v"}, "https://example.com", []string{"--timeout", "5", "-v"}},
+		{[]string{"-T", "5", "--insecure-skip-verify", "https://example.com"}, "https://example.com", []string{"-T", "5", "--insecure-skip-verify"}},
+		{[]string{"-vT5", "https://example.com"}, "https://example.com", []string{"-vT5"}},
+		{[]string{"--warning=1", "--", "https://example.com"}, "https://example.com", []string{"--warning=1", "--"}},
+		// The flag wins, the positional URL is dropped.
+		{[]string{"--url", "https://flag.example.com", "https://example.com"}, "", []string{"--url", "https://flag.example.com"}},
+		{[]string{"https://example.com", "-u", "https://flag.example.com"}, "", []string{"-u", "https://flag.example.com"}},
+		{[]string{"--url=https://flag.example.com", "https://example.com"}, "", []string{"--url=https://flag.example.com"}},
+		// Left to the plugin SDK.
+		{[]string{"--timeout", "5"}, "", []string{"--timeout", "5"}},
+		{[]string{"version"}, "", []string{"version"}},
+		{[]string{"https://a.example.com", "https://b.example.com"}, "", []string{"https://a.example.com", "https://b.example.com"}},
+	}
+	for _, tt := range tests {
+		rest := takeURLArgument(tt.args)
+		if urlArgument != tt.url || !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("%q: expected %q and %q, got %q and %q", tt.args, tt.url, tt.rest, urlArgument, rest)
+		}
+	}
+	urlArgument = ""
+}
+
+func TestCheckArgsURLArgument(t *testing.T) {
+	args := takeURLArgument([]string{"https://example.com/health", "--timeout", "5"})
+	arg := urlArgument
+	parseArgs(t, args...)
+	urlArgument = arg
+	defer func() { urlArgument = "" }()
+	if _, err := checkArgs(nil); err != nil {
+		t.Fatal(err)
+	}
+	if plugin.Url != "https://example.com/health" || plugin.Timeout != 5 {
+		t.Errorf("expected the positional URL, got %s", plugin.Url)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/DoctorOgg/sensu-http-perf-go/httpperf"
+)
+
+// parseProxy parses --proxy, an http, https or socks5 URL with optional
+// user:password credentials.
+func parseProxy(value string) (*url.URL, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || len(u.Hostname()) == 0 {
+		return nil, fmt.Errorf("invalid --proxy %q, expected scheme://host:port", value)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported --proxy scheme %q, must be one of http, https or socks5", u.Scheme)
+	}
+	return u, nil
+}
+
+// proxyFor returns the proxy req goes through, the --proxy one or unless
+// --no-proxy-env the one from HTTP_PROXY, HTTPS_PROXY and NO_PROXY. It
+// returns nil when the request goes direct, as over a --unix-socket.
+func proxyFor(req *http.Request) (*url.URL, error) {
+	if len(plugin.UnixSocket) > 0 {
+		return nil, nil
+	}
+	if proxyURL != nil {
+		return proxyURL, nil
+	}
+	if !plugin.NoProxyEnv {
+		return http.ProxyFromEnvironment(req)
+	}
+	return nil, nil
+}
+
+// requestProxy returns the proxy req went through, or nil. HTTP/3 and h2c
+// requests never use one, whatever the environment says.
+func requestProxy(req *http.Request) *url.URL {
+	if plugin.Http3 && req.URL.Scheme == "https" || plugin.HttpVersion == "2" && req.URL.Scheme == "http" {
+		return nil
+	}
+	proxy, err := proxyFor(req)
+	if err != nil {
+		return nil
+	}
+	return proxy
+}
+
+// proxyPassword returns the password of the --proxy credentials, which is
+// redacted from error messages.
+func proxyPassword() string {
+	if proxyURL == nil {
+		return ""
+	}
+	password, _ := proxyURL.User.Password()
+	return password
+}
+
+// proxyConnectDuration returns the time spent setting up the tunnel through
+// the proxy of req, from the proxy connection to the start of the TLS
+// handshake with the server, or to the connection being ready when there is
+// no TLS. Plain http requests through an http proxy have no tunnel.
+func proxyConnectDuration(req *http.Request, t httpperf.Timings) (time.Duration, bool) {
+	proxy := requestProxy(req)
+	if proxy == nil || t.ConnectDone.IsZero() {
+		return 0, false
+	}
+	if req.URL.Scheme != "https" && !strings.EqualFold(proxy.Scheme, "socks5") {
+		return 0, false
+	}
+	end := t.TLSHandshakeStart
+	if end.IsZero() {
+		end = t.GotConn
+	}
+	return end.Sub(t.ConnectDone), true
+}
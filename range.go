@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// byteRange is the inclusive range of bytes --range asks for.
+type byteRange struct {
+	first, last int64
+}
+
+// size returns the number of bytes in r.
+func (r byteRange) size() int64 {
+	return r.last - r.first + 1
+}
+
+// validateRange parses --range, e.g. "0-1023" or "bytes=0-1023". Only a
+// single closed range is supported, the size of the body to expect has to
+// be known.
+func validateRange() error {
+	requestRange = nil
+	if len(plugin.Range) == 0 {
+		return nil
+	}
+	spec := strings.TrimPrefix(strings.TrimSpace(plugin.Range), "bytes=")
+	first, last, ok := strings.Cut(spec, "-")
+	r := byteRange{}
+	var err1, err2 error
+	r.first, err1 = strconv.ParseInt(first, 10, 64)
+	r.last, err2 = strconv.ParseInt(last, 10, 64)
+	if !ok || err1 != nil || err2 != nil || r.first < 0 || r.last < r.first {
+		return fmt.Errorf("invalid --range %q, expected first-last byte positions, e.g. 0-1023", plugin.Range)
+	}
+	if r.size() > plugin.MaxBodyBytes {
+		return fmt.Errorf("--range of %d bytes exceeds --max-body-bytes %d", r.size(), plugin.MaxBodyBytes)
+	}
+	requestRange = &r
+	return nil
+}
+
+// parseContentRange parses the Content-Range of a 206 response, e.g.
+// "bytes 0-1023/146515" or "bytes 0-1023/*" when the size is unknown, which
+// is returned as -1.
+func parseContentRange(header string) (byteRange, int64, error) {
+	unit, spec, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || unit != "bytes" {
+		return byteRange{}, 0, fmt.Errorf("expected bytes first-last/size")
+	}
+	positions, size, ok := strings.Cut(spec, "/")
+	if !ok {
+		return byteRange{}, 0, fmt.Errorf("missing the size")
+	}
+	first, last, ok := strings.Cut(positions, "-")
+	if !ok {
+		return byteRange{}, 0, fmt.Errorf("expected first-last byte positions")
+	}
+	var (
+		r   byteRange
+		err error
+	)
+	if r.first, err = strconv.ParseInt(first, 10, 64); err != nil || r.first < 0 {
+		return byteRange{}, 0, fmt.Errorf("invalid first byte position %q", first)
+	}
+	if r.last, err = strconv.ParseInt(last, 10, 64); err != nil || r.last < r.first {
+		return byteRange{}, 0, fmt.Errorf("invalid last byte position %q", last)
+	}
+	total := int64(-1)
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil || total <= r.last {
+			return byteRange{}, 0, fmt.Errorf("invalid size %q", size)
+		}
+	}
+	return r, total, nil
+}
+
+// checkRange verifies the response to a --range request: a 206 whose
+// Content-Range is the requested range, cut short only at the end of the
+// resource, with a body of that many bytes. It returns the status the status
+// code and the body each earn, and the details explaining them.
+func checkRange(resp *http.Response, n int64) (codeStatus, bodyStatus, details string) {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return "CRITICAL", "OK", " (range not supported, expected 206)"
+	case resp.StatusCode != http.StatusPartialContent:
+		return "CRITICAL", "OK", " (expected 206 for --range)"
+	}
+	header := resp.Header.Get("Content-Range")
+	if len(header) == 0 {
+		return "OK", "CRITICAL", " 206 without a Content-Range"
+	}
+	got, total, err := parseContentRange(header)
+	if err != nil {
+		return "OK", "CRITICAL", fmt.Sprintf(" invalid Content-Range %q: %v", header, err)
+	}
+	want := *requestRange
+	if total >= 0 && want.last >= total {
+		want.last = total - 1
+	}
+	if got != want {
+		return "OK", "CRITICAL", fmt.Sprintf(" Content-Range %q does not match --range %d-%d", header, requestRange.first, requestRange.last)
+	}
+	if n != got.size() {
+		return "OK", "CRITICAL", fmt.Sprintf(" range body of %d bytes, expected %d", n, got.size())
+	}
+	return "OK", "OK", ""
+}
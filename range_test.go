@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   byteRange
+		total  int64
+	}{
+		{"bytes 0-1023/146515", byteRange{0, 1023}, 146515},
+		{"bytes 100-199/*", byteRange{100, 199}, -1},
+	}
+	for _, tt := range tests {
+		got, total, err := parseContentRange(tt.header)
+		if err != nil || got != tt.want || total != tt.total {
+			t.Errorf("%q: expected %v/%d, got %v/%d %v", tt.header, tt.want, tt.total, got, total, err)
+		}
+	}
+	for _, bad := range []string{"", "bytes */1000", "items 0-9/10", "bytes 0-9", "bytes 9-0/10", "bytes 0-9/5", "bytes a-9/10"} {
+		if _, _, err := parseContentRange(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestValidateRange(t *testing.T) {
+	for _, bad := range []string{"1023", "-1023", "0-", "10-5", "a-b", "0-2000000000"} {
+		parseArgs(t, "--url", "http://example.com/", "--range", bad)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("expected --range %q to be rejected", bad)
+		}
+	}
+	setup(t, "--url", "http://example.com/", "--range", "bytes=10-19")
+	if requestRange == nil || *requestRange != (byteRange{10, 19}) {
+		t.Errorf("expected the range 10-19, got %v", requestRange)
+	}
+}
+
+func TestExecuteCheckRange(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 200)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/full":
+			w.Write(content)
+		case "/bad":
+			w.Header().Set("Content-Range", "bytes 0-99")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[:100])
+		case "/wrong":
+			w.Header().Set("Content-Range", "bytes 0-49/2000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[:50])
+		default:
+			http.ServeContent(w, r, "media.bin", time.Time{}, bytes.NewReader(content))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--url", server.URL, "--range", "0-99"}, sensu.CheckStateOK, "-> 206 Partial Content in "},
+		{[]string{"--url", server.URL, "--range", "1900-2999"}, sensu.CheckStateOK, "range_bytes=100B"},
+		{[]string{"--url", server.URL + "/full", "--range", "0-99"}, sensu.CheckStateCritical, "(range not supported, expected 206)"},
+		{[]string{"--url", server.URL + "/bad", "--range", "0-99"}, sensu.CheckStateCritical, `invalid Content-Range "bytes 0-99": missing the size`},
+		{[]string{"--url", server.URL + "/wrong", "--range", "0-99"}, sensu.CheckStateCritical, `Content-Range "bytes 0-49/2000" does not match --range 0-99`},
+	}
+	for _, tt := range tests {
+		setup(t, tt.args...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected state %d with %q, got %d: %s", tt.args, tt.status, tt.want, status, out)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ratelimitHeaders are the headers the remaining request count is read
+// from unless --ratelimit-header names another one.
+var ratelimitHeaders = []string{"X-RateLimit-Remaining", "RateLimit-Remaining", "X-Rate-Limit-Remaining", "RateLimit"}
+
+// ratelimitRemaining returns the number of requests left before the API
+// throttles, ok is false when the response does not say. A header listing
+// several policies counts the most constrained one, and the structured
+// RateLimit header of the IETF drafts gives it as "remaining=N" or "r=N".
+func ratelimitRemaining(header http.Header) (int64, bool) {
+	names := ratelimitHeaders
+	if len(plugin.RatelimitHeader) > 0 {
+		names = []string{plugin.RatelimitHeader}
+	}
+	for _, name := range names {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		remaining, found := int64(-1), false
+		for _, item := range strings.Split(strings.Join(values, ","), ",") {
+			for _, field := range strings.Split(item, ";") {
+				field = strings.TrimSpace(field)
+				if key, value, ok := strings.Cut(field, "="); ok {
+					if key = strings.ToLower(strings.TrimSpace(key)); key != "remaining" && key != "r" {
+						continue
+					}
+					field = strings.TrimSpace(value)
+				}
+				n, err := strconv.ParseInt(field, 10, 64)
+				if err != nil || n < 0 {
+					continue
+				}
+				if !found || n < remaining {
+					remaining, found = n, true
+				}
+				break
+			}
+		}
+		if found {
+			return remaining, true
+		}
+	}
+	return 0, false
+}
+
+// checkRatelimit compares the remaining request count of a response against
+// --ratelimit-warning and --ratelimit-critical, which alert when it drops
+// below them. It returns the status, the details explaining it and the
+// ratelimit_remaining metric, nil when the response has no such header.
+func checkRatelimit(header http.Header) (string, string, *metric) {
+	remaining, ok := ratelimitRemaining(header)
+	if !ok {
+		return "OK", "", nil
+	}
+	status, details := "OK", ""
+	switch {
+	case plugin.RatelimitCritical > 0 && remaining < plugin.RatelimitCritical:
+		status = "CRITICAL"
+		details = fmt.Sprintf(" ratelimit_remaining %d < %d", remaining, plugin.RatelimitCritical)
+	case plugin.RatelimitWarning > 0 && remaining < plugin.RatelimitWarning:
+		status = "WARNING"
+		details = fmt.Sprintf(" ratelimit_remaining %d < %d", remaining, plugin.RatelimitWarning)
+	}
+	return status, details, &metric{
+		label:    "ratelimit_remaining",
+		value:    float64(remaining),
+		warning:  intThreshold(plugin.RatelimitWarning),
+		critical: intThreshold(plugin.RatelimitCritical),
+		below:    true,
+	}
+}
+
+// rateLimited describes a 429 response along with its Retry-After.
+func rateLimited(header http.Header) string {
+	if retryAfter := strings.TrimSpace(header.Get("Retry-After")); len(retryAfter) > 0 {
+		return " rate limited, Retry-After " + retryAfter
+	}
+	return " rate limited"
+}
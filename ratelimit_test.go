@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestRatelimitRemaining(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		custom string
+		want   int64
+		ok     bool
+	}{
+		{http.Header{}, "", 0, false},
+		{http.Header{"X-Ratelimit-Remaining": {"42"}}, "", 42, true},
+		{http.Header{"Ratelimit-Remaining": {"100, 7;w=60"}}, "", 7, true},
+		{http.Header{"Ratelimit": {"limit=100, remaining=12, reset=30"}}, "", 12, true},
+		{http.Header{"Ratelimit": {`"default";r=3;t=30`}}, "", 3, true},
+		{http.Header{"X-Ratelimit-Remaining": {"soon"}}, "", 0, false},
+		{http.Header{"X-Api-Quota-Left": {"9"}, "X-Ratelimit-Remaining": {"42"}}, "x-api-quota-left", 9, true},
+	}
+	for _, tt := range tests {
+		plugin.RatelimitHeader = tt.custom
+		if got, ok := ratelimitRemaining(tt.header); got != tt.want || ok != tt.ok {
+			t.Errorf("%v: expected %d %t, got %d %t", tt.header, tt.want, tt.ok, got, ok)
+		}
+	}
+	plugin.RatelimitHeader = ""
+}
+
+func TestExecuteCheckRatelimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if remaining := r.URL.Query().Get("remaining"); len(remaining) > 0 {
+			w.Header().Set("X-RateLimit-Remaining", remaining)
+		}
+		if r.URL.Path == "/throttled" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path    string
+		args    []string
+		status  int
+		want    string
+		notWant string
+	}{
+		{"/", []string{"--ratelimit-warning", "10"}, sensu.CheckStateOK, "OK: GET " + ts.URL + "/ -> 200 OK", "ratelimit_remaining"},
+		{"/?remaining=500", []string{"--ratelimit-warning", "100", "--ratelimit-critical", "10"}, sensu.CheckStateOK, " ratelimit_remaining=500;100:;10: ", ""},
+		{"/?remaining=50", []string{"--ratelimit-warning", "100", "--ratelimit-critical", "10"}, sensu.CheckStateWarning, " ratelimit_remaining 50 < 100", ""},
+		{"/?remaining=0", []string{"--ratelimit-warning", "100", "--ratelimit-critical", "10"}, sensu.CheckStateCritical, " ratelimit_remaining 0 < 10", ""},
+		{"/throttled?remaining=0", nil, sensu.CheckStateCritical, "429 Too Many Requests in ", ""},
+		{"/throttled", []string{"--status-ok-anything"}, sensu.CheckStateCritical, " rate limited, Retry-After 30", ""},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL + tt.path}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%s %q: expected %d with %q, got %d: %s", tt.path, tt.args, tt.status, tt.want, status, out)
+		}
+		if len(tt.notWant) > 0 && strings.Contains(out, tt.notWant) {
+			t.Errorf("%s %q: expected no %q in %s", tt.path, tt.args, tt.notWant, out)
+		}
+	}
+
+	parseArgs(t, "--url", ts.URL, "--ratelimit-warning", "10", "--ratelimit-critical", "100")
+	if _, err := checkArgs(nil); err == nil {
+		t.Errorf("expected --ratelimit-warning below --ratelimit-critical to be rejected")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+// The reason token ends the human line of every run, e.g. "reason=timeout",
+// so that event filters can route on it instead of on the wording of the
+// message. The values are part of the output contract and must not change.
+const (
+	reasonOK                = "ok"
+	reasonTimeout           = "timeout"
+	reasonDNS               = "dns_error"
+	reasonConnRefused       = "conn_refused"
+	reasonConnection        = "conn_error"
+	reasonTLS               = "tls_error"
+	reasonCancelled         = "cancelled"
+	reasonConfig            = "config_error"
+	reasonPreRequest        = "pre_request_failed"
+	reasonRedirect          = "redirect_error"
+	reasonRequest           = "request_error"
+	reasonStatusMismatch    = "status_mismatch"
+	reasonBodyMismatch      = "body_mismatch"
+	reasonJSON              = "json_error"
+	reasonHeaderMismatch    = "header_mismatch"
+	reasonCORS              = "cors_mismatch"
+	reasonProtocol          = "protocol_mismatch"
+	reasonTLSVersion        = "tls_version_mismatch"
+	reasonWeakCipher        = "weak_cipher"
+	reasonALPN              = "alpn_mismatch"
+	reasonCertMismatch      = "cert_mismatch"
+	reasonOCSP              = "ocsp_error"
+	reasonNotRevalidated    = "not_revalidated"
+	reasonNotReused         = "conn_not_reused"
+	reasonNotResumed        = "tls_not_resumed"
+	reasonThresholdWarning  = "threshold_warning"
+	reasonThresholdCritical = "threshold_critical"
+)
+
+// reasonOf returns the reason token of m: the one of what failed the request
+// or set the status, or else of the breached threshold.
+func reasonOf(m measurement) string {
+	switch {
+	case len(m.reason) > 0:
+		return m.reason
+	case len(m.err) > 0:
+		return reasonRequest
+	case m.status == "OK":
+		return reasonOK
+	case m.status == "WARNING":
+		return reasonThresholdWarning
+	}
+	return reasonThresholdCritical
+}
+
+// raise makes status the status of m when it is worse, along with reason,
+// the reason token of what set it. Thresholds leave reason empty.
+func (m *measurement) raise(status, reason string) {
+	if worseStatus(m.status, status) != m.status {
+		m.status, m.reason = status, reason
+	}
+}
+
+// reasonKey formats the reason token of m as it ends the human line.
+func reasonKey(m measurement) string {
+	return " reason=" + reasonOf(m)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestReasonTokens(t *testing.T) {
+	// Event filters match on these, they must never change.
+	for token, want := range map[string]string{
+		reasonOK:                "ok",
+		reasonTimeout:           "timeout",
+		reasonDNS:               "dns_error",
+		reasonConnRefused:       "conn_refused",
+		reasonConnection:        "conn_error",
+		reasonTLS:               "tls_error",
+		reasonCancelled:         "cancelled",
+		reasonConfig:            "config_error",
+		reasonPreRequest:        "pre_request_failed",
+		reasonRedirect:          "redirect_error",
+		reasonRequest:           "request_error",
+		reasonStatusMismatch:    "status_mismatch",
+		reasonBodyMismatch:      "body_mismatch",
+		reasonJSON:              "json_error",
+		reasonHeaderMismatch:    "header_mismatch",
+		reasonCORS:              "cors_mismatch",
+		reasonProtocol:          "protocol_mismatch",
+		reasonTLSVersion:        "tls_version_mismatch",
+		reasonWeakCipher:        "weak_cipher",
+		reasonALPN:              "alpn_mismatch",
+		reasonCertMismatch:      "cert_mismatch",
+		reasonOCSP:              "ocsp_error",
+		reasonNotRevalidated:    "not_revalidated",
+		reasonNotReused:         "conn_not_reused",
+		reasonNotResumed:        "tls_not_resumed",
+		reasonThresholdWarning:  "threshold_warning",
+		reasonThresholdCritical: "threshold_critical",
+	} {
+		if token != want {
+			t.Errorf("expected the token %s, got %s", want, token)
+		}
+	}
+}
+
+func TestReasonOf(t *testing.T) {
+	tests := []struct {
+		m    measurement
+		want string
+	}{
+		{measurement{status: "OK"}, reasonOK},
+		{measurement{status: "CRITICAL", reason: reasonStatusMismatch}, reasonStatusMismatch},
+		{measurement{status: "WARNING", reason: reasonHeaderMismatch}, reasonHeaderMismatch},
+		{measurement{status: "CRITICAL"}, reasonThresholdCritical},
+		{measurement{status: "WARNING"}, reasonThresholdWarning},
+		{measurement{status: "CRITICAL", err: "lookup failed", reason: reasonDNS}, reasonDNS},
+		{measurement{status: "CRITICAL", err: "response body too large"}, reasonRequest},
+	}
+	for _, tt := range tests {
+		if got := reasonOf(tt.m); got != tt.want {
+			t.Errorf("%+v: expected %s, got %s", tt.m, tt.want, got)
+		}
+	}
+}
+
+func TestRaise(t *testing.T) {
+	m := measurement{status: "OK"}
+	m.raise("WARNING", reasonStatusMismatch)
+	m.raise("WARNING", reasonBodyMismatch)
+	if m.status != "WARNING" || m.reason != reasonStatusMismatch {
+		t.Errorf("expected the first check reaching a status to keep it, got %s %s", m.status, m.reason)
+	}
+	m.raise("CRITICAL", "")
+	m.raise("OK", reasonCORS)
+	if m.status != "CRITICAL" || reasonOf(m) != reasonThresholdCritical {
+		t.Errorf("expected a worse threshold to take over, got %s %s", m.status, reasonOf(m))
+	}
+}
+
+func TestExecuteCheckReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	refused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusedURL := "http://" + refused.Addr().String() + "/"
+	refused.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--url", server.URL}, sensu.CheckStateOK, " reason=ok | "},
+		{[]string{"--url", server.URL + "/error"}, sensu.CheckStateCritical, " reason=status_mismatch | "},
+		{[]string{"--url", server.URL, "--expect-body-contains", "goodbye"}, sensu.CheckStateCritical, " reason=body_mismatch | "},
+		{[]string{"--url", server.URL + "/slow", "--warning", "0.01"}, sensu.CheckStateWarning, " reason=threshold_warning | "},
+		{[]string{"--url", server.URL + "/slow", "--warning", "0.005", "--critical", "0.01"}, sensu.CheckStateCritical, " reason=threshold_critical | "},
+		{[]string{"--url", server.URL + "/slow", "--warning", "0.01", "--expect-header", "X-Missing: yes"}, sensu.CheckStateCritical, " reason=header_mismatch | "},
+		{[]string{"--url", server.URL, "--conditional"}, sensu.CheckStateWarning, " reason=not_revalidated | "},
+		{[]string{"--url", server.URL + "/error", "--warning", "0.005", "--critical", "0.01", "--expect-header", "X-Missing: yes"}, sensu.CheckStateCritical, " reason=status_mismatch | "},
+		{[]string{"--url", refusedURL}, sensu.CheckStateCritical, ": connection refused to " + refused.Addr().String() + " reason=conn_refused | "},
+		{[]string{"--url", server.URL, "--output-format", "json"}, sensu.CheckStateOK, `"reason":"ok"`},
+	}
+	for _, tt := range tests {
+		setup(t, tt.args...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected state %d with %q, got %d: %s", tt.args, tt.status, tt.want, status, out)
+		}
+	}
+}
+
+func TestCheckArgsReason(t *testing.T) {
+	parseArgs(t, "--url", "http://example.com/", "--status-map", "503=fine")
+	if _, err := checkArgs(nil); err == nil || !strings.HasSuffix(err.Error(), " reason=config_error") {
+		t.Errorf("expected the config_error reason token, got %v", err)
+	}
+}
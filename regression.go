@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// regressionWindow is how many recent total request durations the state
+	// file keeps.
+	regressionWindow = 20
+
+	// regressionMinRuns is how many previous runs the median needs before it
+	// is a baseline worth comparing against.
+	regressionMinRuns = 3
+)
+
+// runState is the content of the --state-file.
+type runState struct {
+	URL string `json:"url"`
+	// Totals are the total request durations of the recent runs in
+	// seconds, oldest first.
+	Totals []float64 `json:"totals"`
+}
+
+// tracksRegression reports whether the runs are recorded in a state file.
+func tracksRegression() bool {
+	return len(plugin.StateFile) > 0 || plugin.RegressionWarning > 0 || plugin.RegressionCritical > 0
+}
+
+// validateRegression checks the regression thresholds.
+func validateRegression() error {
+	switch {
+	case plugin.RegressionWarning < 0 || plugin.RegressionCritical < 0:
+		return fmt.Errorf("--regression-warning and --regression-critical must not be negative")
+	case plugin.RegressionWarning > 0 && plugin.RegressionWarning <= 1, plugin.RegressionCritical > 0 && plugin.RegressionCritical <= 1:
+		return fmt.Errorf("--regression-warning and --regression-critical are multipliers of the baseline and must be greater than 1")
+	case plugin.RegressionWarning > 0 && plugin.RegressionCritical > 0 && plugin.RegressionWarning > plugin.RegressionCritical:
+		return fmt.Errorf("--regression-warning must be lower than --regression-critical")
+	case tracksRegression() && (plugin.AllIps || len(checkedURLs) > 0):
+		return fmt.Errorf("--state-file and the regression thresholds are not supported with --all-ips or --urls-file")
+	}
+	return nil
+}
+
+// statePath returns the --state-file, by default a file named after a hash
+// of the URL in a directory of the plugin in the cache directory of the user,
+// which is created. Without one, e.g. when HOME is not set, the directory is
+// in the temporary directory.
+func statePath() string {
+	if len(plugin.StateFile) > 0 {
+		return plugin.StateFile
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	dir = filepath.Join(dir, plugin.Name)
+	// An error shows when the file is opened.
+	_ = os.MkdirAll(dir, 0o700)
+	sum := sha256.Sum256([]byte(plugin.Url))
+	return filepath.Join(dir, fmt.Sprintf("%x.json", sum[:8]))
+}
+
+// median returns the median of values, which must not be empty.
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// updateState adds total to the state file at path and returns the totals
+// of the previous runs. The file is locked while it is updated so that
+// concurrent runs take turns. A file that cannot be parsed or belongs to
+// another URL starts over.
+func updateState(path string, total time.Duration) ([]float64, error) {
+	f, err := openStateFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var state runState
+	if err := json.Unmarshal(data, &state); err != nil || state.URL != plugin.Url {
+		state = runState{URL: plugin.Url}
+	}
+	previous := state.Totals
+	state.Totals = append(append([]float64(nil), previous...), total.Seconds())
+	if n := len(state.Totals); n > regressionWindow {
+		state.Totals = state.Totals[n-regressionWindow:]
+	}
+	if data, err = json.Marshal(state); err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// checkRegression records the total request duration of m in the state file
+// at path and compares it against the median of the previous runs, adding
+// baseline_median and regression_ratio. Until there are enough previous runs,
+// or when the state file cannot be used, m is returned as is.
+func checkRegression(m measurement, path string) measurement {
+	previous, err := updateState(path, m.elapsed)
+	if err != nil {
+		if plugin.Verbose {
+			m.details += fmt.Sprintf(" state_file_error=%q", err.Error())
+		}
+		return m
+	}
+	if len(previous) < regressionMinRuns {
+		return m
+	}
+	baseline := median(previous)
+	if baseline <= 0 {
+		return m
+	}
+
+	ratio := math.Round(m.elapsed.Seconds()/baseline*100) / 100
+	switch {
+	case plugin.RegressionCritical > 0 && ratio >= float64(plugin.RegressionCritical):
+		m.raise("CRITICAL", "")
+		m.details += fmt.Sprintf(" regression %gx the baseline", ratio)
+	case plugin.RegressionWarning > 0 && ratio >= float64(plugin.RegressionWarning):
+		m.raise("WARNING", "")
+		m.details += fmt.Sprintf(" regression %gx the baseline", ratio)
+	}
+	ratioMetric := valueMetric("regression_ratio", ratio, "")
+	ratioMetric.warning = threshold(plugin.RegressionWarning)
+	ratioMetric.critical = threshold(plugin.RegressionCritical)
+	m.metrics = append(m.metrics,
+		durationMetric("baseline_median", time.Duration(baseline*float64(time.Second)), 0, 0),
+		ratioMetric,
+	)
+	return m
+}
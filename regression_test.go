@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestMedian(t *testing.T) {
+	for _, tt := range []struct {
+		values []float64
+		want   float64
+	}{
+		{[]float64{3}, 3},
+		{[]float64{5, 1, 3}, 3},
+		{[]float64{4, 1, 3, 2}, 2.5},
+	} {
+		if got := median(tt.values); got != tt.want {
+			t.Errorf("median(%v): expected %v, got %v", tt.values, tt.want, got)
+		}
+	}
+}
+
+func TestUpdateState(t *testing.T) {
+	parseArgs(t, "--url", "http://example.com/")
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	for i := 1; i <= regressionWindow+5; i++ {
+		previous, err := updateState(path, time.Duration(i)*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := i - 1
+		if want > regressionWindow {
+			want = regressionWindow
+		}
+		if len(previous) != want {
+			t.Fatalf("run %d: expected %d previous totals, got %d", i, want, len(previous))
+		}
+	}
+	data, _ := os.ReadFile(path)
+	var state runState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Totals) != regressionWindow || state.Totals[0] != 6 || state.URL != "http://example.com/" {
+		t.Errorf("expected the last %d totals of the URL, got %+v", regressionWindow, state)
+	}
+
+	// Unreadable state and the state of another URL start over.
+	if err := os.WriteFile(path, []byte("{garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if previous, err := updateState(path, time.Second); err != nil || len(previous) != 0 {
+		t.Errorf("expected corrupt state to start over, got %v %v", previous, err)
+	}
+	parseArgs(t, "--url", "http://example.org/")
+	if previous, err := updateState(path, time.Second); err != nil || len(previous) != 0 {
+		t.Errorf("expected the state of another URL to be ignored, got %v %v", previous, err)
+	}
+}
+
+func TestUpdateStateConcurrent(t *testing.T) {
+	parseArgs(t, "--url", "http://example.com/")
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := updateState(path, time.Second); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	previous, err := updateState(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previous) != 10 {
+		t.Errorf("expected every concurrent run to be recorded, got %d", len(previous))
+	}
+}
+
+func TestCheckRegression(t *testing.T) {
+	parseArgs(t, "--url", "http://example.com/", "--regression-warning", "3", "--regression-critical", "5")
+	path := filepath.Join(t.TempDir(), "state.json")
+	ok := func(d time.Duration) measurement { return measurement{status: "OK", elapsed: d} }
+
+	// No baseline on the first runs.
+	for i := 0; i < regressionMinRuns; i++ {
+		if m := checkRegression(ok(10*time.Second), path); m.status != "OK" || len(m.metrics) != 0 {
+			t.Fatalf("run %d: expected no regression evaluation, got %+v", i+1, m)
+		}
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		status  string
+		want    string
+	}{
+		{20 * time.Second, "OK", "baseline_median=10.000000s;;;0 regression_ratio=2;3;5"},
+		{40 * time.Second, "WARNING", "baseline_median=10.000000s;;;0 regression_ratio=4;3;5"},
+		// The median resists the previous outliers.
+		{55 * time.Second, "CRITICAL", "baseline_median=10.000000s;;;0 regression_ratio=5.5;3;5"},
+	}
+	for _, tt := range tests {
+		m := checkRegression(ok(tt.elapsed), path)
+		if got := formatPerfdata(m.metrics, outputFormat(), false); m.status != tt.status || got != tt.want {
+			t.Errorf("%s: expected %s with %q, got %s with %q%s", tt.elapsed, tt.status, tt.want, m.status, got, m.details)
+		}
+	}
+
+	// An unusable state file is no reason to fail the check.
+	m := checkRegression(ok(time.Hour), filepath.Join(t.TempDir(), "missing", "state.json"))
+	if m.status != "OK" || len(m.metrics) != 0 {
+		t.Errorf("expected no regression evaluation without a state file, got %+v", m)
+	}
+}
+
+func TestUpdateStateSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are followed on windows")
+	}
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	if err := os.WriteFile(target, []byte("keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "state.json")
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+	parseArgs(t, "--url", "http://example.com/")
+	if _, err := updateState(path, time.Second); err == nil {
+		t.Error("expected a symlinked state file to be refused")
+	}
+	if data, _ := os.ReadFile(target); string(data) != "keep" {
+		t.Errorf("expected the target of the symlink to be left alone, got %q", data)
+	}
+}
+
+func TestExecuteCheckRegression(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	for i := 0; i <= regressionMinRuns; i++ {
+		setup(t, "--url", ts.URL, "--state-file", path, "--regression-critical", "1000")
+		status, out := run(t)
+		if status != sensu.CheckStateOK {
+			t.Fatalf("run %d: expected OK, got %d: %s", i+1, status, out)
+		}
+		if hasBaseline := strings.Contains(out, " baseline_median=") && strings.Contains(out, " regression_ratio="); hasBaseline != (i == regressionMinRuns) {
+			t.Errorf("run %d: unexpected baseline in %s", i+1, out)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--regression-warning", "-1"},
+		{"--regression-warning", "1"},
+		{"--regression-warning", "5", "--regression-critical", "3"},
+		{"--regression-warning", "2", "--all-ips"},
+	} {
+		parseArgs(t, append([]string{"--url", ts.URL}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected a validation error", args)
+		}
+	}
+}
+
+func TestStatePath(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parseArgs(t, "--url", "http://example.com/")
+	a := statePath()
+	parseArgs(t, "--url", "http://example.org/")
+	if b := statePath(); a == b || filepath.Dir(a) != filepath.Join(dir, plugin.Name) {
+		t.Errorf("expected a file per URL in the cache directory of the user, got %s and %s", a, b)
+	}
+	if info, err := os.Stat(filepath.Dir(a)); err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("expected a private directory for the state files, got %v %v", info, err)
+	}
+	parseArgs(t, "--url", "http://example.org/", "--state-file", "/var/lib/check.json")
+	if got := statePath(); got != "/var/lib/check.json" {
+		t.Errorf("expected the --state-file, got %s", got)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// buildRequest builds the first request to the URL from the options. It also
+// returns the bearer token or --auth-command value it sent, if any, so that
+// errors can be redacted.
+func buildRequest() (*http.Request, string, error) {
+	var body io.Reader
+	if len(requestBody) > 0 {
+		body = bytes.NewReader(requestBody)
+	}
+	req, err := http.NewRequest(plugin.Method, plugin.Url, body)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid --url: %v", err)
+	}
+
+	if len(requestBody) > 0 {
+		contentType := plugin.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if plugin.UserAgent != "" {
+		req.Header.Set("User-Agent", plugin.UserAgent)
+	}
+
+	if expectsContinue() {
+		req.Header.Set("Expect", "100-continue")
+	}
+
+	// Connection: close makes every request open a new connection, HTTP/2
+	// then uses a connection of its own for it too.
+	req.Close = plugin.NoKeepalive
+
+	// Setting the header turns off the transparent gzip of the transport,
+	// which DisableCompression also does for identity.
+	if len(plugin.AcceptEncoding) > 0 {
+		req.Header.Set("Accept-Encoding", plugin.AcceptEncoding)
+	}
+
+	if requestRange != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", requestRange.first, requestRange.last))
+	}
+
+	if len(basicAuthUser) > 0 && plugin.AuthType == "basic" {
+		req.SetBasicAuth(basicAuthUser, basicAuthPassword)
+	}
+
+	bearerToken, err := readBearerToken()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(bearerToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+bearerToken)
+	}
+	if len(authCommandHeader) > 0 {
+		req.Header.Set("Authorization", authCommandHeader)
+		bearerToken = authCommandHeader
+	}
+
+	for name, values := range requestHeaders {
+		switch name {
+		case "Host":
+			// Go ignores a Host entry in req.Header, the request field wins.
+			req.Host = values[len(values)-1]
+		case "User-Agent":
+			req.Header[name] = values
+		default:
+			for _, value := range values {
+				req.Header.Add(name, value)
+			}
+		}
+	}
+
+	if len(plugin.HostHeader) > 0 {
+		req.Host = plugin.HostHeader
+	}
+	if len(plugin.CorsOrigin) > 0 {
+		setPreflight(req)
+	}
+	return req, bearerToken, nil
+}
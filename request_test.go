@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBuildRequest(t *testing.T) {
+	setup(t, "--url", "http://example.com/a", "--method", "POST", "--request-body", `{"a":1}`,
+		"--header", "X-Test: 1", "--header", "Host: internal.example.com", "--bearer-token", "secret")
+	req, bearerToken, err := buildRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPost || req.URL.String() != "http://example.com/a" {
+		t.Errorf("unexpected request %s %s", req.Method, req.URL)
+	}
+	if req.Host != "internal.example.com" {
+		t.Errorf("expected Host internal.example.com, got %q", req.Host)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %q", got)
+	}
+	if got := req.Header.Get("X-Test"); got != "1" {
+		t.Errorf("expected X-Test 1, got %q", got)
+	}
+	if bearerToken != "secret" || req.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("expected bearer token secret, got %q %q", bearerToken, req.Header.Get("Authorization"))
+	}
+}
@@ -0,0 +1,7 @@
+//go:build cgo
+
+package main
+
+// cgoResolver reports whether the system resolver may be the cgo one, which
+// needs a build with cgo enabled.
+const cgoResolver = true
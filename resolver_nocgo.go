@@ -0,0 +1,7 @@
+//go:build !cgo
+
+package main
+
+// cgoResolver reports whether the system resolver may be the cgo one, this
+// build has cgo disabled and always resolves with the Go resolver.
+const cgoResolver = false
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// measureSamples takes --samples measurements, --sample-interval apart, and
+// evaluates the thresholds. Several samples are summarised and the
+// thresholds apply to the --evaluate statistic of their timings. The
+// deadline of ctx bounds every sample, the first failing one fails the run.
+// A --warmup request goes first and is not measured.
+func measureSamples(ctx context.Context, overrides map[string]string) measurement {
+	transports := newTransportCache(overrides)
+	defer func() { transports.close() }()
+
+	if plugin.Warmup {
+		if w := measure(ctx, transports); len(w.err) > 0 && plugin.FailOnWarmupError {
+			m := failure("CRITICAL", "warm-up request failed: "+w.err)
+			m.reason = w.reason
+			return m
+		}
+		if plugin.WarmupNewConnection {
+			transports.close()
+			transports = newTransportCache(overrides)
+		}
+	}
+
+	var samples []measurement
+	for i := 0; i < plugin.Samples; i++ {
+		if i > 0 {
+			timer := time.NewTimer(time.Duration(plugin.SampleInterval) * time.Millisecond)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				m := failure("CRITICAL", fmt.Sprintf("timed out after %d of %d samples", i, plugin.Samples))
+				m.reason = reasonTimeout
+				return m
+			case <-timer.C:
+			}
+			if plugin.NoKeepalive {
+				transports.close()
+				transports = newTransportCache(overrides)
+			}
+		}
+		var m measurement
+		if plugin.Requests > 1 {
+			m = measureLoad(ctx, transports)
+		} else if plugin.MeasureReuse {
+			m = measureReuse(ctx, transports)
+		} else if plugin.MeasureResumption {
+			m = measureResumption(ctx, transports)
+		} else if plugin.Conditional {
+			m = measureConditional(ctx, transports)
+		} else {
+			m = measureWithRetries(ctx, transports)
+		}
+		if len(m.err) > 0 {
+			if plugin.Samples > 1 {
+				m.err = fmt.Sprintf("sample %d of %d: %s", i+1, plugin.Samples, m.err)
+			}
+			return m
+		}
+		samples = append(samples, m)
+	}
+	m := samples[0]
+	if len(samples) > 1 {
+		m = summarize(samples, plugin.Evaluate)
+	}
+	if plugin.Warmup {
+		m.metrics = append(m.metrics, valueMetric("warmup", 1, ""))
+	}
+	return evaluate(m)
+}
+
+// measureReuse sends the request a second time right after the first over
+// the same transports, which should reuse the connection. The first request
+// is the measurement, the totals of both requests and whether the second
+// one reused the connection are added.
+func measureReuse(ctx context.Context, transports *transportCache) measurement {
+	cold := measureWithRetries(ctx, transports)
+	if len(cold.err) > 0 {
+		return cold
+	}
+	warm := measure(ctx, transports)
+	if len(warm.err) > 0 {
+		warm.err = "second request failed: " + warm.err
+		return warm
+	}
+	var reused float64
+	if warm.reused {
+		reused = 1
+	} else {
+		cold.details += " connection not reused"
+		if plugin.WarnOnNoReuse {
+			cold.raise("WARNING", reasonNotReused)
+		}
+	}
+	cold.metrics = append(cold.metrics,
+		durationMetric("cold_total_duration", cold.elapsed, 0, 0),
+		durationMetric("warm_total_duration", warm.elapsed, 0, 0),
+		valueMetric("reuse_worked", reused, ""),
+	)
+	return cold
+}
+
+// measureResumption sends the request a second time right after the first
+// over a new connection, which should resume the TLS session of the first
+// one. The first request is the measurement, whether the second one resumed
+// and the duration of its handshake are added. Servers send TLS 1.3 session
+// tickets right after the handshake, ahead of the response, so the ticket
+// has arrived once the first response was read.
+func measureResumption(ctx context.Context, transports *transportCache) measurement {
+	cold := measureWithRetries(ctx, transports)
+	if len(cold.err) > 0 {
+		return cold
+	}
+	// Closing the idle connections keeps the transports and their session
+	// cache.
+	transports.close()
+	resumed := measure(ctx, transports)
+	if len(resumed.err) > 0 {
+		resumed.err = "second request failed: " + resumed.err
+		return resumed
+	}
+	var didResume float64
+	if resumed.tls != nil && resumed.tls.DidResume {
+		didResume = 1
+	} else {
+		cold.details += " tls session not resumed"
+		if plugin.WarnOnNoResumption {
+			cold.raise("WARNING", reasonNotResumed)
+		}
+	}
+	cold.metrics = append(cold.metrics, valueMetric("tls_resumed", didResume, ""))
+	for _, p := range resumed.phases {
+		if p.name == "tls_handshake_duration" && p.occurred() {
+			cold.metrics = append(cold.metrics, durationMetric("tls_resumed_handshake_duration", p.end.Sub(p.start), 0, 0))
+		}
+	}
+	return cold
+}
+
+// measureConditional repeats the request right after the first with the
+// validators of its response, which a cache honoring them answers with 304
+// Not Modified. The first request is the measurement, the phases and total
+// of the second one are added with a revalidation_ prefix along with
+// revalidated. A response without validators, or a second one that is not a
+// 304, is a warning.
+func measureConditional(ctx context.Context, transports *transportCache) measurement {
+	m := measureWithRetries(ctx, transports)
+	if len(m.err) > 0 {
+		return m
+	}
+	if len(m.validators) == 0 {
+		m.raise("WARNING", reasonNotRevalidated)
+		m.details += " no ETag or Last-Modified to revalidate with"
+		return m
+	}
+	second := measureWith(ctx, transports, m.validators)
+	if len(second.err) > 0 {
+		second.err = "revalidation request failed: " + second.err
+		return second
+	}
+	var revalidated float64
+	if second.httpStatus == http.StatusNotModified {
+		revalidated = 1
+	} else {
+		m.raise("WARNING", reasonNotRevalidated)
+		m.details += fmt.Sprintf(" not revalidated, got %d", second.httpStatus)
+	}
+	for _, pm := range phaseMetrics(second.phases) {
+		pm.label = "revalidation_" + pm.label
+		pm.warning, pm.critical = nil, nil
+		m.metrics = append(m.metrics, pm)
+	}
+	m.metrics = append(m.metrics,
+		durationMetric("revalidation_duration", second.elapsed, 0, 0),
+		valueMetric("revalidated", revalidated, ""),
+	)
+	return m
+}
+
+// conditionalHeader returns the If-None-Match and If-Modified-Since headers
+// revalidating a response with header.
+func conditionalHeader(header http.Header) http.Header {
+	validators := http.Header{}
+	if etag := header.Get("ETag"); len(etag) > 0 {
+		validators.Set("If-None-Match", etag)
+	}
+	if lastModified := header.Get("Last-Modified"); len(lastModified) > 0 {
+		validators.Set("If-Modified-Since", lastModified)
+	}
+	return validators
+}
+
+// timingMetrics are the per request timings that summarize replaces with
+// statistics.
+var timingMetrics = map[string]bool{
+	"dns_duration":               true,
+	"tls_handshake_duration":     true,
+	"connect_duration":           true,
+	"first_byte_duration":        true,
+	"network_setup_duration":     true,
+	"request_write_duration":     true,
+	"server_processing_duration": true,
+	"continue_wait_duration":     true,
+	"total_request_duration":     true,
+}
+
+// summarize combines samples into one measurement. The status, details and
+// other metrics are those of the worst sample, the timings are replaced by
+// the min, avg, max and p95 of the total, the average of every phase and
+// the evaluated statistic the thresholds are compared against.
+func summarize(samples []measurement, statistic string) measurement {
+	details := fmt.Sprintf(" samples=%d evaluate=%s", len(samples), statistic)
+	return combine(samples, statistic, []string{"min", "avg", "max", "p95"}, details)
+}
+
+// combine is summarize with the statistics of the total in stats, to which
+// the evaluated one is added, and details appended to those of the worst
+// measurement.
+func combine(samples []measurement, statistic string, stats []string, details string) measurement {
+	worst := samples[0]
+	for _, s := range samples[1:] {
+		if worseStatus(worst.status, s.status) != worst.status {
+			worst = s
+		}
+	}
+	m := worst
+	m.details += details
+
+	// Every sample was sent with its own trace ID, the details only have
+	// the one of the worst.
+	if plugin.Verbose && len(plugin.TraceHeader) > 0 {
+		ids := make([]string, len(samples))
+		for i, s := range samples {
+			ids[i] = s.traceID
+		}
+		m.details += fmt.Sprintf(" %ss=%s", traceKey(), strings.Join(ids, ","))
+	}
+
+	totals := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		totals[i] = s.elapsed
+	}
+	m.elapsed = sampleStatistic(totals, statistic)
+
+	evaluated := false
+	for _, stat := range stats {
+		evaluated = evaluated || stat == statistic
+	}
+	if !evaluated {
+		stats = append(stats, statistic)
+	}
+	var metrics []metric
+	for _, stat := range stats {
+		var warning, critical float32
+		if stat == statistic {
+			warning, critical = plugin.Warning, plugin.Critical
+		}
+		metrics = append(metrics, durationMetric("total_"+stat, sampleStatistic(totals, stat), warning, critical))
+	}
+
+	// Phases that did not happen in a sample, such as the connect of a
+	// reused connection, are left out of its statistics. The statistic
+	// is stored as a phase starting now so checkPhases can compare it.
+	now := time.Now()
+	m.phases = make([]phase, len(worst.phases))
+	for j, p := range worst.phases {
+		var durations []time.Duration
+		for _, s := range samples {
+			if sp := s.phases[j]; sp.occurred() {
+				durations = append(durations, sp.end.Sub(sp.start))
+			}
+		}
+		m.phases[j] = phase{name: p.name + " " + statistic, warning: p.warning, critical: p.critical}
+		if len(durations) == 0 {
+			continue
+		}
+		m.phases[j].start = now
+		m.phases[j].end = now.Add(sampleStatistic(durations, statistic))
+		var warning, critical float32
+		if statistic == "avg" {
+			warning, critical = p.warning, p.critical
+		}
+		metrics = append(metrics, durationMetric(p.name+"_avg", sampleStatistic(durations, "avg"), warning, critical))
+	}
+
+	for _, pm := range worst.metrics {
+		if !timingMetrics[pm.label] {
+			metrics = append(metrics, pm)
+		}
+	}
+	m.metrics = metrics
+	return m
+}
+
+// sampleStatistic returns the min, avg, max or the nearest rank p50, p95 or
+// p99 of durations, which must not be empty.
+func sampleStatistic(durations []time.Duration, statistic string) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	switch statistic {
+	case "min":
+		return sorted[0]
+	case "max":
+		return sorted[len(sorted)-1]
+	case "p50", "p95", "p99":
+		p, _ := strconv.ParseFloat(statistic[1:], 64)
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		return sorted[rank-1]
+	}
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	return sum / time.Duration(len(sorted))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampleStatistic(t *testing.T) {
+	ms := func(values ...int) []time.Duration {
+		var durations []time.Duration
+		for _, v := range values {
+			durations = append(durations, time.Duration(v)*time.Millisecond)
+		}
+		return durations
+	}
+	samples := ms(30, 10, 20, 40)
+	outlier := ms(100, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20)
+	tests := []struct {
+		durations []time.Duration
+		statistic string
+		want      time.Duration
+	}{
+		{samples, "min", 10 * time.Millisecond},
+		{samples, "max", 40 * time.Millisecond},
+		{samples, "avg", 25 * time.Millisecond},
+		{samples, "p50", 20 * time.Millisecond},
+		{samples, "p95", 40 * time.Millisecond},
+		{ms(5), "p99", 5 * time.Millisecond},
+		{outlier, "p50", 11 * time.Millisecond},
+		{outlier, "p95", 20 * time.Millisecond},
+		{outlier, "p99", 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := sampleStatistic(tt.durations, tt.statistic); got != tt.want {
+			t.Errorf("%s of %v: expected %s, got %s", tt.statistic, tt.durations, tt.want, got)
+		}
+	}
+	if samples[0] != 30*time.Millisecond {
+		t.Errorf("expected the durations to be left unsorted, got %v", samples)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	parseArgs(t)
+	base := time.Now()
+	sample := func(status string, total, dns time.Duration) measurement {
+		m := measurement{status: status, elapsed: total, details: " " + status}
+		m.phases = []phase{{name: "dns_duration", warning: 0.5}, {name: "connect_duration"}}
+		if dns > 0 {
+			m.phases[0].start, m.phases[0].end = base, base.Add(dns)
+		}
+		m.metrics = []metric{durationMetric("total_request_duration", total, 0, 0), valueMetric("http_status", 200, "")}
+		return m
+	}
+	m := summarize([]measurement{
+		sample("OK", 100*time.Millisecond, 40*time.Millisecond),
+		sample("CRITICAL", 300*time.Millisecond, 0),
+		sample("OK", 200*time.Millisecond, 20*time.Millisecond),
+	}, "max")
+
+	if m.status != "CRITICAL" || m.details != " CRITICAL samples=3 evaluate=max" {
+		t.Errorf("expected the worst sample, got %s %q", m.status, m.details)
+	}
+	if m.elapsed != 300*time.Millisecond {
+		t.Errorf("expected the max total, got %s", m.elapsed)
+	}
+	if d := m.phases[0].end.Sub(m.phases[0].start); d != 40*time.Millisecond || m.phases[0].name != "dns_duration max" {
+		t.Errorf("expected the max dns phase of the samples it occurred in, got %s %s", m.phases[0].name, d)
+	}
+	if !m.phases[1].start.IsZero() {
+		t.Errorf("expected the connect phase to be skipped, got %+v", m.phases[1])
+	}
+	var labels []string
+	for _, pm := range m.metrics {
+		labels = append(labels, pm.label)
+	}
+	want := []string{"total_min", "total_avg", "total_max", "total_p95", "dns_duration_avg", "http_status"}
+	if len(labels) != len(want) {
+		t.Fatalf("expected metrics %q, got %q", want, labels)
+	}
+	for i := range want {
+		if labels[i] != want[i] {
+			t.Errorf("expected metrics %q, got %q", want, labels)
+			break
+		}
+	}
+	if m.metrics[2].critical == nil || m.metrics[0].critical != nil {
+		t.Errorf("expected the thresholds on total_max only")
+	}
+}
+
+func TestExecuteCheckSamplesReuseConnection(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 32<<10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	var dump bytes.Buffer
+	debugOutput = &dump
+	defer func() { debugOutput = os.Stderr }()
+	setup(t, "--url", ts.URL, "--samples", "3", "--verbose")
+	_, out := run(t)
+	if !strings.Contains(out, " drained_bytes=32768") {
+		t.Errorf("expected the unread body to be drained, got %s", out)
+	}
+	// The body is not measured, draining it lets the warm samples reuse
+	// the connection of the first.
+	var reused []string
+	for _, line := range strings.Split(dump.String(), "\n") {
+		if i := strings.Index(line, " reused="); i >= 0 && strings.HasPrefix(line, "* remote_addr=") {
+			reused = append(reused, line[i+len(" reused="):])
+		}
+	}
+	if strings.Join(reused, ",") != "false,true,true" {
+		t.Errorf("expected samples 2 and 3 to reuse the connection, got %q", reused)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// securityHeaders are the response headers --check-security-headers expects,
+// in the order they are reported.
+var securityHeaders = []string{
+	"Strict-Transport-Security",
+	"X-Content-Type-Options",
+	"X-Frame-Options",
+	"Content-Security-Policy",
+}
+
+// parseIgnoredSecurityHeaders parses --ignore-security-header entries into
+// the set of canonical header names left out of the audit.
+func parseIgnoredSecurityHeaders(entries []string) (map[string]bool, error) {
+	ignored := map[string]bool{}
+	for _, entry := range entries {
+		name := http.CanonicalHeaderKey(strings.TrimSpace(entry))
+		known := false
+		for _, h := range securityHeaders {
+			known = known || h == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unsupported --ignore-security-header %q, must be one of %s", entry, strings.Join(securityHeaders, ", "))
+		}
+		ignored[name] = true
+	}
+	return ignored, nil
+}
+
+// checkSecurityHeaders audits the security headers of a response, returning
+// the missing ones and a description of a Strict-Transport-Security max-age
+// below --hsts-min-age. HSTS is only expected over https, browsers ignore it
+// on plain http.
+func checkSecurityHeaders(resp *http.Response) (missing []string, weak string) {
+	for _, name := range securityHeaders {
+		if ignoredSecurityHeaders[name] || name == "Strict-Transport-Security" && resp.TLS == nil {
+			continue
+		}
+		value := resp.Header.Get(name)
+		if len(strings.TrimSpace(value)) == 0 {
+			missing = append(missing, name)
+			continue
+		}
+		if name == "Strict-Transport-Security" {
+			if age, ok := hstsMaxAge(value); !ok || age < plugin.HstsMinAge {
+				weak = fmt.Sprintf("Strict-Transport-Security %q max-age below %d", value, plugin.HstsMinAge)
+			}
+		}
+	}
+	return missing, weak
+}
+
+// hstsMaxAge returns the max-age directive of a Strict-Transport-Security
+// header, ok is false when it has none.
+func hstsMaxAge(value string) (int64, bool) {
+	for _, directive := range strings.Split(value, ";") {
+		name, v, found := strings.Cut(strings.TrimSpace(directive), "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(name), "max-age") {
+			continue
+		}
+		age, err := strconv.ParseInt(strings.Trim(strings.TrimSpace(v), `"`), 10, 64)
+		return age, err == nil
+	}
+	return 0, false
+}
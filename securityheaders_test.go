@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestHSTSMaxAge(t *testing.T) {
+	tests := []struct {
+		value string
+		age   int64
+		ok    bool
+	}{
+		{"max-age=31536000; includeSubDomains", 31536000, true},
+		{"includeSubDomains; Max-Age=\"600\"", 600, true},
+		{"max-age=soon", 0, false},
+		{"includeSubDomains", 0, false},
+	}
+	for _, tt := range tests {
+		if age, ok := hstsMaxAge(tt.value); age != tt.age || ok != tt.ok {
+			t.Errorf("%q: expected %d %t, got %d %t", tt.value, tt.age, tt.ok, age, ok)
+		}
+	}
+}
+
+func TestExecuteCheckSecurityHeaders(t *testing.T) {
+	handler := func(hsts string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", hsts)
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			if r.URL.Path != "/api" {
+				w.Header().Set("X-Frame-Options", "DENY")
+				w.Header().Set("Content-Security-Policy", "default-src 'self'")
+			}
+		}
+	}
+	secure := httptest.NewTLSServer(handler("max-age=31536000"))
+	defer secure.Close()
+	weak := httptest.NewTLSServer(handler("max-age=300"))
+	defer weak.Close()
+	// HSTS means nothing over plain http.
+	plain := httptest.NewServer(handler(""))
+	defer plain.Close()
+
+	tests := []struct {
+		url    string
+		args   []string
+		status int
+		want   string
+	}{
+		{secure.URL, nil, sensu.CheckStateOK, " missing_security_headers=0 "},
+		{plain.URL, nil, sensu.CheckStateOK, " missing_security_headers=0 "},
+		{secure.URL + "/api", nil, sensu.CheckStateWarning, " missing_security_headers=X-Frame-Options,Content-Security-Policy "},
+		{secure.URL + "/api", []string{"--security-headers-critical"}, sensu.CheckStateCritical, " missing_security_headers=X-Frame-Options,Content-Security-Policy "},
+		{secure.URL + "/api", []string{"--ignore-security-header", "content-security-policy", "--ignore-security-header", "X-Frame-Options"}, sensu.CheckStateOK, " missing_security_headers=0 "},
+		{weak.URL, nil, sensu.CheckStateWarning, ` Strict-Transport-Security "max-age=300" max-age below 15768000`},
+		{weak.URL, []string{"--hsts-min-age", "300"}, sensu.CheckStateOK, " missing_security_headers=0 "},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", tt.url, "--insecure-skip-verify", "--check-security-headers"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%s %q: expected %d with %q, got %d: %s", tt.url, tt.args, tt.status, tt.want, status, out)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--check-security-headers", "--ignore-security-header", "X-Powered-By"},
+		{"--security-headers-critical"},
+		{"--check-security-headers", "--hsts-min-age", "-1"},
+	} {
+		parseArgs(t, append([]string{"--url", secure.URL}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("%q: expected a validation error", args)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"github.com/DoctorOgg/sensu-http-perf-go/httpperf"
+)
+
+// preRequest is a --pre-request step, sent before the measured request.
+type preRequest struct {
+	method string
+	url    string
+	body   []byte
+}
+
+func (p preRequest) String() string {
+	return p.method + " " + p.url
+}
+
+// parsePreRequests parses --pre-request entries of the form
+// "METHOD URL[ BODY]".
+func parsePreRequests(entries []string) ([]preRequest, error) {
+	steps := make([]preRequest, 0, len(entries))
+	for _, entry := range entries {
+		fields := strings.SplitN(strings.TrimSpace(entry), " ", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid --pre-request %q, expected \"METHOD URL[ BODY]\"", entry)
+		}
+		method := strings.ToUpper(fields[0])
+		if !isAllowedMethod(method) {
+			return nil, fmt.Errorf("unsupported --pre-request method %q, must be one of %s", fields[0], strings.Join(allowedMethods, ", "))
+		}
+		if err := validateURL(fields[1]); err != nil {
+			return nil, fmt.Errorf("invalid --pre-request %q: %v", entry, err)
+		}
+		step := preRequest{method: method, url: fields[1]}
+		if len(fields) == 3 {
+			step.body = []byte(fields[2])
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// parseCookies parses --cookie entries of the form "name=value".
+func parseCookies(entries []string) ([]*http.Cookie, error) {
+	cookies := make([]*http.Cookie, 0, len(entries))
+	for _, entry := range entries {
+		name, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || len(name) == 0 {
+			return nil, fmt.Errorf("invalid --cookie %q, expected name=value", entry)
+		}
+		cookies = append(cookies, &http.Cookie{Name: name, Value: value})
+	}
+	return cookies, nil
+}
+
+// newSessionJar returns the cookie jar shared by the --pre-request steps and
+// the measured request, holding the --cookie cookies for the URL host.
+func newSessionJar() http.CookieJar {
+	jar, _ := cookiejar.New(nil)
+	if u, err := url.Parse(plugin.Url); err == nil && len(staticCookies) > 0 {
+		jar.SetCookies(u, staticCookies)
+	}
+	return jar
+}
+
+// runPreRequests sends the --pre-request steps in order with jar, following
+// redirects, and reads their bodies. It returns the failure of the first step
+// that could not be sent or got a 4xx or 5xx response, ok when every step
+// succeeded. Their timings are not part of the measurement.
+func runPreRequests(ctx context.Context, jar http.CookieJar, transport func(*http.Request) http.RoundTripper, secrets ...string) (measurement, bool) {
+	for i, step := range preRequests {
+		name := fmt.Sprintf("pre-request %d (%s)", i+1, redact(step.String(), secrets...))
+		req, err := newStepRequest(step)
+		if err != nil {
+			m := failure("CRITICAL", fmt.Sprintf("%s failed: %s", name, redact(err.Error(), secrets...)))
+			m.reason = reasonPreRequest
+			return m, false
+		}
+		x, err := httpperf.Send(ctx, req, step.body, plugin.MaxRedirects, jar, transport)
+		if err != nil {
+			m := classifyError(err, secrets...)
+			m.status = "CRITICAL"
+			m.err = fmt.Sprintf("%s failed: %s", name, m.err)
+			return m, false
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(x.Response.Body, plugin.MaxBodyBytes))
+		x.Response.Body.Close()
+		if x.Response.StatusCode >= 400 {
+			m := failure("CRITICAL", fmt.Sprintf("%s returned %s", name, x.Response.Status))
+			m.reason = reasonPreRequest
+			return m, false
+		}
+	}
+	return measurement{}, true
+}
+
+// newStepRequest builds the request of a --pre-request step, with the
+// Content-Type, User-Agent and --header headers of the measured request.
+func newStepRequest(step preRequest) (*http.Request, error) {
+	var body io.Reader
+	if len(step.body) > 0 {
+		body = bytes.NewReader(step.body)
+	}
+	req, err := http.NewRequest(step.method, step.url, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(step.body) > 0 {
+		contentType := plugin.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	if plugin.UserAgent != "" {
+		req.Header.Set("User-Agent", plugin.UserAgent)
+	}
+	for name, values := range requestHeaders {
+		if name == "Host" {
+			continue
+		}
+		req.Header[name] = append([]string(nil), values...)
+	}
+	return req, nil
+}
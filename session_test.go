@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestParsePreRequests(t *testing.T) {
+	steps, err := parsePreRequests([]string{"post http://example.com/login {\"user\": \"a\"}", "GET https://example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].method != "POST" || string(steps[0].body) != `{"user": "a"}` || steps[1].body != nil {
+		t.Errorf("unexpected steps %+v", steps)
+	}
+	for _, entry := range []string{"GET", "FETCH http://example.com/", "GET ftp://example.com/"} {
+		if _, err := parsePreRequests([]string{entry}); err == nil {
+			t.Errorf("%q: expected an error", entry)
+		}
+	}
+}
+
+func TestParseCookies(t *testing.T) {
+	cookies, err := parseCookies([]string{"session=abc", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookies) != 2 || cookies[0].String() != "session=abc" || cookies[1].Name != "empty" {
+		t.Errorf("unexpected cookies %v", cookies)
+	}
+	if _, err := parseCookies([]string{"novalue"}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestExecuteCheckPreRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			body, _ := io.ReadAll(r.Body)
+			if r.Method != http.MethodPost || string(body) != "user=a" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+		case "/dashboard":
+			c, err := r.Cookie("session")
+			if err != nil || c.Value != "s1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if c, err := r.Cookie("theme"); err != nil || c.Value != "dark" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"--pre-request", "POST " + ts.URL + "/login user=a", "--cookie", "theme=dark"}, sensu.CheckStateOK, " OK: GET " + ts.URL + "/dashboard -> 200 OK"},
+		{[]string{"--cookie", "theme=dark"}, sensu.CheckStateWarning, " WARNING: GET " + ts.URL + "/dashboard -> 401 Unauthorized"},
+		{[]string{"--pre-request", "POST " + ts.URL + "/login user=a"}, sensu.CheckStateWarning, " WARNING: GET " + ts.URL + "/dashboard -> 400 Bad Request"},
+		{[]string{"--pre-request", "POST " + ts.URL + "/login user=b"}, sensu.CheckStateCritical,
+			"CRITICAL: pre-request 1 (POST " + ts.URL + "/login) returned 401 Unauthorized reason=pre_request_failed"},
+		{[]string{"--pre-request", "GET http://127.0.0.1:1/"}, sensu.CheckStateCritical,
+			"CRITICAL: pre-request 1 (GET http://127.0.0.1:1/) failed: connection refused"},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", ts.URL + "/dashboard"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected state %d with %q, got %d: %s", tt.args, tt.status, tt.want, status, out)
+		}
+	}
+	// Only the final request is measured.
+	setup(t, "--url", ts.URL+"/dashboard", "--pre-request", "POST "+ts.URL+"/login user=a", "--cookie", "theme=dark")
+	if _, out := run(t); !strings.Contains(out, "redirect_count=0") || !strings.Contains(out, "request_body_bytes=0B") {
+		t.Errorf("expected the metrics of the final request only, got %s", out)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsCredentials are the credentials --aws-sigv4 signs requests with.
+type awsCredentials struct {
+	accessKeyID, secretAccessKey, sessionToken string
+	// expires is when credentials of the instance metadata service must be
+	// fetched again, zero for the ones that do not expire.
+	expires time.Time
+}
+
+// validateSigV4 parses --aws-sigv4 "region/service", e.g. eu-west-1/es.
+func validateSigV4() error {
+	awsRegion, awsService, awsCredentialsCache = "", "", nil
+	if len(plugin.AwsSigv4) == 0 {
+		return nil
+	}
+	region, service, _ := strings.Cut(plugin.AwsSigv4, "/")
+	region, service = strings.TrimSpace(region), strings.TrimSpace(service)
+	if len(region) == 0 || len(service) == 0 || strings.Contains(service, "/") {
+		return fmt.Errorf("invalid --aws-sigv4 %q, expected region/service, e.g. us-east-1/execute-api", plugin.AwsSigv4)
+	}
+	if len(plugin.User) > 0 || len(plugin.BearerToken) > 0 || len(plugin.BearerTokenFile) > 0 {
+		return fmt.Errorf("--aws-sigv4 cannot be combined with --user or a bearer token")
+	}
+	awsRegion, awsService = region, service
+	return nil
+}
+
+// loadAWSCredentials returns the static keys of the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables, of the AWS_PROFILE of the
+// shared credentials and config files, or the role of the EC2 instance
+// metadata service. This is a subset of the chain of the AWS SDKs, container
+// credentials, web identity, SSO, credential_process and role_arn profiles
+// are not supported. They are fetched once per run, the
+// concurrent requests wait for the first one to fetch them.
+func loadAWSCredentials(ctx context.Context) (awsCredentials, error) {
+	awsCredentialsMu.Lock()
+	defer awsCredentialsMu.Unlock()
+	if c := awsCredentialsCache; c != nil && (c.expires.IsZero() || time.Until(c.expires) > time.Minute) {
+		return *c, nil
+	}
+	c, err := findAWSCredentials(ctx)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	awsCredentialsCache = &c
+	return c, nil
+}
+
+// findAWSCredentials looks the credentials up in the sources of
+// loadAWSCredentials, in order.
+func findAWSCredentials(ctx context.Context) (awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); len(id) > 0 && len(secret) > 0 {
+		return awsCredentials{accessKeyID: id, secretAccessKey: secret, sessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if len(profile) == 0 {
+		profile = "default"
+	}
+	home, _ := os.UserHomeDir()
+	files := []struct{ env, path, section string }{
+		{"AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, ".aws", "credentials"), profile},
+		{"AWS_CONFIG_FILE", filepath.Join(home, ".aws", "config"), "profile " + profile},
+	}
+	if profile == "default" {
+		files[1].section = "default"
+	}
+	for _, f := range files {
+		path := os.Getenv(f.env)
+		if len(path) == 0 {
+			path = f.path
+		}
+		keys, err := readAWSProfile(path, f.section)
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		if len(keys["aws_access_key_id"]) > 0 && len(keys["aws_secret_access_key"]) > 0 {
+			return awsCredentials{accessKeyID: keys["aws_access_key_id"], secretAccessKey: keys["aws_secret_access_key"], sessionToken: keys["aws_session_token"]}, nil
+		}
+	}
+
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials found in the environment or the shared credentials files")
+	}
+	c, err := instanceCredentials(ctx)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials found in the environment, the shared credentials files or the instance metadata service: %v", err)
+	}
+	return c, nil
+}
+
+// readAWSProfile returns the keys of section in the shared credentials or
+// config file at path, nothing when the file does not exist.
+func readAWSProfile(path, section string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read AWS credentials: %v", err)
+	}
+	defer f.Close()
+
+	keys := map[string]string{}
+	var current string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0 || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			current = strings.TrimSpace(strings.Trim(line, "[]"))
+		case current == section:
+			if key, value, ok := strings.Cut(line, "="); ok {
+				keys[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+			}
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// instanceCredentials fetches the credentials of the instance role from the
+// EC2 instance metadata service with an IMDSv2 session token.
+func instanceCredentials(ctx context.Context) (awsCredentials, error) {
+	endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if len(endpoint) == 0 {
+		endpoint = "http://169.254.169.254"
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	// The metadata service is link local, never proxied.
+	client := &http.Client{Transport: &http.Transport{}}
+
+	get := func(method, path string, header http.Header) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, method, endpoint+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s %s returned %s", method, path, resp.Status)
+		}
+		return body, nil
+	}
+
+	token, err := get(http.MethodPut, "/latest/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"300"}})
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+	const path = "/latest/meta-data/iam/security-credentials/"
+	roles, err := get(http.MethodGet, path, header)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if len(role) == 0 {
+		return awsCredentials{}, fmt.Errorf("the instance has no IAM role")
+	}
+	body, err := get(http.MethodGet, path+url.PathEscape(role), header)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var doc struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(body, &doc); err != nil || len(doc.AccessKeyID) == 0 || len(doc.SecretAccessKey) == 0 {
+		return awsCredentials{}, fmt.Errorf("invalid credentials for the instance role %s", role)
+	}
+	return awsCredentials{accessKeyID: doc.AccessKeyID, secretAccessKey: doc.SecretAccessKey, sessionToken: doc.Token, expires: doc.Expiration}, nil
+}
+
+// signV4 signs req and its body with AWS Signature Version 4 at now, like
+// curl --aws-sigv4. Only the host and the x-amz headers are signed, the
+// other headers may still change without breaking the signature.
+func signV4(req *http.Request, body []byte, c awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	stamp := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", stamp)
+	if len(c.sessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := req.Host
+	if len(host) == 0 {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.Join(values, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.Join(strings.Fields(headers[name]), " "))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL, service),
+		canonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + c.secretAccessKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.accessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalPath returns the URI encoded path of u, every service but S3
+// encodes it twice.
+func canonicalPath(u *url.URL, service string) string {
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		segment = awsEscape(segment)
+		if service != "s3" {
+			segment = awsEscape(segment)
+		}
+		segments[i] = segment
+	}
+	path := strings.Join(segments, "/")
+	if len(path) == 0 {
+		return "/"
+	}
+	return path
+}
+
+// canonicalQuery returns the query of u sorted by name and value.
+func canonicalQuery(u *url.URL) string {
+	var params [][2]string
+	for name, values := range u.Query() {
+		for _, value := range values {
+			params = append(params, [2]string{awsEscape(name), awsEscape(value)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	encoded := make([]string, len(params))
+	for i, p := range params {
+		encoded[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// awsEscape percent encodes s leaving only the unreserved characters, as
+// SigV4 requires.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestSignV4(t *testing.T) {
+	// Examples of the AWS Signature Version 4 test suite.
+	creds := awsCredentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	tests := []struct {
+		method, url string
+		signature   string
+	}{
+		{"GET", "https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"POST", "https://example.amazonaws.com/", "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.url, nil)
+		signV4(req, nil, creds, "us-east-1", "service", now)
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + tt.signature
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s %s: expected %s, got %s", tt.method, tt.url, want, got)
+		}
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("expected X-Amz-Date 20150830T123600Z, got %s", got)
+		}
+	}
+
+	req, _ := http.NewRequest("PUT", "https://bucket.s3.amazonaws.com/a%20b", nil)
+	creds.sessionToken = "session"
+	signV4(req, []byte("body"), creds, "eu-west-1", "s3", now)
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,") {
+		t.Errorf("expected the payload hash and session token to be signed, got %s", got)
+	}
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != sha256Hex([]byte("body")) {
+		t.Errorf("expected the hash of the body, got %s", got)
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+	for _, tt := range []struct{ url, service, want string }{
+		{"https://h", "es", "/"},
+		{"https://h/a b/c", "es", "/a%2520b/c"},
+		{"https://h/a b/c", "s3", "/a%20b/c"},
+		{"https://h/_search", "es", "/_search"},
+	} {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		if got := canonicalPath(req.URL, tt.service); got != tt.want {
+			t.Errorf("%s %s: expected %s, got %s", tt.service, tt.url, tt.want, got)
+		}
+	}
+}
+
+// noAWSEnvironment points the credential chain at nothing.
+func noAWSEnvironment(t *testing.T) string {
+	dir := t.TempDir()
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return dir
+}
+
+func TestFindAWSCredentials(t *testing.T) {
+	dir := noAWSEnvironment(t)
+	if _, err := findAWSCredentials(context.Background()); err == nil {
+		t.Error("expected an error without credentials")
+	}
+
+	config := "[default]\nregion = eu-west-1\n[profile ops]\naws_access_key_id = AKIDCONFIG\naws_secret_access_key = config-secret\n"
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_PROFILE", "ops")
+	if c, err := findAWSCredentials(context.Background()); err != nil || c.accessKeyID != "AKIDCONFIG" {
+		t.Errorf("expected the ops profile of the config file, got %q %v", c.accessKeyID, err)
+	}
+
+	credentials := "# comment\n[ops]\naws_access_key_id = AKIDFILE\naws_secret_access_key = file-secret\naws_session_token = file-token\n"
+	if err := os.WriteFile(filepath.Join(dir, "credentials"), []byte(credentials), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := findAWSCredentials(context.Background()); err != nil || c.accessKeyID != "AKIDFILE" || c.sessionToken != "file-token" {
+		t.Errorf("expected the credentials file to win over the config file, got %q %v", c.accessKeyID, err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	if c, err := findAWSCredentials(context.Background()); err != nil || c.accessKeyID != "AKIDENV" {
+		t.Errorf("expected the environment to win, got %q %v", c.accessKeyID, err)
+	}
+}
+
+func TestInstanceCredentials(t *testing.T) {
+	noAWSEnvironment(t)
+	var fetches atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			fetches.Add(1)
+			w.Write([]byte("imds-token"))
+		case r.Header.Get("X-Aws-Ec2-Metadata-Token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("monitoring\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/monitoring":
+			w.Write([]byte(`{"Code":"Success","AccessKeyId":"ASIAROLE","SecretAccessKey":"role-secret","Token":"role-token","Expiration":"2030-01-01T00:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "")
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", ts.URL)
+
+	c, err := findAWSCredentials(context.Background())
+	if err != nil || c.accessKeyID != "ASIAROLE" || c.sessionToken != "role-token" || c.expires.Year() != 2030 {
+		t.Errorf("expected the credentials of the instance role, got %+v %v", c, err)
+	}
+
+	// The workers of --concurrency share a single fetch.
+	fetches.Store(0)
+	awsCredentialsCache = nil
+	defer func() { awsCredentialsCache = nil }()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c, err := loadAWSCredentials(context.Background()); err != nil || c.accessKeyID != "ASIAROLE" {
+				t.Errorf("expected the cached credentials, got %+v %v", c, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected the instance metadata service to be asked once, got %d", n)
+	}
+}
+
+func TestExecuteCheckSigV4(t *testing.T) {
+	noAWSEnvironment(t)
+	var authorization, date string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, date = r.Header.Get("Authorization"), r.Header.Get("X-Amz-Date")
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL+"/prod/health", "--aws-sigv4", "eu-west-1/execute-api", "--verbose")
+	status, out := run(t)
+	if status != sensu.CheckStateUnknown || !strings.Contains(out, "no AWS credentials found") {
+		t.Errorf("expected UNKNOWN without credentials, got %d: %s", status, out)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "very-secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session-secret")
+	setup(t, "--url", ts.URL+"/prod/health", "--aws-sigv4", "eu-west-1/execute-api", "--verbose")
+	status, out = run(t)
+	if status != sensu.CheckStateOK || !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(authorization, "/eu-west-1/execute-api/aws4_request") || len(date) == 0 {
+		t.Errorf("expected a signed request, got %d %q: %s", status, authorization, out)
+	}
+	for _, secret := range []string{"very-secret", "session-secret", "Signature="} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected %q to stay out of the output, got %s", secret, out)
+		}
+	}
+
+	for _, args := range [][]string{{"--aws-sigv4", "eu-west-1"}, {"--aws-sigv4", "/es"}, {"--aws-sigv4", "eu-west-1/es", "--user", "a:b"}} {
+		parseArgs(t, append([]string{"--url", ts.URL}, args...)...)
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("expected %q to be rejected", args)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// bindError is returned when a connection cannot be opened from the
+// --source-address or on the --interface, e.g. because the address is not
+// configured on this host.
+type bindError struct {
+	err error
+}
+
+func (e *bindError) Error() string {
+	cause := e.err
+	var sysErr *os.SyscallError
+	if errors.As(cause, &sysErr) {
+		cause = sysErr.Err
+	}
+	return fmt.Sprintf("unable to bind to %s: %v", sourceName(), cause)
+}
+
+func (e *bindError) Unwrap() error {
+	return e.err
+}
+
+// parseSourceAddress parses --source-address, returning nil when it is not
+// set.
+func parseSourceAddress(value string) (net.IP, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid --source-address %q, expected an IP address", value)
+	}
+	return ip, nil
+}
+
+// sourceName describes the --source-address and --interface connections are
+// bound to, for messages.
+func sourceName() string {
+	switch {
+	case sourceIP != nil && len(plugin.Interface) > 0:
+		return fmt.Sprintf("source address %s on interface %s", sourceIP, plugin.Interface)
+	case sourceIP != nil:
+		return "source address " + sourceIP.String()
+	}
+	return "interface " + plugin.Interface
+}
+
+// bound reports whether connections are pinned to a source address or
+// interface.
+func bound() bool {
+	return sourceIP != nil || len(plugin.Interface) > 0
+}
+
+// bindDialer makes dialer open its connections from the --source-address and
+// on the --interface, if set.
+func bindDialer(dialer *net.Dialer) {
+	if sourceIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: sourceIP}
+	}
+	if len(plugin.Interface) > 0 {
+		dialer.Control = bindToDevice(plugin.Interface)
+	}
+}
+
+// withSource wraps dial so that failing to bind the connection is reported
+// as a bindError, which is a problem with this host rather than the server.
+func withSource(dial dialFunc) dialFunc {
+	if !bound() {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil && isBindError(err) {
+			return nil, &bindError{err: err}
+		}
+		return conn, err
+	}
+}
+
+// isBindError reports whether err comes from binding the socket rather than
+// connecting it. An address of the other family than the --source-address
+// cannot be dialed at all.
+func isBindError(err error) bool {
+	var (
+		sysErr  *os.SyscallError
+		addrErr *net.AddrError
+	)
+	switch {
+	case errors.As(err, &sysErr):
+		return sysErr.Syscall == "bind" || sysErr.Syscall == "setsockopt"
+	case errors.As(err, &addrErr):
+		return addrErr.Err == "no suitable address found"
+	}
+	return false
+}
+
+// listenPacket opens the UDP socket of a QUIC connection, bound like the TCP
+// connections.
+func listenPacket(ctx context.Context) (net.PacketConn, error) {
+	var lc net.ListenConfig
+	if len(plugin.Interface) > 0 {
+		lc.Control = bindToDevice(plugin.Interface)
+	}
+	addr := ":0"
+	if sourceIP != nil {
+		addr = net.JoinHostPort(sourceIP.String(), "0")
+	}
+	conn, err := lc.ListenPacket(ctx, "udp", addr)
+	if err != nil {
+		return nil, &bindError{err: err}
+	}
+	return conn, nil
+}
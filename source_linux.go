@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// interfaceSupported reports whether --interface is available, binding a
+// socket to a device needs SO_BINDTODEVICE.
+const interfaceSupported = true
+
+// bindToDevice returns a socket Control function setting SO_BINDTODEVICE,
+// so that the connection leaves through the named interface whatever the
+// routing table says.
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.BindToDevice(int(fd), name)
+		}); cerr != nil {
+			return cerr
+		}
+		return os.NewSyscallError("setsockopt", err)
+	}
+}
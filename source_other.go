@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// interfaceSupported reports whether --interface is available, binding a
+// socket to a device needs SO_BINDTODEVICE.
+const interfaceSupported = false
+
+// bindToDevice is never called, --interface is rejected on this platform.
+func bindToDevice(string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
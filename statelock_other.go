@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import "os"
+
+// openStateFile opens or creates the state file at path.
+func openStateFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+}
+
+// lockFile does nothing, concurrent runs may overwrite each other's update
+// of the state file on this platform.
+func lockFile(*os.File) error {
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// statusRule maps a status code, or a class of them such as 5xx, to the
+// status of the check.
+type statusRule struct {
+	pattern string
+	status  string
+}
+
+// parseStatusMap parses a comma separated --status-map such as
+// "503=warning,429=ok,4xx=critical" into rules by code or class.
+func parseStatusMap(list string) (map[string]statusRule, error) {
+	rules := map[string]statusRule{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		pattern, state, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --status-map entry %q, expected code=status", entry)
+		}
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if !validStatusPattern(pattern) {
+			return nil, fmt.Errorf("invalid --status-map entry %q, expected a code between 100 and 599 or a class such as 5xx", entry)
+		}
+		status := strings.ToUpper(strings.TrimSpace(state))
+		switch status {
+		case "OK", "WARNING", "CRITICAL":
+		default:
+			return nil, fmt.Errorf("invalid --status-map entry %q, the status must be one of ok, warning or critical", entry)
+		}
+		if rule, ok := rules[pattern]; ok && rule.status != status {
+			return nil, fmt.Errorf("conflicting --status-map entries for %s, %s and %s", pattern, strings.ToLower(rule.status), strings.ToLower(status))
+		}
+		rules[pattern] = statusRule{pattern: pattern, status: status}
+	}
+	return rules, nil
+}
+
+// validStatusPattern reports whether pattern is a status code or a class
+// of them.
+func validStatusPattern(pattern string) bool {
+	if len(pattern) != 3 || pattern[0] < '1' || pattern[0] > '5' {
+		return false
+	}
+	if pattern[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(pattern)
+	return err == nil
+}
+
+// mapStatus returns the --status-map rule for code, a rule for the code
+// itself wins over the one for its class.
+func mapStatus(code int, rules map[string]statusRule) (statusRule, bool) {
+	if rule, ok := rules[strconv.Itoa(code)]; ok {
+		return rule, true
+	}
+	rule, ok := rules[fmt.Sprintf("%dxx", code/100)]
+	return rule, ok
+}
+
+// checkStatusCode returns the status code earns the check and the details
+// explaining it. An unexpected status code is reported regardless of how
+// fast it arrived, but the timings are still emitted.
+func checkStatusCode(code int) (string, string) {
+	if rule, mapped := mapStatus(code, statusMap); mapped {
+		return rule.status, fmt.Sprintf(" (--status-map %s=%s)", rule.pattern, strings.ToLower(rule.status))
+	}
+	switch {
+	case len(expectedStatus) > 0:
+		if !statusExpected(code, expectedStatus) {
+			return "CRITICAL", fmt.Sprintf(" (expected %s)", plugin.ExpectStatus)
+		}
+	case code == http.StatusTooManyRequests:
+		// Being throttled is an outage for the client, whatever
+		// --status-ok-anything says.
+		return "CRITICAL", ""
+	case plugin.StatusOkAnything:
+	case code >= 500:
+		return "CRITICAL", ""
+	case code >= 400:
+		return "WARNING", ""
+	}
+	return "OK", ""
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestParseStatusMap(t *testing.T) {
+	rules, err := parseStatusMap("503=warning, 429=OK,4xx=critical,5XX=critical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code, want := range map[int]string{503: "503=WARNING", 502: "5xx=CRITICAL", 429: "429=OK", 404: "4xx=CRITICAL", 200: ""} {
+		rule, ok := mapStatus(code, rules)
+		if got := rule.pattern + "=" + rule.status; ok != (len(want) > 0) || ok && got != want {
+			t.Errorf("code %d: expected %q, got %q", code, want, got)
+		}
+	}
+	for _, bad := range []string{"503", "503=fine", "6xx=ok", "5x=ok", "abc=ok", "099=ok", "503=ok,503=critical", "4xx=ok,4XX=warning"} {
+		if _, err := parseStatusMap(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if _, err := parseStatusMap("503=ok,503=ok"); err != nil {
+		t.Errorf("expected a repeated entry to be accepted, got %v", err)
+	}
+}
+
+func TestExecuteCheckStatusMap(t *testing.T) {
+	var code int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		code   int
+		args   []string
+		status int
+		detail string
+	}{
+		{503, nil, sensu.CheckStateWarning, "(--status-map 503=warning)"},
+		{502, nil, sensu.CheckStateCritical, "(--status-map 5xx=critical)"},
+		{429, nil, sensu.CheckStateOK, "(--status-map 429=ok)"},
+		{401, nil, sensu.CheckStateCritical, "(--status-map 4xx=critical)"},
+		{200, nil, sensu.CheckStateOK, ""},
+		// The worse of the mapped status and the latency wins.
+		{429, []string{"--warning", "0", "--critical", "0.000001"}, sensu.CheckStateCritical, "(--status-map 429=ok)"},
+		// A mapped code is not held against --expect-status.
+		{503, []string{"--expect-status", "200"}, sensu.CheckStateWarning, "(--status-map 503=warning)"},
+	}
+	for _, tt := range tests {
+		code = tt.code
+		setup(t, append([]string{"--url", ts.URL, "--status-map", "503=warning,429=ok,4xx=critical,5xx=critical"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.detail) {
+			t.Errorf("%d %v: expected %d with %q, got %d: %s", tt.code, tt.args, tt.status, tt.detail, status, out)
+		}
+	}
+}
+
+func TestCheckArgsStatusMap(t *testing.T) {
+	parseArgs(t, "--url", "http://example.com", "--status-map", "503=ok,503=critical")
+	if status, err := checkArgs(nil); err == nil || status != sensu.CheckStateUnknown || !strings.Contains(err.Error(), "conflicting") {
+		t.Errorf("expected UNKNOWN for a conflicting map, got %d %v", status, err)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DoctorOgg/sensu-http-perf-go/httpperf"
+)
+
+// tlsVersions maps the version names accepted by the TLS options.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// weakCiphers are the TLS 1.2 and older suites --weak-cipher-status alerts
+// on: those without an AEAD mode, using RC4 or 3DES, or relying on RSA key
+// exchange without forward secrecy.
+var weakCiphers = map[uint16]bool{
+	tls.TLS_RSA_WITH_RC4_128_SHA:                true,
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA:           true,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:            true,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:            true,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256:         true,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:         true,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:         true,
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:        true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:    true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA:    true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: true,
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA:          true,
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:     true,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:      true,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:      true,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   true,
+}
+
+// weakCipher reports whether the connection of state negotiated one of the
+// weakCiphers. The TLS 1.3 suites are all strong.
+func weakCipher(state *tls.ConnectionState) bool {
+	return state.Version < tls.VersionTLS13 && weakCiphers[state.CipherSuite]
+}
+
+// parseTLSVersion parses a TLS version such as "1.2", returning 0 for an
+// empty value.
+func parseTLSVersion(value string) (uint16, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	version, ok := tlsVersions[strings.TrimPrefix(strings.ToUpper(value), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q, must be one of 1.0, 1.1, 1.2 or 1.3", value)
+	}
+	return version, nil
+}
+
+// parsePins validates --pin-sha256 values, base64 SHA-256 hashes optionally
+// prefixed with "sha256//" as used by HPKP and curl.
+func parsePins(values []string) ([]string, error) {
+	var pins []string
+	for _, value := range values {
+		pin := strings.TrimPrefix(strings.TrimSpace(value), "sha256//")
+		if hash, err := base64.StdEncoding.DecodeString(pin); err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid --pin-sha256 %q, expected a base64 SHA-256 hash", value)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// spkiHash returns the base64 SHA-256 hash of the public key of cert.
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// pinError is returned when the server public key matches none of the pins.
+type pinError struct {
+	expected []string
+	got      string
+}
+
+func (e *pinError) Error() string {
+	return fmt.Sprintf("certificate pin mismatch: expected %s, got %s", strings.Join(e.expected, " or "), e.got)
+}
+
+// verifyPins returns a VerifyPeerCertificate callback accepting a leaf
+// certificate whose SPKI hash is one of pins. It runs after the regular chain
+// verification, so a pinned certificate must still be trusted.
+func verifyPins(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return &pinError{expected: pins, got: "no certificate"}
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		got := spkiHash(leaf)
+		for _, pin := range pins {
+			if got == pin {
+				return nil
+			}
+		}
+		return &pinError{expected: pins, got: got}
+	}
+}
+
+// loadCertPool builds a pool from the PEM bundle in file and the PEM files in
+// dir. It returns nil when neither is set so the system roots are used.
+func loadCertPool(file, dir string) (*x509.CertPool, error) {
+	if len(file) == 0 && len(dir) == 0 {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	if len(file) > 0 {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read --ca-file: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("--ca-file %s contains no PEM certificates", file)
+		}
+	}
+	if len(dir) > 0 {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read --ca-path: %v", err)
+		}
+		var found bool
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			// Follow symlinks such as the hashed names of c_rehash.
+			if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read --ca-path: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("--ca-path file %s contains no PEM certificates", path)
+			}
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("--ca-path %s contains no certificates", dir)
+		}
+	}
+	return pool, nil
+}
+
+// loadClientCertificate loads the client certificate and key for mutual TLS,
+// decrypting a legacy encrypted PEM key with password.
+func loadClientCertificate(certFile, keyFile, password string) (tls.Certificate, error) {
+	if len(password) == 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("unable to load --client-cert and --client-key: %v", err)
+		}
+		return cert, nil
+	}
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to read --client-cert: %v", err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to read --client-key: %v", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return tls.Certificate{}, fmt.Errorf("--client-key %s contains no PEM data", keyFile)
+	}
+	// Legacy PEM encryption, as written by openssl rsa -des3, is the only
+	// kind the standard library can decrypt.
+	if x509.IsEncryptedPEMBlock(block) {
+		der, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("unable to decrypt --client-key: %v", err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to load --client-cert and --client-key: %v", err)
+	}
+	return cert, nil
+}
+
+// certificateChain returns the verified chain of the connection, or the
+// certificates sent by the server when verification was skipped.
+func certificateChain(state *tls.ConnectionState) []*x509.Certificate {
+	if len(state.VerifiedChains) > 0 {
+		return state.VerifiedChains[0]
+	}
+	return state.PeerCertificates
+}
+
+// soonestExpiry returns the certificate of chain that expires first along
+// with its position: leaf, intermediate or root.
+func soonestExpiry(chain []*x509.Certificate) (*x509.Certificate, string) {
+	var soonest int
+	for i, cert := range chain {
+		if cert.NotAfter.Before(chain[soonest].NotAfter) {
+			soonest = i
+		}
+	}
+	cert := chain[soonest]
+	switch {
+	case soonest == 0:
+		return cert, "leaf"
+	case bytes.Equal(cert.RawIssuer, cert.RawSubject):
+		return cert, "root"
+	}
+	return cert, "intermediate"
+}
+
+// describeVerifyError explains a certificate verification failure found in
+// err, which otherwise only shows up as a wrapped one-line request error.
+func describeVerifyError(err error) (string, bool) {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &unknownAuthority):
+		message := "certificate verification failed: " + unknownAuthority.Error()
+		if cert := unknownAuthority.Cert; cert != nil {
+			message += fmt.Sprintf(" (CN=%s issued by CN=%s)", cert.Subject.CommonName, cert.Issuer.CommonName)
+		}
+		return message, true
+	case errors.As(err, &hostname):
+		return "certificate verification failed: " + hostname.Error(), true
+	case errors.As(err, &invalid):
+		message := "certificate verification failed: " + invalid.Error()
+		if invalid.Reason == x509.Expired && invalid.Cert != nil {
+			message += fmt.Sprintf(" (CN=%s expired %s)", invalid.Cert.Subject.CommonName, invalid.Cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+		return message, true
+	}
+	return "", false
+}
+
+// certExpiryDays returns the days left until cert expires, rounded to two
+// decimals and negative once it has expired.
+func certExpiryDays(cert *x509.Certificate, now time.Time) float64 {
+	days := cert.NotAfter.Sub(now).Hours() / 24
+	return math.Round(days*100) / 100
+}
+
+// checkConnectionTLS adds the version, cipher suite and ALPN protocol of the
+// TLS connection state to verdict and raises it for --fail-on-tls-below,
+// --weak-cipher-status and --expect-alpn.
+func checkConnectionTLS(verdict *measurement, state *tls.ConnectionState, t httpperf.Timings) {
+	verdict.details += fmt.Sprintf(" tls=%s cipher=%s", httpperf.TLSVersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	if tlsFailBelow > 0 && state.Version < tlsFailBelow {
+		verdict.raise("CRITICAL", reasonTLSVersion)
+		verdict.details += fmt.Sprintf(" (expected %s or newer)", httpperf.TLSVersionName(tlsFailBelow))
+	}
+	if plugin.WeakCipherStatus != "ok" && weakCipher(state) {
+		verdict.raise(strings.ToUpper(plugin.WeakCipherStatus), reasonWeakCipher)
+		verdict.details += " (weak cipher)"
+	}
+	// A reused connection had its handshake before this request.
+	alpn := t.ALPN
+	if len(alpn) == 0 {
+		alpn = state.NegotiatedProtocol
+	}
+	if len(alpn) > 0 {
+		verdict.details += " alpn=" + alpn
+	}
+	if len(plugin.ExpectAlpn) > 0 && alpn != plugin.ExpectAlpn {
+		if len(alpn) == 0 {
+			verdict.details += " alpn=none"
+		}
+		alpnStatus := "WARNING"
+		if plugin.ExpectAlpnCritical {
+			alpnStatus = "CRITICAL"
+		}
+		verdict.raise(alpnStatus, reasonALPN)
+		verdict.details += fmt.Sprintf(" (expected alpn=%s)", plugin.ExpectAlpn)
+	}
+}
+
+// checkCertificates evaluates the expiry of the server certificate, and of
+// the rest of its chain with --check-chain, its identity and its OCSP
+// staple, raising verdict and returning their metrics.
+func checkCertificates(verdict *measurement, state *tls.ConnectionState) []metric {
+	now := time.Now()
+	leaf := state.PeerCertificates[0]
+	days := certExpiryDays(leaf, now)
+	metrics := []metric{expiryMetric("cert_expiry_days", days)}
+	verdict.raise(expiryStatus(days), "")
+	if plugin.CertExpiryWarning > 0 || plugin.CertExpiryCritical > 0 {
+		verdict.details += fmt.Sprintf(" cert CN=%s expires %s (%.1f days)", leaf.Subject.CommonName, leaf.NotAfter.UTC().Format(time.RFC3339), days)
+	}
+
+	// The expiry thresholds also apply to every other certificate of the
+	// chain, an intermediate may expire before the leaf.
+	if plugin.CheckChain {
+		cert, position := soonestExpiry(certificateChain(state))
+		days := certExpiryDays(cert, now)
+		metrics = append(metrics, expiryMetric("chain_min_expiry_days", days))
+		verdict.raise(expiryStatus(days), "")
+		verdict.details += fmt.Sprintf(" chain_min_expiry %s CN=%s (%.1f days)", position, cert.Subject.CommonName, days)
+	}
+
+	// The presented certificate is checked even when it was not verified,
+	// that is when it matters most which one was served.
+	identityStatus, identityDetails := checkCertIdentity(leaf)
+	verdict.raise(identityStatus, reasonCertMismatch)
+	verdict.details += identityDetails
+
+	if plugin.RequireOcspStaple {
+		ocspStatus, ocspDetails, ocspMetrics := checkOCSPStaple(state, now)
+		verdict.raise(ocspStatus, reasonOCSP)
+		verdict.details += ocspDetails
+		metrics = append(metrics, ocspMetrics...)
+	}
+	return metrics
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// writeCertPEM writes cert as a PEM file in dir and returns its path.
+func writeCertPEM(t *testing.T, dir string, cert *x509.Certificate) string {
+	t.Helper()
+	path := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCertPool(t *testing.T) {
+	if pool, err := loadCertPool("", ""); pool != nil || err != nil {
+		t.Errorf("expected the system roots without options, got %v, %v", pool, err)
+	}
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	dir := t.TempDir()
+	ca := writeCertPEM(t, dir, ts.Certificate())
+	if _, err := loadCertPool(ca, ""); err != nil {
+		t.Errorf("unexpected error loading --ca-file: %v", err)
+	}
+	if _, err := loadCertPool("", dir); err != nil {
+		t.Errorf("unexpected error loading --ca-path: %v", err)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.pem")
+	os.WriteFile(bad, []byte("not a certificate"), 0o600)
+	for _, tt := range []struct{ file, dir string }{
+		{filepath.Join(dir, "missing.pem"), ""},
+		{bad, ""},
+		{"", filepath.Dir(bad)},
+		{"", t.TempDir()},
+		{"", filepath.Join(dir, "missing")},
+	} {
+		if _, err := loadCertPool(tt.file, tt.dir); err == nil {
+			t.Errorf("expected an error for file %q dir %q", tt.file, tt.dir)
+		}
+	}
+}
+
+func TestCertExpiryDays(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		notAfter time.Time
+		want     float64
+	}{
+		{now.Add(30 * 24 * time.Hour), 30},
+		{now.Add(36 * time.Hour), 1.5},
+		{now.Add(-48 * time.Hour), -2},
+	}
+	for _, tt := range tests {
+		if got := certExpiryDays(&x509.Certificate{NotAfter: tt.notAfter}, now); got != tt.want {
+			t.Errorf("%s: expected %g days, got %g", tt.notAfter, tt.want, got)
+		}
+	}
+}
+
+// writeClientCert creates a self-signed client certificate and key in dir,
+// encrypting the key when password is set, and returns the certificate and
+// the file paths.
+func writeClientCert(t *testing.T, dir, password string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sensu-agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	keyBlock := &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}
+	if len(password) > 0 {
+		keyBlock, err = x509.EncryptPEMBlock(rand.Reader, keyBlock.Type, keyDER, []byte(password), x509.PEMCipherAES256)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(keyBlock), 0o600)
+	return cert, certFile, keyFile
+}
+
+func TestLoadClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeClientCert(t, dir, "")
+	if _, err := loadClientCertificate(certFile, keyFile, ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	encrypted := t.TempDir()
+	_, certFile, keyFile = writeClientCert(t, encrypted, "s3cret")
+	if _, err := loadClientCertificate(certFile, keyFile, "s3cret"); err != nil {
+		t.Errorf("unexpected error with the key password: %v", err)
+	}
+	if _, err := loadClientCertificate(certFile, keyFile, "wrong"); err == nil {
+		t.Error("expected an error with the wrong key password")
+	}
+	if _, err := loadClientCertificate(certFile, keyFile, ""); err == nil {
+		t.Error("expected an error for an encrypted key without password")
+	}
+	if _, err := loadClientCertificate(filepath.Join(dir, "missing.pem"), keyFile, ""); err == nil {
+		t.Error("expected an error for a missing certificate")
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		value string
+		want  uint16
+		err   bool
+	}{
+		{"", 0, false},
+		{"1.0", tls.VersionTLS10, false},
+		{"1.2", tls.VersionTLS12, false},
+		{"TLS1.3", tls.VersionTLS13, false},
+		{"1.4", 0, true},
+		{"ssl3", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTLSVersion(tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseTLSVersion(%q): expected %d (error %t), got %d, %v", tt.value, tt.want, tt.err, got, err)
+		}
+	}
+}
+
+func TestExecuteCheckWeakCipher(t *testing.T) {
+	weak := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	weak.TLS = &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}}
+	weak.StartTLS()
+	defer weak.Close()
+	strong := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer strong.Close()
+
+	tests := []struct {
+		url    string
+		args   []string
+		status int
+		want   string
+	}{
+		{weak.URL, nil, sensu.CheckStateOK, " cipher=TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA alpn="},
+		{weak.URL, []string{"--weak-cipher-status", "warning"}, sensu.CheckStateWarning, " cipher=TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA (weak cipher)"},
+		{weak.URL, []string{"--weak-cipher-status", "critical"}, sensu.CheckStateCritical, " reason=weak_cipher | "},
+		{weak.URL, []string{"--output-format", "json"}, sensu.CheckStateOK, `"tls_cipher":"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA"`},
+		{strong.URL, []string{"--weak-cipher-status", "critical"}, sensu.CheckStateOK, " tls=TLS1.3 cipher=TLS_AES_128_GCM_SHA256 alpn="},
+	}
+	for _, tt := range tests {
+		setup(t, append([]string{"--url", tt.url, "--insecure-skip-verify"}, tt.args...)...)
+		status, out := run(t)
+		if status != tt.status || !strings.Contains(out, tt.want) {
+			t.Errorf("%q: expected state %d with %q, got %d: %s", tt.args, tt.status, tt.want, status, out)
+		}
+	}
+
+	parseArgs(t, "--url", weak.URL, "--weak-cipher-status", "unknown")
+	if _, err := checkArgs(nil); err == nil {
+		t.Error("expected an error for --weak-cipher-status unknown")
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	valid := "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	pins, err := parsePins([]string{valid, "sha256//" + valid})
+	if err != nil || len(pins) != 2 || pins[1] != valid {
+		t.Errorf("unexpected result %q, %v", pins, err)
+	}
+	for _, value := range []string{"not base64!", "c2hvcnQ=", ""} {
+		if _, err := parsePins([]string{value}); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestSoonestExpiry(t *testing.T) {
+	now := time.Now()
+	leaf := &x509.Certificate{NotAfter: now.Add(90 * 24 * time.Hour), RawSubject: []byte("leaf"), RawIssuer: []byte("intermediate")}
+	intermediate := &x509.Certificate{NotAfter: now.Add(30 * 24 * time.Hour), RawSubject: []byte("intermediate"), RawIssuer: []byte("root")}
+	root := &x509.Certificate{NotAfter: now.Add(10 * 24 * time.Hour), RawSubject: []byte("root"), RawIssuer: []byte("root")}
+
+	tests := []struct {
+		chain    []*x509.Certificate
+		want     *x509.Certificate
+		position string
+	}{
+		{[]*x509.Certificate{leaf}, leaf, "leaf"},
+		{[]*x509.Certificate{leaf, intermediate}, intermediate, "intermediate"},
+		{[]*x509.Certificate{leaf, intermediate, root}, root, "root"},
+	}
+	for i, tt := range tests {
+		cert, position := soonestExpiry(tt.chain)
+		if cert != tt.want || position != tt.position {
+			t.Errorf("%d: expected the %s, got %s", i, tt.position, position)
+		}
+	}
+}
+
+func TestDescribeVerifyError(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "www.example.com"}, Issuer: pkix.Name{CommonName: "Private CA"}}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("Get: %w", x509.UnknownAuthorityError{Cert: cert}), "certificate verification failed: x509: certificate signed by unknown authority (CN=www.example.com issued by CN=Private CA)"},
+		{fmt.Errorf("Get: %w", x509.HostnameError{Certificate: cert, Host: "example.org"}), "certificate verification failed: x509: certificate is not valid for any names, but wanted to match example.org"},
+		{fmt.Errorf("Get: %w", x509.CertificateInvalidError{Cert: cert, Reason: x509.NotAuthorizedToSign}), "certificate verification failed: x509: certificate is not authorized to sign other certificates"},
+	}
+	for _, tt := range tests {
+		if got, ok := describeVerifyError(tt.err); !ok || got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+	if _, ok := describeVerifyError(errors.New("connection refused")); ok {
+		t.Error("expected other errors to be left alone")
+	}
+}
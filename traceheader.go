@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// traceHeaderName returns the header sent by --trace-header.
+func traceHeaderName() string {
+	if plugin.TraceHeader == "request-id" {
+		return "X-Request-Id"
+	}
+	return "Traceparent"
+}
+
+// traceKey returns how the ID of --trace-header is labelled in the output,
+// trace_id for traceparent, whose trace ID is what tracing backends search
+// by, and request_id otherwise.
+func traceKey() string {
+	if plugin.TraceHeader == "request-id" {
+		return "request_id"
+	}
+	return "trace_id"
+}
+
+// setTraceHeader sends req with a fresh --trace-header and returns the ID to
+// look it up by, empty when the option is not set.
+func setTraceHeader(req *http.Request) (string, error) {
+	switch plugin.TraceHeader {
+	case "traceparent":
+		traceID, header, err := newTraceparent()
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set(traceHeaderName(), header)
+		return traceID, nil
+	case "request-id":
+		id, err := newUUID()
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set(traceHeaderName(), id)
+		return id, nil
+	}
+	return "", nil
+}
+
+// requestTraceID returns the ID of the --trace-header req was sent with,
+// empty when it has none.
+func requestTraceID(req *http.Request) string {
+	if req == nil || len(plugin.TraceHeader) == 0 {
+		return ""
+	}
+	value := req.Header.Get(traceHeaderName())
+	if plugin.TraceHeader == "traceparent" {
+		if parts := strings.Split(value, "-"); len(parts) == 4 {
+			return parts[1]
+		}
+		return ""
+	}
+	return value
+}
+
+// newTraceparent returns a random W3C trace context trace ID and the sampled
+// traceparent header carrying it, version 00 with a random parent ID.
+func newTraceparent() (string, string, error) {
+	traceID, err := randomNonZero(16)
+	if err != nil {
+		return "", "", err
+	}
+	parentID, err := randomNonZero(8)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(traceID), fmt.Sprintf("00-%x-%x-01", traceID, parentID), nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate a request ID: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// randomNonZero returns n random bytes, the trace context forbids IDs that
+// are all zero.
+func randomNonZero(n int) ([]byte, error) {
+	b := make([]byte, n)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("unable to generate a trace ID: %v", err)
+		}
+		for _, c := range b {
+			if c != 0 {
+				return b, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+var (
+	traceparentFormat = regexp.MustCompile(`^00-([0-9a-f]{32})-[0-9a-f]{16}-01$`)
+	uuidFormat        = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+)
+
+func TestNewTraceparent(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		traceID, header, err := newTraceparent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := traceparentFormat.FindStringSubmatch(header)
+		if m == nil || m[1] != traceID {
+			t.Fatalf("expected a version 00 traceparent with trace ID %s, got %q", traceID, header)
+		}
+		if traceID == strings.Repeat("0", 32) || strings.Contains(header, "-"+strings.Repeat("0", 16)+"-") {
+			t.Fatalf("expected non-zero IDs, got %q", header)
+		}
+		if seen[traceID] {
+			t.Fatalf("expected a fresh trace ID, got %s twice", traceID)
+		}
+		seen[traceID] = true
+	}
+}
+
+func TestNewUUID(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id, err := newUUID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uuidFormat.MatchString(id) {
+			t.Fatalf("expected a version 4 UUID, got %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("expected a fresh UUID, got %s twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestExecuteCheckTraceHeader(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r.Header.Get("Traceparent")+r.Header.Get("X-Request-Id"))
+		if r.URL.RawQuery == "big" {
+			w.Write([]byte("too large"))
+		}
+	}))
+	defer ts.Close()
+
+	setup(t, "--url", ts.URL, "--trace-header", "traceparent")
+	status, out := run(t)
+	m := traceparentFormat.FindStringSubmatch(received[0])
+	if status != sensu.CheckStateOK || m == nil || !strings.Contains(out, " trace_id="+m[1]+" ") {
+		t.Errorf("expected the trace ID of %q in the output, got %d: %s", received[0], status, out)
+	}
+
+	received = nil
+	setup(t, "--url", ts.URL, "--trace-header", "request-id")
+	status, out = run(t)
+	if status != sensu.CheckStateOK || !uuidFormat.MatchString(received[0]) || !strings.Contains(out, " request_id="+received[0]+" ") {
+		t.Errorf("expected the request ID %q in the output, got %d: %s", received[0], status, out)
+	}
+
+	received = nil
+	setup(t, "--url", ts.URL, "--trace-header", "request-id", "--samples", "3", "--sample-interval", "1", "--verbose")
+	debugOutput = io.Discard
+	defer func() { debugOutput = os.Stderr }()
+	status, out = run(t)
+	if len(received) != 3 || received[0] == received[1] || received[1] == received[2] {
+		t.Fatalf("expected an ID per sample, got %q", received)
+	}
+	if status != sensu.CheckStateOK || !strings.Contains(out, " request_ids="+strings.Join(received, ",")+" ") {
+		t.Errorf("expected every sample ID in the verbose output, got %d: %s", status, out)
+	}
+
+	// A failed request still tells which one it was.
+	received = nil
+	setup(t, "--url", ts.URL+"/?big", "--trace-header", "request-id", "--read-body", "--max-body-bytes", "1")
+	status, out = run(t)
+	if status != sensu.CheckStateCritical || !strings.Contains(out, " request_id="+received[0]) {
+		t.Errorf("expected the request ID in the failure, got %d: %s", status, out)
+	}
+
+	parseArgs(t, "--url", ts.URL, "--trace-header", "traceparent", "--header", "traceparent: 00-abc")
+	if _, err := checkArgs(nil); err == nil {
+		t.Errorf("expected --trace-header and a traceparent --header to conflict")
+	}
+}
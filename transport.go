@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// transportKey identifies a transport. Each one pins the TLS server name,
+// the first hop may use the --host-header override while later hops can land
+// on other hosts. The --sni and --pin-sha256 options only apply to the host
+// of the URL.
+type transportKey struct {
+	name   string
+	pinned bool
+	h2c    bool
+	h3     bool
+}
+
+// transportCache holds the transports of a check so that samples and retries
+// can reuse connections, unless --no-keepalive is set, and the concurrent
+// requests of --concurrency share it. overrides are the --resolve entries
+// along with the address forced by --all-ips.
+type transportCache struct {
+	overrides map[string]string
+
+	mu         sync.Mutex
+	transports map[transportKey]http.RoundTripper
+
+	// clientCertPresented is set once a server asked for the client
+	// certificate.
+	clientCertPresented atomic.Bool
+
+	// sessions keeps the TLS sessions for new connections to resume with
+	// --measure-resumption, otherwise every connection does a full
+	// handshake.
+	sessions tls.ClientSessionCache
+}
+
+func newTransportCache(overrides map[string]string) *transportCache {
+	c := &transportCache{
+		overrides:  overrides,
+		transports: map[transportKey]http.RoundTripper{},
+	}
+	if plugin.MeasureResumption {
+		c.sessions = tls.NewLRUClientSessionCache(0)
+	}
+	return c
+}
+
+// get returns the transport for key, creating it on first use.
+func (c *transportCache) get(key transportKey) http.RoundTripper {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if transport, ok := c.transports[key]; ok {
+		return transport
+	}
+	transport := newTransport(key, c.overrides, c.sessions, func() { c.clientCertPresented.Store(true) })
+	c.transports[key] = transport
+	return transport
+}
+
+// newTransport creates the transport for key from the options, connecting to
+// the addresses in overrides and resuming the TLS sessions kept in sessions,
+// if any. clientCertPresented is called when a server asks for the client
+// certificate.
+func newTransport(key transportKey, overrides map[string]string, sessions tls.ClientSessionCache, clientCertPresented func()) http.RoundTripper {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: plugin.InsecureSkipVerify,
+		ServerName:         key.name,
+		RootCAs:            rootCAs,
+		MinVersion:         tlsMinVersion,
+		MaxVersion:         tlsMaxVersion,
+		ClientSessionCache: sessions,
+	}
+	if len(clientCertificates) > 0 {
+		// Only servers that ask for it get the certificate, the output notes
+		// when that happened.
+		tlsConfig.GetClientCertificate = func(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			clientCertPresented()
+			return &clientCertificates[0], nil
+		}
+	}
+	if key.pinned && len(pins) > 0 {
+		tlsConfig.VerifyPeerCertificate = verifyPins(pins)
+	}
+	dialer := &net.Dialer{
+		Timeout:  connectTimeout(),
+		Resolver: newResolver(dnsServer, plugin.Resolver),
+	}
+	bindDialer(dialer)
+	dial := withSource(withConnectTimeout(newDialContext(dialer, plugin.IpVersion, overrides)))
+	if len(plugin.UnixSocket) > 0 {
+		dial = unixDialContext(dialer, plugin.UnixSocket)
+	}
+	var transport http.RoundTripper
+	if key.h3 {
+		transport = newHTTP3Transport(tlsConfig, dialer.Resolver, plugin.IpVersion, overrides)
+	} else if key.h2c {
+		// Cleartext HTTP/2 with prior knowledge, the "TLS" dial is a plain
+		// connection.
+		transport = &http2.Transport{
+			AllowHTTP:          true,
+			DisableCompression: len(plugin.AcceptEncoding) > 0,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}
+	} else {
+		t := &http.Transport{
+			DialContext:           dial,
+			Proxy:                 proxyFor,
+			TLSHandshakeTimeout:   time.Duration(plugin.TlsTimeout) * time.Millisecond,
+			TLSClientConfig:       tlsConfig,
+			ResponseHeaderTimeout: time.Duration(plugin.Timeout) * time.Second,
+			DisableKeepAlives:     plugin.NoKeepalive,
+			DisableCompression:    len(plugin.AcceptEncoding) > 0,
+			ExpectContinueTimeout: expectContinueTimeout(),
+			// A custom TLS config disables HTTP/2 unless asked for.
+			ForceAttemptHTTP2: plugin.HttpVersion != "1.1",
+		}
+		// Keep a connection for every worker of --concurrency.
+		if plugin.Concurrency > http.DefaultMaxIdleConnsPerHost {
+			t.MaxIdleConnsPerHost = plugin.Concurrency
+		}
+		if plugin.HttpVersion == "1.1" {
+			t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+		transport = t
+	}
+	return transport
+}
+
+// close closes the idle connections of every transport, QUIC connections
+// keep their UDP socket open until then.
+func (c *transportCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, transport := range c.transports {
+		if closer, ok := transport.(io.Closer); ok {
+			closer.Close()
+		} else if t, ok := transport.(interface{ CloseIdleConnections() }); ok {
+			t.CloseIdleConnections()
+		}
+	}
+}
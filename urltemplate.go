@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// expandURL expands the --url template with the Sensu event as its data, e.g.
+// https://{{ .Entity.Name }}.internal/healthz, and then the $VAR or ${VAR}
+// environment variables it references, e.g. https://$HOSTNAME/healthz for
+// agents run outside Sensu.
+func expandURL(raw string, event *corev2.Event) (string, error) {
+	expanded := raw
+	if strings.Contains(raw, "{{") {
+		if event == nil {
+			return "", fmt.Errorf("--url %q is a template but there is no Sensu event to expand it with, the check needs stdin: true and --annotations-from-stdin", raw)
+		}
+		tmpl, err := template.New("url").Option("missingkey=error").Parse(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid --url template: %v", err)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, event); err != nil {
+			return "", fmt.Errorf("unable to expand the --url template: %v", err)
+		}
+		expanded = b.String()
+	}
+
+	var missing []string
+	expanded = os.Expand(expanded, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, "$"+name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("--url references %s, which is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+func TestExpandURL(t *testing.T) {
+	t.Setenv("TEST_URL_HOST", "web1")
+	event := corev2.FixtureEvent("db1", "check1")
+	event.Entity.Labels = map[string]string{"region": "eu"}
+
+	tests := []struct {
+		url   string
+		event *corev2.Event
+		want  string
+		err   string
+	}{
+		{"https://example.com/", nil, "https://example.com/", ""},
+		{"https://{{ .Entity.Name }}.internal/healthz", event, "https://db1.internal/healthz", ""},
+		{"https://{{ .Entity.Name }}.{{ index .Entity.Labels \"region\" }}.internal/", event, "https://db1.eu.internal/", ""},
+		{"https://$TEST_URL_HOST/healthz", nil, "https://web1/healthz", ""},
+		{"https://${TEST_URL_HOST}.{{ .Check.Name }}/", event, "https://web1.check1/", ""},
+		{"https://{{ .Entity.Name }}/", nil, "", "no Sensu event"},
+		{"https://{{ .Entity.Name /", event, "", "invalid --url template"},
+		{"https://{{ .Entity.Nope }}/", event, "", "unable to expand the --url template"},
+		{"https://$TEST_URL_UNSET/", nil, "", "$TEST_URL_UNSET, which is not set"},
+	}
+	for _, tt := range tests {
+		got, err := expandURL(tt.url, tt.event)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error %q, got %v", tt.url, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: expected %q, got %q %v", tt.url, tt.want, got, err)
+		}
+	}
+}
+
+func TestExecuteCheckURLTemplate(t *testing.T) {
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer ts.Close()
+
+	parseArgs(t, "--url", ts.URL+"/{{ .Entity.Name }}/healthz")
+	if status, err := checkArgs(corev2.FixtureEvent("web1", "check1")); err != nil {
+		t.Fatalf("checkArgs: %d %v", status, err)
+	}
+	status, out := run(t)
+	if status != sensu.CheckStateOK || path != "/web1/healthz" || !strings.Contains(out, " GET "+ts.URL+"/web1/healthz -> 200 OK") {
+		t.Errorf("expected the expanded URL to be requested and shown, got %d %s: %s", status, path, out)
+	}
+
+	parseArgs(t, "--url", ts.URL+"/{{ .Entity.Name }}/healthz")
+	if status, err := checkArgs(nil); err == nil || status != sensu.CheckStateUnknown {
+		t.Errorf("expected UNKNOWN without an event, got %d %v", status, err)
+	}
+}